@@ -2,20 +2,30 @@ package main
 
 import (
 	"log"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/everest-an/dchat-backend/internal/auth"
 	"github.com/everest-an/dchat-backend/internal/config"
 	"github.com/everest-an/dchat-backend/internal/database"
+	"github.com/everest-an/dchat-backend/internal/e2ee"
 	"github.com/everest-an/dchat-backend/internal/handlers"
+	"github.com/everest-an/dchat-backend/internal/mailer"
+	"github.com/everest-an/dchat-backend/internal/messaging"
 	"github.com/everest-an/dchat-backend/internal/middleware"
 	"github.com/everest-an/dchat-backend/internal/privadoid"
 	privadoidHandlers "github.com/everest-an/dchat-backend/internal/privadoid/handlers"
+	"github.com/everest-an/dchat-backend/internal/sms"
+	"github.com/everest-an/dchat-backend/internal/transparency"
+	"github.com/everest-an/dchat-backend/internal/websocket"
+	"github.com/everest-an/dchat-backend/pkg/utils"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.Load(config.APIService)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
@@ -27,14 +37,89 @@ func main() {
 	}
 	defer db.Close()
 
+	// Initialize Redis
+	redisClient, err := utils.NewRedisClient(&cfg.Redis)
+	if err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer redisClient.Close()
+
+	// Initialize signing keys
+	keyring, err := auth.NewKeyring(db.DB, &cfg.JWT)
+	if err != nil {
+		log.Fatalf("Failed to initialize signing keys: %v", err)
+	}
+	go keyring.Run()
+
+	// Smart-contract wallet signatures (EIP-1271/ERC-6492) are checked on-chain
+	var contractCaller auth.ContractCaller
+	if cfg.Web3.RPCURL != "" {
+		client, err := ethclient.Dial(cfg.Web3.RPCURL)
+		if err != nil {
+			log.Fatalf("Failed to connect to RPC node: %v", err)
+		}
+		defer client.Close()
+		contractCaller = client
+	}
+
 	// Initialize services
-	jwtService := auth.NewJWTService(&cfg.JWT)
-	web3Service := auth.NewWeb3Service()
-	userService := auth.NewUserService(db.DB)
+	jwtService := auth.NewJWTService(&cfg.JWT, keyring)
+	web3Service := auth.NewWeb3Service(contractCaller, cfg.Web3.ChainID, common.HexToAddress(cfg.Web3.ERC6492ValidatorAddress))
+	identityService := auth.NewIdentityService(db.DB)
+	userService := auth.NewUserService(db.DB, identityService)
+	linkedInClient := auth.NewLinkedInClient(&cfg.LinkedIn)
+	mail, err := mailer.New(&cfg.Mail)
+	if err != nil {
+		log.Fatalf("Failed to initialize mailer: %v", err)
+	}
+	smsSender, err := sms.New(&cfg.SMS)
+	if err != nil {
+		log.Fatalf("Failed to initialize SMS sender: %v", err)
+	}
+	nonceStore := auth.NewNonceStore(redisClient, time.Duration(cfg.SIWE.NonceTTLMinutes)*time.Minute)
+	siweService := auth.NewSIWEService(&cfg.SIWE, cfg.Web3.ChainIDs, nonceStore, web3Service)
+	denylist := auth.NewTokenDenylist(redisClient)
+	sessionService := auth.NewSessionService(db.DB, jwtService, denylist, time.Duration(cfg.JWT.RefreshTokenDays)*24*time.Hour)
+	emailAuthService := auth.NewEmailAuthService(db.DB, identityService, sessionService, mail, cfg.Server.AppURL)
+	otpStore := auth.NewOTPStore(redisClient, time.Duration(cfg.OTP.TTLMinutes)*time.Minute, cfg.OTP.MaxAttempts, cfg.OTP.NumberHourlyLimit, cfg.OTP.IPHourlyLimit)
+	phoneAuthService := auth.NewPhoneAuthService(db.DB, otpStore, identityService, sessionService, smsSender)
+	messageService := messaging.NewMessageService(db.DB, &cfg.Messaging)
+	conversationService := messaging.NewConversationService(db.DB, messageService)
+	groupService := messaging.NewGroupService(db.DB, messageService)
+	// Message edits and deletions made over REST reach devices through the websocket nodes
+	notifier := websocket.NewNotifier(db.DB, redisClient, "api")
+	sessionService.OnRevoke(notifier.SessionRevoked)
+	channelService := messaging.NewChannelService(db.DB, messageService)
+	preKeyService := e2ee.NewPreKeyService(db.DB, redisClient)
+	mlsService := e2ee.NewMLSService(db.DB, redisClient, groupService)
+	logKey, err := transparency.SigningKey(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load key transparency signing key: %v", err)
+	}
+	keyLog := transparency.NewKeyLog(db.DB, logKey)
+	certificateKey, err := e2ee.CertificateKey(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load sealed sender certificate key: %v", err)
+	}
+	sealedSenderService := e2ee.NewSealedSenderService(db.DB, redisClient, certificateKey, &cfg.SealedSender)
+	go sealedSenderService.Run()
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(userService, jwtService, web3Service)
-	messageHandler := handlers.NewMessageHandler(db.DB)
+	authHandler := handlers.NewAuthHandler(userService, sessionService, web3Service, siweService)
+	sessionHandler := handlers.NewSessionHandler(sessionService)
+	userHandler := handlers.NewUserHandler(userService)
+	jwksHandler := handlers.NewJWKSHandler(keyring)
+	identityHandler := handlers.NewIdentityHandler(identityService, sessionService, siweService, linkedInClient)
+	emailAuthHandler := handlers.NewEmailAuthHandler(emailAuthService)
+	phoneAuthHandler := handlers.NewPhoneAuthHandler(phoneAuthService)
+	messageHandler := handlers.NewMessageHandler(messageService, conversationService, notifier)
+	groupHandler := handlers.NewGroupHandler(groupService, notifier)
+	channelHandler := handlers.NewChannelHandler(channelService)
+	searchHandler := handlers.NewSearchHandler(messageService)
+	keyHandler := handlers.NewKeyHandler(preKeyService, notifier)
+	mlsHandler := handlers.NewMLSHandler(mlsService)
+	transparencyHandler := handlers.NewTransparencyHandler(keyLog)
+	sealedSenderHandler := handlers.NewSealedSenderHandler(sealedSenderService, notifier)
 
 	// Initialize Privado ID
 	privadoConfig := privadoid.LoadConfig()
@@ -47,6 +132,10 @@ func main() {
 	}
 
 	router := gin.Default()
+	// Behind no proxy, X-Forwarded-For is whatever the client sent
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	// Apply middleware
 	router.Use(middleware.CORSMiddleware())
@@ -60,26 +149,137 @@ func main() {
 		})
 	})
 
+	// Public keys for verifying access tokens
+	router.GET("/.well-known/jwks.json", jwksHandler.GetJWKS)
+
 	// Public routes
 	api := router.Group("/api")
 	{
 		api.POST("/auth/nonce", authHandler.GetNonce)
 		api.POST("/auth/wallet-login", authHandler.WalletLogin)
+		api.POST("/auth/refresh", authHandler.Refresh)
+		api.POST("/auth/linkedin-login", identityHandler.LinkedInLogin)
+		api.POST("/auth/register", emailAuthHandler.Register)
+		api.POST("/auth/email-login", emailAuthHandler.Login)
+		api.POST("/auth/verify-email", emailAuthHandler.VerifyEmail)
+		api.POST("/auth/resend-verification", emailAuthHandler.ResendVerification)
+		api.POST("/auth/password-reset/request", emailAuthHandler.RequestPasswordReset)
+		api.POST("/auth/password-reset/confirm", emailAuthHandler.ResetPassword)
+		api.POST("/auth/phone-code", phoneAuthHandler.RequestLoginCode)
+		api.POST("/auth/phone-login", phoneAuthHandler.Login)
+
+		// Key transparency log, public so anyone can audit it
+		api.GET("/transparency/key", transparencyHandler.GetLogKey)
+		api.GET("/transparency/sth", transparencyHandler.GetTreeHead)
+		api.GET("/transparency/sth/:size", transparencyHandler.GetTreeHeadAt)
+		api.GET("/transparency/consistency", transparencyHandler.GetConsistencyProof)
+
+		// Sealed-sender messages are sent without logging in, so the server
+		// never learns the sender
+		api.GET("/sealed/key", sealedSenderHandler.GetCertificateKey)
+		api.POST("/sealed/messages", sealedSenderHandler.SendSealedMessage)
 	}
 
 	// Protected routes
 	protected := api.Group("")
-	protected.Use(middleware.AuthMiddleware(jwtService))
+	protected.Use(middleware.AuthMiddleware(jwtService, denylist))
 	{
 		// User routes
 		protected.GET("/user/me", authHandler.GetCurrentUser)
+		protected.POST("/auth/logout", authHandler.Logout)
+		protected.PUT("/user/me/discoverability", userHandler.SetDiscoverability)
+		protected.PUT("/user/me/public-key", transparencyHandler.PublishKey)
+
+		// Directory routes
+		protected.GET("/users/search", userHandler.SearchUsers)
+		protected.GET("/users/:id", userHandler.GetProfile)
+
+		// Session routes
+		protected.GET("/sessions", sessionHandler.ListSessions)
+		protected.DELETE("/sessions/:id", sessionHandler.RevokeSession)
+		protected.DELETE("/sessions", sessionHandler.RevokeOtherSessions)
+
+		// Linked identity routes
+		protected.GET("/identities", identityHandler.ListIdentities)
+		protected.POST("/identities/wallet", identityHandler.LinkWallet)
+		protected.POST("/identities/linkedin", identityHandler.LinkLinkedIn)
+		protected.POST("/identities/email", emailAuthHandler.LinkEmail)
+		protected.POST("/identities/phone/code", phoneAuthHandler.RequestVerificationCode)
+		protected.POST("/identities/phone", phoneAuthHandler.VerifyPhone)
+		protected.PUT("/identities/:id/primary", identityHandler.SetPrimary)
+		protected.DELETE("/identities/:id", identityHandler.Unlink)
 
 		// Message routes
 		protected.POST("/messages", messageHandler.SendMessage)
 		protected.GET("/messages/:user_id", messageHandler.GetMessages)
+		protected.PATCH("/messages/:id", messageHandler.EditMessage)
+		protected.DELETE("/messages/:id", messageHandler.DeleteMessage)
+		protected.GET("/messages/edits/:id", messageHandler.GetMessageEdits)
+		protected.GET("/messages/thread/:id", messageHandler.GetThread)
+		protected.POST("/messages/:id/reactions", messageHandler.AddReaction)
+		protected.DELETE("/messages/:id/reactions", messageHandler.RemoveReaction)
 		protected.GET("/conversations", messageHandler.GetConversations)
+		protected.PATCH("/conversations/:id", messageHandler.UpdateConversation)
+		protected.PUT("/conversations/:id/disappearing", messageHandler.SetDisappearingTimer)
 		protected.PUT("/messages/read/:sender_id", messageHandler.MarkAsRead)
 
+		// Group routes
+		protected.POST("/groups", groupHandler.CreateGroup)
+		protected.POST("/groups/join/:code", groupHandler.JoinGroup)
+		protected.GET("/groups/:id", groupHandler.GetGroup)
+		protected.PATCH("/groups/:id", groupHandler.RenameGroup)
+		protected.POST("/groups/:id/members", groupHandler.InviteMembers)
+		protected.DELETE("/groups/:id/members/:user_id", groupHandler.KickMember)
+		protected.PUT("/groups/:id/members/:user_id/role", groupHandler.SetMemberRole)
+		protected.POST("/groups/:id/leave", groupHandler.LeaveGroup)
+		protected.POST("/groups/:id/invite-link", groupHandler.CreateInviteLink)
+		protected.DELETE("/groups/:id/invite-link", groupHandler.RevokeInviteLink)
+		protected.GET("/groups/:id/messages", groupHandler.GetGroupMessages)
+		protected.PUT("/groups/:id/read", groupHandler.MarkGroupRead)
+		protected.POST("/groups/:id/mls", mlsHandler.CreateMLSGroup)
+		protected.GET("/groups/:id/mls", mlsHandler.GetMLSGroup)
+		protected.GET("/groups/:id/mls/messages", mlsHandler.GetMLSMessages)
+
+		// Channel routes
+		protected.POST("/channels", channelHandler.CreateChannel)
+		protected.GET("/channels", channelHandler.SearchChannels)
+		protected.POST("/channels/join/:code", channelHandler.JoinChannel)
+		protected.GET("/channels/:id", channelHandler.GetChannel)
+		protected.PATCH("/channels/:id", channelHandler.UpdateChannel)
+		protected.POST("/channels/:id/subscription", channelHandler.Subscribe)
+		protected.DELETE("/channels/:id/subscription", channelHandler.Unsubscribe)
+		protected.POST("/channels/:id/subscribers", channelHandler.InviteSubscribers)
+		protected.DELETE("/channels/:id/subscribers/:user_id", channelHandler.RemoveSubscriber)
+		protected.PUT("/channels/:id/subscribers/:user_id/role", channelHandler.SetSubscriberRole)
+		protected.POST("/channels/:id/invite-link", channelHandler.CreateInviteLink)
+		protected.DELETE("/channels/:id/invite-link", channelHandler.RevokeInviteLink)
+		protected.GET("/channels/:id/posts", channelHandler.GetPosts)
+		protected.PUT("/channels/:id/read", channelHandler.MarkChannelRead)
+
+		// Search routes
+		protected.GET("/search/messages", searchHandler.SearchMessages)
+
+		// End-to-end encryption key directory routes
+		protected.PUT("/keys", keyHandler.UploadKeys)
+		protected.GET("/keys/count", keyHandler.GetPreKeyCount)
+		protected.GET("/keys/:user_id/devices", keyHandler.GetDevices)
+		protected.POST("/keys/:user_id/devices/:device_id/claim", keyHandler.ClaimBundle)
+
+		// MLS key package routes; group messages are relayed over the websocket
+		protected.PUT("/mls/key-packages", mlsHandler.UploadKeyPackages)
+		protected.GET("/mls/key-packages/count", mlsHandler.GetKeyPackageCount)
+		protected.POST("/mls/key-packages/:user_id/claim", mlsHandler.ClaimKeyPackages)
+
+		// Proof that a user's public key is in the key transparency log
+		protected.GET("/transparency/users/:id", transparencyHandler.GetInclusionProof)
+
+		// Sealed-sender routes
+		protected.POST("/sealed/certificate", sealedSenderHandler.IssueCertificate)
+		protected.PUT("/sealed/delivery-token", sealedSenderHandler.SetDeliveryToken)
+		protected.DELETE("/sealed/delivery-token", sealedSenderHandler.DisableSealedSender)
+		protected.GET("/sealed/messages", sealedSenderHandler.GetSealedMessages)
+		protected.DELETE("/sealed/messages", sealedSenderHandler.AckSealedMessages)
+
 		// Privado ID verification routes
 		protected.POST("/verifications/request", privadoHandler.CreateRequest)
 		protected.GET("/verifications/user/:userId", privadoHandler.GetUserVerifications)
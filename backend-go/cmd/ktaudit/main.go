@@ -0,0 +1,188 @@
+// Command ktaudit audits the key transparency log. It verifies the signature
+// of the latest tree head and checks that it is consistent with the last
+// tree head it verified, so that a log that rewrites history or shows
+// different clients different trees is detected.
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/transparency"
+)
+
+// errEquivocation is a failed check that proves the log misbehaved, as
+// opposed to the log being unreachable
+var errEquivocation = errors.New("key transparency log is inconsistent")
+
+// auditState is what the auditor remembers between runs
+type auditState struct {
+	LogKey   []byte                 `json:"log_key"`
+	TreeHead *transparency.TreeHead `json:"tree_head,omitempty"`
+}
+
+type auditor struct {
+	baseURL   string
+	statePath string
+	client    *http.Client
+	state     auditState
+}
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:8080/api", "base URL of the dChat API")
+	statePath := flag.String("state", "ktaudit.json", "file holding the last verified tree head")
+	logKey := flag.String("log-key", "", "base64 Ed25519 key of the log; trusted on first use when empty")
+	interval := flag.Duration("interval", 0, "check again at this interval instead of once")
+	flag.Parse()
+
+	a := &auditor{
+		baseURL:   strings.TrimRight(*baseURL, "/"),
+		statePath: *statePath,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+	if err := a.load(*logKey); err != nil {
+		log.Fatalf("Failed to load audit state: %v", err)
+	}
+
+	for {
+		err := a.check()
+		switch {
+		case errors.Is(err, errEquivocation):
+			log.Fatalf("❌ %v", err)
+		case err != nil && *interval == 0:
+			log.Fatalf("Audit failed: %v", err)
+		case err != nil:
+			log.Printf("Audit failed, retrying: %v", err)
+		}
+		if *interval == 0 {
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// load reads the saved state and settles which log key to trust
+func (a *auditor) load(pinnedKey string) error {
+	data, err := os.ReadFile(a.statePath)
+	if err == nil {
+		if err := json.Unmarshal(data, &a.state); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if pinnedKey != "" {
+		key, err := base64.StdEncoding.DecodeString(pinnedKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return errors.New("-log-key must be a base64 Ed25519 public key")
+		}
+		if a.state.LogKey != nil && string(a.state.LogKey) != string(key) {
+			return errors.New("-log-key differs from the key in the state file")
+		}
+		a.state.LogKey = key
+	}
+	if a.state.LogKey == nil {
+		var published struct {
+			PublicKey []byte `json:"public_key"`
+		}
+		if err := a.get("/transparency/key", nil, &published); err != nil {
+			return err
+		}
+		if len(published.PublicKey) != ed25519.PublicKeySize {
+			return errors.New("log published an invalid key")
+		}
+		a.state.LogKey = published.PublicKey
+		log.Printf("Trusting log key %s", base64.StdEncoding.EncodeToString(published.PublicKey))
+	}
+	return nil
+}
+
+// check verifies the latest tree head against the last verified one
+func (a *auditor) check() error {
+	var head transparency.TreeHead
+	if err := a.get("/transparency/sth", nil, &head); err != nil {
+		return err
+	}
+	if !head.Verify(a.state.LogKey) {
+		return fmt.Errorf("%w: tree head at size %d has an invalid signature", errEquivocation, head.TreeSize)
+	}
+
+	previous := a.state.TreeHead
+	switch {
+	case previous == nil:
+		log.Printf("First tree head at size %d", head.TreeSize)
+	case head.TreeSize < previous.TreeSize:
+		return fmt.Errorf("%w: tree shrank from size %d to %d", errEquivocation, previous.TreeSize, head.TreeSize)
+	case head.TreeSize == previous.TreeSize:
+		if string(head.RootHash) != string(previous.RootHash) {
+			return fmt.Errorf("%w: two different roots at size %d", errEquivocation, head.TreeSize)
+		}
+		log.Printf("Tree unchanged at size %d", head.TreeSize)
+	default:
+		var proof [][]byte
+		if previous.TreeSize > 0 {
+			var response struct {
+				Proof [][]byte `json:"proof"`
+			}
+			query := url.Values{}
+			query.Set("first", fmt.Sprint(previous.TreeSize))
+			query.Set("second", fmt.Sprint(head.TreeSize))
+			if err := a.get("/transparency/consistency", query, &response); err != nil {
+				return err
+			}
+			proof = response.Proof
+		}
+		if !transparency.VerifyConsistency(previous.TreeSize, head.TreeSize, previous.RootHash, head.RootHash, proof) {
+			return fmt.Errorf("%w: tree at size %d does not extend tree at size %d", errEquivocation, head.TreeSize, previous.TreeSize)
+		}
+		log.Printf("✅ Tree at size %d is consistent with size %d", head.TreeSize, previous.TreeSize)
+	}
+
+	a.state.TreeHead = &head
+	return a.save()
+}
+
+func (a *auditor) save() error {
+	data, err := json.MarshalIndent(&a.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write then rename so a crash never leaves a truncated state file
+	tmp := a.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.statePath)
+}
+
+func (a *auditor) get(path string, query url.Values, out interface{}) error {
+	target := a.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	resp, err := a.client.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return fmt.Errorf("GET %s: %s %s", path, resp.Status, body.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/transparency"
+)
+
+// fakeLog serves the transparency endpoints the auditor reads
+type fakeLog struct {
+	key    ed25519.PrivateKey
+	leaves [][]byte
+	// Served instead of the tree head of leaves when set
+	head *transparency.TreeHead
+}
+
+func newFakeLog(t *testing.T) (*fakeLog, *httptest.Server) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &fakeLog{key: key}
+	server := httptest.NewServer(l)
+	t.Cleanup(server.Close)
+	return l, server
+}
+
+func (l *fakeLog) append(n int) {
+	for i := 0; i < n; i++ {
+		l.leaves = append(l.leaves, transparency.LeafHash([]byte(fmt.Sprintf("leaf %d", len(l.leaves)))))
+	}
+}
+
+func (l *fakeLog) treeHead(leaves [][]byte) *transparency.TreeHead {
+	head := &transparency.TreeHead{TreeSize: uint64(len(leaves)), Timestamp: time.Now().UnixMilli(), RootHash: transparency.RootHash(leaves)}
+	head.Sign(l.key)
+	return head
+}
+
+func (l *fakeLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body interface{}
+	switch r.URL.Path {
+	case "/transparency/key":
+		body = map[string]interface{}{"public_key": []byte(l.key.Public().(ed25519.PublicKey))}
+	case "/transparency/sth":
+		body = l.head
+		if l.head == nil {
+			body = l.treeHead(l.leaves)
+		}
+	case "/transparency/consistency":
+		first, _ := strconv.Atoi(r.URL.Query().Get("first"))
+		second, _ := strconv.Atoi(r.URL.Query().Get("second"))
+		if first <= 0 || first > second || second > len(l.leaves) {
+			w.WriteHeader(http.StatusBadRequest)
+			body = map[string]string{"error": "invalid tree sizes"}
+			break
+		}
+		body = map[string]interface{}{"proof": transparency.ConsistencyProof(l.leaves[:second], first)}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+func newTestAuditor(t *testing.T, server *httptest.Server, statePath string) *auditor {
+	t.Helper()
+	a := &auditor{baseURL: server.URL, statePath: statePath, client: server.Client()}
+	if err := a.load(""); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAuditorFollowsGrowingLog(t *testing.T) {
+	l, server := newFakeLog(t)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	a := newTestAuditor(t, server, statePath)
+	if string(a.state.LogKey) != string(l.key.Public().(ed25519.PublicKey)) {
+		t.Fatal("auditor did not trust the published key")
+	}
+	// The empty tree, then growth from it, from a complete subtree and from
+	// a partial one
+	for _, n := range []int{0, 3, 1, 5, 7} {
+		l.append(n)
+		if err := a.check(); err != nil {
+			t.Fatalf("at size %d: %v", len(l.leaves), err)
+		}
+	}
+
+	// A later run resumes from the saved tree head
+	a = newTestAuditor(t, server, statePath)
+	if a.state.TreeHead == nil || a.state.TreeHead.TreeSize != uint64(len(l.leaves)) {
+		t.Fatalf("saved tree head = %+v, want size %d", a.state.TreeHead, len(l.leaves))
+	}
+	l.append(2)
+	if err := a.check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditorDetectsEquivocation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(l *fakeLog)
+	}{
+		{"rewritten history", func(l *fakeLog) {
+			l.leaves[2] = transparency.LeafHash([]byte("forged"))
+			l.append(3)
+		}},
+		{"shrunk tree", func(l *fakeLog) {
+			l.head = l.treeHead(l.leaves[:4])
+		}},
+		{"other root at the same size", func(l *fakeLog) {
+			forged := append(append([][]byte{}, l.leaves[:5]...), transparency.LeafHash([]byte("forged")))
+			l.head = l.treeHead(forged)
+		}},
+		{"tree head signed by another key", func(l *fakeLog) {
+			l.append(1)
+			_, l.key, _ = ed25519.GenerateKey(nil)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, server := newFakeLog(t)
+			a := newTestAuditor(t, server, filepath.Join(t.TempDir(), "state.json"))
+			l.append(6)
+			if err := a.check(); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.modify(l)
+			if err := a.check(); !errors.Is(err, errEquivocation) {
+				t.Fatalf("err = %v, want errEquivocation", err)
+			}
+			if a.state.TreeHead.TreeSize != 6 {
+				t.Fatalf("saved a tree head that failed the audit: %+v", a.state.TreeHead)
+			}
+		})
+	}
+}
+
+func TestAuditorLoadPinnedKey(t *testing.T) {
+	l, server := newFakeLog(t)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	pinned := base64.StdEncoding.EncodeToString(l.key.Public().(ed25519.PublicKey))
+
+	a := &auditor{baseURL: server.URL, statePath: statePath, client: server.Client()}
+	if err := a.load(pinned); err != nil {
+		t.Fatal(err)
+	}
+	l.append(1)
+	if err := a.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	for _, key := range []string{"not a key", base64.StdEncoding.EncodeToString(other)} {
+		a := &auditor{baseURL: server.URL, statePath: statePath, client: server.Client()}
+		if err := a.load(key); err == nil {
+			t.Fatalf("-log-key %q: loaded", key)
+		}
+	}
+}
+
+func TestAuditorUnreachableLog(t *testing.T) {
+	l, server := newFakeLog(t)
+	a := newTestAuditor(t, server, filepath.Join(t.TempDir(), "state.json"))
+	l.append(2)
+	if err := a.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Close()
+	if err := a.check(); err == nil || errors.Is(err, errEquivocation) {
+		t.Fatalf("err = %v, want a non-equivocation error", err)
+	}
+}
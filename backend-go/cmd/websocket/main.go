@@ -1,19 +1,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/everest-an/dchat-backend/internal/auth"
 	"github.com/everest-an/dchat-backend/internal/config"
 	"github.com/everest-an/dchat-backend/internal/database"
 	"github.com/everest-an/dchat-backend/internal/middleware"
 	"github.com/everest-an/dchat-backend/internal/websocket"
+	"github.com/everest-an/dchat-backend/pkg/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	gorilla_websocket "github.com/gorilla/websocket"
 )
 
+// How long in-flight requests get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
 var upgrader = gorilla_websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -24,7 +33,7 @@ var upgrader = gorilla_websocket.Upgrader{
 
 func main() {
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.Load(config.WebSocketService)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
@@ -36,11 +45,22 @@ func main() {
 	}
 	defer db.Close()
 
-	// Initialize JWT service
-	jwtService := auth.NewJWTService(&cfg.JWT)
+	// Initialize Redis
+	redisClient, err := utils.NewRedisClient(&cfg.Redis)
+	if err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer redisClient.Close()
+
+	// Verify tokens against the API's published keys and the revoked token denylist
+	jwtService := auth.NewJWTVerifier(auth.NewJWKSClient(cfg.JWT.JWKSURL))
+	denylist := auth.NewTokenDenylist(redisClient)
+
+	// Join the cluster of websocket nodes and initialize the hub
+	cluster := websocket.NewCluster(redisClient, uuid.New().String())
+	log.Printf("🔗 Joined websocket cluster as node %s", cluster.NodeID())
 
-	// Initialize WebSocket hub
-	hub := websocket.NewHub(db.DB)
+	hub := websocket.NewHub(db.DB, cluster, &cfg.Messaging)
 	go hub.Run()
 
 	// Setup Gin router
@@ -49,6 +69,10 @@ func main() {
 	}
 
 	router := gin.Default()
+	// Behind no proxy, X-Forwarded-For is whatever the client sent
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 	router.Use(middleware.CORSMiddleware())
 
 	// Health check
@@ -57,12 +81,12 @@ func main() {
 			"status":        "ok",
 			"service":       "dChat WebSocket",
 			"version":       "2.0.0-go",
-			"online_users":  len(hub.Clients),
+			"online_users":  len(hub.GetOnlineUsers()),
 		})
 	})
 
 	// WebSocket endpoint
-	router.GET("/ws", middleware.AuthMiddleware(jwtService), func(c *gin.Context) {
+	router.GET("/ws", middleware.AuthMiddleware(jwtService, denylist), func(c *gin.Context) {
 		userID, exists := c.Get("user_id")
 		if !exists {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
@@ -76,7 +100,13 @@ func main() {
 		}
 
 		clientID := uuid.New().String()
-		client := websocket.NewClient(clientID, userID.(uint), conn, hub)
+
+		// Each login session is one device; tokens without a session get their own
+		deviceID := clientID
+		if sessionID, _ := c.Get("session_id"); sessionID != nil && sessionID.(uint) != 0 {
+			deviceID = websocket.SessionDevice(sessionID.(uint))
+		}
+		client := websocket.NewClient(clientID, userID.(uint), deviceID, conn, hub)
 
 		hub.Register <- client
 
@@ -87,8 +117,27 @@ func main() {
 
 	// Start server
 	port := ":" + cfg.Server.WebSocketPort
-	log.Printf("🚀 dChat WebSocket server starting on port %s", port)
-	if err := router.Run(port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	server := &http.Server{Addr: port, Handler: router}
+	go func() {
+		log.Printf("🚀 dChat WebSocket server starting on port %s", port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Leave the cluster explicitly on shutdown; deferred calls don't run
+	// when the process is killed by a signal
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Println("Shutting down WebSocket server...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down server: %v", err)
+	}
+	if err := hub.Shutdown(); err != nil {
+		log.Printf("Failed to leave websocket cluster: %v", err)
 	}
 }
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/everest-an/dchat-backend/pkg/utils"
+)
+
+const (
+	denylistKeyPrefix       = "jwt:denylist:"
+	revokedSessionKeyPrefix = "jwt:revoked-session:"
+)
+
+// TokenDenylist records revoked access token IDs (jti), and revoked sessions
+// whose every token is rejected, until the tokens expire
+type TokenDenylist struct {
+	redis *utils.RedisClient
+}
+
+func NewTokenDenylist(redisClient *utils.RedisClient) *TokenDenylist {
+	return &TokenDenylist{redis: redisClient}
+}
+
+// Revoke denylists a token ID for the remainder of its lifetime
+func (d *TokenDenylist) Revoke(tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if tokenID == "" || ttl <= 0 {
+		// Already expired, nothing to deny
+		return nil
+	}
+	return d.redis.Set(denylistKeyPrefix+tokenID, 1, ttl)
+}
+
+// RevokeSession rejects every access token of a session until expiresAt,
+// the expiry of the last token issued to it
+func (d *TokenDenylist) RevokeSession(sessionID uint, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if sessionID == 0 || ttl <= 0 {
+		return nil
+	}
+	return d.redis.Set(fmt.Sprintf("%s%d", revokedSessionKeyPrefix, sessionID), 1, ttl)
+}
+
+// IsRevoked reports whether a token ID has been denylisted
+func (d *TokenDenylist) IsRevoked(tokenID string) (bool, error) {
+	return d.redis.Exists(denylistKeyPrefix + tokenID)
+}
+
+// IsTokenRevoked reports whether an access token was revoked, by itself or
+// along with its session
+func (d *TokenDenylist) IsTokenRevoked(claims *Claims) (bool, error) {
+	if revoked, err := d.IsRevoked(claims.ID); err != nil || revoked {
+		return revoked, err
+	}
+	if claims.SessionID == 0 {
+		return false, nil
+	}
+	return d.redis.Exists(fmt.Sprintf("%s%d", revokedSessionKeyPrefix, claims.SessionID))
+}
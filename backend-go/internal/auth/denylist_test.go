@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/testutil"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestTokenDenylist(t *testing.T) {
+	redisClient, server := testutil.NewRedis(t)
+	denylist := NewTokenDenylist(redisClient)
+	claims := func(id string, sessionID uint) *Claims {
+		return &Claims{SessionID: sessionID, RegisteredClaims: jwt.RegisteredClaims{ID: id}}
+	}
+
+	if err := denylist.Revoke("jti-1", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := denylist.RevokeSession(7, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		claims  *Claims
+		revoked bool
+	}{
+		{claims("jti-1", 0), true},
+		{claims("jti-2", 7), true},
+		{claims("jti-2", 8), false},
+		{claims("jti-2", 0), false},
+	}
+	for _, tt := range tests {
+		revoked, err := denylist.IsTokenRevoked(tt.claims)
+		if err != nil || revoked != tt.revoked {
+			t.Errorf("jti %s, session %d: revoked=%v err=%v, want %v", tt.claims.ID, tt.claims.SessionID, revoked, err, tt.revoked)
+		}
+	}
+
+	// Entries last only as long as the tokens they deny
+	server.FastForward(time.Minute + time.Second)
+	if revoked, _ := denylist.IsTokenRevoked(claims("jti-1", 7)); revoked {
+		t.Fatal("denylist entries outlived the tokens")
+	}
+	if err := denylist.RevokeSession(9, time.Now().Add(-time.Second)); err != nil || server.Exists("jwt:revoked-session:9") {
+		t.Fatalf("revoking a session whose tokens expired: err=%v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/everest-an/dchat-backend/internal/database"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultDirectoryLimit = 20
+	MaxDirectoryLimit     = 50
+	// Shorter queries match too much of the directory to be useful
+	minDirectoryQueryLength = 2
+)
+
+var (
+	ErrUserNotFound           = errors.New("user not found")
+	ErrDirectoryQueryTooShort = errors.New("search query must be at least 2 characters")
+	ErrInvalidDiscoverability = errors.New("discoverability must be everyone, contacts or nobody")
+)
+
+// sharesConversation matches users who are in a direct or group conversation
+// with the viewer and have accepted it, by writing in it or joining it
+// themselves. Being messaged or added to a group by someone doesn't make
+// them a contact, and neither does subscribing to the same channel.
+const sharesConversation = `EXISTS (
+	SELECT 1 FROM conversation_member mine
+	JOIN conversation_member theirs ON theirs.conversation_id = mine.conversation_id
+	JOIN conversation c ON c.id = mine.conversation_id
+	WHERE mine.user_id = ? AND theirs.user_id = "user".id AND theirs.accepted AND c.type <> 'channel')`
+
+// SearchUsers finds users by name, username, company or position, tolerating
+// typos through trigram similarity, or by wallet address prefix. Users who
+// aren't discoverable by the viewer are left out, as is the viewer.
+func (s *UserService) SearchUsers(viewerID uint, query string, offset, limit int) ([]models.PublicProfile, error) {
+	query = strings.TrimSpace(query)
+	if len([]rune(query)) < minDirectoryQueryLength {
+		return nil, ErrDirectoryQueryTooShort
+	}
+	if limit <= 0 {
+		limit = DefaultDirectoryLimit
+	}
+	if limit > MaxDirectoryLimit {
+		limit = MaxDirectoryLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	contains := "%" + database.EscapeLike(query) + "%"
+	prefix := database.EscapeLike(query) + "%"
+
+	var profiles []models.PublicProfile
+	err := s.db.Model(&models.User{}).
+		Select(`id, name, username, company, position, wallet_address, public_key, created_at`).
+		Where(`(name ILIKE @contains OR username ILIKE @contains OR company ILIKE @contains OR position ILIKE @contains
+			OR name % @query OR username % @query OR wallet_address LIKE @wallet)`,
+			map[string]interface{}{"contains": contains, "query": query, "wallet": strings.ToLower(prefix)}).
+		Where("id <> ?", viewerID).
+		Scopes(discoverableBy(viewerID, false)).
+		// Exact and prefix matches on the username or name come first, then the closest
+		Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL: `(LOWER(username) = LOWER(?)) DESC,
+				(username ILIKE ? OR name ILIKE ?) DESC,
+				GREATEST(similarity(name, ?), similarity(username, ?), similarity(company, ?), similarity(position, ?)) DESC,
+				id ASC`,
+			Vars:               []interface{}{query, prefix, prefix, query, query, query, query},
+			WithoutParentheses: true,
+		}}).
+		Offset(offset).
+		Limit(limit).
+		Find(&profiles).Error
+	return profiles, err
+}
+
+// PublicProfile returns a user's profile without their contact details.
+// Users who can't be found by the viewer are still visible to their contacts.
+func (s *UserService) PublicProfile(viewerID, userID uint) (*models.PublicProfile, error) {
+	q := s.db.Model(&models.User{}).
+		Select(`id, name, username, company, position, wallet_address, public_key, created_at`).
+		Where("id = ?", userID)
+	if userID != viewerID {
+		q = q.Scopes(discoverableBy(viewerID, true))
+	}
+
+	var profile models.PublicProfile
+	err := q.Take(&profile).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// SetDiscoverability changes who can find the user in the directory
+func (s *UserService) SetDiscoverability(userID uint, discoverability models.Discoverability) error {
+	switch discoverability {
+	case models.DiscoverableByEveryone, models.DiscoverableByContacts, models.DiscoverableByNobody:
+	default:
+		return ErrInvalidDiscoverability
+	}
+	return s.db.Model(&models.User{}).Where("id = ?", userID).Update("discoverability", discoverability).Error
+}
+
+// discoverableBy keeps the users the viewer may find. Contacts can always
+// see a profile, whatever its owner's setting.
+func discoverableBy(viewerID uint, profile bool) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if profile {
+			return db.Where("(discoverability = ? OR "+sharesConversation+")", models.DiscoverableByEveryone, viewerID)
+		}
+		return db.Where("(discoverability = ? OR (discoverability = ? AND "+sharesConversation+"))",
+			models.DiscoverableByEveryone, models.DiscoverableByContacts, viewerID)
+	}
+}
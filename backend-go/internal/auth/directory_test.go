@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+	"gorm.io/gorm"
+)
+
+// share puts users in a conversation of the given type, accepted by the
+// first of them only, as if they had created it
+func share(t *testing.T, db *gorm.DB, conversationType models.ConversationType, userIDs ...uint) uint {
+	t.Helper()
+	conversation := models.Conversation{Type: conversationType}
+	if err := db.Create(&conversation).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i, userID := range userIDs {
+		member := models.ConversationMember{ConversationID: conversation.ID, UserID: userID, Accepted: i == 0}
+		if err := db.Create(&member).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return conversation.ID
+}
+
+func accept(t *testing.T, db *gorm.DB, conversationID, userID uint) {
+	t.Helper()
+	err := db.Model(&models.ConversationMember{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Update("accepted", true).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func searchIDs(t *testing.T, service *UserService, viewerID uint, query string) map[uint]bool {
+	t.Helper()
+	profiles, err := service.SearchUsers(viewerID, query, 0, MaxDirectoryLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[uint]bool{}
+	for _, profile := range profiles {
+		ids[profile.ID] = true
+	}
+	return ids
+}
+
+func TestDirectoryDiscoverability(t *testing.T) {
+	db := testutil.NewDB(t)
+	service := NewUserService(db, NewIdentityService(db))
+	viewer := testutil.NewUser(t, db, "Quillon Viewer")
+	everyone := testutil.NewUser(t, db, "Quillon Everyone")
+	contacts := testutil.NewUser(t, db, "Quillon Contacts")
+	nobody := testutil.NewUser(t, db, "Quillon Nobody")
+	if err := service.SetDiscoverability(contacts.ID, models.DiscoverableByContacts); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.SetDiscoverability(nobody.ID, models.DiscoverableByNobody); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.SetDiscoverability(everyone.ID, "friends"); !errors.Is(err, ErrInvalidDiscoverability) {
+		t.Fatalf("unknown setting: err = %v", err)
+	}
+
+	found := searchIDs(t, service, viewer.ID, "quillon")
+	if !found[everyone.ID] || found[contacts.ID] || found[nobody.ID] || found[viewer.ID] {
+		t.Fatalf("strangers found %v", found)
+	}
+
+	// Messaging someone or adding them to a group doesn't make them a contact
+	direct := share(t, db, models.ConversationDirect, viewer.ID, contacts.ID)
+	group := share(t, db, models.ConversationGroup, viewer.ID, contacts.ID, nobody.ID)
+	channel := share(t, db, models.ConversationChannel, nobody.ID, viewer.ID)
+	accept(t, db, channel, viewer.ID)
+	if found := searchIDs(t, service, viewer.ID, "quillon"); found[contacts.ID] {
+		t.Fatal("found a contacts-only user who never accepted the conversation")
+	}
+	if _, err := service.PublicProfile(viewer.ID, nobody.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("profile shared only through a channel: err = %v", err)
+	}
+
+	// Replying to the direct conversation does
+	accept(t, db, direct, contacts.ID)
+	if found := searchIDs(t, service, viewer.ID, "quillon"); !found[contacts.ID] || found[nobody.ID] {
+		t.Fatalf("contacts found %v", found)
+	}
+
+	// Contacts see a profile whatever its setting, but never find it by searching
+	if _, err := service.PublicProfile(viewer.ID, nobody.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("profile of a group member who never accepted: err = %v", err)
+	}
+	accept(t, db, group, nobody.ID)
+	profile, err := service.PublicProfile(viewer.ID, nobody.ID)
+	if err != nil || profile.ID != nobody.ID {
+		t.Fatalf("profile of a contact: profile=%+v err=%v", profile, err)
+	}
+	if found := searchIDs(t, service, viewer.ID, "quillon"); found[nobody.ID] {
+		t.Fatal("found a user who is discoverable by nobody")
+	}
+}
+
+func TestSearchUsersQuery(t *testing.T) {
+	db := testutil.NewDB(t)
+	service := NewUserService(db, NewIdentityService(db))
+	viewer := testutil.NewUser(t, db, "Viewer")
+	exact := testutil.NewUser(t, db, "Marisol")
+	fuzzy := testutil.NewUser(t, db, "Marisola Brandt")
+	if err := db.Model(exact).Update("username", "marisol").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.SearchUsers(viewer.ID, " m ", 0, 0); !errors.Is(err, ErrDirectoryQueryTooShort) {
+		t.Fatalf("one-letter query: err = %v", err)
+	}
+
+	profiles, err := service.SearchUsers(viewer.ID, "Marisol", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) < 2 || profiles[0].ID != exact.ID {
+		t.Fatalf("exact username match is not first: %+v", profiles)
+	}
+	if found := searchIDs(t, service, viewer.ID, "marisl"); !found[exact.ID] {
+		t.Fatal("typo did not match through similarity")
+	}
+	if found := searchIDs(t, service, viewer.ID, "brandt"); !found[fuzzy.ID] || found[exact.ID] {
+		t.Fatalf("substring search found %v", found)
+	}
+
+	// LIKE wildcards in the query match literally
+	if found := searchIDs(t, service, viewer.ID, "%%"); len(found) != 0 {
+		t.Fatalf("wildcard query matched %v", found)
+	}
+}
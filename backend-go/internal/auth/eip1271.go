@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	// bytes4(keccak256("isValidSignature(bytes32,bytes)"))
+	eip1271MagicValue = []byte{0x16, 0x26, 0xba, 0x7e}
+
+	// ERC-6492 wrapped signatures end with this 32-byte suffix
+	erc6492MagicSuffix = common.FromHex("0x6492649264926492649264926492649264926492649264926492649264926492")
+
+	ErrContractCallReverted = errors.New("contract call reverted")
+)
+
+// JSON-RPC error code of a call the EVM reverted
+const executionRevertedCode = 3
+
+const signatureContractsABI = `[
+	{"type":"function","name":"isValidSignature","stateMutability":"view",
+	 "inputs":[{"name":"hash","type":"bytes32"},{"name":"signature","type":"bytes"}],
+	 "outputs":[{"name":"magicValue","type":"bytes4"}]},
+	{"type":"function","name":"isValidSig","stateMutability":"nonpayable",
+	 "inputs":[{"name":"signer","type":"address"},{"name":"hash","type":"bytes32"},{"name":"signature","type":"bytes"}],
+	 "outputs":[{"name":"valid","type":"bool"}]}
+]`
+
+var (
+	signatureContracts = mustParseABI(signatureContractsABI)
+
+	// abi.encode(address factory, bytes factoryCalldata, bytes signature)
+	erc6492Wrapper = abi.Arguments{
+		{Type: mustNewABIType("address")},
+		{Type: mustNewABIType("bytes")},
+		{Type: mustNewABIType("bytes")},
+	}
+)
+
+// ContractCaller is the subset of an Ethereum client needed to verify
+// contract signatures. It is satisfied by *ethclient.Client and by the
+// simulated backend in tests.
+type ContractCaller interface {
+	CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error)
+	CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// callContract runs a read-only call at the latest block
+func (s *Web3Service) callContract(ctx context.Context, to common.Address, data []byte) ([]byte, error) {
+	result, err := s.caller.CallContract(ctx, ethereum.CallMsg{To: &to, Data: data}, nil)
+	if err != nil {
+		if isExecutionReverted(err) {
+			return nil, fmt.Errorf("%w: %v", ErrContractCallReverted, err)
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// isExecutionReverted reports whether a call failed because the EVM reverted
+// it. Other JSON-RPC errors, such as a missing block or a rate limit, say
+// nothing about the signature and are passed on.
+func isExecutionReverted(err error) bool {
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == executionRevertedCode {
+		return true
+	}
+	// Some nodes report reverts with a generic code, but always with the
+	// revert data attached
+	var dataErr rpc.DataError
+	return errors.As(err, &dataErr) && strings.HasPrefix(dataErr.Error(), "execution reverted")
+}
+
+// verifyContractSignature checks a signature against a smart-contract wallet,
+// handling both deployed (EIP-1271) and counterfactual (ERC-6492) accounts
+func (s *Web3Service) verifyContractSignature(ctx context.Context, address common.Address, hash []byte, sig []byte) (bool, error) {
+	if s.caller == nil {
+		return false, nil
+	}
+
+	code, err := s.caller.CodeAt(ctx, address, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to get account code: %w", err)
+	}
+
+	if isERC6492Signature(sig) {
+		if len(code) > 0 {
+			// Already deployed: the inner signature is a plain EIP-1271 signature
+			_, _, inner, err := unwrapERC6492Signature(sig)
+			if err != nil {
+				return false, err
+			}
+			return s.isValidSignature(ctx, address, hash, inner)
+		}
+		return s.isValidCounterfactualSignature(ctx, address, hash, sig)
+	}
+
+	if len(code) == 0 {
+		// Not a contract, and ECDSA recovery already failed
+		return false, nil
+	}
+	return s.isValidSignature(ctx, address, hash, sig)
+}
+
+// isValidSignature calls EIP-1271 isValidSignature on a deployed account
+func (s *Web3Service) isValidSignature(ctx context.Context, address common.Address, hash []byte, sig []byte) (bool, error) {
+	data, err := signatureContracts.Pack("isValidSignature", common.BytesToHash(hash), sig)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := s.callContract(ctx, address, data)
+	if errors.Is(err, ErrContractCallReverted) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to call isValidSignature: %w", err)
+	}
+
+	return len(result) >= 4 && bytes.Equal(result[:4], eip1271MagicValue), nil
+}
+
+// isValidCounterfactualSignature asks the ERC-6492 validator contract to
+// simulate the account deployment and check the signature in one eth_call
+func (s *Web3Service) isValidCounterfactualSignature(ctx context.Context, address common.Address, hash []byte, sig []byte) (bool, error) {
+	if s.erc6492Validator == (common.Address{}) {
+		return false, errors.New("counterfactual signatures require ERC6492_VALIDATOR_ADDRESS")
+	}
+
+	data, err := signatureContracts.Pack("isValidSig", address, common.BytesToHash(hash), sig)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := s.callContract(ctx, s.erc6492Validator, data)
+	if errors.Is(err, ErrContractCallReverted) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to call ERC-6492 validator: %w", err)
+	}
+
+	values, err := signatureContracts.Unpack("isValidSig", result)
+	if err != nil || len(values) != 1 {
+		return false, fmt.Errorf("unexpected ERC-6492 validator result: %v", err)
+	}
+	valid, _ := values[0].(bool)
+	return valid, nil
+}
+
+func isERC6492Signature(sig []byte) bool {
+	return len(sig) > len(erc6492MagicSuffix) && bytes.HasSuffix(sig, erc6492MagicSuffix)
+}
+
+// unwrapERC6492Signature splits a wrapped signature into the deployment
+// factory, its calldata and the inner signature
+func unwrapERC6492Signature(sig []byte) (common.Address, []byte, []byte, error) {
+	values, err := erc6492Wrapper.Unpack(sig[:len(sig)-len(erc6492MagicSuffix)])
+	if err != nil || len(values) != 3 {
+		return common.Address{}, nil, nil, fmt.Errorf("invalid ERC-6492 signature: %v", err)
+	}
+
+	factory, _ := values[0].(common.Address)
+	calldata, _ := values[1].([]byte)
+	inner, _ := values[2].([]byte)
+	return factory, calldata, inner, nil
+}
+
+func mustParseABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func mustNewABIType(t string) abi.Type {
+	typ, err := abi.NewType(t, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	return typ
+}
@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const testChainID = 1337
+
+// ownerWalletCode is the runtime code of a minimal ERC-1271 wallet.
+// isValidSignature(hash, sig) recovers the signer of a 65-byte r||s||v
+// signature with the ecrecover precompile and returns the magic value when
+// it is the owner, bytes4(0) otherwise.
+func ownerWalletCode(owner common.Address) []byte {
+	code := []byte{
+		0x60, 0x04, 0x35, 0x60, 0x00, 0x52, // mstore(0x00, hash)
+		0x60, 0xa4, 0x35, 0x60, 0xf8, 0x1c, 0x60, 0x20, 0x52, // mstore(0x20, v)
+		0x60, 0x64, 0x35, 0x60, 0x40, 0x52, // mstore(0x40, r)
+		0x60, 0x84, 0x35, 0x60, 0x60, 0x52, // mstore(0x60, s)
+		// staticcall(gas, ecrecover, 0x00, 0x80, 0x80, 0x20)
+		0x60, 0x20, 0x60, 0x80, 0x60, 0x80, 0x60, 0x00, 0x60, 0x01, 0x5a, 0xfa, 0x50,
+		0x60, 0x80, 0x51, // mload(0x80)
+		0x73, // push20 owner
+	}
+	code = append(code, owner.Bytes()...)
+	return append(code,
+		0x14, 0x60, 0x49, 0x57, // jumpi(magic, eq)
+		0x60, 0x20, 0x60, 0xc0, 0xf3, // return(0xc0, 0x20): zero
+		0x5b,                                           // magic:
+		0x63, 0x16, 0x26, 0xba, 0x7e, 0x60, 0xe0, 0x1b, // 0x1626ba7e << 224
+		0x60, 0x00, 0x52, 0x60, 0x20, 0x60, 0x00, 0xf3, // return(0x00, 0x20)
+	)
+}
+
+// deployCode is init code that deploys runtime as a contract's code
+func deployCode(runtime []byte) []byte {
+	code := []byte{
+		0x61, byte(len(runtime) >> 8), byte(len(runtime)), 0x80, // push2 len, dup1
+		0x60, 0x0c, 0x60, 0x00, 0x39, // codecopy(0x00, 0x0c, len)
+		0x60, 0x00, 0xf3, // return(0x00, len)
+	}
+	return append(code, runtime...)
+}
+
+// create2FactoryCode deploys its calldata as init code with CREATE2 and salt 0
+var create2FactoryCode = []byte{
+	0x36, 0x60, 0x00, 0x60, 0x00, 0x37, // calldatacopy(0x00, 0x00, calldatasize)
+	0x60, 0x00, 0x36, 0x60, 0x00, 0x60, 0x00, 0xf5, // create2(0, 0x00, calldatasize, 0)
+	0x00,
+}
+
+// erc6492ValidatorCode is a minimal ERC-6492 validator for tests.
+// isValidSig(signer, hash, sig) unwraps abi.encode(factory, factoryCalldata,
+// innerSig) from the wrapped signature, calls the factory to deploy the
+// signer, then returns whether the signer's isValidSignature(hash, innerSig)
+// returns the EIP-1271 magic value.
+var erc6492ValidatorCode = []byte{
+	// calldatacopy(0x00, 0x84 + factoryCalldataOffset + 0x20, factoryCalldataLength)
+	0x60, 0xa4, 0x35, 0x60, 0x84, 0x01, 0x80, 0x35, 0x90, 0x60, 0x20, 0x01, 0x81, 0x90, 0x60, 0x00, 0x37,
+	// call(gas, factory, 0, 0x00, factoryCalldataLength, 0, 0)
+	0x60, 0x00, 0x60, 0x00, 0x82, 0x60, 0x00, 0x60, 0x00, 0x60, 0x84, 0x35, 0x5a, 0xf1, 0x50, 0x50,
+	// isValidSignature(hash, innerSig) at 0x00
+	0x63, 0x16, 0x26, 0xba, 0x7e, 0x60, 0xe0, 0x1b, 0x60, 0x00, 0x52, // selector
+	0x60, 0x24, 0x35, 0x60, 0x04, 0x52, // hash
+	0x60, 0x40, 0x60, 0x24, 0x52, // offset of the signature
+	0x60, 0xc4, 0x35, 0x60, 0x84, 0x01, 0x80, 0x35, 0x80, 0x60, 0x44, 0x52, // its length
+	0x80, 0x91, 0x60, 0x20, 0x01, 0x60, 0x64, 0x37, // and bytes
+	// staticcall(gas, signer, 0x00, 0x64 + innerSigLength, 0x1000, 0x20)
+	0x60, 0x64, 0x01, 0x60, 0x20, 0x61, 0x10, 0x00, 0x82, 0x60, 0x00, 0x60, 0x04, 0x35, 0x5a, 0xfa, 0x50, 0x50,
+	// return(abi.encode(mload(0x1000) >> 224 == magic))
+	0x61, 0x10, 0x00, 0x51, 0x60, 0xe0, 0x1c, 0x63, 0x16, 0x26, 0xba, 0x7e, 0x14,
+	0x60, 0x00, 0x52, 0x60, 0x20, 0x60, 0x00, 0xf3,
+}
+
+func signMessage(t *testing.T, key *ecdsa.PrivateKey, service *Web3Service, message string) []byte {
+	t.Helper()
+	sig, err := crypto.Sign(service.hashMessage(message), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig[64] += 27
+	return sig
+}
+
+func newContractWalletService(t *testing.T, owner common.Address) (*Web3Service, common.Address) {
+	t.Helper()
+	wallet := common.HexToAddress("0x000000000000000000000000000000000000c0de")
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		wallet: {Code: ownerWalletCode(owner), Balance: big.NewInt(0)},
+	}, 30_000_000)
+	t.Cleanup(func() { backend.Close() })
+	return NewWeb3Service(backend, testChainID, common.Address{}), wallet
+}
+
+func TestVerifySignatureEOA(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	service := NewWeb3Service(nil, testChainID, common.Address{})
+	sig := signMessage(t, key, service, "hello")
+
+	valid, err := service.VerifySignature(testChainID, crypto.PubkeyToAddress(key.PublicKey).Hex(), "hello", hexutil.Encode(sig))
+	if err != nil || !valid {
+		t.Fatalf("EOA signature: valid=%v err=%v", valid, err)
+	}
+
+	valid, err = service.VerifySignature(testChainID, crypto.PubkeyToAddress(key.PublicKey).Hex(), "goodbye", hexutil.Encode(sig))
+	if err != nil || valid {
+		t.Fatalf("EOA signature over another message: valid=%v err=%v", valid, err)
+	}
+}
+
+func TestVerifySignatureContractWallet(t *testing.T) {
+	owner, _ := crypto.GenerateKey()
+	stranger, _ := crypto.GenerateKey()
+	service, wallet := newContractWalletService(t, crypto.PubkeyToAddress(owner.PublicKey))
+
+	tests := []struct {
+		name  string
+		key   *ecdsa.PrivateKey
+		valid bool
+	}{
+		{"owner", owner, true},
+		{"stranger", stranger, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig := signMessage(t, tt.key, service, "sign in")
+			valid, err := service.VerifySignature(testChainID, wallet.Hex(), "sign in", hexutil.Encode(sig))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid != tt.valid {
+				t.Fatalf("valid = %v, want %v", valid, tt.valid)
+			}
+		})
+	}
+}
+
+func TestVerifySignatureContractWalletOtherChain(t *testing.T) {
+	owner, _ := crypto.GenerateKey()
+	service, wallet := newContractWalletService(t, crypto.PubkeyToAddress(owner.PublicKey))
+
+	sig := signMessage(t, owner, service, "sign in")
+	valid, err := service.VerifySignature(testChainID+1, wallet.Hex(), "sign in", hexutil.Encode(sig))
+	if err != nil || valid {
+		t.Fatalf("contract wallet on another chain: valid=%v err=%v", valid, err)
+	}
+}
+
+func TestVerifySignatureERC6492Deployed(t *testing.T) {
+	owner, _ := crypto.GenerateKey()
+	service, wallet := newContractWalletService(t, crypto.PubkeyToAddress(owner.PublicKey))
+
+	inner := signMessage(t, owner, service, "sign in")
+	wrapped, err := erc6492Wrapper.Pack(common.HexToAddress("0xfac7"), []byte{0x01, 0x02}, inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := append(wrapped, erc6492MagicSuffix...)
+
+	valid, err := service.VerifySignature(testChainID, wallet.Hex(), "sign in", hexutil.Encode(sig))
+	if err != nil || !valid {
+		t.Fatalf("wrapped signature of deployed wallet: valid=%v err=%v", valid, err)
+	}
+}
+
+func TestVerifySignatureNoCode(t *testing.T) {
+	owner, _ := crypto.GenerateKey()
+	stranger, _ := crypto.GenerateKey()
+	service, _ := newContractWalletService(t, crypto.PubkeyToAddress(owner.PublicKey))
+
+	sig := signMessage(t, stranger, service, "sign in")
+	valid, err := service.VerifySignature(testChainID, crypto.PubkeyToAddress(owner.PublicKey).Hex(), "sign in", hexutil.Encode(sig))
+	if err != nil || valid {
+		t.Fatalf("account without code: valid=%v err=%v", valid, err)
+	}
+}
+
+func TestVerifySignatureERC6492Counterfactual(t *testing.T) {
+	owner, _ := crypto.GenerateKey()
+	stranger, _ := crypto.GenerateKey()
+	factory := common.HexToAddress("0x000000000000000000000000000000000000fac7")
+	validator := common.HexToAddress("0x0000000000000000000000000000000000006492")
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		factory:   {Code: create2FactoryCode, Balance: big.NewInt(0)},
+		validator: {Code: erc6492ValidatorCode, Balance: big.NewInt(0)},
+	}, 30_000_000)
+	t.Cleanup(func() { backend.Close() })
+	service := NewWeb3Service(backend, testChainID, validator)
+
+	// The wallet the factory will deploy, which has no code yet
+	initCode := deployCode(ownerWalletCode(crypto.PubkeyToAddress(owner.PublicKey)))
+	wallet := crypto.CreateAddress2(factory, [32]byte{}, crypto.Keccak256(initCode))
+	if code, _ := backend.CodeAt(context.Background(), wallet, nil); len(code) != 0 {
+		t.Fatal("wallet is already deployed")
+	}
+
+	tests := []struct {
+		name  string
+		key   *ecdsa.PrivateKey
+		valid bool
+	}{
+		{"owner", owner, true},
+		{"stranger", stranger, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped, err := erc6492Wrapper.Pack(factory, initCode, signMessage(t, tt.key, service, "sign in"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig := append(wrapped, erc6492MagicSuffix...)
+
+			kind, err := service.VerifyWallet(testChainID, wallet.Hex(), "sign in", hexutil.Encode(sig))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid := kind == WalletContract; valid != tt.valid {
+				t.Fatalf("valid = %v, want %v", valid, tt.valid)
+			}
+		})
+	}
+
+	// The deployment was only simulated
+	if code, _ := backend.CodeAt(context.Background(), wallet, nil); len(code) != 0 {
+		t.Fatal("verifying deployed the wallet")
+	}
+}
+
+// rpcError is a JSON-RPC error returned by a node
+type rpcError struct {
+	code int
+	data interface{}
+	msg  string
+}
+
+func (e *rpcError) Error() string          { return e.msg }
+func (e *rpcError) ErrorCode() int         { return e.code }
+func (e *rpcError) ErrorData() interface{} { return e.data }
+
+// failingCaller is a deployed contract whose calls fail with err
+type failingCaller struct {
+	err error
+}
+
+func (c *failingCaller) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	return []byte{0x00}, nil
+}
+
+func (c *failingCaller) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return nil, c.err
+}
+
+func TestVerifySignatureCallErrors(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	wallet := common.HexToAddress("0x000000000000000000000000000000000000c0de").Hex()
+	transport := errors.New("connection refused")
+	noBlock := &rpcError{code: -32000, msg: "header not found"}
+
+	tests := []struct {
+		name string
+		err  error
+		// Whether the signature is simply invalid rather than unverifiable
+		reverted bool
+	}{
+		{"reverted", &rpcError{code: executionRevertedCode, data: "0x", msg: "execution reverted"}, true},
+		{"reverted with a generic code", &rpcError{code: -32000, data: "0x08c379a0", msg: "execution reverted: not owner"}, true},
+		{"node error", noBlock, false},
+		{"transport error", transport, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewWeb3Service(&failingCaller{err: tt.err}, testChainID, common.Address{})
+			sig := signMessage(t, key, service, "sign in")
+			valid, err := service.VerifySignature(testChainID, wallet, "sign in", hexutil.Encode(sig))
+			if valid {
+				t.Fatal("failed call verified a signature")
+			}
+			if tt.reverted != (err == nil) {
+				t.Fatalf("err = %v, want an error: %v", err, !tt.reverted)
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/mailer"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	emailVerifyTokenTTL   = 24 * time.Hour
+	passwordResetTokenTTL = time.Hour
+
+	// Lock the account for lockoutDuration after maxFailedLogins wrong passwords
+	maxFailedLogins = 5
+	lockoutDuration = 15 * time.Minute
+)
+
+var (
+	ErrInvalidEmail       = errors.New("invalid email address")
+	ErrEmailTaken         = errors.New("email is already registered")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrAccountLocked      = errors.New("too many failed attempts, account temporarily locked")
+	ErrInvalidEmailToken  = errors.New("invalid or expired token")
+)
+
+// EmailAuthService implements email + password registration and login
+type EmailAuthService struct {
+	db         *gorm.DB
+	identities *IdentityService
+	sessions   *SessionService
+	mailer     mailer.Mailer
+	appURL     string
+}
+
+func NewEmailAuthService(db *gorm.DB, identities *IdentityService, sessions *SessionService, m mailer.Mailer, appURL string) *EmailAuthService {
+	return &EmailAuthService{
+		db:         db,
+		identities: identities,
+		sessions:   sessions,
+		mailer:     m,
+		appURL:     strings.TrimSuffix(appURL, "/"),
+	}
+}
+
+// Register creates an account that can log in once the email is verified.
+// Registering again with an address that was never verified replaces the
+// pending registration, so an unverified sign-up cannot squat an address.
+// Links mailed for the replaced registration stop working, or whoever
+// verified with one would get the account with the replacement password.
+func (s *EmailAuthService) Register(email, password, name string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	if err := ValidatePassword(password); err != nil {
+		return err
+	}
+
+	if _, err := s.identities.FindUser(models.IdentityEmail, "", email); err == nil {
+		return ErrEmailTaken
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = strings.SplitN(email, "@", 2)[0]
+	}
+
+	var user models.User
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("email = ?", email).First(&user).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			user = models.User{
+				Email:        email,
+				PasswordHash: hash,
+				Name:         name,
+				Username:     name,
+			}
+			return tx.Create(&user).Error
+		case err != nil:
+			return err
+		}
+
+		// Only a pending registration without any login method can be replaced
+		var identities int64
+		if err := tx.Model(&models.UserIdentity{}).Where("user_id = ?", user.ID).Count(&identities).Error; err != nil {
+			return err
+		}
+		if user.IsEmailVerified || identities > 0 {
+			return ErrEmailTaken
+		}
+
+		if err := tx.Where("user_id = ? AND purpose = ? AND used_at IS NULL", user.ID, models.EmailTokenVerify).
+			Delete(&models.EmailToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"password_hash": hash,
+			"name":          name,
+			"username":      name,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.sendVerification(&user, email)
+}
+
+// RequestEmailLink starts linking an email to an existing account. If the
+// account has no password yet, the given one becomes its password.
+func (s *EmailAuthService) RequestEmailLink(userID uint, email, password string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+
+	if existing, err := s.identities.FindUser(models.IdentityEmail, "", email); err == nil {
+		if existing.ID != userID {
+			return ErrIdentityInUse
+		}
+		return nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return err
+	}
+
+	if user.PasswordHash == "" && password != "" {
+		if err := ValidatePassword(password); err != nil {
+			return err
+		}
+		hash, err := HashPassword(password)
+		if err != nil {
+			return err
+		}
+		if err := s.db.Model(&user).Update("password_hash", hash).Error; err != nil {
+			return err
+		}
+	}
+
+	return s.sendVerification(&user, email)
+}
+
+// ResendVerification re-sends the verification mail of a pending registration
+func (s *EmailAuthService) ResendVerification(email string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	err = s.db.Where("email = ? AND is_email_verified = false", email).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Don't reveal whether the address is registered
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.sendVerification(&user, email)
+}
+
+// VerifyEmail consumes a verification token and links the email to its account
+func (s *EmailAuthService) VerifyEmail(token string) (*models.User, error) {
+	emailToken, err := s.consumeToken(token, models.EmailTokenVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.identities.LinkIdentity(emailToken.UserID, models.IdentityEmail, "", emailToken.Email); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := s.db.First(&user, emailToken.UserID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Login checks the password and starts a session
+func (s *EmailAuthService) Login(email, password, device, ipAddress string) (*TokenPair, *models.User, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	user, err := s.identities.FindUser(models.IdentityEmail, "", email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Pending registrations can't log in either. Their password isn't
+		// checked, since failures there aren't counted, and they hash
+		// anyway so unknown addresses take as long as wrong passwords.
+		verifyDummyPassword(password)
+		return nil, nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return nil, nil, ErrAccountLocked
+	}
+	if user.PasswordHash == "" {
+		verifyDummyPassword(password)
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	valid, needsRehash, err := VerifyPassword(user.PasswordHash, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !valid {
+		if err := s.recordFailedLogin(user); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	updates := map[string]interface{}{"failed_login_attempts": 0, "locked_until": nil}
+	if needsRehash {
+		if hash, err := HashPassword(password); err == nil {
+			updates["password_hash"] = hash
+		}
+	}
+	// A concurrent wrong guess may have locked the account since it was loaded
+	result := s.db.Model(&models.User{}).
+		Where("id = ? AND (locked_until IS NULL OR locked_until <= ?)", user.ID, time.Now()).
+		Updates(updates)
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil, ErrAccountLocked
+	}
+
+	tokens, err := s.sessions.CreateSession(user, device, ipAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tokens, user, nil
+}
+
+// RequestPasswordReset mails a reset link if the address belongs to an account
+func (s *EmailAuthService) RequestPasswordReset(email string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	user, err := s.identities.FindUser(models.IdentityEmail, "", email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Don't reveal whether the address is registered
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := s.createToken(user.ID, email, models.EmailTokenPasswordReset, passwordResetTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Someone asked to reset the password of your dChat account.\n\n"+
+		"Reset it here within the next hour:\n%s/reset-password?token=%s\n\n"+
+		"If this wasn't you, you can ignore this email.", s.appURL, token)
+	return s.mailer.Send(email, "Reset your dChat password", body)
+}
+
+// ResetPassword sets a new password and signs out every session
+func (s *EmailAuthService) ResetPassword(token, newPassword string) error {
+	if err := ValidatePassword(newPassword); err != nil {
+		return err
+	}
+
+	emailToken, err := s.consumeToken(token, models.EmailTokenPasswordReset)
+	if err != nil {
+		return err
+	}
+
+	hash, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Model(&models.User{}).Where("id = ?", emailToken.UserID).Updates(map[string]interface{}{
+		"password_hash":         hash,
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	return s.sessions.RevokeOtherSessions(emailToken.UserID, 0)
+}
+
+// recordFailedLogin counts a wrong password in a single statement, so
+// parallel guesses can't all read the same count and slip past the limit.
+// The guess that reaches the limit locks the account and resets the count.
+func (s *EmailAuthService) recordFailedLogin(user *models.User) error {
+	var counted models.User
+	err := s.db.Model(&counted).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "failed_login_attempts"}, {Name: "locked_until"}}}).
+		Where("id = ?", user.ID).
+		Updates(map[string]interface{}{
+			"failed_login_attempts": gorm.Expr("CASE WHEN failed_login_attempts + 1 >= ? THEN 0 ELSE failed_login_attempts + 1 END", maxFailedLogins),
+			"locked_until":          gorm.Expr("CASE WHEN failed_login_attempts + 1 >= ? THEN ? ELSE locked_until END", maxFailedLogins, time.Now().Add(lockoutDuration)),
+		}).Error
+	if err != nil {
+		return err
+	}
+	if counted.FailedLoginAttempts == 0 {
+		log.Printf("🔒 Account locked after failed logins: UserID=%d", user.ID)
+	}
+	return nil
+}
+
+func (s *EmailAuthService) sendVerification(user *models.User, email string) error {
+	token, err := s.createToken(user.ID, email, models.EmailTokenVerify, emailVerifyTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nConfirm that %s is your email address:\n%s/verify-email?token=%s\n\n"+
+		"The link expires in 24 hours.", user.Name, email, s.appURL, token)
+	return s.mailer.Send(email, "Verify your email for dChat", body)
+}
+
+func (s *EmailAuthService) createToken(userID uint, email string, purpose models.EmailTokenPurpose, ttl time.Duration) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	record := models.EmailToken{
+		UserID:    userID,
+		Email:     email,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.db.Create(&record).Error; err != nil {
+		return "", fmt.Errorf("failed to store token: %w", err)
+	}
+	return token, nil
+}
+
+// consumeToken marks a token as used; each token works exactly once
+func (s *EmailAuthService) consumeToken(token string, purpose models.EmailTokenPurpose) (*models.EmailToken, error) {
+	var record models.EmailToken
+	result := s.db.Model(&record).
+		Clauses(clause.Returning{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), purpose, time.Now()).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidEmailToken
+	}
+	return &record, nil
+}
+
+func normalizeEmail(email string) (string, error) {
+	email = NormalizeIdentifier(models.IdentityEmail, email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+)
+
+func TestEmailLoginLockout(t *testing.T) {
+	db := testutil.NewDB(t)
+	identities := NewIdentityService(db)
+	service := NewEmailAuthService(db, identities, nil, nil, "https://dchat.pro")
+
+	hash, err := HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Name: "Ada", Email: "ada@example.com", PasswordHash: hash, IsEmailVerified: true}
+	if err := identities.CreateUserWithIdentity(&user, models.IdentityEmail, "", user.Email); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < maxFailedLogins; i++ {
+		if _, _, err := service.Login(user.Email, "wrong password", "test", "127.0.0.1"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: err = %v, want ErrInvalidCredentials", i+1, err)
+		}
+	}
+
+	var locked models.User
+	if err := db.First(&locked, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if locked.LockedUntil == nil || locked.FailedLoginAttempts != 0 {
+		t.Fatalf("account not locked after %d failures: %+v", maxFailedLogins, locked)
+	}
+	if _, _, err := service.Login(user.Email, "correct horse battery", "test", "127.0.0.1"); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("right password while locked: err = %v, want ErrAccountLocked", err)
+	}
+}
+
+func TestEmailLoginUnknownAddress(t *testing.T) {
+	db := testutil.NewDB(t)
+	service := NewEmailAuthService(db, NewIdentityService(db), nil, nil, "https://dchat.pro")
+
+	if _, _, err := service.Login("nobody@example.com", "whatever password", "test", "127.0.0.1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("err = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+// recordingMailer keeps the tokens of the links it would have mailed
+type recordingMailer struct {
+	tokens []string
+}
+
+func (m *recordingMailer) Send(to, subject, body string) error {
+	_, token, _ := strings.Cut(body, "token=")
+	m.tokens = append(m.tokens, strings.Fields(token)[0])
+	return nil
+}
+
+func TestRegisterReplacingPendingRevokesLinks(t *testing.T) {
+	db := testutil.NewDB(t)
+	mail := &recordingMailer{}
+	service := NewEmailAuthService(db, NewIdentityService(db), nil, mail, "https://dchat.pro")
+
+	if err := service.Register("ada@example.com", "victim password 1", "Ada"); err != nil {
+		t.Fatal(err)
+	}
+	// Someone else registers the same unverified address with their password
+	if err := service.Register("ada@example.com", "attacker password 2", "Mallory"); err != nil {
+		t.Fatal(err)
+	}
+	if len(mail.tokens) != 2 {
+		t.Fatalf("mailed %d links, want 2", len(mail.tokens))
+	}
+
+	if _, err := service.VerifyEmail(mail.tokens[0]); !errors.Is(err, ErrInvalidEmailToken) {
+		t.Fatalf("link of the replaced registration: err = %v, want ErrInvalidEmailToken", err)
+	}
+	user, err := service.VerifyEmail(mail.tokens[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "ada@example.com" || user.Name != "Mallory" {
+		t.Fatalf("verified %+v", user)
+	}
+}
+
+func TestEmailLoginPendingRegistration(t *testing.T) {
+	db := testutil.NewDB(t)
+	service := NewEmailAuthService(db, NewIdentityService(db), nil, &recordingMailer{}, "https://dchat.pro")
+	if err := service.Register("ada@example.com", "correct horse battery", "Ada"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The right and a wrong password fail alike, so the pending password can't be guessed
+	for _, password := range []string{"correct horse battery", "wrong password"} {
+		if _, _, err := service.Login("ada@example.com", password, "test", "127.0.0.1"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("%q: err = %v, want ErrInvalidCredentials", password, err)
+		}
+	}
+}
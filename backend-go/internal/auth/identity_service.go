@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrIdentityInUse    = errors.New("identity is already linked to another account")
+	ErrIdentityNotFound = errors.New("identity not found")
+	ErrLastIdentity     = errors.New("cannot unlink the only login method")
+	ErrWalletOtherChain = errors.New("contract wallet is linked on another chain; link this chain from your account first")
+)
+
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+
+// WalletChain returns the CAIP-2 chain identifier for an EVM chain ID
+func WalletChain(chainID int64) string {
+	return fmt.Sprintf("eip155:%d", chainID)
+}
+
+// NormalizeIdentifier returns the canonical form used for lookups
+func NormalizeIdentifier(identityType models.IdentityType, identifier string) string {
+	identifier = strings.TrimSpace(identifier)
+	switch identityType {
+	case models.IdentityWallet, models.IdentityEmail:
+		return strings.ToLower(identifier)
+	case models.IdentityPhone:
+		return phoneSeparators.Replace(identifier)
+	default:
+		return identifier
+	}
+}
+
+// IdentityService manages the login methods linked to each account
+type IdentityService struct {
+	db *gorm.DB
+}
+
+func NewIdentityService(db *gorm.DB) *IdentityService {
+	return &IdentityService{db: db}
+}
+
+// FindUser resolves a linked identity to its account
+func (s *IdentityService) FindUser(identityType models.IdentityType, chain, identifier string) (*models.User, error) {
+	var user models.User
+	err := s.db.
+		Joins(`JOIN user_identity ui ON ui.user_id = "user".id`).
+		Where("ui.type = ? AND ui.chain = ? AND ui.identifier = ?", identityType, chain, NormalizeIdentifier(identityType, identifier)).
+		First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindWalletUser resolves a wallet linked on any chain to its account
+func (s *IdentityService) FindWalletUser(walletAddress string) (*models.User, error) {
+	var user models.User
+	err := s.db.
+		Joins(`JOIN user_identity ui ON ui.user_id = "user".id`).
+		Where("ui.type = ? AND ui.identifier = ?", models.IdentityWallet, NormalizeIdentifier(models.IdentityWallet, walletAddress)).
+		Order("ui.created_at ASC").
+		First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CreateUserWithIdentity creates an account whose first login method is the given identity
+func (s *IdentityService) CreateUserWithIdentity(user *models.User, identityType models.IdentityType, chain, identifier string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		_, err := s.link(tx, user.ID, identityType, chain, identifier)
+		return err
+	})
+}
+
+// LinkIdentity attaches a proven identity to an account. Linking an identity
+// the account already owns is a no-op.
+func (s *IdentityService) LinkIdentity(userID uint, identityType models.IdentityType, chain, identifier string) (*models.UserIdentity, error) {
+	var identity *models.UserIdentity
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		identity, err = s.link(tx, userID, identityType, chain, identifier)
+		return err
+	})
+	return identity, err
+}
+
+// ListIdentities returns every login method linked to the account
+func (s *IdentityService) ListIdentities(userID uint) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := s.db.Where("user_id = ?", userID).Order("type ASC, created_at ASC").Find(&identities).Error
+	return identities, err
+}
+
+// SetPrimary makes an identity the primary one of its type
+func (s *IdentityService) SetPrimary(userID, identityID uint) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.lockIdentity(tx, userID, identityID, &identity); err != nil {
+			return err
+		}
+		return s.makePrimary(tx, &identity)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// Unlink removes an identity. If it was primary, the oldest remaining
+// identity of the same type is promoted.
+func (s *IdentityService) Unlink(userID, identityID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var identity models.UserIdentity
+		if err := s.lockIdentity(tx, userID, identityID, &identity); err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&models.UserIdentity{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count <= 1 {
+			return ErrLastIdentity
+		}
+
+		if err := tx.Delete(&identity).Error; err != nil {
+			return err
+		}
+		if !identity.IsPrimary {
+			return nil
+		}
+
+		var successor models.UserIdentity
+		err := tx.Where("user_id = ? AND type = ?", userID, identity.Type).Order("created_at ASC").First(&successor).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return s.mirror(tx, userID, identity.Type, "")
+		}
+		if err != nil {
+			return err
+		}
+		return s.makePrimary(tx, &successor)
+	})
+}
+
+func (s *IdentityService) link(tx *gorm.DB, userID uint, identityType models.IdentityType, chain, identifier string) (*models.UserIdentity, error) {
+	identifier = NormalizeIdentifier(identityType, identifier)
+
+	var existing models.UserIdentity
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("type = ? AND chain = ? AND identifier = ?", identityType, chain, identifier).
+		First(&existing).Error
+	if err == nil {
+		if existing.UserID != userID {
+			return nil, ErrIdentityInUse
+		}
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var primaries int64
+	if err := tx.Model(&models.UserIdentity{}).
+		Where("user_id = ? AND type = ? AND is_primary = true", userID, identityType).
+		Count(&primaries).Error; err != nil {
+		return nil, err
+	}
+
+	identity := models.UserIdentity{
+		UserID:     userID,
+		Type:       identityType,
+		Chain:      chain,
+		Identifier: identifier,
+		VerifiedAt: time.Now(),
+	}
+	if err := tx.Create(&identity).Error; err != nil {
+		return nil, fmt.Errorf("failed to link identity: %w", err)
+	}
+
+	// The first identity of a type becomes its primary
+	if primaries == 0 {
+		if err := s.makePrimary(tx, &identity); err != nil {
+			return nil, err
+		}
+	}
+	return &identity, nil
+}
+
+func (s *IdentityService) lockIdentity(tx *gorm.DB, userID, identityID uint, identity *models.UserIdentity) error {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND user_id = ?", identityID, userID).
+		First(identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrIdentityNotFound
+	}
+	return err
+}
+
+func (s *IdentityService) makePrimary(tx *gorm.DB, identity *models.UserIdentity) error {
+	if err := tx.Model(&models.UserIdentity{}).
+		Where("user_id = ? AND type = ? AND id <> ?", identity.UserID, identity.Type, identity.ID).
+		Update("is_primary", false).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(identity).Update("is_primary", true).Error; err != nil {
+		return err
+	}
+	return s.mirror(tx, identity.UserID, identity.Type, identity.Identifier)
+}
+
+// mirror keeps the legacy single-value columns on User in sync with the primary identities
+func (s *IdentityService) mirror(tx *gorm.DB, userID uint, identityType models.IdentityType, identifier string) error {
+	// Unique columns are cleared to NULL rather than ''
+	var value interface{}
+	if identifier != "" {
+		value = identifier
+	}
+
+	var updates map[string]interface{}
+	switch identityType {
+	case models.IdentityWallet:
+		updates = map[string]interface{}{"wallet_address": value}
+	case models.IdentityEmail:
+		updates = map[string]interface{}{"email": value, "is_email_verified": identifier != ""}
+	case models.IdentityPhone:
+		updates = map[string]interface{}{"phone_number": value, "is_phone_verified": identifier != ""}
+	case models.IdentityLinkedIn:
+		updates = map[string]interface{}{"linkedin_id": identifier}
+	default:
+		return nil
+	}
+	return tx.Model(&models.User{}).Where("id = ?", userID).Updates(updates).Error
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJWKRoundTrip(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		algorithm string
+		key       crypto.PublicKey
+		keyType   string
+	}{
+		{"ES256", &ecKey.PublicKey, "EC"},
+		{"EdDSA", edPublic, "OKP"},
+	}
+	for _, tt := range tests {
+		jwk, err := NewJWK("kid-1", tt.algorithm, tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(&JWKS{Keys: []JWK{*jwk}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var set JWKS
+		if err := json.Unmarshal(data, &set); err != nil {
+			t.Fatal(err)
+		}
+		got := set.Keys[0]
+		if got.KeyType != tt.keyType || got.KeyID != "kid-1" || got.Algorithm != tt.algorithm || got.Use != "sig" {
+			t.Fatalf("%s: JWK = %+v", tt.algorithm, got)
+		}
+		key, err := got.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !key.(interface{ Equal(crypto.PublicKey) bool }).Equal(tt.key) {
+			t.Fatalf("%s: decoded a different key", tt.algorithm)
+		}
+	}
+
+	// Coordinates are padded to the curve size
+	jwk, _ := NewJWK("kid-1", "ES256", &ecKey.PublicKey)
+	if x, _ := base64.RawURLEncoding.DecodeString(jwk.X); len(x) != 32 {
+		t.Fatalf("x is %d bytes, want 32", len(x))
+	}
+}
+
+func TestJWKRejects(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk, _ := NewJWK("kid-1", "ES256", &ecKey.PublicKey)
+
+	offCurve := *jwk
+	offCurve.Y = offCurve.X
+	shortEd := JWK{KeyType: "OKP", Curve: "Ed25519", X: base64.RawURLEncoding.EncodeToString([]byte("short"))}
+	otherCurve := *jwk
+	otherCurve.Curve = "P-384"
+	for name, bad := range map[string]JWK{"off the curve": offCurve, "short Ed25519": shortEd, "unsupported curve": otherCurve} {
+		if _, err := bad.PublicKey(); err == nil {
+			t.Errorf("%s: decoded", name)
+		}
+	}
+	if _, err := NewJWK("kid-1", "RS256", "not a key"); err == nil {
+		t.Error("encoded an unsupported key")
+	}
+}
+
+// jwksServer publishes the Ed25519 keys in keys by kid and counts fetches
+type jwksServer struct {
+	keys    map[string]ed25519.PublicKey
+	fetches atomic.Int32
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.fetches.Add(1)
+	set := &JWKS{}
+	for kid, key := range s.keys {
+		jwk, _ := NewJWK(kid, "EdDSA", key)
+		set.Keys = append(set.Keys, *jwk)
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+func TestJWKSClientRefresh(t *testing.T) {
+	first, _, _ := ed25519.GenerateKey(rand.Reader)
+	second, _, _ := ed25519.GenerateKey(rand.Reader)
+	keys := &jwksServer{keys: map[string]ed25519.PublicKey{"first": first}}
+	server := httptest.NewServer(keys)
+	defer server.Close()
+	client := NewJWKSClient(server.URL)
+
+	key, err := client.PublicKey("first")
+	if err != nil || !first.Equal(key) {
+		t.Fatalf("first key: err = %v", err)
+	}
+	if _, err := client.PublicKey("first"); err != nil || keys.fetches.Load() != 1 {
+		t.Fatalf("cached key refetched: fetches=%d err=%v", keys.fetches.Load(), err)
+	}
+
+	// A key rotated in is picked up on its first use, but unknown kids can't
+	// make the client hammer the endpoint
+	keys.keys["second"] = second
+	if _, err := client.PublicKey("second"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("unknown kid right after a fetch: err = %v", err)
+	}
+	client.lastAttempt = time.Now().Add(-jwksMinRefreshInterval - time.Second)
+	key, err = client.PublicKey("second")
+	if err != nil || !second.Equal(key) {
+		t.Fatalf("rotated key: err = %v", err)
+	}
+	if _, err := client.PublicKey("unknown"); !errors.Is(err, ErrUnknownKey) || keys.fetches.Load() != 2 {
+		t.Fatalf("unknown kid: fetches=%d err=%v", keys.fetches.Load(), err)
+	}
+
+	// Cached keys outlive a brief outage of the endpoint
+	server.Close()
+	client.fetchedAt = time.Now().Add(-jwksCacheTTL - time.Second)
+	if key, err := client.PublicKey("first"); err != nil || !first.Equal(key) {
+		t.Fatalf("during an outage: err = %v", err)
+	}
+}
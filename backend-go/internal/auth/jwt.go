@@ -6,48 +6,71 @@ import (
 
 	"github.com/everest-an/dchat-backend/internal/config"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type Claims struct {
 	UserID        uint   `json:"user_id"`
 	WalletAddress string `json:"wallet_address"`
+	SessionID     uint   `json:"sid"`
 	jwt.RegisteredClaims
 }
 
 type JWTService struct {
-	secretKey       []byte
-	expirationHours int
+	keyring   *Keyring
+	keys      KeySet
+	accessTTL time.Duration
 }
 
-func NewJWTService(cfg *config.JWTConfig) *JWTService {
+// NewJWTService creates a service that signs with the keyring and verifies against it
+func NewJWTService(cfg *config.JWTConfig, keyring *Keyring) *JWTService {
 	return &JWTService{
-		secretKey:       []byte(cfg.SecretKey),
-		expirationHours: cfg.ExpirationHours,
+		keyring:   keyring,
+		keys:      keyring,
+		accessTTL: time.Minute * time.Duration(cfg.AccessTokenMinutes),
 	}
 }
 
-func (s *JWTService) GenerateToken(userID uint, walletAddress string) (string, error) {
-	claims := Claims{
+// NewJWTVerifier creates a verify-only service, e.g. backed by a JWKSClient
+func NewJWTVerifier(keys KeySet) *JWTService {
+	return &JWTService{keys: keys}
+}
+
+// GenerateToken issues a short-lived access token bound to a session.
+// Every token carries a unique ID (jti) so it can be denylisted.
+func (s *JWTService) GenerateToken(userID uint, walletAddress string, sessionID uint) (string, *Claims, error) {
+	if s.keyring == nil {
+		return "", nil, errors.New("token signing is not available")
+	}
+
+	now := time.Now()
+	claims := &Claims{
 		UserID:        userID,
 		WalletAddress: walletAddress,
+		SessionID:     sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * time.Duration(s.expirationHours))),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.accessTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.secretKey)
+	signed, err := s.keyring.Sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
 }
 
 func (s *JWTService) ValidateToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, errors.New("missing kid header")
 		}
-		return s.secretKey, nil
-	})
+		return s.keys.PublicKey(kid)
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
 
 	if err != nil {
 		return nil, err
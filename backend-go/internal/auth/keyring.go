@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	// Interval between rotation checks and keyring reloads
+	keyringRefreshInterval = time.Minute
+
+	// Serializes rotation across API replicas
+	keyringAdvisoryLock = 4361001
+)
+
+var (
+	ErrNoSigningKey = errors.New("no active signing key")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+// KeySet resolves the public key for a token's kid header
+type KeySet interface {
+	PublicKey(kid string) (crypto.PublicKey, error)
+}
+
+type keyringEntry struct {
+	kid        string
+	algorithm  string
+	privateKey crypto.Signer
+	publicKey  crypto.PublicKey
+	notBefore  time.Time
+	retireAt   time.Time
+}
+
+// Keyring holds the JWT signing keys. Keys are stored encrypted in the
+// database so every API replica signs with the same key, and are rotated
+// on a schedule. A new key is published in the JWKS an overlap window
+// before it starts signing, and a retired key stays published until every
+// token it signed has expired.
+type Keyring struct {
+	db        *gorm.DB
+	aead      cipher.AEAD
+	algorithm string
+	rotation  time.Duration
+	overlap   time.Duration
+	tokenTTL  time.Duration
+
+	mu   sync.RWMutex
+	keys []*keyringEntry
+}
+
+func NewKeyring(db *gorm.DB, cfg *config.JWTConfig) (*Keyring, error) {
+	// Derive the key-encryption key from the configured secret
+	kek := sha256.Sum256([]byte(cfg.SecretKey))
+	block, err := aes.NewCipher(kek[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Keyring{
+		db:        db,
+		aead:      aead,
+		algorithm: cfg.Algorithm,
+		rotation:  time.Duration(cfg.KeyRotationDays) * 24 * time.Hour,
+		overlap:   time.Duration(cfg.KeyOverlapHours) * time.Hour,
+		tokenTTL:  time.Duration(cfg.AccessTokenMinutes) * time.Minute,
+	}
+
+	if err := k.Rotate(); err != nil {
+		return nil, err
+	}
+	if err := k.Load(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Run periodically rotates and reloads keys so that replicas converge
+func (k *Keyring) Run() {
+	ticker := time.NewTicker(keyringRefreshInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := k.Rotate(); err != nil {
+			log.Printf("Failed to rotate signing keys: %v", err)
+		}
+		if err := k.Load(); err != nil {
+			log.Printf("Failed to reload signing keys: %v", err)
+		}
+	}
+}
+
+// Rotate creates the next signing key when the current one is about to retire
+func (k *Keyring) Rotate() error {
+	return k.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", keyringAdvisoryLock).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		var latest models.SigningKey
+		err := tx.Order("retire_at DESC").First(&latest).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var notBefore time.Time
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound) || !latest.RetireAt.After(now):
+			// No usable key at all: sign with a new one right away
+			notBefore = now
+		case latest.RetireAt.Sub(now) <= k.overlap:
+			// Pre-publish the successor so verifiers can cache it in time
+			notBefore = latest.RetireAt
+		default:
+			return nil
+		}
+
+		key, err := k.generate(notBefore)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(key).Error; err != nil {
+			return err
+		}
+
+		log.Printf("🔑 Signing key created: KID=%s, Algorithm=%s, NotBefore=%s", key.KID, key.Algorithm, key.NotBefore.Format(time.RFC3339))
+		return nil
+	})
+}
+
+// Load reads the published keys from the database
+func (k *Keyring) Load() error {
+	var rows []models.SigningKey
+	if err := k.db.Where("expires_at > ?", time.Now()).Order("not_before ASC").Find(&rows).Error; err != nil {
+		return err
+	}
+
+	keys := make([]*keyringEntry, 0, len(rows))
+	for _, row := range rows {
+		entry, err := k.decode(&row)
+		if err != nil {
+			return fmt.Errorf("failed to decode signing key %s: %w", row.KID, err)
+		}
+		keys = append(keys, entry)
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	return nil
+}
+
+// signingKey returns the newest key that is currently allowed to sign
+func (k *Keyring) signingKey() (*keyringEntry, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		key := k.keys[i]
+		if !key.notBefore.After(now) && now.Before(key.retireAt) {
+			return key, nil
+		}
+	}
+	return nil, ErrNoSigningKey
+}
+
+// Sign signs the claims with the active key and sets its kid header
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	key, err := k.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.algorithm), claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.privateKey)
+}
+
+// PublicKey implements KeySet
+func (k *Keyring) PublicKey(kid string) (crypto.PublicKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.kid == kid {
+			return key.publicKey, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// JWKS returns every published public key as a JSON Web Key Set
+func (k *Keyring) JWKS() *JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	// Newest first, so clients that pick the first key pick the current one
+	set := &JWKS{Keys: make([]JWK, 0, len(k.keys))}
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		key := k.keys[i]
+		jwk, err := NewJWK(key.kid, key.algorithm, key.publicKey)
+		if err != nil {
+			log.Printf("Failed to encode signing key %s: %v", key.kid, err)
+			continue
+		}
+		set.Keys = append(set.Keys, *jwk)
+	}
+	return set
+}
+
+func (k *Keyring) generate(notBefore time.Time) (*models.SigningKey, error) {
+	var signer crypto.Signer
+	switch k.algorithm {
+	case "ES256":
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		signer = privateKey
+	case "EdDSA":
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		signer = privateKey
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", k.algorithm)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := k.encrypt(privateDER)
+	if err != nil {
+		return nil, err
+	}
+
+	// The kid is derived from the public key so it is stable and unique
+	fingerprint := sha256.Sum256(publicDER)
+	retireAt := notBefore.Add(k.rotation)
+
+	return &models.SigningKey{
+		KID:                 hex.EncodeToString(fingerprint[:8]),
+		Algorithm:           k.algorithm,
+		PublicKey:           publicDER,
+		PrivateKeyEncrypted: encrypted,
+		NotBefore:           notBefore,
+		RetireAt:            retireAt,
+		ExpiresAt:           retireAt.Add(k.tokenTTL + k.overlap),
+	}, nil
+}
+
+func (k *Keyring) decode(row *models.SigningKey) (*keyringEntry, error) {
+	privateDER, err := k.decrypt(row.PrivateKeyEncrypted)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(privateDER)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key cannot sign")
+	}
+
+	return &keyringEntry{
+		kid:        row.KID,
+		algorithm:  row.Algorithm,
+		privateKey: signer,
+		publicKey:  signer.Public(),
+		notBefore:  row.NotBefore,
+		retireAt:   row.RetireAt,
+	}, nil
+}
+
+func (k *Keyring) encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return k.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (k *Keyring) decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < k.aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:k.aead.NonceSize()], ciphertext[k.aead.NonceSize():]
+	return k.aead.Open(nil, nonce, sealed, nil)
+}
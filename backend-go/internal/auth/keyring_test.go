@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	db := testutil.NewDB(t)
+	if err := db.Where("1 = 1").Delete(&models.SigningKey{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := NewKeyring(db, &testJWTConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := keyring.signingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Far from retirement, rotating does nothing
+	if err := keyring.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&models.SigningKey{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("%d keys, want 1", count)
+	}
+
+	// Within the overlap window the successor is published but doesn't sign yet
+	retireAt := time.Now().Add(time.Hour)
+	if err := db.Model(&models.SigningKey{}).Where("kid = ?", current.kid).Update("retire_at", retireAt).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Load(); err != nil {
+		t.Fatal(err)
+	}
+	set := keyring.JWKS()
+	if len(set.Keys) != 2 || set.Keys[1].KeyID != current.kid {
+		t.Fatalf("JWKS = %+v, want the successor first and the current key", set.Keys)
+	}
+	successor := set.Keys[0].KeyID
+	if signer, _ := keyring.signingKey(); signer.kid != current.kid {
+		t.Fatalf("signing with %s before the current key retired", signer.kid)
+	}
+
+	// Tokens carry the kid of the key that signed them
+	jwtService := NewJWTService(&testJWTConfig, keyring)
+	token, _, err := jwtService.GenerateToken(1, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	if err != nil || parsed.Header["kid"] != current.kid {
+		t.Fatalf("kid = %v, err = %v", parsed.Header["kid"], err)
+	}
+
+	// Once the current key retires the successor signs
+	if err := db.Model(&models.SigningKey{}).Where("kid = ?", current.kid).Update("retire_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&models.SigningKey{}).Where("kid = ?", successor).Update("not_before", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if signer, err := keyring.signingKey(); err != nil || signer.kid != successor {
+		t.Fatalf("signing key = %v, err = %v, want the successor", signer, err)
+	}
+	// The retired key stays published until its tokens expire
+	if _, err := jwtService.ValidateToken(token); err != nil {
+		t.Fatalf("token signed by the retired key: %v", err)
+	}
+
+	// A keyring with another secret can't read the stored keys
+	other := testJWTConfig
+	other.SecretKey = "another secret"
+	if _, err := NewKeyring(db, &other); err == nil {
+		t.Fatal("decrypted signing keys with the wrong secret")
+	}
+}
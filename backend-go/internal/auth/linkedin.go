@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+)
+
+const (
+	linkedInTokenURL    = "https://www.linkedin.com/oauth/v2/accessToken"
+	linkedInUserInfoURL = "https://api.linkedin.com/v2/userinfo"
+)
+
+var ErrLinkedInNotConfigured = errors.New("LinkedIn login is not configured")
+
+// LinkedInProfile is the OpenID Connect userinfo of a LinkedIn member
+type LinkedInProfile struct {
+	Subject string `json:"sub"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+}
+
+// LinkedInClient proves control of a LinkedIn account by exchanging an
+// OAuth authorization code for the member's profile
+type LinkedInClient struct {
+	cfg    *config.LinkedInConfig
+	client *http.Client
+}
+
+func NewLinkedInClient(cfg *config.LinkedInConfig) *LinkedInClient {
+	return &LinkedInClient{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Exchange trades an authorization code for the profile of the member who granted it
+func (c *LinkedInClient) Exchange(code string) (*LinkedInProfile, error) {
+	if c.cfg.ClientID == "" || c.cfg.ClientSecret == "" {
+		return nil, ErrLinkedInNotConfigured
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.cfg.RedirectURI},
+		"client_id":     {c.cfg.ClientID},
+		"client_secret": {c.cfg.ClientSecret},
+	}
+	resp, err := c.client.Post(linkedInTokenURL, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange LinkedIn code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("LinkedIn rejected authorization code: status %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || token.AccessToken == "" {
+		return nil, errors.New("invalid LinkedIn token response")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, linkedInUserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	resp, err = c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch LinkedIn profile: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch LinkedIn profile: status %d", resp.StatusCode)
+	}
+
+	var profile LinkedInProfile
+	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil || profile.Subject == "" {
+		return nil, errors.New("invalid LinkedIn profile response")
+	}
+	return &profile, nil
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/everest-an/dchat-backend/pkg/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const nonceKeyPrefix = "siwe:nonce:"
+
+var ErrNonceNotFound = errors.New("nonce not found or already used")
+
+// NonceStore keeps issued login nonces in Redis until they are consumed or expire
+type NonceStore struct {
+	redis *utils.RedisClient
+	ttl   time.Duration
+}
+
+func NewNonceStore(redisClient *utils.RedisClient, ttl time.Duration) *NonceStore {
+	return &NonceStore{
+		redis: redisClient,
+		ttl:   ttl,
+	}
+}
+
+// Save records a nonce as issued to the given wallet address on a chain
+func (s *NonceStore) Save(nonce, walletAddress string, chainID int64) error {
+	if err := s.redis.Set(nonceKeyPrefix+nonce, nonceOwner(walletAddress, chainID), s.ttl); err != nil {
+		return fmt.Errorf("failed to store nonce: %w", err)
+	}
+	return nil
+}
+
+// Consume removes the nonce and checks that it was issued to the given wallet
+// on the given chain. A nonce can only be consumed once, even by concurrent
+// requests.
+func (s *NonceStore) Consume(nonce, walletAddress string, chainID int64) error {
+	owner, err := s.redis.GetDel(nonceKeyPrefix + nonce)
+	if errors.Is(err, redis.Nil) {
+		return ErrNonceNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to consume nonce: %w", err)
+	}
+
+	if owner != nonceOwner(walletAddress, chainID) {
+		return ErrNonceNotFound
+	}
+	return nil
+}
+
+func nonceOwner(walletAddress string, chainID int64) string {
+	return fmt.Sprintf("%s@%d", strings.ToLower(walletAddress), chainID)
+}
+
+// TTL returns how long an issued nonce stays valid
+func (s *NonceStore) TTL() time.Duration {
+	return s.ttl
+}
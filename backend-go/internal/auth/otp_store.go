@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/everest-an/dchat-backend/pkg/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	otpKeyPrefix         = "otp:code:"
+	otpAttemptsKeyPrefix = "otp:attempts:"
+	otpRateKeyPrefix     = "otp:rate:"
+	otpDigits            = 6
+)
+
+var (
+	ErrInvalidOTP          = errors.New("invalid or expired code")
+	ErrOTPAttemptsExceeded = errors.New("too many wrong codes, request a new one")
+	ErrOTPRateLimited      = errors.New("too many codes requested, try again later")
+)
+
+// OTPStore keeps hashed one-time codes in Redis with a TTL and a limit on
+// verification attempts, and rate-limits how often codes are issued
+type OTPStore struct {
+	redis             *utils.RedisClient
+	ttl               time.Duration
+	maxAttempts       int64
+	numberHourlyLimit int64
+	ipHourlyLimit     int64
+}
+
+func NewOTPStore(redisClient *utils.RedisClient, ttl time.Duration, maxAttempts, numberHourlyLimit, ipHourlyLimit int) *OTPStore {
+	return &OTPStore{
+		redis:             redisClient,
+		ttl:               ttl,
+		maxAttempts:       int64(maxAttempts),
+		numberHourlyLimit: int64(numberHourlyLimit),
+		ipHourlyLimit:     int64(ipHourlyLimit),
+	}
+}
+
+// Issue generates a code for the given key, replacing any earlier one.
+// The phone number and client IP are counted against their hourly limits.
+func (s *OTPStore) Issue(key, phone, ipAddress string) (string, error) {
+	if err := s.checkRate("number:"+phone, s.numberHourlyLimit); err != nil {
+		return "", err
+	}
+	if err := s.checkRate("ip:"+ipAddress, s.ipHourlyLimit); err != nil {
+		return "", err
+	}
+
+	code, err := generateOTP()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.redis.Set(otpKeyPrefix+key, hashOTP(key, code), s.ttl); err != nil {
+		return "", fmt.Errorf("failed to store code: %w", err)
+	}
+	if err := s.redis.Delete(otpAttemptsKeyPrefix + key); err != nil {
+		return "", fmt.Errorf("failed to store code: %w", err)
+	}
+	return code, nil
+}
+
+// Verify checks a code and consumes it on success. After maxAttempts wrong
+// guesses the code is discarded and a new one has to be requested.
+func (s *OTPStore) Verify(key, code string) error {
+	attempts, err := s.redis.IncrWithTTL(otpAttemptsKeyPrefix+key, s.ttl)
+	if err != nil {
+		return fmt.Errorf("failed to verify code: %w", err)
+	}
+	if attempts > s.maxAttempts {
+		_ = s.redis.Delete(otpKeyPrefix + key)
+		return ErrOTPAttemptsExceeded
+	}
+
+	stored, err := s.redis.Get(otpKeyPrefix + key)
+	if errors.Is(err, redis.Nil) {
+		return ErrInvalidOTP
+	}
+	if err != nil {
+		return fmt.Errorf("failed to verify code: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(hashOTP(key, code))) != 1 {
+		return ErrInvalidOTP
+	}
+
+	// Only one concurrent request can consume the code
+	if _, err := s.redis.GetDel(otpKeyPrefix + key); errors.Is(err, redis.Nil) {
+		return ErrInvalidOTP
+	} else if err != nil {
+		return fmt.Errorf("failed to consume code: %w", err)
+	}
+	_ = s.redis.Delete(otpAttemptsKeyPrefix + key)
+	return nil
+}
+
+// TTL returns how long an issued code stays valid
+func (s *OTPStore) TTL() time.Duration {
+	return s.ttl
+}
+
+func (s *OTPStore) checkRate(subject string, limit int64) error {
+	count, err := s.redis.IncrWithTTL(otpRateKeyPrefix+subject, time.Hour)
+	if err != nil {
+		return fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	if count > limit {
+		return ErrOTPRateLimited
+	}
+	return nil
+}
+
+func generateOTP() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < otpDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", otpDigits, n), nil
+}
+
+// hashOTP binds the code to its key so a stored hash is only valid for that key
+func hashOTP(key, code string) string {
+	return hashToken(key + ":" + code)
+}
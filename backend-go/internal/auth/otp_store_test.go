@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/testutil"
+)
+
+func TestOTPStoreVerify(t *testing.T) {
+	redisClient, server := testutil.NewRedis(t)
+	store := NewOTPStore(redisClient, 5*time.Minute, 3, 10, 10)
+
+	code, err := store.Issue("login:+14155550123", "+14155550123", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != otpDigits {
+		t.Fatalf("code = %q, want %d digits", code, otpDigits)
+	}
+	// Only a hash of the code is stored
+	if stored, _ := server.Get(otpKeyPrefix + "login:+14155550123"); stored == code || stored == "" {
+		t.Fatalf("stored %q for code %q", stored, code)
+	}
+
+	if err := store.Verify("verify:1:+14155550123", code); !errors.Is(err, ErrInvalidOTP) {
+		t.Fatalf("code under another key: err = %v", err)
+	}
+	if err := store.Verify("login:+14155550123", code); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Verify("login:+14155550123", code); !errors.Is(err, ErrInvalidOTP) {
+		t.Fatalf("reused code: err = %v", err)
+	}
+
+	// Codes expire with their TTL
+	code, err = store.Issue("login:+14155550123", "+14155550123", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.FastForward(5*time.Minute + time.Second)
+	if err := store.Verify("login:+14155550123", code); !errors.Is(err, ErrInvalidOTP) {
+		t.Fatalf("expired code: err = %v", err)
+	}
+}
+
+func TestOTPStoreAttemptLimit(t *testing.T) {
+	redisClient, _ := testutil.NewRedis(t)
+	store := NewOTPStore(redisClient, 5*time.Minute, 3, 10, 10)
+
+	code, err := store.Issue("login:+14155550123", "+14155550123", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < 3; i++ {
+		if err := store.Verify("login:+14155550123", wrong); !errors.Is(err, ErrInvalidOTP) {
+			t.Fatalf("guess %d: err = %v", i+1, err)
+		}
+	}
+	// The right code no longer works once the guesses are used up
+	if err := store.Verify("login:+14155550123", code); !errors.Is(err, ErrOTPAttemptsExceeded) {
+		t.Fatalf("after 3 wrong guesses: err = %v", err)
+	}
+
+	// A new code comes with new attempts
+	code, err = store.Issue("login:+14155550123", "+14155550123", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Verify("login:+14155550123", code); err != nil {
+		t.Fatalf("new code: %v", err)
+	}
+}
+
+func TestOTPStoreRateLimits(t *testing.T) {
+	redisClient, server := testutil.NewRedis(t)
+	store := NewOTPStore(redisClient, 5*time.Minute, 3, 2, 3)
+
+	// Each number gets two codes an hour, whatever the IP
+	for i := 0; i < 2; i++ {
+		if _, err := store.Issue("login:+14155550123", "+14155550123", fmt.Sprintf("10.0.0.%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.Issue("login:+14155550123", "+14155550123", "10.0.1.1"); !errors.Is(err, ErrOTPRateLimited) {
+		t.Fatalf("number over the limit: err = %v", err)
+	}
+
+	// Each IP gets three, whatever the number
+	for i := 0; i < 3; i++ {
+		phone := fmt.Sprintf("+1415555020%d", i)
+		if _, err := store.Issue("login:"+phone, phone, "10.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.Issue("login:+14155550299", "+14155550299", "10.0.2.1"); !errors.Is(err, ErrOTPRateLimited) {
+		t.Fatalf("IP over the limit: err = %v", err)
+	}
+
+	// The limits reset after an hour
+	server.FastForward(time.Hour + time.Second)
+	if _, err := store.Issue("login:+14155550123", "+14155550123", "10.0.2.1"); err != nil {
+		t.Fatalf("after an hour: %v", err)
+	}
+}
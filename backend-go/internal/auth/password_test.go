@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPasswordRoundTrip(t *testing.T) {
+	hash, err := HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=4$") {
+		t.Fatalf("unexpected hash format: %s", hash)
+	}
+
+	valid, needsRehash, err := VerifyPassword(hash, "correct horse battery")
+	if err != nil || !valid || needsRehash {
+		t.Fatalf("right password: valid=%v needsRehash=%v err=%v", valid, needsRehash, err)
+	}
+	valid, _, err = VerifyPassword(hash, "wrong horse battery")
+	if err != nil || valid {
+		t.Fatalf("wrong password: valid=%v err=%v", valid, err)
+	}
+}
+
+func TestVerifyPasswordRehash(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse battery"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, needsRehash, err := VerifyPassword(string(legacy), "correct horse battery")
+	if err != nil || !valid || !needsRehash {
+		t.Fatalf("bcrypt hash: valid=%v needsRehash=%v err=%v", valid, needsRehash, err)
+	}
+
+	// An argon2id hash with fewer iterations than the current parameters
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte("correct horse battery"), salt, 1, argonMemory, argonThreads, argonKeyLen)
+	weaker := fmt.Sprintf("$argon2id$v=%d$m=%d,t=1,p=%d$%s$%s", argon2.Version, argonMemory, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	valid, needsRehash, err = VerifyPassword(weaker, "correct horse battery")
+	if err != nil || !valid || !needsRehash {
+		t.Fatalf("weaker argon2id hash: valid=%v needsRehash=%v err=%v", valid, needsRehash, err)
+	}
+}
+
+func TestVerifyPasswordUnsupportedHash(t *testing.T) {
+	if _, _, err := VerifyPassword("plaintext", "plaintext"); err == nil {
+		t.Fatal("expected an error for an unknown hash format")
+	}
+}
+
+func TestVerifyDummyPassword(t *testing.T) {
+	verifyDummyPassword("anything")
+	if !strings.HasPrefix(dummyHash, "$argon2id$") {
+		t.Fatalf("dummy hash should use the current algorithm: %q", dummyHash)
+	}
+}
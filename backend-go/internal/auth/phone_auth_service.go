@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/sms"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidPhone = errors.New("phone number must be in international format, e.g. +14155550123")
+
+	e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+)
+
+// PhoneAuthService verifies phone numbers with one-time SMS codes, both to
+// link a number to an account and to log in with it
+type PhoneAuthService struct {
+	db         *gorm.DB
+	otps       *OTPStore
+	identities *IdentityService
+	sessions   *SessionService
+	sender     sms.SMSSender
+}
+
+func NewPhoneAuthService(db *gorm.DB, otps *OTPStore, identities *IdentityService, sessions *SessionService, sender sms.SMSSender) *PhoneAuthService {
+	return &PhoneAuthService{
+		db:         db,
+		otps:       otps,
+		identities: identities,
+		sessions:   sessions,
+		sender:     sender,
+	}
+}
+
+// RequestLoginCode texts a login code to the number
+func (s *PhoneAuthService) RequestLoginCode(phone, ipAddress string) error {
+	phone, err := normalizePhone(phone)
+	if err != nil {
+		return err
+	}
+	return s.sendCode(loginOTPKey(phone), phone, ipAddress)
+}
+
+// Login checks a login code and starts a session. An account is created the
+// first time an unknown number logs in.
+func (s *PhoneAuthService) Login(phone, code, device, ipAddress string) (*TokenPair, *models.User, bool, error) {
+	phone, err := normalizePhone(phone)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if err := s.otps.Verify(loginOTPKey(phone), code); err != nil {
+		return nil, nil, false, err
+	}
+
+	isNewUser := false
+	user, err := s.identities.FindUser(models.IdentityPhone, "", phone)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if err := s.releaseUnverified(phone, 0); err != nil {
+			return nil, nil, false, err
+		}
+		// Only the last digits end up in the public profile
+		suffix := phone[len(phone)-4:]
+		user = &models.User{
+			Name:     fmt.Sprintf("User_%s", suffix),
+			Username: fmt.Sprintf("user_%s", suffix),
+		}
+		if err := s.identities.CreateUserWithIdentity(user, models.IdentityPhone, "", phone); err != nil {
+			return nil, nil, false, err
+		}
+		isNewUser = true
+	} else if err != nil {
+		return nil, nil, false, err
+	}
+
+	tokens, err := s.sessions.CreateSession(user, device, ipAddress)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return tokens, user, isNewUser, nil
+}
+
+// RequestVerificationCode texts a code that links the number to the account
+func (s *PhoneAuthService) RequestVerificationCode(userID uint, phone, ipAddress string) error {
+	phone, err := normalizePhone(phone)
+	if err != nil {
+		return err
+	}
+
+	if owner, err := s.identities.FindUser(models.IdentityPhone, "", phone); err == nil && owner.ID != userID {
+		return ErrIdentityInUse
+	} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return s.sendCode(verifyOTPKey(userID, phone), phone, ipAddress)
+}
+
+// VerifyPhone checks a verification code and links the number to the account
+func (s *PhoneAuthService) VerifyPhone(userID uint, phone, code string) (*models.UserIdentity, error) {
+	phone, err := normalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.otps.Verify(verifyOTPKey(userID, phone), code); err != nil {
+		return nil, err
+	}
+
+	if err := s.releaseUnverified(phone, userID); err != nil {
+		return nil, err
+	}
+	return s.identities.LinkIdentity(userID, models.IdentityPhone, "", phone)
+}
+
+func (s *PhoneAuthService) sendCode(key, phone, ipAddress string) error {
+	code, err := s.otps.Issue(key, phone, ipAddress)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Your dChat code is %s. It expires in %d minutes.", code, int(s.otps.TTL().Minutes()))
+	if err := s.sender.Send(phone, body); err != nil {
+		return fmt.Errorf("failed to send code: %w", err)
+	}
+	return nil
+}
+
+// releaseUnverified clears the number from accounts that stored it without
+// verifying it, so an unproven claim cannot block the verified owner
+func (s *PhoneAuthService) releaseUnverified(phone string, userID uint) error {
+	return s.db.Model(&models.User{}).
+		Where("phone_number = ? AND is_phone_verified = false AND id <> ?", phone, userID).
+		Update("phone_number", nil).Error
+}
+
+func loginOTPKey(phone string) string {
+	return "login:" + phone
+}
+
+func verifyOTPKey(userID uint, phone string) string {
+	return fmt.Sprintf("verify:%d:%s", userID, phone)
+}
+
+func normalizePhone(phone string) (string, error) {
+	phone = NormalizeIdentifier(models.IdentityPhone, phone)
+	if !e164Pattern.MatchString(phone) {
+		return "", ErrInvalidPhone
+	}
+	return phone, nil
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+)
+
+// recordingSender keeps the codes it would have texted
+type recordingSender struct {
+	codes map[string]string
+}
+
+func (s *recordingSender) Send(to, body string) error {
+	_, code, _ := strings.Cut(body, "code is ")
+	s.codes[to] = code[:otpDigits]
+	return nil
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  string
+		err   error
+	}{
+		{"+14155550123", "+14155550123", nil},
+		{" +1 (415) 555-0123 ", "+14155550123", nil},
+		{"14155550123", "", ErrInvalidPhone},
+		{"+0123456789", "", ErrInvalidPhone},
+		{"+12345", "", ErrInvalidPhone},
+	}
+	for _, tt := range tests {
+		got, err := normalizePhone(tt.phone)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("normalizePhone(%q) = %q, %v, want %q, %v", tt.phone, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestPhoneLogin(t *testing.T) {
+	db := testutil.NewDB(t)
+	redisClient, _ := testutil.NewRedis(t)
+	sender := &recordingSender{codes: map[string]string{}}
+	identities := NewIdentityService(db)
+	service := NewPhoneAuthService(db, NewOTPStore(redisClient, 5*time.Minute, 3, 5, 20), identities, newTestSessionService(t, db), sender)
+
+	// Someone stored the number on their profile without proving it
+	squatter := testutil.NewUser(t, db, "Squatter")
+	if err := db.Model(squatter).Update("phone_number", "+14155550123").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.RequestLoginCode("+1 415 555 0123", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	code := sender.codes["+14155550123"]
+	if _, _, _, err := service.Login("+14155550123", "not it", "test", "10.0.0.1"); !errors.Is(err, ErrInvalidOTP) {
+		t.Fatalf("wrong code: err = %v", err)
+	}
+	tokens, user, isNew, err := service.Login("+14155550123", code, "test", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isNew || tokens.AccessToken == "" || user.Name != "User_0123" {
+		t.Fatalf("first login: new=%v user=%+v", isNew, user)
+	}
+	var released models.User
+	if err := db.First(&released, squatter.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if released.PhoneNumber != "" {
+		t.Fatalf("unverified claim kept the number: %v", released.PhoneNumber)
+	}
+
+	// The next login finds the same account
+	if err := service.RequestLoginCode("+14155550123", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	_, again, isNew, err := service.Login("+14155550123", sender.codes["+14155550123"], "test", "10.0.0.1")
+	if err != nil || isNew || again.ID != user.ID {
+		t.Fatalf("second login: user=%+v new=%v err=%v", again, isNew, err)
+	}
+}
+
+func TestVerifyPhone(t *testing.T) {
+	db := testutil.NewDB(t)
+	redisClient, _ := testutil.NewRedis(t)
+	sender := &recordingSender{codes: map[string]string{}}
+	service := NewPhoneAuthService(db, NewOTPStore(redisClient, 5*time.Minute, 3, 5, 20), NewIdentityService(db), nil, sender)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+
+	if err := service.RequestVerificationCode(alice.ID, "+14155550123", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	// A code texted for Alice doesn't verify the number for Bob
+	if _, err := service.VerifyPhone(bob.ID, "+14155550123", sender.codes["+14155550123"]); !errors.Is(err, ErrInvalidOTP) {
+		t.Fatalf("another user's code: err = %v", err)
+	}
+	identity, err := service.VerifyPhone(alice.ID, "+14155550123", sender.codes["+14155550123"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.UserID != alice.ID {
+		t.Fatalf("identity = %+v", identity)
+	}
+
+	if err := service.RequestVerificationCode(bob.ID, "+14155550123", "10.0.0.1"); !errors.Is(err, ErrIdentityInUse) {
+		t.Fatalf("number linked to another account: err = %v", err)
+	}
+}
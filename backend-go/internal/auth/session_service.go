@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, session revoked")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// TokenPair is returned on login and on every refresh
+type TokenPair struct {
+	AccessToken           string    `json:"token"`
+	AccessTokenExpiresAt  time.Time `json:"token_expires_at"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+	SessionID             uint      `json:"session_id"`
+}
+
+// SessionService manages signed-in devices and their rotating refresh tokens
+type SessionService struct {
+	db         *gorm.DB
+	jwtService *JWTService
+	denylist   *TokenDenylist
+	refreshTTL time.Duration
+	// Called for every session revoked, to disconnect its devices
+	onRevoke func(userID, sessionID uint)
+}
+
+func NewSessionService(db *gorm.DB, jwtService *JWTService, denylist *TokenDenylist, refreshTTL time.Duration) *SessionService {
+	return &SessionService{
+		db:         db,
+		jwtService: jwtService,
+		denylist:   denylist,
+		refreshTTL: refreshTTL,
+	}
+}
+
+// OnRevoke registers a function called with each session that is revoked
+func (s *SessionService) OnRevoke(fn func(userID, sessionID uint)) {
+	s.onRevoke = fn
+}
+
+// CreateSession starts a new session for a user who just logged in
+func (s *SessionService) CreateSession(user *models.User, device, ipAddress string) (*TokenPair, error) {
+	var pair *TokenPair
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		session := models.Session{
+			UserID:     user.ID,
+			Device:     device,
+			IPAddress:  ipAddress,
+			LastUsedAt: now,
+			ExpiresAt:  now.Add(s.refreshTTL),
+		}
+		if err := tx.Create(&session).Error; err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+
+		var err error
+		pair, err = s.issueTokens(tx, &session, user)
+		return err
+	})
+	return pair, err
+}
+
+// Refresh rotates a refresh token and issues a new access token.
+// Presenting a token that was already rotated revokes the whole session.
+func (s *SessionService) Refresh(refreshToken, device, ipAddress string) (*TokenPair, error) {
+	var pair *TokenPair
+	reused := false
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var token models.RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(refreshToken)).
+			First(&token).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+
+		var session models.Session
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&session, token.SessionID).Error; err != nil {
+			return err
+		}
+
+		if token.RotatedAt != nil {
+			// The token was already exchanged: someone else holds a copy
+			reused = true
+			log.Printf("⚠️ Refresh token reuse detected: SessionID=%d, UserID=%d", session.ID, session.UserID)
+			return s.revoke(tx, &session)
+		}
+
+		now := time.Now()
+		if !session.IsActive() || now.After(token.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		if err := tx.Model(&token).Update("rotated_at", now).Error; err != nil {
+			return err
+		}
+
+		var user models.User
+		if err := tx.First(&user, session.UserID).Error; err != nil {
+			return err
+		}
+
+		session.LastUsedAt = now
+		session.ExpiresAt = now.Add(s.refreshTTL)
+		session.IPAddress = ipAddress
+		if device != "" {
+			session.Device = device
+		}
+
+		pair, err = s.issueTokens(tx, &session, &user)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, ErrRefreshTokenReused
+	}
+	return pair, nil
+}
+
+// Logout revokes the session behind an access token along with the token itself
+func (s *SessionService) Logout(claims *Claims) error {
+	if err := s.denylist.Revoke(claims.ID, claims.ExpiresAt.Time); err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+
+	err := s.RevokeSession(claims.UserID, claims.SessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
+	}
+	return err
+}
+
+// ListSessions returns the user's active sessions, most recently used first
+func (s *SessionService) ListSessions(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := s.db.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession ends one of the user's sessions and denylists its access token
+func (s *SessionService) RevokeSession(userID, sessionID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var session models.Session
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", sessionID, userID).
+			First(&session).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return s.revoke(tx, &session)
+	})
+}
+
+// RevokeOtherSessions ends every session of the user except the current one
+func (s *SessionService) RevokeOtherSessions(userID, currentSessionID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var sessions []models.Session
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, currentSessionID).
+			Find(&sessions).Error
+		if err != nil {
+			return err
+		}
+		for i := range sessions {
+			if err := s.revoke(tx, &sessions[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SessionService) revoke(tx *gorm.DB, session *models.Session) error {
+	if session.RevokedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	if err := tx.Model(session).Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.RefreshToken{}).
+		Where("session_id = ? AND rotated_at IS NULL", session.ID).
+		Update("rotated_at", now).Error; err != nil {
+		return err
+	}
+
+	// Access tokens issued before the latest are still unexpired too
+	if err := s.denylist.RevokeSession(session.ID, session.AccessExpiresAt); err != nil {
+		return err
+	}
+	if s.onRevoke != nil {
+		s.onRevoke(session.UserID, session.ID)
+	}
+	return nil
+}
+
+func (s *SessionService) issueTokens(tx *gorm.DB, session *models.Session, user *models.User) (*TokenPair, error) {
+	accessToken, claims, err := s.jwtService.GenerateToken(user.ID, user.WalletAddress, session.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
+	}
+
+	refreshToken, err := generateOpaqueToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	token := models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: session.ExpiresAt,
+	}
+	if err := tx.Create(&token).Error; err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	session.AccessTokenID = claims.ID
+	session.AccessExpiresAt = claims.ExpiresAt.Time
+	if err := tx.Save(session).Error; err != nil {
+		return nil, fmt.Errorf("failed to update session: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:           accessToken,
+		AccessTokenExpiresAt:  claims.ExpiresAt.Time,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: session.ExpiresAt,
+		SessionID:             session.ID,
+	}, nil
+}
+
+// generateOpaqueToken returns 32 random bytes encoded for use in URLs and headers
+func generateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the SHA-256 hex digest stored instead of the raw token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
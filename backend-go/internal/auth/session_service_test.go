@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+	"gorm.io/gorm"
+)
+
+var testJWTConfig = config.JWTConfig{
+	SecretKey:          "test secret",
+	AccessTokenMinutes: 15,
+	RefreshTokenDays:   30,
+	Algorithm:          "ES256",
+	KeyRotationDays:    30,
+	KeyOverlapHours:    24,
+}
+
+func newTestSessionService(t *testing.T, db *gorm.DB) *SessionService {
+	t.Helper()
+	keyring, err := NewKeyring(db, &testJWTConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redisClient, _ := testutil.NewRedis(t)
+	return NewSessionService(db, NewJWTService(&testJWTConfig, keyring), NewTokenDenylist(redisClient), 30*24*time.Hour)
+}
+
+// accepted validates an access token the way AuthMiddleware does
+func accepted(t *testing.T, service *SessionService, accessToken string) bool {
+	t.Helper()
+	claims, err := service.jwtService.ValidateToken(accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := service.denylist.IsTokenRevoked(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return !revoked
+}
+
+func TestRefreshRotation(t *testing.T) {
+	db := testutil.NewDB(t)
+	service := newTestSessionService(t, db)
+	user := testutil.NewUser(t, db, "Alice")
+
+	first, err := service.CreateSession(user, "phone", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.Refresh(first.RefreshToken, "", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.SessionID != first.SessionID || second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Fatalf("refresh did not rotate within the session: %+v", second)
+	}
+	if _, err := service.Refresh("not a token", "", "10.0.0.2"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("unknown token: err = %v", err)
+	}
+
+	var session models.Session
+	if err := db.First(&session, first.SessionID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if session.IPAddress != "10.0.0.2" || session.Device != "phone" {
+		t.Fatalf("session = %+v, want the refreshing IP and the original device", session)
+	}
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	db := testutil.NewDB(t)
+	service := newTestSessionService(t, db)
+	user := testutil.NewUser(t, db, "Alice")
+
+	var revoked []uint
+	service.OnRevoke(func(userID, sessionID uint) {
+		if userID == user.ID {
+			revoked = append(revoked, sessionID)
+		}
+	})
+
+	first, err := service.CreateSession(user, "phone", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.Refresh(first.RefreshToken, "", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Presenting the rotated token again revokes the whole session
+	if _, err := service.Refresh(first.RefreshToken, "", "10.0.0.3"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reused token: err = %v", err)
+	}
+	if _, err := service.Refresh(second.RefreshToken, "", "10.0.0.1"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("latest token of a revoked session: err = %v", err)
+	}
+	for _, token := range []string{first.AccessToken, second.AccessToken} {
+		if accepted(t, service, token) {
+			t.Fatal("access token of a revoked session is still accepted")
+		}
+	}
+	if len(revoked) != 1 || revoked[0] != first.SessionID {
+		t.Fatalf("revoked sessions = %v, want [%d]", revoked, first.SessionID)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	db := testutil.NewDB(t)
+	service := newTestSessionService(t, db)
+	user := testutil.NewUser(t, db, "Alice")
+
+	tokens, err := service.CreateSession(user, "phone", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := service.jwtService.ValidateToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Logout(claims); err != nil {
+		t.Fatal(err)
+	}
+	if accepted(t, service, tokens.AccessToken) {
+		t.Fatal("access token accepted after logout")
+	}
+	if _, err := service.Refresh(tokens.RefreshToken, "", "10.0.0.1"); err == nil {
+		t.Fatal("refreshed a logged-out session")
+	}
+	// Logging out twice is harmless
+	if err := service.Logout(claims); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	db := testutil.NewDB(t)
+	service := newTestSessionService(t, db)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+
+	phone, err := service.CreateSession(alice, "phone", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop, err := service.CreateSession(alice, "laptop", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tablet, err := service.CreateSession(alice, "tablet", "10.0.0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tokens issued before the latest refresh are still unexpired
+	stale := laptop.AccessToken
+	if laptop, err = service.Refresh(laptop.RefreshToken, "", "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.RevokeSession(bob.ID, laptop.SessionID); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("another user's session: err = %v", err)
+	}
+	if err := service.RevokeSession(alice.ID, laptop.SessionID); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{stale, laptop.AccessToken} {
+		if accepted(t, service, token) {
+			t.Fatal("access token of a revoked session is still accepted")
+		}
+	}
+	if !accepted(t, service, phone.AccessToken) {
+		t.Fatal("revoking one session rejected another")
+	}
+
+	if err := service.RevokeOtherSessions(alice.ID, phone.SessionID); err != nil {
+		t.Fatal(err)
+	}
+	if accepted(t, service, tablet.AccessToken) || !accepted(t, service, phone.AccessToken) {
+		t.Fatal("revoking other sessions did not keep only the current one")
+	}
+	sessions, err := service.ListSessions(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != phone.SessionID {
+		t.Fatalf("sessions = %+v, want only the current one", sessions)
+	}
+}
@@ -0,0 +1,334 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/everest-an/dchat-backend/internal/config"
+)
+
+const (
+	siweVersion  = "1"
+	siwePreamble = " wants you to sign in with your Ethereum account:"
+
+	// Allowed clock drift between the client and the server
+	siweClockSkew = time.Minute
+)
+
+var (
+	ErrInvalidSIWEMessage = errors.New("invalid SIWE message")
+	ErrSIWEMismatch       = errors.New("SIWE message does not match this server")
+	ErrSIWEExpired        = errors.New("SIWE message has expired")
+	ErrSIWENotYetValid    = errors.New("SIWE message is not yet valid")
+	ErrInvalidSignature   = errors.New("invalid signature")
+	ErrUnsupportedChain   = errors.New("chain is not supported for sign-in")
+)
+
+// SIWEMessage is an EIP-4361 Sign-In with Ethereum message
+type SIWEMessage struct {
+	Domain         string
+	Address        string
+	Statement      string
+	URI            string
+	Version        string
+	ChainID        int64
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+	RequestID      string
+	Resources      []string
+}
+
+// String renders the message in the exact text format the wallet signs
+func (m *SIWEMessage) String() string {
+	var b strings.Builder
+
+	b.WriteString(m.Domain + siwePreamble + "\n")
+	b.WriteString(m.Address + "\n\n")
+	if m.Statement != "" {
+		b.WriteString(m.Statement + "\n")
+	}
+	b.WriteString("\n")
+
+	fmt.Fprintf(&b, "URI: %s\n", m.URI)
+	fmt.Fprintf(&b, "Version: %s\n", m.Version)
+	fmt.Fprintf(&b, "Chain ID: %d\n", m.ChainID)
+	fmt.Fprintf(&b, "Nonce: %s\n", m.Nonce)
+	fmt.Fprintf(&b, "Issued At: %s", m.IssuedAt.UTC().Format(time.RFC3339))
+	if m.ExpirationTime != nil {
+		fmt.Fprintf(&b, "\nExpiration Time: %s", m.ExpirationTime.UTC().Format(time.RFC3339))
+	}
+	if m.NotBefore != nil {
+		fmt.Fprintf(&b, "\nNot Before: %s", m.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if m.RequestID != "" {
+		fmt.Fprintf(&b, "\nRequest ID: %s", m.RequestID)
+	}
+	if len(m.Resources) > 0 {
+		b.WriteString("\nResources:")
+		for _, resource := range m.Resources {
+			b.WriteString("\n- " + resource)
+		}
+	}
+
+	return b.String()
+}
+
+// ParseSIWEMessage parses the EIP-4361 text format
+func ParseSIWEMessage(message string) (*SIWEMessage, error) {
+	lines := strings.Split(strings.ReplaceAll(message, "\r\n", "\n"), "\n")
+	if len(lines) < 4 {
+		return nil, fmt.Errorf("%w: message too short", ErrInvalidSIWEMessage)
+	}
+
+	var m SIWEMessage
+
+	// Header
+	if !strings.HasSuffix(lines[0], siwePreamble) {
+		return nil, fmt.Errorf("%w: missing preamble", ErrInvalidSIWEMessage)
+	}
+	m.Domain = strings.TrimSuffix(lines[0], siwePreamble)
+	if m.Domain == "" {
+		return nil, fmt.Errorf("%w: missing domain", ErrInvalidSIWEMessage)
+	}
+
+	// Address must be EIP-55 checksummed
+	m.Address = lines[1]
+	if !common.IsHexAddress(m.Address) || common.HexToAddress(m.Address).Hex() != m.Address {
+		return nil, fmt.Errorf("%w: address is not EIP-55 checksummed", ErrInvalidSIWEMessage)
+	}
+
+	if lines[2] != "" {
+		return nil, fmt.Errorf("%w: expected blank line after address", ErrInvalidSIWEMessage)
+	}
+
+	// Optional statement, surrounded by blank lines
+	i := 3
+	if lines[i] != "" {
+		m.Statement = lines[i]
+		i++
+		if i >= len(lines) || lines[i] != "" {
+			return nil, fmt.Errorf("%w: expected blank line after statement", ErrInvalidSIWEMessage)
+		}
+	}
+	i++
+
+	// Fields always appear in this order
+	field := func(name string, required bool) (string, error) {
+		prefix := name + ": "
+		if i < len(lines) && strings.HasPrefix(lines[i], prefix) {
+			value := strings.TrimPrefix(lines[i], prefix)
+			i++
+			return value, nil
+		}
+		if required {
+			return "", fmt.Errorf("%w: missing %s", ErrInvalidSIWEMessage, name)
+		}
+		return "", nil
+	}
+	timestamp := func(name, value string) (time.Time, error) {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: invalid %s", ErrInvalidSIWEMessage, name)
+		}
+		return t, nil
+	}
+
+	var err error
+	if m.URI, err = field("URI", true); err != nil {
+		return nil, err
+	}
+	if m.Version, err = field("Version", true); err != nil {
+		return nil, err
+	}
+
+	chainID, err := field("Chain ID", true)
+	if err != nil {
+		return nil, err
+	}
+	if m.ChainID, err = strconv.ParseInt(chainID, 10, 64); err != nil {
+		return nil, fmt.Errorf("%w: invalid Chain ID", ErrInvalidSIWEMessage)
+	}
+
+	if m.Nonce, err = field("Nonce", true); err != nil {
+		return nil, err
+	}
+	if len(m.Nonce) < 8 {
+		return nil, fmt.Errorf("%w: nonce too short", ErrInvalidSIWEMessage)
+	}
+
+	issuedAt, err := field("Issued At", true)
+	if err != nil {
+		return nil, err
+	}
+	if m.IssuedAt, err = timestamp("Issued At", issuedAt); err != nil {
+		return nil, err
+	}
+
+	expirationTime, err := field("Expiration Time", false)
+	if err != nil {
+		return nil, err
+	}
+	if expirationTime != "" {
+		t, err := timestamp("Expiration Time", expirationTime)
+		if err != nil {
+			return nil, err
+		}
+		m.ExpirationTime = &t
+	}
+
+	notBefore, err := field("Not Before", false)
+	if err != nil {
+		return nil, err
+	}
+	if notBefore != "" {
+		t, err := timestamp("Not Before", notBefore)
+		if err != nil {
+			return nil, err
+		}
+		m.NotBefore = &t
+	}
+
+	if m.RequestID, err = field("Request ID", false); err != nil {
+		return nil, err
+	}
+
+	if i < len(lines) && lines[i] == "Resources:" {
+		i++
+		for i < len(lines) && strings.HasPrefix(lines[i], "- ") {
+			m.Resources = append(m.Resources, strings.TrimPrefix(lines[i], "- "))
+			i++
+		}
+	}
+
+	if i != len(lines) {
+		return nil, fmt.Errorf("%w: unexpected content after fields", ErrInvalidSIWEMessage)
+	}
+
+	return &m, nil
+}
+
+// SIWEService issues and verifies Sign-In with Ethereum messages
+type SIWEService struct {
+	cfg *config.SIWEConfig
+	// Accepted chains; messages are issued for the first unless asked otherwise
+	chainIDs    []int64
+	nonces      *NonceStore
+	web3Service *Web3Service
+}
+
+func NewSIWEService(cfg *config.SIWEConfig, chainIDs []int64, nonces *NonceStore, web3Service *Web3Service) *SIWEService {
+	return &SIWEService{
+		cfg:         cfg,
+		chainIDs:    chainIDs,
+		nonces:      nonces,
+		web3Service: web3Service,
+	}
+}
+
+// IssueMessage creates a SIWE message for the wallet on a chain and
+// remembers its nonce. A chainID of 0 picks the primary chain.
+func (s *SIWEService) IssueMessage(walletAddress string, chainID int64) (*SIWEMessage, error) {
+	if !common.IsHexAddress(walletAddress) {
+		return nil, errors.New("invalid wallet address format")
+	}
+	if chainID == 0 {
+		chainID = s.chainIDs[0]
+	}
+	if !s.supportsChain(chainID) {
+		return nil, ErrUnsupportedChain
+	}
+
+	nonce, err := s.web3Service.GenerateNonce()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	if err := s.nonces.Save(nonce, walletAddress, chainID); err != nil {
+		return nil, err
+	}
+
+	issuedAt := time.Now().UTC().Truncate(time.Second)
+	expirationTime := issuedAt.Add(s.nonces.TTL())
+
+	return &SIWEMessage{
+		Domain:         s.cfg.Domain,
+		Address:        common.HexToAddress(walletAddress).Hex(),
+		Statement:      s.cfg.Statement,
+		URI:            s.cfg.URI,
+		Version:        siweVersion,
+		ChainID:        chainID,
+		Nonce:          nonce,
+		IssuedAt:       issuedAt,
+		ExpirationTime: &expirationTime,
+	}, nil
+}
+
+// Verify validates every field of a signed SIWE message, checks the
+// signature and consumes the nonce so the message cannot be replayed. It
+// reports whether the wallet signed as an EOA or a smart contract.
+func (s *SIWEService) Verify(walletAddress, message, signature string) (*SIWEMessage, WalletKind, error) {
+	m, err := ParseSIWEMessage(message)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !strings.EqualFold(m.Address, walletAddress) {
+		return nil, 0, fmt.Errorf("%w: address", ErrSIWEMismatch)
+	}
+	if m.Domain != s.cfg.Domain {
+		return nil, 0, fmt.Errorf("%w: domain", ErrSIWEMismatch)
+	}
+	if m.URI != s.cfg.URI {
+		return nil, 0, fmt.Errorf("%w: URI", ErrSIWEMismatch)
+	}
+	if m.Version != siweVersion {
+		return nil, 0, fmt.Errorf("%w: version", ErrSIWEMismatch)
+	}
+	if !s.supportsChain(m.ChainID) {
+		return nil, 0, fmt.Errorf("%w: chain ID", ErrSIWEMismatch)
+	}
+
+	now := time.Now()
+	if m.IssuedAt.After(now.Add(siweClockSkew)) {
+		return nil, 0, ErrSIWENotYetValid
+	}
+	if m.NotBefore != nil && m.NotBefore.After(now.Add(siweClockSkew)) {
+		return nil, 0, ErrSIWENotYetValid
+	}
+	// The server always sets an expiration, so a message without one was not issued here
+	if m.ExpirationTime == nil || !now.Before(*m.ExpirationTime) {
+		return nil, 0, ErrSIWEExpired
+	}
+
+	kind, err := s.web3Service.VerifyWallet(m.ChainID, m.Address, message, signature)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if kind == 0 {
+		return nil, 0, ErrInvalidSignature
+	}
+
+	// Consume the nonce last so that invalid attempts cannot burn it. The
+	// nonce is bound to the chain it was issued for, so a message can't
+	// claim another chain than the one it was issued for.
+	if err := s.nonces.Consume(m.Nonce, m.Address, m.ChainID); err != nil {
+		return nil, 0, err
+	}
+
+	return m, kind, nil
+}
+
+func (s *SIWEService) supportsChain(chainID int64) bool {
+	for _, supported := range s.chainIDs {
+		if chainID == supported {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+)
+
+const validSIWEMessage = `dchat.pro wants you to sign in with your Ethereum account:
+0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2
+
+Sign in to dChat.
+
+URI: https://dchat.pro
+Version: 1
+Chain ID: 137
+Nonce: 32891756abcdef
+Issued At: 2026-10-16T10:00:00Z
+Expiration Time: 2026-10-16T10:10:00Z
+Request ID: login-1
+Resources:
+- https://dchat.pro/terms
+- ipfs://bafybeigdyrzt`
+
+func TestParseSIWEMessageRoundTrip(t *testing.T) {
+	m, err := ParseSIWEMessage(validSIWEMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Domain != "dchat.pro" || m.ChainID != 137 || m.Nonce != "32891756abcdef" || m.RequestID != "login-1" {
+		t.Fatalf("unexpected fields: %+v", m)
+	}
+	if m.Statement != "Sign in to dChat." || len(m.Resources) != 2 {
+		t.Fatalf("unexpected statement or resources: %+v", m)
+	}
+	if m.ExpirationTime == nil || !m.ExpirationTime.Equal(time.Date(2026, 10, 16, 10, 10, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected expiration time: %v", m.ExpirationTime)
+	}
+	if got := m.String(); got != validSIWEMessage {
+		t.Fatalf("String() does not reproduce the message:\n%s", got)
+	}
+}
+
+func TestParseSIWEMessageWithoutStatement(t *testing.T) {
+	message := strings.Replace(validSIWEMessage, "Sign in to dChat.\n", "", 1)
+	m, err := ParseSIWEMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Statement != "" || m.String() != message {
+		t.Fatalf("unexpected round trip: %+v", m)
+	}
+}
+
+func TestParseSIWEMessageRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+	}{
+		{"missing preamble", strings.Replace(validSIWEMessage, " wants you to sign in", " would like you to sign in", 1)},
+		{"lowercase address", strings.Replace(validSIWEMessage, "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2", "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2", 1)},
+		{"missing URI", strings.Replace(validSIWEMessage, "URI: https://dchat.pro\n", "", 1)},
+		{"invalid chain ID", strings.Replace(validSIWEMessage, "Chain ID: 137", "Chain ID: polygon", 1)},
+		{"short nonce", strings.Replace(validSIWEMessage, "32891756abcdef", "1234", 1)},
+		{"invalid timestamp", strings.Replace(validSIWEMessage, "2026-10-16T10:00:00Z", "yesterday", 1)},
+		{"fields out of order", strings.Replace(validSIWEMessage, "URI: https://dchat.pro\nVersion: 1", "Version: 1\nURI: https://dchat.pro", 1)},
+		{"trailing content", validSIWEMessage + "\nsomething else"},
+		{"too short", "dchat.pro wants you to sign in with your Ethereum account:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSIWEMessage(tt.message); !errors.Is(err, ErrInvalidSIWEMessage) {
+				t.Fatalf("err = %v, want ErrInvalidSIWEMessage", err)
+			}
+		})
+	}
+}
+
+func newTestSIWEService(t *testing.T, chainIDs ...int64) *SIWEService {
+	t.Helper()
+	redisClient, _ := testutil.NewRedis(t)
+	cfg := &config.SIWEConfig{Domain: "dchat.pro", URI: "https://dchat.pro", Statement: "Sign in to dChat."}
+	return NewSIWEService(cfg, chainIDs, NewNonceStore(redisClient, 10*time.Minute), NewWeb3Service(nil, chainIDs[0], common.Address{}))
+}
+
+func signSIWE(t *testing.T, m *SIWEMessage) (string, string) {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Address = crypto.PubkeyToAddress(key.PublicKey).Hex()
+	message := m.String()
+	sig, err := crypto.Sign((&Web3Service{}).hashMessage(message), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig[64] += 27
+	return message, hexutil.Encode(sig)
+}
+
+func TestSIWEServiceChains(t *testing.T) {
+	service := newTestSIWEService(t, 1, 137)
+	key, _ := crypto.GenerateKey()
+	wallet := crypto.PubkeyToAddress(key.PublicKey).Hex()
+
+	m, err := service.IssueMessage(wallet, 0)
+	if err != nil || m.ChainID != 1 {
+		t.Fatalf("default chain: chain=%v err=%v", m, err)
+	}
+	m, err = service.IssueMessage(wallet, 137)
+	if err != nil || m.ChainID != 137 {
+		t.Fatalf("secondary chain: chain=%v err=%v", m, err)
+	}
+	if _, err := service.IssueMessage(wallet, 10); !errors.Is(err, ErrUnsupportedChain) {
+		t.Fatalf("unsupported chain: err=%v", err)
+	}
+}
+
+func TestSIWEServiceVerify(t *testing.T) {
+	service := newTestSIWEService(t, 1, 137)
+
+	m, err := service.IssueMessage(common.Address{}.Hex(), 137)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The nonce was issued to another address
+	message, signature := signSIWE(t, m)
+	if _, _, err := service.Verify(m.Address, message, signature); !errors.Is(err, ErrNonceNotFound) {
+		t.Fatalf("nonce of another wallet: err=%v", err)
+	}
+
+	key, _ := crypto.GenerateKey()
+	m, err = service.IssueMessage(crypto.PubkeyToAddress(key.PublicKey).Hex(), 137)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message = m.String()
+	sig, _ := crypto.Sign((&Web3Service{}).hashMessage(message), key)
+	sig[64] += 27
+	signature = hexutil.Encode(sig)
+
+	verified, kind, err := service.Verify(m.Address, message, signature)
+	if err != nil || verified.ChainID != 137 || kind != WalletEOA {
+		t.Fatalf("verify: %v kind=%d err=%v", verified, kind, err)
+	}
+	if _, _, err := service.Verify(m.Address, message, signature); !errors.Is(err, ErrNonceNotFound) {
+		t.Fatalf("replay: err=%v", err)
+	}
+}
+
+func TestSIWENonceBoundToChain(t *testing.T) {
+	service := newTestSIWEService(t, 1, 137)
+	key, _ := crypto.GenerateKey()
+	m, err := service.IssueMessage(crypto.PubkeyToAddress(key.PublicKey).Hex(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(m *SIWEMessage) (string, string) {
+		message := m.String()
+		sig, _ := crypto.Sign((&Web3Service{}).hashMessage(message), key)
+		sig[64] += 27
+		return message, hexutil.Encode(sig)
+	}
+	other := *m
+	other.ChainID = 137
+	message, signature := sign(&other)
+	if _, _, err := service.Verify(m.Address, message, signature); !errors.Is(err, ErrNonceNotFound) {
+		t.Fatalf("nonce of another chain: err=%v", err)
+	}
+
+	m, err = service.IssueMessage(m.Address, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, signature = sign(m)
+	if _, _, err := service.Verify(m.Address, message, signature); err != nil {
+		t.Fatalf("nonce of the chain it was issued for: %v", err)
+	}
+}
+
+func TestSIWEServiceVerifyRejects(t *testing.T) {
+	service := newTestSIWEService(t, 1, 137)
+	now := time.Now().UTC().Truncate(time.Second)
+	expires := now.Add(5 * time.Minute)
+	expired := now.Add(-time.Minute)
+
+	base := func() *SIWEMessage {
+		return &SIWEMessage{
+			Domain: "dchat.pro", Statement: "Sign in to dChat.", URI: "https://dchat.pro", Version: "1",
+			ChainID: 1, Nonce: "0123456789abcdef", IssuedAt: now, ExpirationTime: &expires,
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(*SIWEMessage)
+		want   error
+	}{
+		{"other domain", func(m *SIWEMessage) { m.Domain = "evil.example" }, ErrSIWEMismatch},
+		{"other URI", func(m *SIWEMessage) { m.URI = "https://evil.example" }, ErrSIWEMismatch},
+		{"unsupported chain", func(m *SIWEMessage) { m.ChainID = 10 }, ErrSIWEMismatch},
+		{"expired", func(m *SIWEMessage) { m.ExpirationTime = &expired }, ErrSIWEExpired},
+		{"no expiration", func(m *SIWEMessage) { m.ExpirationTime = nil }, ErrSIWEExpired},
+		{"issued in the future", func(m *SIWEMessage) { m.IssuedAt = now.Add(time.Hour) }, ErrSIWENotYetValid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := base()
+			tt.modify(m)
+			message, signature := signSIWE(t, m)
+			if _, _, err := service.Verify(m.Address, message, signature); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -10,24 +10,30 @@ import (
 )
 
 type UserService struct {
-	db *gorm.DB
+	db         *gorm.DB
+	identities *IdentityService
 }
 
-func NewUserService(db *gorm.DB) *UserService {
-	return &UserService{db: db}
+func NewUserService(db *gorm.DB, identities *IdentityService) *UserService {
+	return &UserService{db: db, identities: identities}
 }
 
-// GetOrCreateUserByWallet ensures one wallet address = one account
-func (s *UserService) GetOrCreateUserByWallet(walletAddress string) (*models.User, bool, error) {
+// GetOrCreateUserByWallet resolves a wallet on a chain through the linked
+// identities, creating a new account the first time an unknown wallet signs
+// in. An EOA is the same key on every chain, so one linked on another chain
+// signs in to the same account and gets this chain linked too. A contract
+// wallet can have another owner on another chain, so it is refused rather
+// than given a second account; its owner links the new chain from their
+// account.
+func (s *UserService) GetOrCreateUserByWallet(chainID int64, walletAddress string, kind WalletKind) (*models.User, bool, error) {
 	// Normalize wallet address to lowercase
 	walletAddress = strings.ToLower(walletAddress)
+	chain := WalletChain(chainID)
 
-	var user models.User
-	err := s.db.Where("wallet_address = ?", walletAddress).First(&user).Error
-
+	user, err := s.identities.FindUser(models.IdentityWallet, chain, walletAddress)
 	if err == nil {
 		// User exists
-		return &user, false, nil
+		return user, false, nil
 	}
 
 	if !errors.Is(err, gorm.ErrRecordNotFound) {
@@ -35,18 +41,45 @@ func (s *UserService) GetOrCreateUserByWallet(walletAddress string) (*models.Use
 		return nil, false, fmt.Errorf("database error: %w", err)
 	}
 
+	user, err = s.identities.FindWalletUser(walletAddress)
+	if err == nil {
+		if kind != WalletEOA {
+			return nil, false, ErrWalletOtherChain
+		}
+		if _, err := s.identities.LinkIdentity(user.ID, models.IdentityWallet, chain, walletAddress); err != nil {
+			return nil, false, err
+		}
+		return user, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, fmt.Errorf("database error: %w", err)
+	}
+
+	// Accounts created before identities were introduced only have the column set
+	var legacy models.User
+	err = s.db.Where("wallet_address = ?", walletAddress).First(&legacy).Error
+	if err == nil {
+		if _, err := s.identities.LinkIdentity(legacy.ID, models.IdentityWallet, chain, walletAddress); err != nil {
+			return nil, false, err
+		}
+		return &legacy, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, fmt.Errorf("database error: %w", err)
+	}
+
 	// User doesn't exist, create new account
-	user = models.User{
+	newUser := models.User{
 		WalletAddress: walletAddress,
 		Name:          fmt.Sprintf("User_%s", walletAddress[:8]),
 		Username:      walletAddress[:12],
 	}
 
-	if err := s.db.Create(&user).Error; err != nil {
-		return nil, false, fmt.Errorf("failed to create user: %w", err)
+	if err := s.identities.CreateUserWithIdentity(&newUser, models.IdentityWallet, chain, walletAddress); err != nil {
+		return nil, false, err
 	}
 
-	return &user, true, nil
+	return &newUser, true, nil
 }
 
 // GetUserByID retrieves user by ID
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+)
+
+func TestGetOrCreateUserByWalletAcrossChains(t *testing.T) {
+	db := testutil.NewDB(t)
+	service := NewUserService(db, NewIdentityService(db))
+	key, _ := crypto.GenerateKey()
+	wallet := crypto.PubkeyToAddress(key.PublicKey).Hex()
+
+	user, isNew, err := service.GetOrCreateUserByWallet(1, wallet, WalletEOA)
+	if err != nil || !isNew {
+		t.Fatalf("first login: isNew=%v err=%v", isNew, err)
+	}
+
+	// An EOA signs in to the same account on any chain, which is linked on the way
+	again, isNew, err := service.GetOrCreateUserByWallet(137, wallet, WalletEOA)
+	if err != nil || isNew || again.ID != user.ID {
+		t.Fatalf("EOA on another chain: user=%+v isNew=%v err=%v", again, isNew, err)
+	}
+	if _, err := service.identities.FindUser(models.IdentityWallet, WalletChain(137), wallet); err != nil {
+		t.Fatalf("second chain was not linked: %v", err)
+	}
+
+	// The same address as a contract may belong to someone else there
+	if _, _, err := service.GetOrCreateUserByWallet(10, wallet, WalletContract); !errors.Is(err, ErrWalletOtherChain) {
+		t.Fatalf("contract on another chain: err = %v", err)
+	}
+	// but is the same account on a chain it is linked on
+	if again, _, err := service.GetOrCreateUserByWallet(137, wallet, WalletContract); err != nil || again.ID != user.ID {
+		t.Fatalf("contract on a linked chain: user=%+v err=%v", again, err)
+	}
+}
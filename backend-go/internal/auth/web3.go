@@ -1,49 +1,109 @@
 package auth
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
-type Web3Service struct{}
+// Upper bound for on-chain signature checks
+const contractCallTimeout = 10 * time.Second
 
-func NewWeb3Service() *Web3Service {
-	return &Web3Service{}
+type Web3Service struct {
+	// Optional: without a caller only EOA (ECDSA) signatures are accepted
+	caller ContractCaller
+	// Chain the caller reads from
+	chainID          int64
+	erc6492Validator common.Address
 }
 
+func NewWeb3Service(caller ContractCaller, chainID int64, erc6492Validator common.Address) *Web3Service {
+	return &Web3Service{
+		caller:           caller,
+		chainID:          chainID,
+		erc6492Validator: erc6492Validator,
+	}
+}
+
+// WalletKind is how a wallet proved a signature
+type WalletKind int
+
+const (
+	// A key pair, which is the same account on every chain
+	WalletEOA WalletKind = iota + 1
+	// A smart-contract account, which only exists on the chain it is
+	// deployed on
+	WalletContract
+)
+
 // VerifySignature verifies that the signature was created by the wallet address
-func (s *Web3Service) VerifySignature(walletAddress, message, signature string) (bool, error) {
+// on a chain. EOA signatures are checked by ECDSA recovery, which holds on
+// every chain; smart-contract wallets fall back to EIP-1271, and undeployed
+// accounts to ERC-6492, but only on the chain the contract caller reads,
+// since the same address can be a different contract elsewhere.
+func (s *Web3Service) VerifySignature(chainID int64, walletAddress, message, signature string) (bool, error) {
+	kind, err := s.VerifyWallet(chainID, walletAddress, message, signature)
+	return kind != 0, err
+}
+
+// VerifyWallet verifies a signature like VerifySignature and reports whether
+// the wallet signed as an EOA or a smart contract, or 0 if it did not sign
+func (s *Web3Service) VerifyWallet(chainID int64, walletAddress, message, signature string) (WalletKind, error) {
 	// Normalize wallet address
 	if !common.IsHexAddress(walletAddress) {
-		return false, errors.New("invalid wallet address format")
+		return 0, errors.New("invalid wallet address format")
 	}
 	address := common.HexToAddress(walletAddress)
 
 	// Decode signature
 	sig, err := hexutil.Decode(signature)
 	if err != nil {
-		return false, fmt.Errorf("failed to decode signature: %w", err)
+		return 0, fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	// Hash the message with Ethereum prefix
+	hash := s.hashMessage(message)
+
+	// Ethereum EOA signatures are 65 bytes: R (32) + S (32) + V (1)
+	if len(sig) == 65 && !isERC6492Signature(sig) {
+		valid, err := s.verifyECDSASignature(address, hash, sig)
+		if err == nil && valid {
+			return WalletEOA, nil
+		}
+	}
+
+	// Recovery failed or did not match: the address may be a contract wallet
+	if chainID != s.chainID {
+		return 0, nil
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), contractCallTimeout)
+	defer cancel()
 
-	// Ethereum signatures are 65 bytes: R (32) + S (32) + V (1)
-	if len(sig) != 65 {
-		return false, errors.New("invalid signature length")
+	valid, err := s.verifyContractSignature(ctx, address, hash, sig)
+	if err != nil || !valid {
+		return 0, err
 	}
+	return WalletContract, nil
+}
+
+// verifyECDSASignature recovers the signer of a 65-byte signature
+func (s *Web3Service) verifyECDSASignature(address common.Address, hash []byte, signature []byte) (bool, error) {
+	// Copy so the caller's signature is left untouched
+	sig := make([]byte, len(signature))
+	copy(sig, signature)
 
 	// Adjust V value (Ethereum uses 27/28, but we need 0/1)
 	if sig[64] >= 27 {
 		sig[64] -= 27
 	}
 
-	// Hash the message with Ethereum prefix
-	hash := s.hashMessage(message)
-
 	// Recover public key from signature
 	pubKey, err := crypto.SigToPub(hash, sig)
 	if err != nil {
@@ -2,24 +2,49 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
+// Service is a binary that loads the configuration. Each validates only the
+// settings it uses.
+type Service int
+
+const (
+	APIService Service = iota
+	WebSocketService
+)
+
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Redis    RedisConfig
-	JWT      JWTConfig
-	Web3     Web3Config
+	Server       ServerConfig
+	Database     DatabaseConfig
+	Redis        RedisConfig
+	JWT          JWTConfig
+	Web3         Web3Config
+	SIWE         SIWEConfig
+	LinkedIn     LinkedInConfig
+	Mail         MailConfig
+	SMS          SMSConfig
+	OTP          OTPConfig
+	Messaging    MessagingConfig
+	Transparency TransparencyConfig
+	SealedSender SealedSenderConfig
 }
 
 type ServerConfig struct {
 	APIPort       string
 	WebSocketPort string
 	Environment   string
+	// Public URL of the web app, used for links in outbound email
+	AppURL string
+	// Addresses or CIDRs of the reverse proxies whose X-Forwarded-For is
+	// believed. Nil, without a proxy, takes the client IP from the connection.
+	TrustedProxies []string
 }
 
 type DatabaseConfig struct {
@@ -39,31 +64,121 @@ type RedisConfig struct {
 }
 
 type JWTConfig struct {
-	SecretKey      string
-	ExpirationHours int
+	// SecretKey encrypts the signing keys stored in the database
+	SecretKey          string
+	AccessTokenMinutes int
+	RefreshTokenDays   int
+	Algorithm          string
+	KeyRotationDays    int
+	KeyOverlapHours    int
+	JWKSURL            string
 }
 
 type Web3Config struct {
-	RPCURL          string
-	ChainID         int64
+	RPCURL string
+	// Chain of RPCURL, where smart-contract wallet signatures are checked
+	ChainID int64
+	// Chains accepted in SIWE messages, ChainID first
+	ChainIDs        []int64
 	ContractAddress string
+	// Deployed ERC-6492 UniversalSigValidator, for undeployed smart accounts
+	ERC6492ValidatorAddress string
+}
+
+// SIWEConfig describes the EIP-4361 messages issued for wallet login
+type SIWEConfig struct {
+	Domain          string
+	URI             string
+	Statement       string
+	NonceTTLMinutes int
+}
+
+// LinkedInConfig holds the OAuth client used to link LinkedIn accounts
+type LinkedInConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+}
+
+// MailConfig selects and configures the outbound mailer
+type MailConfig struct {
+	Driver       string
+	From         string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	LogFile      string
+}
+
+// SMSConfig selects the outbound SMS sender
+type SMSConfig struct {
+	Driver string
 }
 
-func Load() (*Config, error) {
+// OTPConfig limits the one-time codes sent to phone numbers
+type OTPConfig struct {
+	TTLMinutes  int
+	MaxAttempts int
+	// Codes that can be requested per hour for one number and from one IP
+	NumberHourlyLimit int
+	IPHourlyLimit     int
+}
+
+// MessagingConfig limits what senders may do to messages after sending them
+type MessagingConfig struct {
+	// How long after sending a message its sender may delete it for everyone
+	DeleteWindowMinutes int
+}
+
+// TransparencyConfig holds the key that signs tree heads of the key
+// transparency log
+type TransparencyConfig struct {
+	// Base64 Ed25519 seed; required
+	SigningKey string
+}
+
+// SealedSenderConfig configures 1:1 messages whose sender is hidden from the server
+type SealedSenderConfig struct {
+	// Base64 Ed25519 seed signing delivery certificates; required
+	CertificateKey   string
+	CertificateHours int
+	// Sealed messages accepted per hour for one recipient and from one IP
+	RecipientHourlyLimit int
+	IPHourlyLimit        int
+}
+
+// Load reads the configuration from the environment and validates it for service
+func Load(service Service) (*Config, error) {
 	// Load .env file if exists
 	_ = godotenv.Load()
 
 	dbPort, _ := strconv.Atoi(getEnv("DB_PORT", "5432"))
 	redisPort, _ := strconv.Atoi(getEnv("REDIS_PORT", "6379"))
 	redisDB, _ := strconv.Atoi(getEnv("REDIS_DB", "0"))
-	jwtExpiration, _ := strconv.Atoi(getEnv("JWT_EXPIRATION_HOURS", "24"))
+	accessTokenMinutes, _ := strconv.Atoi(getEnv("JWT_ACCESS_TOKEN_MINUTES", "15"))
+	refreshTokenDays, _ := strconv.Atoi(getEnv("JWT_REFRESH_TOKEN_DAYS", "30"))
+	keyRotationDays, _ := strconv.Atoi(getEnv("JWT_KEY_ROTATION_DAYS", "30"))
+	keyOverlapHours, _ := strconv.Atoi(getEnv("JWT_KEY_OVERLAP_HOURS", "24"))
 	chainID, _ := strconv.ParseInt(getEnv("CHAIN_ID", "1"), 10, 64)
+	siweNonceTTL, _ := strconv.Atoi(getEnv("SIWE_NONCE_TTL_MINUTES", "10"))
+	smtpPort, _ := strconv.Atoi(getEnv("SMTP_PORT", "587"))
+	otpTTL, _ := strconv.Atoi(getEnv("OTP_TTL_MINUTES", "5"))
+	otpMaxAttempts, _ := strconv.Atoi(getEnv("OTP_MAX_ATTEMPTS", "5"))
+	otpNumberLimit, _ := strconv.Atoi(getEnv("OTP_NUMBER_HOURLY_LIMIT", "5"))
+	otpIPLimit, _ := strconv.Atoi(getEnv("OTP_IP_HOURLY_LIMIT", "20"))
+	deleteWindow, _ := strconv.Atoi(getEnv("MESSAGE_DELETE_WINDOW_MINUTES", "2880"))
+	certificateHours, _ := strconv.Atoi(getEnv("SEALED_SENDER_CERTIFICATE_HOURS", "24"))
+	sealedRecipientLimit, _ := strconv.Atoi(getEnv("SEALED_SENDER_RECIPIENT_HOURLY_LIMIT", "1000"))
+	sealedIPLimit, _ := strconv.Atoi(getEnv("SEALED_SENDER_IP_HOURLY_LIMIT", "600"))
 
 	config := &Config{
 		Server: ServerConfig{
-			APIPort:       getEnv("API_PORT", "8080"),
-			WebSocketPort: getEnv("WEBSOCKET_PORT", "8081"),
-			Environment:   getEnv("ENVIRONMENT", "development"),
+			APIPort:        getEnv("API_PORT", "8080"),
+			WebSocketPort:  getEnv("WEBSOCKET_PORT", "8081"),
+			Environment:    getEnv("ENVIRONMENT", "development"),
+			AppURL:         getEnv("APP_URL", "https://dchat.pro"),
+			TrustedProxies: parseList(getEnv("TRUSTED_PROXIES", "")),
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
@@ -80,30 +195,120 @@ func Load() (*Config, error) {
 			DB:       redisDB,
 		},
 		JWT: JWTConfig{
-			SecretKey:      getEnv("JWT_SECRET", ""),
-			ExpirationHours: jwtExpiration,
+			SecretKey:          getEnv("JWT_SECRET", ""),
+			AccessTokenMinutes: accessTokenMinutes,
+			RefreshTokenDays:   refreshTokenDays,
+			Algorithm:          getEnv("JWT_ALGORITHM", "ES256"),
+			KeyRotationDays:    keyRotationDays,
+			KeyOverlapHours:    keyOverlapHours,
+			JWKSURL:            getEnv("JWT_JWKS_URL", "http://localhost:8080/.well-known/jwks.json"),
 		},
 		Web3: Web3Config{
-			RPCURL:          getEnv("RPC_URL", ""),
-			ChainID:         chainID,
-			ContractAddress: getEnv("CONTRACT_ADDRESS", ""),
+			RPCURL:                  getEnv("RPC_URL", ""),
+			ChainID:                 chainID,
+			ChainIDs:                parseChainIDs(chainID, getEnv("CHAIN_IDS", "")),
+			ContractAddress:         getEnv("CONTRACT_ADDRESS", ""),
+			ERC6492ValidatorAddress: getEnv("ERC6492_VALIDATOR_ADDRESS", ""),
+		},
+		SIWE: SIWEConfig{
+			Domain:          getEnv("SIWE_DOMAIN", "dchat.pro"),
+			URI:             getEnv("SIWE_URI", "https://dchat.pro"),
+			Statement:       getEnv("SIWE_STATEMENT", "Sign in to dChat with your Ethereum account."),
+			NonceTTLMinutes: siweNonceTTL,
+		},
+		LinkedIn: LinkedInConfig{
+			ClientID:     getEnv("LINKEDIN_CLIENT_ID", ""),
+			ClientSecret: getEnv("LINKEDIN_CLIENT_SECRET", ""),
+			RedirectURI:  getEnv("LINKEDIN_REDIRECT_URI", "https://dchat.pro/auth/linkedin/callback"),
+		},
+		Mail: MailConfig{
+			Driver:       getEnv("MAIL_DRIVER", "log"),
+			From:         getEnv("MAIL_FROM", "dChat <no-reply@dchat.pro>"),
+			SMTPHost:     getEnv("SMTP_HOST", "localhost"),
+			SMTPPort:     smtpPort,
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			LogFile:      getEnv("MAIL_LOG_FILE", ""),
+		},
+		SMS: SMSConfig{
+			Driver: getEnv("SMS_DRIVER", "log"),
+		},
+		OTP: OTPConfig{
+			TTLMinutes:        otpTTL,
+			MaxAttempts:       otpMaxAttempts,
+			NumberHourlyLimit: otpNumberLimit,
+			IPHourlyLimit:     otpIPLimit,
+		},
+		Messaging: MessagingConfig{
+			DeleteWindowMinutes: deleteWindow,
+		},
+		Transparency: TransparencyConfig{
+			SigningKey: getEnv("KT_SIGNING_KEY", ""),
+		},
+		SealedSender: SealedSenderConfig{
+			CertificateKey:       getEnv("SEALED_SENDER_CERTIFICATE_KEY", ""),
+			CertificateHours:     certificateHours,
+			RecipientHourlyLimit: sealedRecipientLimit,
+			IPHourlyLimit:        sealedIPLimit,
 		},
 	}
 
-	if err := config.Validate(); err != nil {
+	if err := config.Validate(service); err != nil {
 		return nil, err
 	}
 
 	return config, nil
 }
 
-func (c *Config) Validate() error {
+// Validate checks the settings service uses. The websocket service only
+// verifies tokens, with the API's published keys, so it needs no JWT_SECRET.
+func (c *Config) Validate(service Service) error {
 	if c.Database.Password == "" {
 		return fmt.Errorf("DB_PASSWORD is required")
 	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("TRUSTED_PROXIES must be a comma-separated list of IP addresses or CIDRs")
+			}
+		}
+	}
+	if c.Messaging.DeleteWindowMinutes <= 0 {
+		return fmt.Errorf("MESSAGE_DELETE_WINDOW_MINUTES must be positive")
+	}
+	if service == WebSocketService {
+		if u, err := url.Parse(c.JWT.JWKSURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("JWT_JWKS_URL must be an http(s) URL")
+		}
+		return nil
+	}
+
 	if c.JWT.SecretKey == "" {
 		return fmt.Errorf("JWT_SECRET is required")
 	}
+	if c.JWT.AccessTokenMinutes <= 0 || c.JWT.RefreshTokenDays <= 0 {
+		return fmt.Errorf("JWT_ACCESS_TOKEN_MINUTES and JWT_REFRESH_TOKEN_DAYS must be positive")
+	}
+	if c.JWT.Algorithm != "ES256" && c.JWT.Algorithm != "EdDSA" {
+		return fmt.Errorf("JWT_ALGORITHM must be ES256 or EdDSA")
+	}
+	if c.JWT.KeyRotationDays <= 0 || c.JWT.KeyOverlapHours <= 0 {
+		return fmt.Errorf("JWT_KEY_ROTATION_DAYS and JWT_KEY_OVERLAP_HOURS must be positive")
+	}
+	for _, chainID := range c.Web3.ChainIDs {
+		if chainID <= 0 {
+			return fmt.Errorf("CHAIN_IDS must be a comma-separated list of positive chain IDs")
+		}
+	}
+	if c.SIWE.NonceTTLMinutes <= 0 {
+		return fmt.Errorf("SIWE_NONCE_TTL_MINUTES must be positive")
+	}
+	if c.OTP.TTLMinutes <= 0 || c.OTP.MaxAttempts <= 0 || c.OTP.NumberHourlyLimit <= 0 || c.OTP.IPHourlyLimit <= 0 {
+		return fmt.Errorf("OTP_TTL_MINUTES, OTP_MAX_ATTEMPTS and OTP hourly limits must be positive")
+	}
+	if c.SealedSender.CertificateHours <= 0 || c.SealedSender.RecipientHourlyLimit <= 0 || c.SealedSender.IPHourlyLimit <= 0 {
+		return fmt.Errorf("SEALED_SENDER_CERTIFICATE_HOURS and sealed sender hourly limits must be positive")
+	}
 	return nil
 }
 
@@ -120,3 +325,34 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// parseList reads a comma-separated list, returning nil when it is empty
+func parseList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// parseChainIDs reads a comma-separated list of chain IDs, always starting
+// with the primary chain. Malformed entries come back as 0 for Validate.
+func parseChainIDs(primary int64, list string) []int64 {
+	chainIDs := []int64{primary}
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		chainID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			chainID = 0
+		}
+		if chainID != primary {
+			chainIDs = append(chainIDs, chainID)
+		}
+	}
+	return chainIDs
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseChainIDs(t *testing.T) {
+	tests := []struct {
+		list string
+		want []int64
+	}{
+		{"", []int64{1}},
+		{"137, 10", []int64{1, 137, 10}},
+		{"1,137", []int64{1, 137}},
+		{"137,polygon", []int64{1, 137, 0}},
+	}
+	for _, tt := range tests {
+		if got := parseChainIDs(1, tt.list); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseChainIDs(1, %q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestParseList(t *testing.T) {
+	if got := parseList(""); got != nil {
+		t.Errorf("parseList(\"\") = %v, want nil", got)
+	}
+	want := []string{"10.0.0.1", "172.16.0.0/12"}
+	if got := parseList(" 10.0.0.1, ,172.16.0.0/12 "); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseList = %v, want %v", got, want)
+	}
+}
+
+func TestValidatePerService(t *testing.T) {
+	valid := func() *Config {
+		return &Config{
+			Database:     DatabaseConfig{Password: "secret"},
+			JWT:          JWTConfig{SecretKey: "secret", AccessTokenMinutes: 15, RefreshTokenDays: 30, Algorithm: "ES256", KeyRotationDays: 30, KeyOverlapHours: 24, JWKSURL: "http://api:8080/.well-known/jwks.json"},
+			Web3:         Web3Config{ChainIDs: []int64{1}},
+			SIWE:         SIWEConfig{NonceTTLMinutes: 10},
+			OTP:          OTPConfig{TTLMinutes: 5, MaxAttempts: 5, NumberHourlyLimit: 5, IPHourlyLimit: 20},
+			Messaging:    MessagingConfig{DeleteWindowMinutes: 60},
+			SealedSender: SealedSenderConfig{CertificateHours: 24, RecipientHourlyLimit: 10, IPHourlyLimit: 10},
+		}
+	}
+	for _, service := range []Service{APIService, WebSocketService} {
+		if err := valid().Validate(service); err != nil {
+			t.Fatalf("service %d: %v", service, err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		modify    func(c *Config)
+		api       bool
+		websocket bool
+	}{
+		{"no JWT_SECRET", func(c *Config) { c.JWT.SecretKey = "" }, false, true},
+		{"no OTP limits", func(c *Config) { c.OTP = OTPConfig{} }, false, true},
+		{"relative JWKS URL", func(c *Config) { c.JWT.JWKSURL = "/.well-known/jwks.json" }, true, false},
+		{"no DB_PASSWORD", func(c *Config) { c.Database.Password = "" }, false, false},
+		{"malformed proxy", func(c *Config) { c.Server.TrustedProxies = []string{"10.0.0.0/33"} }, false, false},
+		{"proxy CIDR", func(c *Config) { c.Server.TrustedProxies = []string{"10.0.0.1", "172.16.0.0/12"} }, true, true},
+	}
+	for _, tt := range tests {
+		for service, want := range map[Service]bool{APIService: tt.api, WebSocketService: tt.websocket} {
+			c := valid()
+			tt.modify(c)
+			if err := c.Validate(service); (err == nil) != want {
+				t.Errorf("%s, service %d: err = %v, want valid=%v", tt.name, service, err, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package e2ee
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// RFC 9420 wire constants the delivery service needs to route messages.
+// Everything past the framing headers is opaque to the server.
+const (
+	mlsVersion10 = 1
+
+	wireFormatPublicMessage  = 1
+	wireFormatPrivateMessage = 2
+	wireFormatWelcome        = 3
+	wireFormatKeyPackage     = 5
+
+	senderTypeMember            = 1
+	senderTypeExternal          = 2
+	senderTypeNewMemberProposal = 3
+	senderTypeNewMemberCommit   = 4
+)
+
+// MLSContentType is what a handshake or application message carries
+type MLSContentType string
+
+const (
+	MLSApplication MLSContentType = "application"
+	MLSProposal    MLSContentType = "proposal"
+	MLSCommit      MLSContentType = "commit"
+	MLSWelcome     MLSContentType = "welcome"
+)
+
+var mlsContentTypes = map[byte]MLSContentType{1: MLSApplication, 2: MLSProposal, 3: MLSCommit}
+
+var ErrMalformedMLSMessage = errors.New("malformed MLS message")
+
+// mlsHeader is the unencrypted framing of a PublicMessage or PrivateMessage
+type mlsHeader struct {
+	GroupID     []byte
+	Epoch       uint64
+	ContentType MLSContentType
+}
+
+// parseMLSGroupMessage reads the group, epoch and content type of an
+// MLSMessage carrying a PublicMessage or PrivateMessage
+func parseMLSGroupMessage(data []byte) (*mlsHeader, error) {
+	r := &tlsReader{data: data}
+	wireFormat, err := r.mlsMessageHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	header := &mlsHeader{}
+	if header.GroupID, err = r.vector(); err != nil {
+		return nil, err
+	}
+	if header.Epoch, err = r.uint64(); err != nil {
+		return nil, err
+	}
+
+	var contentType byte
+	switch wireFormat {
+	case wireFormatPublicMessage:
+		// FramedContent: sender, authenticated_data, then content_type
+		if err := r.sender(); err != nil {
+			return nil, err
+		}
+		if _, err := r.vector(); err != nil {
+			return nil, err
+		}
+		if contentType, err = r.uint8(); err != nil {
+			return nil, err
+		}
+	case wireFormatPrivateMessage:
+		if contentType, err = r.uint8(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrMalformedMLSMessage
+	}
+
+	var ok bool
+	if header.ContentType, ok = mlsContentTypes[contentType]; !ok {
+		return nil, ErrMalformedMLSMessage
+	}
+	return header, nil
+}
+
+// parseMLSWelcome checks that data is an MLSMessage carrying a Welcome
+func parseMLSWelcome(data []byte) error {
+	r := &tlsReader{data: data}
+	wireFormat, err := r.mlsMessageHeader()
+	if err != nil {
+		return err
+	}
+	if wireFormat != wireFormatWelcome {
+		return ErrMalformedMLSMessage
+	}
+	return nil
+}
+
+// parseMLSKeyPackage reads the cipher suite of an MLSMessage carrying a KeyPackage
+func parseMLSKeyPackage(data []byte) (uint16, error) {
+	r := &tlsReader{data: data}
+	wireFormat, err := r.mlsMessageHeader()
+	if err != nil {
+		return 0, err
+	}
+	if wireFormat != wireFormatKeyPackage {
+		return 0, ErrMalformedMLSMessage
+	}
+	version, err := r.uint16()
+	if err != nil || version != mlsVersion10 {
+		return 0, ErrMalformedMLSMessage
+	}
+	return r.uint16()
+}
+
+// tlsReader decodes the TLS presentation language as profiled by RFC 9420
+type tlsReader struct {
+	data []byte
+}
+
+func (r *tlsReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.data) < n {
+		return nil, ErrMalformedMLSMessage
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, nil
+}
+
+func (r *tlsReader) uint8() (byte, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *tlsReader) uint16() (uint16, error) {
+	b, err := r.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func (r *tlsReader) uint32() (uint32, error) {
+	b, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func (r *tlsReader) uint64() (uint64, error) {
+	b, err := r.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// vector reads a variable-length vector, whose length is a QUIC-style
+// variable-length integer of at most four bytes in its shortest encoding
+func (r *tlsReader) vector() ([]byte, error) {
+	first, err := r.uint8()
+	if err != nil {
+		return nil, err
+	}
+
+	var length uint32
+	switch first >> 6 {
+	case 0:
+		length = uint32(first)
+	case 1:
+		rest, err := r.next(1)
+		if err != nil {
+			return nil, err
+		}
+		length = uint32(first&0x3f)<<8 | uint32(rest[0])
+		if length < 1<<6 {
+			return nil, ErrMalformedMLSMessage
+		}
+	case 2:
+		rest, err := r.next(3)
+		if err != nil {
+			return nil, err
+		}
+		length = uint32(first&0x3f)<<24 | uint32(rest[0])<<16 | uint32(rest[1])<<8 | uint32(rest[2])
+		if length < 1<<14 {
+			return nil, ErrMalformedMLSMessage
+		}
+	default:
+		return nil, ErrMalformedMLSMessage
+	}
+	return r.next(int(length))
+}
+
+// mlsMessageHeader reads the version and wire format of an MLSMessage
+func (r *tlsReader) mlsMessageHeader() (uint16, error) {
+	version, err := r.uint16()
+	if err != nil || version != mlsVersion10 {
+		return 0, ErrMalformedMLSMessage
+	}
+	return r.uint16()
+}
+
+// sender skips over the Sender of a FramedContent
+func (r *tlsReader) sender() error {
+	senderType, err := r.uint8()
+	if err != nil {
+		return err
+	}
+	switch senderType {
+	case senderTypeMember, senderTypeExternal:
+		_, err = r.uint32()
+		return err
+	case senderTypeNewMemberProposal, senderTypeNewMemberCommit:
+		return nil
+	default:
+		return ErrMalformedMLSMessage
+	}
+}
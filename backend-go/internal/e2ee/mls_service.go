@@ -0,0 +1,335 @@
+package e2ee
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/messaging"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Largest MLS submission relayed: a handshake or application message,
+	// together with its Welcome if it has one. Base64-encoded in a websocket
+	// frame it stays well under the 512 KiB read limit.
+	MaxMLSMessageSize = 256 << 10
+	// Group IDs are opaque<V> in MLS; clients pick 16 or 32 random bytes
+	maxMLSGroupIDLength = 255
+
+	MaxKeyPackagesPerUpload = 100
+	MaxKeyPackagesPerDevice = 500
+
+	DefaultMLSMessageLimit = 100
+	MaxMLSMessageLimit     = 500
+)
+
+var (
+	ErrMLSGroupNotFound     = errors.New("group does not have an MLS group")
+	ErrMLSGroupExists       = errors.New("group already has an MLS group")
+	ErrInvalidMLSGroupID    = errors.New("MLS group ID must be 1 to 255 bytes")
+	ErrMLSGroupMismatch     = errors.New("message belongs to a different MLS group")
+	ErrWrongEpoch           = errors.New("message epoch does not match the group's current epoch")
+	ErrMLSMessageTooLarge   = errors.New("MLS message is too large")
+	ErrWelcomeWithoutCommit = errors.New("a Welcome can only be sent with the commit that adds its recipients")
+	ErrWelcomeRecipients    = errors.New("recipients of a Welcome must be members of the group")
+	ErrTooManyKeyPackages   = errors.New("too many key packages")
+)
+
+// EpochError is a message rejected because the group has moved to another
+// epoch; the sender has to process the messages it missed and try again
+type EpochError struct {
+	Epoch   uint64
+	Current uint64
+}
+
+func (e *EpochError) Error() string {
+	return fmt.Sprintf("message is for epoch %d but the group is at epoch %d", e.Epoch, e.Current)
+}
+
+func (e *EpochError) Is(target error) bool {
+	return target == ErrWrongEpoch
+}
+
+// MLSSubmission is a message sent to a group, with the Welcome for new
+// members when it is a commit that adds them
+type MLSSubmission struct {
+	Message           []byte
+	Welcome           []byte
+	WelcomeRecipients []uint
+}
+
+// MLSDelivery is an accepted submission and who it goes to
+type MLSDelivery struct {
+	Message *models.MLSMessage
+	Members []uint
+	// Set when the submission carried a Welcome
+	Welcome           *models.MLSMessage
+	WelcomeRecipients []uint
+}
+
+// MLSService is the delivery service half of MLS (RFC 9420) for group
+// conversations. It stores KeyPackages and relays handshake and application
+// messages in one total order per group, accepting a commit only for the
+// group's current epoch so that members never fork. Message contents stay
+// encrypted end to end; only the framing header is read.
+type MLSService struct {
+	db     *gorm.DB
+	redis  *utils.RedisClient
+	groups *messaging.GroupService
+}
+
+func NewMLSService(db *gorm.DB, redisClient *utils.RedisClient, groups *messaging.GroupService) *MLSService {
+	return &MLSService{db: db, redis: redisClient, groups: groups}
+}
+
+// CreateGroup records the MLS group an admin created for a group
+// conversation, at epoch 0
+func (s *MLSService) CreateGroup(userID, conversationID uint, groupID []byte, cipherSuite uint16) (*models.MLSGroup, error) {
+	if len(groupID) == 0 || len(groupID) > maxMLSGroupIDLength {
+		return nil, ErrInvalidMLSGroupID
+	}
+	if err := s.groups.RequireRole(userID, conversationID, models.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	group := models.MLSGroup{
+		ConversationID: conversationID,
+		GroupID:        groupID,
+		CipherSuite:    cipherSuite,
+		CreatedBy:      userID,
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&group)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to create MLS group: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrMLSGroupExists
+	}
+	return &group, nil
+}
+
+// Group returns the MLS group of a group conversation the user belongs to
+func (s *MLSService) Group(userID, conversationID uint) (*models.MLSGroup, error) {
+	if _, err := s.groups.MemberIDs(userID, conversationID); err != nil {
+		return nil, err
+	}
+
+	var group models.MLSGroup
+	err := s.db.Where("conversation_id = ?", conversationID).Take(&group).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMLSGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// Submit accepts a message from a member. Commits, proposals and application
+// messages must be for the group's current epoch; an accepted commit moves
+// the group to the next epoch, so of two commits racing for the same epoch
+// the second is rejected. A Welcome is relayed right after its commit.
+func (s *MLSService) Submit(senderID, conversationID uint, submission *MLSSubmission) (*MLSDelivery, error) {
+	if len(submission.Message)+len(submission.Welcome) > MaxMLSMessageSize {
+		return nil, ErrMLSMessageTooLarge
+	}
+	header, err := parseMLSGroupMessage(submission.Message)
+	if err != nil {
+		return nil, err
+	}
+	if submission.Welcome != nil {
+		if header.ContentType != MLSCommit {
+			return nil, ErrWelcomeWithoutCommit
+		}
+		if err := parseMLSWelcome(submission.Welcome); err != nil {
+			return nil, err
+		}
+	}
+
+	members, err := s.groups.MemberIDs(senderID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	delivery := &MLSDelivery{Members: members}
+	if submission.Welcome != nil {
+		if len(submission.WelcomeRecipients) == 0 {
+			return nil, ErrWelcomeRecipients
+		}
+		isMember := make(map[uint]bool, len(members))
+		for _, id := range members {
+			isMember[id] = true
+		}
+		for _, id := range submission.WelcomeRecipients {
+			if !isMember[id] {
+				return nil, ErrWelcomeRecipients
+			}
+		}
+		delivery.WelcomeRecipients = submission.WelcomeRecipients
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var group models.MLSGroup
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("conversation_id = ?", conversationID).Take(&group).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrMLSGroupNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(group.GroupID, header.GroupID) {
+			return ErrMLSGroupMismatch
+		}
+		if header.Epoch != group.Epoch {
+			return &EpochError{Epoch: header.Epoch, Current: group.Epoch}
+		}
+
+		delivery.Message = &models.MLSMessage{
+			ConversationID: conversationID,
+			Seq:            group.LastSeq + 1,
+			Epoch:          header.Epoch,
+			ContentType:    string(header.ContentType),
+			SenderID:       senderID,
+			Data:           submission.Message,
+		}
+		if err := tx.Create(delivery.Message).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"last_seq": delivery.Message.Seq}
+		if header.ContentType == MLSCommit {
+			updates["epoch"] = group.Epoch + 1
+		}
+
+		if submission.Welcome != nil {
+			delivery.Welcome = &models.MLSMessage{
+				ConversationID: conversationID,
+				Seq:            delivery.Message.Seq + 1,
+				Epoch:          group.Epoch + 1,
+				ContentType:    string(MLSWelcome),
+				SenderID:       senderID,
+				Data:           submission.Welcome,
+			}
+			if err := tx.Create(delivery.Welcome).Error; err != nil {
+				return err
+			}
+			recipients := make([]models.MLSWelcomeRecipient, 0, len(delivery.WelcomeRecipients))
+			for _, userID := range delivery.WelcomeRecipients {
+				recipients = append(recipients, models.MLSWelcomeRecipient{MessageID: delivery.Welcome.ID, UserID: userID})
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&recipients).Error; err != nil {
+				return err
+			}
+			updates["last_seq"] = delivery.Welcome.Seq
+		}
+
+		return tx.Model(&group).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Messages returns the group's messages after seq in delivery order, for
+// members catching up. Welcomes are only included for their recipients.
+func (s *MLSService) Messages(userID, conversationID uint, afterSeq uint64, limit int) ([]models.MLSMessage, error) {
+	if _, err := s.groups.MemberIDs(userID, conversationID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultMLSMessageLimit
+	}
+	if limit > MaxMLSMessageLimit {
+		limit = MaxMLSMessageLimit
+	}
+
+	var messages []models.MLSMessage
+	err := s.db.Where("conversation_id = ? AND seq > ?", conversationID, afterSeq).
+		Where(`(content_type <> ? OR EXISTS (
+			SELECT 1 FROM mls_welcome_recipient r WHERE r.message_id = mls_message.id AND r.user_id = ?))`, MLSWelcome, userID).
+		Order("seq ASC").
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// UploadKeyPackages stores KeyPackages for the device userID is signed in on
+// and returns how many it has available
+func (s *MLSService) UploadKeyPackages(userID, deviceID uint, keyPackages [][]byte) (int64, error) {
+	if deviceID == 0 {
+		return 0, ErrDeviceSessionRequired
+	}
+	if len(keyPackages) > MaxKeyPackagesPerUpload {
+		return 0, ErrTooManyKeyPackages
+	}
+
+	rows := make([]models.MLSKeyPackage, 0, len(keyPackages))
+	for _, data := range keyPackages {
+		if len(data) > MaxMLSMessageSize {
+			return 0, ErrMLSMessageTooLarge
+		}
+		cipherSuite, err := parseMLSKeyPackage(data)
+		if err != nil {
+			return 0, err
+		}
+		rows = append(rows, models.MLSKeyPackage{UserID: userID, DeviceID: deviceID, CipherSuite: cipherSuite, Data: data})
+	}
+
+	var available int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.MLSKeyPackage{}).Where("device_id = ?", deviceID).Count(&available).Error; err != nil {
+			return err
+		}
+		if available+int64(len(rows)) > MaxKeyPackagesPerDevice {
+			return ErrTooManyKeyPackages
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := tx.Create(&rows).Error; err != nil {
+			return err
+		}
+		available += int64(len(rows))
+		return nil
+	})
+	return available, err
+}
+
+// KeyPackageCount returns how many KeyPackages the device has available
+func (s *MLSService) KeyPackageCount(deviceID uint) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.MLSKeyPackage{}).Where("device_id = ?", deviceID).Count(&count).Error
+	return count, err
+}
+
+// ClaimKeyPackages hands out one KeyPackage of the cipher suite for each
+// signed-in device of userID, so that all of them can be added to a group.
+// Claimed KeyPackages are deleted; concurrent claims get different ones.
+// Claims are rate-limited per claimer and target user like prekey bundles.
+func (s *MLSService) ClaimKeyPackages(claimerID, userID uint, cipherSuite uint16) ([]models.MLSKeyPackage, error) {
+	if err := checkClaimRate(s.redis, claimerID, userID); err != nil {
+		return nil, err
+	}
+
+	var claimed []models.MLSKeyPackage
+	err := s.db.Raw(`DELETE FROM mls_key_package WHERE id IN (
+			SELECT k.id FROM (
+				SELECT DISTINCT device_id FROM mls_key_package WHERE user_id = ? AND cipher_suite = ?
+			) d
+			JOIN session ON session.id = d.device_id AND session.revoked_at IS NULL AND session.expires_at > ?
+			CROSS JOIN LATERAL (
+				SELECT id FROM mls_key_package p
+				WHERE p.device_id = d.device_id AND p.cipher_suite = ?
+				ORDER BY p.id
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			) k)
+		RETURNING id, user_id, device_id, cipher_suite, data, created_at`,
+		userID, cipherSuite, time.Now(), cipherSuite).Scan(&claimed).Error
+	return claimed, err
+}
@@ -0,0 +1,227 @@
+package e2ee
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/everest-an/dchat-backend/internal/testutil"
+)
+
+// tlsVector encodes a variable-length vector with the shortest length prefix
+func tlsVector(data []byte) []byte {
+	n := len(data)
+	var prefix []byte
+	switch {
+	case n < 1<<6:
+		prefix = []byte{byte(n)}
+	case n < 1<<14:
+		prefix = []byte{0x40 | byte(n>>8), byte(n)}
+	default:
+		prefix = []byte{0x80 | byte(n>>24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	return append(prefix, data...)
+}
+
+func mlsMessage(wireFormat uint16, body ...[]byte) []byte {
+	out := binary.BigEndian.AppendUint16(nil, mlsVersion10)
+	out = binary.BigEndian.AppendUint16(out, wireFormat)
+	for _, b := range body {
+		out = append(out, b...)
+	}
+	return out
+}
+
+func uint64Bytes(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+func TestTLSReaderVector(t *testing.T) {
+	for _, n := range []int{0, 1, 63, 64, 300, 16383, 16384, 70000} {
+		data := bytes.Repeat([]byte{0xab}, n)
+		r := &tlsReader{data: append(tlsVector(data), 0x01)}
+
+		got, err := r.vector()
+		if err != nil {
+			t.Fatalf("length %d: %v", n, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("length %d: read %d bytes", n, len(got))
+		}
+		if len(r.data) != 1 {
+			t.Fatalf("length %d: %d bytes left, want 1", n, len(r.data))
+		}
+	}
+}
+
+func TestTLSReaderVectorRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"two-byte prefix for a short length", []byte{0x40, 0x05, 1, 2, 3, 4, 5}},
+		{"four-byte prefix for a short length", []byte{0x80, 0x00, 0x00, 0x05, 1, 2, 3, 4, 5}},
+		{"reserved eight-byte prefix", []byte{0xc0, 0, 0, 0, 0, 0, 0, 1, 1}},
+		{"truncated prefix", []byte{0x80, 0x00}},
+		{"truncated data", []byte{0x05, 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &tlsReader{data: tt.data}
+			if _, err := r.vector(); !errors.Is(err, ErrMalformedMLSMessage) {
+				t.Fatalf("err = %v, want ErrMalformedMLSMessage", err)
+			}
+		})
+	}
+}
+
+func TestTLSReaderIntegers(t *testing.T) {
+	r := &tlsReader{data: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0, 0, 0, 0, 0, 0, 0x01, 0x00}}
+	u8, _ := r.uint8()
+	u16, _ := r.uint16()
+	u32, _ := r.uint32()
+	u64, err := r.uint64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u8 != 0x01 || u16 != 0x0203 || u32 != 0x04050607 || u64 != 0x100 {
+		t.Fatalf("read %#x %#x %#x %#x", u8, u16, u32, u64)
+	}
+	if _, err := r.uint8(); !errors.Is(err, ErrMalformedMLSMessage) {
+		t.Fatalf("read past the end: err = %v", err)
+	}
+}
+
+func TestParseMLSGroupMessage(t *testing.T) {
+	groupID := []byte("group-1")
+	tests := []struct {
+		name    string
+		message []byte
+		want    MLSContentType
+	}{
+		{
+			"public commit from a member",
+			mlsMessage(wireFormatPublicMessage, tlsVector(groupID), uint64Bytes(7),
+				[]byte{senderTypeMember, 0, 0, 0, 2}, tlsVector([]byte("aad")), []byte{3}, []byte("commit body")),
+			MLSCommit,
+		},
+		{
+			"public proposal from an external sender",
+			mlsMessage(wireFormatPublicMessage, tlsVector(groupID), uint64Bytes(7),
+				[]byte{senderTypeExternal, 0, 0, 0, 0}, tlsVector(nil), []byte{2}),
+			MLSProposal,
+		},
+		{
+			"external commit",
+			mlsMessage(wireFormatPublicMessage, tlsVector(groupID), uint64Bytes(7),
+				[]byte{senderTypeNewMemberCommit}, tlsVector(nil), []byte{3}),
+			MLSCommit,
+		},
+		{
+			"private application message",
+			mlsMessage(wireFormatPrivateMessage, tlsVector(groupID), uint64Bytes(7), []byte{1}, tlsVector([]byte("ciphertext"))),
+			MLSApplication,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := parseMLSGroupMessage(tt.message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(header.GroupID, groupID) || header.Epoch != 7 || header.ContentType != tt.want {
+				t.Fatalf("header = %+v", header)
+			}
+		})
+	}
+}
+
+func TestParseMLSGroupMessageRejects(t *testing.T) {
+	valid := mlsMessage(wireFormatPublicMessage, tlsVector([]byte("group-1")), uint64Bytes(7),
+		[]byte{senderTypeMember, 0, 0, 0, 2}, tlsVector(nil), []byte{3})
+
+	// Every truncation of a valid message
+	for n := 0; n < len(valid); n++ {
+		if _, err := parseMLSGroupMessage(valid[:n]); !errors.Is(err, ErrMalformedMLSMessage) {
+			t.Fatalf("truncated to %d bytes: err = %v", n, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		message []byte
+	}{
+		{"unknown version", append([]byte{0, 2}, valid[2:]...)},
+		{"welcome", mlsMessage(wireFormatWelcome, tlsVector([]byte("group-1")), uint64Bytes(7), []byte{1})},
+		{"unknown sender type", mlsMessage(wireFormatPublicMessage, tlsVector([]byte("group-1")), uint64Bytes(7), []byte{9}, tlsVector(nil), []byte{1})},
+		{"unknown content type", mlsMessage(wireFormatPrivateMessage, tlsVector([]byte("group-1")), uint64Bytes(7), []byte{4})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseMLSGroupMessage(tt.message); !errors.Is(err, ErrMalformedMLSMessage) {
+				t.Fatalf("err = %v, want ErrMalformedMLSMessage", err)
+			}
+		})
+	}
+}
+
+func TestParseMLSWelcome(t *testing.T) {
+	if err := parseMLSWelcome(mlsMessage(wireFormatWelcome, []byte("welcome body"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := parseMLSWelcome(mlsMessage(wireFormatKeyPackage)); !errors.Is(err, ErrMalformedMLSMessage) {
+		t.Fatalf("key package as welcome: err = %v", err)
+	}
+	if err := parseMLSWelcome([]byte{0, 1}); !errors.Is(err, ErrMalformedMLSMessage) {
+		t.Fatalf("truncated welcome: err = %v", err)
+	}
+}
+
+func TestParseMLSKeyPackage(t *testing.T) {
+	suite, err := parseMLSKeyPackage(mlsMessage(wireFormatKeyPackage, []byte{0, mlsVersion10, 0, 3}, []byte("init key")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if suite != 3 {
+		t.Fatalf("cipher suite = %d, want 3", suite)
+	}
+
+	for name, message := range map[string][]byte{
+		"welcome":            mlsMessage(wireFormatWelcome, []byte{0, mlsVersion10, 0, 3}),
+		"unknown version":    mlsMessage(wireFormatKeyPackage, []byte{0, 2, 0, 3}),
+		"missing suite":      mlsMessage(wireFormatKeyPackage, []byte{0, mlsVersion10}),
+		"truncated envelope": {0, mlsVersion10, 0},
+	} {
+		if _, err := parseMLSKeyPackage(message); !errors.Is(err, ErrMalformedMLSMessage) {
+			t.Errorf("%s: err = %v, want ErrMalformedMLSMessage", name, err)
+		}
+	}
+}
+
+func TestSubmitSizeCoversWelcome(t *testing.T) {
+	service := NewMLSService(nil, nil, nil)
+	submission := &MLSSubmission{
+		Message: make([]byte, MaxMLSMessageSize/2+1),
+		Welcome: make([]byte, MaxMLSMessageSize/2),
+	}
+	if _, err := service.Submit(1, 1, submission); !errors.Is(err, ErrMLSMessageTooLarge) {
+		t.Fatalf("commit and Welcome over the limit together: err = %v", err)
+	}
+}
+
+func TestClaimKeyPackagesRateLimited(t *testing.T) {
+	redisClient, _ := testutil.NewRedis(t)
+	service := NewMLSService(nil, redisClient, nil)
+
+	// Prekey bundles and KeyPackages draw on the same budget
+	for i := 0; i < MaxPreKeyClaimsPerTarget; i++ {
+		if err := checkClaimRate(redisClient, 1, 2); err != nil {
+			t.Fatalf("claim %d: %v", i+1, err)
+		}
+	}
+	if _, err := service.ClaimKeyPackages(1, 2, 1); !errors.Is(err, ErrClaimRateLimited) {
+		t.Fatalf("claim over the limit: err = %v, want ErrClaimRateLimited", err)
+	}
+}
@@ -0,0 +1,339 @@
+package e2ee
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Most one-time prekeys accepted per upload, and kept per device
+	MaxPreKeysPerUpload = 100
+	MaxPreKeysPerDevice = 500
+	// Devices are told to upload more when a claim leaves this many or fewer
+	LowPreKeyThreshold = 10
+
+	// Claims of prekey bundles and MLS KeyPackages one user may make for the
+	// devices of another per window
+	MaxPreKeyClaimsPerTarget = 30
+	preKeyClaimRateKeyPrefix = "prekey:claims:"
+	preKeyClaimRateWindow    = time.Hour
+)
+
+// Low-prekey warnings a device has been sent since its last upload
+const (
+	preKeyWarningNone = iota
+	preKeyWarningLow
+	preKeyWarningEmpty
+)
+
+var (
+	ErrInvalidKey            = errors.New("keys must be base64-encoded 32-byte public keys")
+	ErrInvalidSignature      = errors.New("signed prekey signature does not verify against the identity key")
+	ErrIdentityKeyChanged    = errors.New("identity key of a device cannot change; sign in again to register a new device")
+	ErrIdentityKeyRequired   = errors.New("identity key is required to register a device")
+	ErrTooManyPreKeys        = errors.New("too many one-time prekeys")
+	ErrDeviceNotFound        = errors.New("device has no published keys")
+	ErrSignedPreKeyMissing   = errors.New("device has not published a signed prekey")
+	ErrDuplicatePreKeyID     = errors.New("one-time prekey IDs must be unique")
+	ErrDeviceSessionRequired = errors.New("keys can only be uploaded from a signed-in device session")
+	ErrClaimRateLimited      = errors.New("too many keys claimed for this user, try again later")
+)
+
+// SignedPreKey is a medium-term X25519 key signed with the device's Ed25519
+// identity key; the signature covers the 32 raw public key bytes
+type SignedPreKey struct {
+	KeyID     uint32 `json:"key_id"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// PreKey is a one-time X25519 prekey
+type PreKey struct {
+	KeyID     uint32 `json:"key_id"`
+	PublicKey string `json:"public_key"`
+}
+
+// KeyUpload publishes a device's keys. The identity key is sent with every
+// upload; the signed prekey and one-time prekeys are optional, so devices
+// can rotate the one or top up the other.
+type KeyUpload struct {
+	IdentityKey    string        `json:"identity_key"`
+	SignedPreKey   *SignedPreKey `json:"signed_prekey"`
+	OneTimePreKeys []PreKey      `json:"one_time_prekeys"`
+}
+
+// Device is a device with published keys
+type Device struct {
+	DeviceID    uint   `json:"device_id"`
+	IdentityKey string `json:"identity_key"`
+}
+
+// Bundle is what an initiator needs to run X3DH against one device. The
+// one-time prekey is absent once the device has run out.
+type Bundle struct {
+	UserID        uint         `json:"user_id"`
+	DeviceID      uint         `json:"device_id"`
+	IdentityKey   string       `json:"identity_key"`
+	SignedPreKey  SignedPreKey `json:"signed_prekey"`
+	OneTimePreKey *PreKey      `json:"one_time_prekey,omitempty"`
+	// How many one-time prekeys the device has left, and whether this claim
+	// is the first to leave it low or empty since it last uploaded
+	Remaining int64 `json:"-"`
+	NotifyLow bool  `json:"-"`
+}
+
+// PreKeyService is the key directory for Signal-style X3DH session setup.
+// It stores public keys only; the server never sees private keys or session
+// state. Devices are login sessions, and revoked or expired sessions stop
+// being offered to initiators.
+type PreKeyService struct {
+	db    *gorm.DB
+	redis *utils.RedisClient
+}
+
+func NewPreKeyService(db *gorm.DB, redisClient *utils.RedisClient) *PreKeyService {
+	return &PreKeyService{db: db, redis: redisClient}
+}
+
+// Upload registers or updates the keys of the device userID is signed in on
+func (s *PreKeyService) Upload(userID, deviceID uint, upload *KeyUpload) (int64, error) {
+	if deviceID == 0 {
+		return 0, ErrDeviceSessionRequired
+	}
+	if upload.IdentityKey == "" {
+		return 0, ErrIdentityKeyRequired
+	}
+	identityKey, err := decodeKey(upload.IdentityKey)
+	if err != nil {
+		return 0, err
+	}
+	if upload.SignedPreKey != nil {
+		if err := verifySignedPreKey(identityKey, upload.SignedPreKey); err != nil {
+			return 0, err
+		}
+	}
+	if len(upload.OneTimePreKeys) > MaxPreKeysPerUpload {
+		return 0, ErrTooManyPreKeys
+	}
+	seen := make(map[uint32]bool, len(upload.OneTimePreKeys))
+	for _, preKey := range upload.OneTimePreKeys {
+		if _, err := decodeKey(preKey.PublicKey); err != nil {
+			return 0, err
+		}
+		if seen[preKey.KeyID] {
+			return 0, ErrDuplicatePreKeyID
+		}
+		seen[preKey.KeyID] = true
+	}
+
+	var remaining int64
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var device models.DeviceKey
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("device_id = ?", deviceID).Take(&device).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			device = models.DeviceKey{DeviceID: deviceID, UserID: userID, IdentityKey: upload.IdentityKey}
+			if err := tx.Create(&device).Error; err != nil {
+				return fmt.Errorf("failed to register device keys: %w", err)
+			}
+		case err != nil:
+			return err
+		case device.UserID != userID || device.IdentityKey != upload.IdentityKey:
+			return ErrIdentityKeyChanged
+		}
+
+		if upload.SignedPreKey != nil {
+			if err := tx.Model(&device).Updates(map[string]interface{}{
+				"signed_prekey_id":         upload.SignedPreKey.KeyID,
+				"signed_prekey":            upload.SignedPreKey.PublicKey,
+				"signed_prekey_signature":  upload.SignedPreKey.Signature,
+				"signed_prekey_updated_at": time.Now(),
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(upload.OneTimePreKeys) > 0 {
+			var stored int64
+			if err := tx.Model(&models.OneTimePreKey{}).Where("device_id = ?", deviceID).Count(&stored).Error; err != nil {
+				return err
+			}
+			if stored+int64(len(upload.OneTimePreKeys)) > MaxPreKeysPerDevice {
+				return ErrTooManyPreKeys
+			}
+
+			rows := make([]models.OneTimePreKey, 0, len(upload.OneTimePreKeys))
+			for _, preKey := range upload.OneTimePreKeys {
+				rows = append(rows, models.OneTimePreKey{DeviceID: deviceID, KeyID: preKey.KeyID, PublicKey: preKey.PublicKey})
+			}
+			// Re-uploading a key ID keeps the key already stored
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&models.OneTimePreKey{}).Where("device_id = ?", deviceID).Count(&remaining).Error; err != nil {
+			return err
+		}
+		// A device that topped up can be warned again when it runs low
+		return tx.Model(&device).Update("prekey_warning", preKeyWarning(remaining)).Error
+	})
+	return remaining, err
+}
+
+// Remaining returns how many one-time prekeys the device has left
+func (s *PreKeyService) Remaining(deviceID uint) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.OneTimePreKey{}).Where("device_id = ?", deviceID).Count(&count).Error
+	return count, err
+}
+
+// Devices lists a user's devices that have published keys and are still signed in
+func (s *PreKeyService) Devices(userID uint) ([]Device, error) {
+	var devices []Device
+	err := s.db.Model(&models.DeviceKey{}).
+		Select("device_key.device_id, device_key.identity_key").
+		Scopes(activeDevices).
+		Where("device_key.user_id = ?", userID).
+		Order("device_key.device_id ASC").
+		Scan(&devices).Error
+	return devices, err
+}
+
+// Claim returns a bundle for one device of userID, removing the one-time
+// prekey it hands out so that no other initiator receives it. Concurrent
+// claims each get a different key. Claims are rate-limited per claimer and
+// target user, so one account cannot drain another's prekeys.
+func (s *PreKeyService) Claim(claimerID, userID, deviceID uint) (*Bundle, error) {
+	if err := checkClaimRate(s.redis, claimerID, userID); err != nil {
+		return nil, err
+	}
+
+	var bundle *Bundle
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var device models.DeviceKey
+		err := tx.Select("device_key.*").
+			Scopes(activeDevices).
+			Where("device_key.user_id = ? AND device_key.device_id = ?", userID, deviceID).
+			Take(&device).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrDeviceNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if device.SignedPreKeyID == nil {
+			return ErrSignedPreKeyMissing
+		}
+
+		bundle = &Bundle{
+			UserID:      userID,
+			DeviceID:    deviceID,
+			IdentityKey: device.IdentityKey,
+			SignedPreKey: SignedPreKey{
+				KeyID:     *device.SignedPreKeyID,
+				PublicKey: device.SignedPreKey,
+				Signature: device.SignedPreKeySignature,
+			},
+		}
+
+		// SKIP LOCKED lets concurrent claims take different keys without waiting
+		var claimed []models.OneTimePreKey
+		if err := tx.Raw(`DELETE FROM one_time_prekey
+			WHERE (device_id, key_id) IN (
+				SELECT device_id, key_id FROM one_time_prekey
+				WHERE device_id = ?
+				ORDER BY key_id
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED)
+			RETURNING device_id, key_id, public_key`, deviceID).Scan(&claimed).Error; err != nil {
+			return err
+		}
+		if len(claimed) > 0 {
+			bundle.OneTimePreKey = &PreKey{KeyID: claimed[0].KeyID, PublicKey: claimed[0].PublicKey}
+		}
+
+		if err := tx.Model(&models.OneTimePreKey{}).Where("device_id = ?", deviceID).Count(&bundle.Remaining).Error; err != nil {
+			return err
+		}
+
+		// Concurrent claims may all count more keys than are left, so the
+		// device is warned by whichever claim first sees it low or empty
+		warning := preKeyWarning(bundle.Remaining)
+		result := tx.Model(&models.DeviceKey{}).
+			Where("device_id = ? AND prekey_warning < ?", deviceID, warning).
+			Update("prekey_warning", warning)
+		if result.Error != nil {
+			return result.Error
+		}
+		bundle.NotifyLow = result.RowsAffected > 0
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// checkClaimRate counts a claim by claimerID of userID's keys. Prekey bundles
+// and KeyPackages share the budget, since either hands out keys a device
+// uploaded for strangers to use.
+func checkClaimRate(redisClient *utils.RedisClient, claimerID, userID uint) error {
+	count, err := redisClient.IncrWithTTL(fmt.Sprintf("%s%d:%d", preKeyClaimRateKeyPrefix, claimerID, userID), preKeyClaimRateWindow)
+	if err != nil {
+		return fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	if count > MaxPreKeyClaimsPerTarget {
+		return ErrClaimRateLimited
+	}
+	return nil
+}
+
+func preKeyWarning(remaining int64) int {
+	switch {
+	case remaining == 0:
+		return preKeyWarningEmpty
+	case remaining <= LowPreKeyThreshold:
+		return preKeyWarningLow
+	}
+	return preKeyWarningNone
+}
+
+// activeDevices keeps devices whose login session is still usable
+func activeDevices(db *gorm.DB) *gorm.DB {
+	return db.Joins("JOIN session ON session.id = device_key.device_id").
+		Where("session.revoked_at IS NULL AND session.expires_at > ?", time.Now())
+}
+
+func verifySignedPreKey(identityKey []byte, signed *SignedPreKey) error {
+	publicKey, err := decodeKey(signed.PublicKey)
+	if err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+	if !ed25519.Verify(ed25519.PublicKey(identityKey), publicKey, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// decodeKey reads a base64-encoded Curve25519 public key; identity keys are
+// Ed25519, which clients convert to X25519 for the DH steps of X3DH
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
@@ -0,0 +1,46 @@
+package e2ee
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/everest-an/dchat-backend/internal/testutil"
+)
+
+func TestPreKeyWarning(t *testing.T) {
+	tests := []struct {
+		remaining int64
+		want      int
+	}{
+		{LowPreKeyThreshold + 1, preKeyWarningNone},
+		{LowPreKeyThreshold, preKeyWarningLow},
+		{1, preKeyWarningLow},
+		{0, preKeyWarningEmpty},
+	}
+	for _, tt := range tests {
+		if got := preKeyWarning(tt.remaining); got != tt.want {
+			t.Errorf("preKeyWarning(%d) = %d, want %d", tt.remaining, got, tt.want)
+		}
+	}
+}
+
+func TestClaimRateLimitedPerTarget(t *testing.T) {
+	redisClient, _ := testutil.NewRedis(t)
+
+	for i := 0; i < MaxPreKeyClaimsPerTarget; i++ {
+		if err := checkClaimRate(redisClient, 1, 2); err != nil {
+			t.Fatalf("claim %d: %v", i+1, err)
+		}
+	}
+	if err := checkClaimRate(redisClient, 1, 2); !errors.Is(err, ErrClaimRateLimited) {
+		t.Fatalf("claim over the limit: err = %v, want ErrClaimRateLimited", err)
+	}
+
+	// Other targets and other claimers have their own budgets
+	if err := checkClaimRate(redisClient, 1, 3); err != nil {
+		t.Fatalf("claim for another user: %v", err)
+	}
+	if err := checkClaimRate(redisClient, 4, 2); err != nil {
+		t.Fatalf("claim by another user: %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+package e2ee
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Largest sealed message accepted, as base64 ciphertext
+	MaxSealedMessageSize = 64 << 10
+
+	minDeliveryTokenLength = 16
+	maxDeliveryTokenLength = 64
+
+	DefaultSealedMessageLimit = 100
+	MaxSealedMessageLimit     = 500
+
+	certificateVersion    = 0
+	sealedRateKeyPrefix   = "sealed:rate:"
+	sealedRateLimitWindow = time.Hour
+
+	// Sealed messages the recipient never acknowledges are deleted after
+	// this long, checked every sealedPruneInterval
+	sealedMessageRetention = 30 * 24 * time.Hour
+	sealedPruneInterval    = time.Hour
+)
+
+var (
+	ErrInvalidDeliveryToken    = errors.New("delivery token must be 16 to 64 bytes")
+	ErrDeliveryTokenRejected   = errors.New("recipient does not accept this sealed message")
+	ErrEmptySealedMessage      = errors.New("sealed message is empty")
+	ErrSealedMessageTooLarge   = errors.New("sealed message is too large")
+	ErrSealedSenderRateLimited = errors.New("too many sealed messages, try again later")
+	ErrInvalidCertificateKey   = errors.New("SEALED_SENDER_CERTIFICATE_KEY must be a base64 Ed25519 seed")
+)
+
+// DeliveryCertificate vouches for the sender of sealed messages. The sender
+// puts it inside the encrypted payload, where only the recipient sees it;
+// the recipient checks Signature over Certificate with the server's
+// certificate key. Certificate is a version byte, then the sender ID,
+// device ID and expiry in milliseconds as big-endian uint64s, then the
+// device's identity key with a two-byte length prefix.
+type DeliveryCertificate struct {
+	SenderID    uint   `json:"sender_id"`
+	DeviceID    uint   `json:"device_id"`
+	IdentityKey string `json:"identity_key,omitempty"`
+	// Milliseconds since the Unix epoch
+	Expires     int64  `json:"expires"`
+	Certificate []byte `json:"certificate"`
+	Signature   []byte `json:"signature"`
+}
+
+// SealedSenderService carries 1:1 messages without learning who sent them.
+// Senders prove who they are to the recipient with a short-lived delivery
+// certificate inside the ciphertext, and submit the message without
+// logging in. Since the server can't rate-limit or block senders it doesn't
+// know, delivery is gated by a token each recipient hands out to contacts
+// and can rotate at any time, and by per-recipient and per-IP rate limits.
+type SealedSenderService struct {
+	db                   *gorm.DB
+	redis                *utils.RedisClient
+	key                  ed25519.PrivateKey
+	certificateTTL       time.Duration
+	recipientHourlyLimit int64
+	ipHourlyLimit        int64
+}
+
+func NewSealedSenderService(db *gorm.DB, redisClient *utils.RedisClient, key ed25519.PrivateKey, cfg *config.SealedSenderConfig) *SealedSenderService {
+	return &SealedSenderService{
+		db:                   db,
+		redis:                redisClient,
+		key:                  key,
+		certificateTTL:       time.Duration(cfg.CertificateHours) * time.Hour,
+		recipientHourlyLimit: int64(cfg.RecipientHourlyLimit),
+		ipHourlyLimit:        int64(cfg.IPHourlyLimit),
+	}
+}
+
+// CertificateKey returns the configured certificate signing key. It is
+// required: recipients pin it, so it must not change when another secret
+// is rotated.
+func CertificateKey(cfg *config.Config) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(cfg.SealedSender.CertificateKey)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrInvalidCertificateKey
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// PublicKey is the key recipients verify delivery certificates with
+func (s *SealedSenderService) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// IssueCertificate signs a delivery certificate for the device userID is
+// signed in on, binding the device's identity key when it has published one
+func (s *SealedSenderService) IssueCertificate(userID, deviceID uint) (*DeliveryCertificate, error) {
+	if deviceID == 0 {
+		return nil, ErrDeviceSessionRequired
+	}
+
+	var device models.DeviceKey
+	err := s.db.Where("device_id = ? AND user_id = ?", deviceID, userID).Take(&device).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	certificate := &DeliveryCertificate{
+		SenderID:    userID,
+		DeviceID:    deviceID,
+		IdentityKey: device.IdentityKey,
+		Expires:     time.Now().Add(s.certificateTTL).UnixMilli(),
+	}
+	certificate.Certificate = certificate.encode()
+	certificate.Signature = ed25519.Sign(s.key, certificate.Certificate)
+	return certificate, nil
+}
+
+func (c *DeliveryCertificate) encode() []byte {
+	data := make([]byte, 0, 1+8+8+8+2+len(c.IdentityKey))
+	data = append(data, certificateVersion)
+	data = binary.BigEndian.AppendUint64(data, uint64(c.SenderID))
+	data = binary.BigEndian.AppendUint64(data, uint64(c.DeviceID))
+	data = binary.BigEndian.AppendUint64(data, uint64(c.Expires))
+	data = binary.BigEndian.AppendUint16(data, uint16(len(c.IdentityKey)))
+	return append(data, c.IdentityKey...)
+}
+
+// SetDeliveryToken enables sealed delivery to the user with a new token,
+// revoking the previous one
+func (s *SealedSenderService) SetDeliveryToken(userID uint, token []byte) error {
+	if len(token) < minDeliveryTokenLength || len(token) > maxDeliveryTokenLength {
+		return ErrInvalidDeliveryToken
+	}
+
+	hash := sha256.Sum256(token)
+	row := models.DeliveryToken{UserID: userID, TokenHash: hash[:]}
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"token_hash", "updated_at"}),
+	}).Create(&row).Error
+}
+
+// DisableSealedSender stops accepting sealed messages for the user
+func (s *SealedSenderService) DisableSealedSender(userID uint) error {
+	return s.db.Where("user_id = ?", userID).Delete(&models.DeliveryToken{}).Error
+}
+
+// Send stores a sealed message for the recipient. The same error is
+// returned whether the recipient doesn't exist, hasn't enabled sealed
+// delivery or the token is wrong, so tokens can't be used to probe users.
+func (s *SealedSenderService) Send(recipientID uint, token []byte, content, ipAddress string) (*models.SealedMessage, error) {
+	if content == "" {
+		return nil, ErrEmptySealedMessage
+	}
+	if len(content) > MaxSealedMessageSize {
+		return nil, ErrSealedMessageTooLarge
+	}
+	// Counted before the token is checked, to slow down guessing
+	if err := s.checkRate("ip:"+ipAddress, s.ipHourlyLimit); err != nil {
+		return nil, err
+	}
+
+	var row models.DeliveryToken
+	err := s.db.Where("user_id = ?", recipientID).Take(&row).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	hash := sha256.Sum256(token)
+	if subtle.ConstantTimeCompare(hash[:], row.TokenHash) != 1 {
+		return nil, ErrDeliveryTokenRejected
+	}
+	if err := s.checkRate(fmt.Sprintf("recipient:%d", recipientID), s.recipientHourlyLimit); err != nil {
+		return nil, err
+	}
+
+	message := &models.SealedMessage{RecipientID: recipientID, Content: content}
+	if err := s.db.Create(message).Error; err != nil {
+		return nil, fmt.Errorf("failed to store sealed message: %w", err)
+	}
+	return message, nil
+}
+
+// Messages returns the user's sealed messages with an ID above afterID,
+// oldest first. They are kept until the user acknowledges them or for
+// the retention window.
+func (s *SealedSenderService) Messages(userID, afterID uint, limit int) ([]models.SealedMessage, error) {
+	if limit <= 0 {
+		limit = DefaultSealedMessageLimit
+	}
+	if limit > MaxSealedMessageLimit {
+		limit = MaxSealedMessageLimit
+	}
+
+	var messages []models.SealedMessage
+	err := s.db.Where("recipient_id = ? AND id > ?", userID, afterID).Order("id ASC").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+// Acknowledge records that a device fetched the user's sealed messages up
+// to throughID, and deletes those that every active session of the user
+// has acknowledged, returning how many were deleted. A device that never
+// acknowledges holds messages back until the retention window.
+func (s *SealedSenderService) Acknowledge(userID, sessionID, throughID uint) (int64, error) {
+	if sessionID == 0 {
+		return 0, ErrDeviceSessionRequired
+	}
+
+	var deleted int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		ack := models.SealedMessageAck{SessionID: sessionID, UserID: userID, ThroughID: throughID}
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "session_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"through_id": gorm.Expr("GREATEST(sealed_message_ack.through_id, EXCLUDED.through_id)"),
+				"updated_at": gorm.Expr("EXCLUDED.updated_at"),
+			}),
+		}).Create(&ack).Error
+		if err != nil {
+			return err
+		}
+
+		// Sessions that never acknowledged hold everything back
+		var watermark *uint
+		err = tx.Table("session").
+			Select("MIN(COALESCE(a.through_id, 0))").
+			Joins("LEFT JOIN sealed_message_ack a ON a.session_id = session.id").
+			Where("session.user_id = ? AND session.revoked_at IS NULL AND session.expires_at > ?", userID, time.Now()).
+			Scan(&watermark).Error
+		if err != nil {
+			return err
+		}
+		if watermark == nil || *watermark == 0 {
+			return nil
+		}
+
+		result := tx.Where("recipient_id = ? AND id <= ?", userID, *watermark).Delete(&models.SealedMessage{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	return deleted, err
+}
+
+// Prune deletes sealed messages older than the retention window
+func (s *SealedSenderService) Prune() (int64, error) {
+	result := s.db.Where("created_at < ?", time.Now().Add(-sealedMessageRetention)).Delete(&models.SealedMessage{})
+	return result.RowsAffected, result.Error
+}
+
+// Run periodically prunes sealed messages past the retention window
+func (s *SealedSenderService) Run() {
+	ticker := time.NewTicker(sealedPruneInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := s.Prune()
+		if err != nil {
+			log.Printf("Failed to prune sealed messages: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("🧹 Pruned %d unacknowledged sealed messages", n)
+		}
+	}
+}
+
+func (s *SealedSenderService) checkRate(subject string, limit int64) error {
+	count, err := s.redis.IncrWithTTL(sealedRateKeyPrefix+subject, sealedRateLimitWindow)
+	if err != nil {
+		return fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	if count > limit {
+		return ErrSealedSenderRateLimited
+	}
+	return nil
+}
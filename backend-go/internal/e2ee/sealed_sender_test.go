@@ -0,0 +1,251 @@
+package e2ee
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+	"gorm.io/gorm"
+)
+
+var testSealedSenderConfig = config.SealedSenderConfig{CertificateHours: 24, RecipientHourlyLimit: 3, IPHourlyLimit: 5}
+
+func newTestSealedSenderService(t *testing.T, db *gorm.DB) *SealedSenderService {
+	t.Helper()
+	redisClient, _ := testutil.NewRedis(t)
+	_, key, _ := ed25519.GenerateKey(nil)
+	return NewSealedSenderService(db, redisClient, key, &testSealedSenderConfig)
+}
+
+func TestCertificateKey(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, ed25519.SeedSize)
+	cfg := &config.Config{}
+
+	cfg.SealedSender.CertificateKey = base64.StdEncoding.EncodeToString(seed)
+	key, err := CertificateKey(cfg)
+	if err != nil || !key.Equal(ed25519.NewKeyFromSeed(seed)) {
+		t.Fatalf("configured key: err = %v", err)
+	}
+
+	for _, value := range []string{"", "not base64", base64.StdEncoding.EncodeToString(seed[:31])} {
+		cfg.SealedSender.CertificateKey = value
+		if _, err := CertificateKey(cfg); !errors.Is(err, ErrInvalidCertificateKey) {
+			t.Fatalf("SEALED_SENDER_CERTIFICATE_KEY %q: err = %v, want ErrInvalidCertificateKey", value, err)
+		}
+	}
+}
+
+func TestDeliveryCertificateEncoding(t *testing.T) {
+	c := &DeliveryCertificate{SenderID: 3, DeviceID: 9, IdentityKey: "identity", Expires: 1760000000000}
+	data := c.encode()
+
+	want := []byte{certificateVersion}
+	want = binary.BigEndian.AppendUint64(want, 3)
+	want = binary.BigEndian.AppendUint64(want, 9)
+	want = binary.BigEndian.AppendUint64(want, 1760000000000)
+	want = binary.BigEndian.AppendUint16(want, uint16(len("identity")))
+	want = append(want, "identity"...)
+	if !bytes.Equal(data, want) {
+		t.Fatalf("encode() = %x, want %x", data, want)
+	}
+
+	// Every field is covered by the signature
+	other := *c
+	other.DeviceID = 10
+	if bytes.Equal(other.encode(), data) {
+		t.Fatal("certificates of two devices encode the same")
+	}
+}
+
+func TestIssueCertificate(t *testing.T) {
+	db := testutil.NewDB(t)
+	service := newTestSealedSenderService(t, db)
+	user := testutil.NewUser(t, db, "alice")
+
+	if _, err := service.IssueCertificate(user.ID, 0); !errors.Is(err, ErrDeviceSessionRequired) {
+		t.Fatalf("without a device session: err = %v", err)
+	}
+
+	certificate, err := service.IssueCertificate(user.ID, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certificate.SenderID != user.ID || certificate.DeviceID != 42 || certificate.IdentityKey != "" {
+		t.Fatalf("certificate = %+v", certificate)
+	}
+	if !bytes.Equal(certificate.Certificate, certificate.encode()) ||
+		!ed25519.Verify(service.PublicKey(), certificate.Certificate, certificate.Signature) {
+		t.Fatal("certificate signature does not verify")
+	}
+	expires := time.UnixMilli(certificate.Expires)
+	if d := time.Until(expires); d < 23*time.Hour || d > 24*time.Hour {
+		t.Fatalf("certificate expires in %v, want 24h", d)
+	}
+}
+
+func TestSealedSenderSend(t *testing.T) {
+	db := testutil.NewDB(t)
+	service := newTestSealedSenderService(t, db)
+	recipient := testutil.NewUser(t, db, "bob")
+	stranger := testutil.NewUser(t, db, "carol")
+	token := []byte("0123456789abcdef")
+
+	if err := service.SetDeliveryToken(recipient.ID, []byte("short")); !errors.Is(err, ErrInvalidDeliveryToken) {
+		t.Fatalf("short token: err = %v", err)
+	}
+	if err := service.SetDeliveryToken(recipient.ID, token); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		recipientID uint
+		token       []byte
+		content     string
+		want        error
+	}{
+		{"empty", recipient.ID, token, "", ErrEmptySealedMessage},
+		{"too large", recipient.ID, token, strings.Repeat("a", MaxSealedMessageSize+1), ErrSealedMessageTooLarge},
+		{"wrong token", recipient.ID, []byte("fedcba9876543210"), "sealed", ErrDeliveryTokenRejected},
+		{"recipient without sealed sender", stranger.ID, token, "sealed", ErrDeliveryTokenRejected},
+	}
+	for _, tt := range tests {
+		if _, err := service.Send(tt.recipientID, tt.token, tt.content, "10.0.0.1"); !errors.Is(err, tt.want) {
+			t.Fatalf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	message, err := service.Send(recipient.ID, token, "sealed", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.RecipientID != recipient.ID || message.Content != "sealed" {
+		t.Fatalf("stored %+v", message)
+	}
+
+	// Rotating the token revokes the old one
+	if err := service.SetDeliveryToken(recipient.ID, []byte("a new token 1234")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Send(recipient.ID, token, "sealed", "10.0.0.2"); !errors.Is(err, ErrDeliveryTokenRejected) {
+		t.Fatalf("revoked token: err = %v", err)
+	}
+	if err := service.DisableSealedSender(recipient.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Send(recipient.ID, []byte("a new token 1234"), "sealed", "10.0.0.2"); !errors.Is(err, ErrDeliveryTokenRejected) {
+		t.Fatalf("disabled sealed sender: err = %v", err)
+	}
+}
+
+func TestSealedSenderRateLimits(t *testing.T) {
+	db := testutil.NewDB(t)
+	service := newTestSealedSenderService(t, db)
+	recipient := testutil.NewUser(t, db, "bob")
+	token := []byte("0123456789abcdef")
+	if err := service.SetDeliveryToken(recipient.ID, token); err != nil {
+		t.Fatal(err)
+	}
+
+	// Guesses with a wrong token count against the IP
+	for i := 0; i < testSealedSenderConfig.IPHourlyLimit; i++ {
+		service.Send(recipient.ID, []byte("fedcba9876543210"), "sealed", "10.0.0.1")
+	}
+	if _, err := service.Send(recipient.ID, token, "sealed", "10.0.0.1"); !errors.Is(err, ErrSealedSenderRateLimited) {
+		t.Fatalf("IP over the limit: err = %v", err)
+	}
+
+	// Each sender IP is limited, and so is the recipient across all of them
+	for i := 0; i < testSealedSenderConfig.RecipientHourlyLimit; i++ {
+		if _, err := service.Send(recipient.ID, token, "sealed", fmt.Sprintf("10.0.1.%d", i)); err != nil {
+			t.Fatalf("message %d: %v", i+1, err)
+		}
+	}
+	if _, err := service.Send(recipient.ID, token, "sealed", "10.0.2.1"); !errors.Is(err, ErrSealedSenderRateLimited) {
+		t.Fatalf("recipient over the limit: err = %v", err)
+	}
+}
+
+func TestSealedMessagesAcknowledgeAndPrune(t *testing.T) {
+	db := testutil.NewDB(t)
+	service := newTestSealedSenderService(t, db)
+	recipient := testutil.NewUser(t, db, "bob")
+	other := testutil.NewUser(t, db, "carol")
+
+	var messages []*models.SealedMessage
+	for _, userID := range []uint{recipient.ID, recipient.ID, recipient.ID, other.ID} {
+		message := &models.SealedMessage{RecipientID: userID, Content: "sealed"}
+		if err := db.Create(message).Error; err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, message)
+	}
+
+	// Each of the recipient's devices acknowledges separately
+	var sessions []models.Session
+	for _, device := range []string{"phone", "laptop"} {
+		session := models.Session{UserID: recipient.ID, Device: device, ExpiresAt: time.Now().Add(time.Hour)}
+		if err := db.Create(&session).Error; err != nil {
+			t.Fatal(err)
+		}
+		sessions = append(sessions, session)
+	}
+	revoked := models.Session{UserID: recipient.ID, Device: "old", ExpiresAt: time.Now().Add(time.Hour), RevokedAt: &sessions[0].CreatedAt}
+	if err := db.Create(&revoked).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.Acknowledge(recipient.ID, 0, messages[3].ID); !errors.Is(err, ErrDeviceSessionRequired) {
+		t.Fatalf("without a device session: err = %v", err)
+	}
+	// The laptop hasn't fetched anything yet, so nothing is deleted
+	if deleted, err := service.Acknowledge(recipient.ID, sessions[0].ID, messages[3].ID); err != nil || deleted != 0 {
+		t.Fatalf("first device: deleted=%d err=%v", deleted, err)
+	}
+	if deleted, err := service.Acknowledge(recipient.ID, sessions[1].ID, messages[0].ID); err != nil || deleted != 1 {
+		t.Fatalf("second device through the first message: deleted=%d err=%v", deleted, err)
+	}
+	// Acknowledging an older ID doesn't move a device back
+	if deleted, err := service.Acknowledge(recipient.ID, sessions[0].ID, messages[0].ID); err != nil || deleted != 0 {
+		t.Fatalf("stale acknowledgement: deleted=%d err=%v", deleted, err)
+	}
+
+	// Once every active device has, the user's own messages are deleted
+	deleted, err := service.Acknowledge(recipient.ID, sessions[1].ID, messages[3].ID)
+	if err != nil || deleted != 2 {
+		t.Fatalf("acknowledge: deleted=%d err=%v", deleted, err)
+	}
+	remaining, _ := service.Messages(recipient.ID, 0, 0)
+	if len(remaining) != 0 {
+		t.Fatalf("%d messages left after acknowledging them", len(remaining))
+	}
+	if remaining, _ := service.Messages(other.ID, 0, 0); len(remaining) != 1 {
+		t.Fatalf("acknowledged another user's messages: %d left", len(remaining))
+	}
+
+	// Unacknowledged messages are pruned after the retention window
+	stale := &models.SealedMessage{RecipientID: recipient.ID, Content: "stale", CreatedAt: time.Now().Add(-sealedMessageRetention - time.Hour)}
+	fresh := &models.SealedMessage{RecipientID: recipient.ID, Content: "fresh"}
+	if err := db.Create(stale).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(fresh).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n, err := service.Prune(); err != nil || n < 1 {
+		t.Fatalf("prune: n=%d err=%v", n, err)
+	}
+	remaining, _ = service.Messages(recipient.ID, 0, 0)
+	if len(remaining) != 1 || remaining[0].ID != fresh.ID {
+		t.Fatalf("after pruning: %+v", remaining)
+	}
+}
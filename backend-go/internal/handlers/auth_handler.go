@@ -1,23 +1,28 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/everest-an/dchat-backend/internal/auth"
 	"github.com/gin-gonic/gin"
 )
 
 type AuthHandler struct {
-	userService *auth.UserService
-	jwtService  *auth.JWTService
-	web3Service *auth.Web3Service
+	userService    *auth.UserService
+	sessionService *auth.SessionService
+	web3Service    *auth.Web3Service
+	siweService    *auth.SIWEService
 }
 
-func NewAuthHandler(userService *auth.UserService, jwtService *auth.JWTService, web3Service *auth.Web3Service) *AuthHandler {
+func NewAuthHandler(userService *auth.UserService, sessionService *auth.SessionService, web3Service *auth.Web3Service, siweService *auth.SIWEService) *AuthHandler {
 	return &AuthHandler{
-		userService: userService,
-		jwtService:  jwtService,
-		web3Service: web3Service,
+		userService:    userService,
+		sessionService: sessionService,
+		web3Service:    web3Service,
+		siweService:    siweService,
 	}
 }
 
@@ -25,15 +30,16 @@ type WalletLoginRequest struct {
 	WalletAddress string `json:"wallet_address" binding:"required"`
 	Signature     string `json:"signature" binding:"required"`
 	Message       string `json:"message" binding:"required"`
+	DeviceName    string `json:"device_name"`
 }
 
 type LoginResponse struct {
-	Token   string      `json:"token"`
-	User    interface{} `json:"user"`
-	IsNewUser bool      `json:"is_new_user"`
+	*auth.TokenPair
+	User      interface{} `json:"user"`
+	IsNewUser bool        `json:"is_new_user"`
 }
 
-// WalletLogin handles MetaMask wallet login
+// WalletLogin handles Sign-In with Ethereum (EIP-4361) wallet login
 func (h *AuthHandler) WalletLogin(c *gin.Context) {
 	var req WalletLoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -41,49 +47,114 @@ func (h *AuthHandler) WalletLogin(c *gin.Context) {
 		return
 	}
 
-	// Verify signature
-	valid, err := h.web3Service.VerifySignature(req.WalletAddress, req.Message, req.Signature)
+	// Validate the SIWE message, its signature and consume the nonce
+	message, kind, err := h.siweService.Verify(req.WalletAddress, req.Message, req.Signature)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to verify signature"})
-		return
-	}
-
-	if !valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		switch {
+		case errors.Is(err, auth.ErrInvalidSIWEMessage), errors.Is(err, auth.ErrSIWEMismatch):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, auth.ErrInvalidSignature):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		case errors.Is(err, auth.ErrSIWEExpired), errors.Is(err, auth.ErrSIWENotYetValid), errors.Is(err, auth.ErrNonceNotFound):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify login"})
+		}
 		return
 	}
 
 	// Get or create user (ensures one wallet = one account)
-	user, isNew, err := h.userService.GetOrCreateUserByWallet(req.WalletAddress)
+	user, isNew, err := h.userService.GetOrCreateUserByWallet(message.ChainID, req.WalletAddress, kind)
 	if err != nil {
+		if errors.Is(err, auth.ErrWalletOtherChain) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process user"})
 		return
 	}
 
-	// Generate JWT token
-	token, err := h.jwtService.GenerateToken(user.ID, user.WalletAddress)
+	// Start a session with an access and refresh token
+	tokens, err := h.sessionService.CreateSession(user, deviceName(c, req.DeviceName), c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusOK, LoginResponse{
-		Token:     token,
+		TokenPair: tokens,
 		User:      user,
 		IsNewUser: isNew,
 	})
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+	DeviceName   string `json:"device_name"`
+}
+
+// Refresh exchanges a refresh token for a new token pair
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	tokens, err := h.sessionService.Refresh(req.RefreshToken, req.DeviceName, c.ClientIP())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidRefreshToken) || errors.Is(err, auth.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the current session and access token
+func (h *AuthHandler) Logout(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := h.sessionService.Logout(claims.(*auth.Claims)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// deviceName prefers the name sent by the client and falls back to the user agent
+func deviceName(c *gin.Context, name string) string {
+	if name == "" {
+		name = c.Request.UserAgent()
+	}
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	return name
+}
+
 type GetNonceRequest struct{
 	WalletAddress string `json:"wallet_address" binding:"required"`
+	// Optional chain to sign in on, the primary chain when omitted
+	ChainID int64 `json:"chain_id"`
 }
 
 type NonceResponse struct {
-	Nonce   string `json:"nonce"`
-	Message string `json:"message"`
+	Nonce          string    `json:"nonce"`
+	Message        string    `json:"message"`
+	IssuedAt       time.Time `json:"issued_at"`
+	ExpirationTime time.Time `json:"expiration_time"`
 }
 
-// GetNonce generates a nonce for wallet signature
+// GetNonce issues a SIWE message for the wallet to sign
 func (h *AuthHandler) GetNonce(c *gin.Context) {
 	var req GetNonceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -91,17 +162,26 @@ func (h *AuthHandler) GetNonce(c *gin.Context) {
 		return
 	}
 
-	nonce, err := h.web3Service.GenerateNonce()
+	if !common.IsHexAddress(req.WalletAddress) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid wallet address"})
+		return
+	}
+
+	message, err := h.siweService.IssueMessage(req.WalletAddress, req.ChainID)
 	if err != nil {
+		if errors.Is(err, auth.ErrUnsupportedChain) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate nonce"})
 		return
 	}
 
-	message := "Sign this message to authenticate with dChat: " + nonce
-
 	c.JSON(http.StatusOK, NonceResponse{
-		Nonce:   nonce,
-		Message: message,
+		Nonce:          message.Nonce,
+		Message:        message.String(),
+		IssuedAt:       message.IssuedAt,
+		ExpirationTime: *message.ExpirationTime,
 	})
 }
 
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/everest-an/dchat-backend/internal/messaging"
+	"github.com/gin-gonic/gin"
+)
+
+type ChannelHandler struct {
+	channelService *messaging.ChannelService
+}
+
+func NewChannelHandler(channelService *messaging.ChannelService) *ChannelHandler {
+	return &ChannelHandler{channelService: channelService}
+}
+
+type CreateChannelRequest struct {
+	Title  string `json:"title" binding:"required"`
+	Public bool   `json:"public"`
+}
+
+type MarkChannelReadRequest struct {
+	// Latest post the subscriber has seen; 0 means the latest post
+	MessageID uint `json:"message_id"`
+}
+
+// CreateChannel creates a channel owned by the current user
+func (h *ChannelHandler) CreateChannel(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req CreateChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	channel, err := h.channelService.Create(userID.(uint), req.Title, req.Public)
+	if err != nil {
+		h.respondError(c, err, "Failed to create channel")
+		return
+	}
+
+	c.JSON(http.StatusCreated, channel)
+}
+
+// SearchChannels lists public channels. Query parameters: q and limit.
+func (h *ChannelHandler) SearchChannels(c *gin.Context) {
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = parsed
+	}
+
+	channels, err := h.channelService.Search(c.Query("q"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search channels"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"channels": channels})
+}
+
+// GetChannel returns a channel and its subscriber count
+func (h *ChannelHandler) GetChannel(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	channelID, ok := parseIDParam(c, "id", "Invalid channel ID")
+	if !ok {
+		return
+	}
+
+	channel, err := h.channelService.Get(userID.(uint), channelID)
+	if err != nil {
+		h.respondError(c, err, "Failed to get channel")
+		return
+	}
+
+	c.JSON(http.StatusOK, channel)
+}
+
+// UpdateChannel changes the channel title or visibility
+func (h *ChannelHandler) UpdateChannel(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	channelID, ok := parseIDParam(c, "id", "Invalid channel ID")
+	if !ok {
+		return
+	}
+
+	var settings messaging.ChannelSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	channel, err := h.channelService.Update(userID.(uint), channelID, settings)
+	if err != nil {
+		h.respondError(c, err, "Failed to update channel")
+		return
+	}
+
+	c.JSON(http.StatusOK, channel)
+}
+
+// Subscribe subscribes the current user to a public channel
+func (h *ChannelHandler) Subscribe(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	channelID, ok := parseIDParam(c, "id", "Invalid channel ID")
+	if !ok {
+		return
+	}
+
+	if err := h.channelService.Subscribe(userID.(uint), channelID); err != nil {
+		h.respondError(c, err, "Failed to subscribe")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// Unsubscribe removes the current user from the channel
+func (h *ChannelHandler) Unsubscribe(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	channelID, ok := parseIDParam(c, "id", "Invalid channel ID")
+	if !ok {
+		return
+	}
+
+	if err := h.channelService.Unsubscribe(userID.(uint), channelID); err != nil {
+		h.respondError(c, err, "Failed to unsubscribe")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// InviteSubscribers subscribes users to the channel
+func (h *ChannelHandler) InviteSubscribers(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	channelID, ok := parseIDParam(c, "id", "Invalid channel ID")
+	if !ok {
+		return
+	}
+
+	var req InviteMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.channelService.Invite(userID.(uint), channelID, req.UserIDs); err != nil {
+		h.respondError(c, err, "Failed to invite subscribers")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// RemoveSubscriber removes a subscriber from the channel
+func (h *ChannelHandler) RemoveSubscriber(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	channelID, ok := parseIDParam(c, "id", "Invalid channel ID")
+	if !ok {
+		return
+	}
+	targetID, ok := parseIDParam(c, "user_id", "Invalid user ID")
+	if !ok {
+		return
+	}
+
+	if err := h.channelService.RemoveSubscriber(userID.(uint), channelID, targetID); err != nil {
+		h.respondError(c, err, "Failed to remove subscriber")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// SetSubscriberRole makes a subscriber an admin who may post, or back
+func (h *ChannelHandler) SetSubscriberRole(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	channelID, ok := parseIDParam(c, "id", "Invalid channel ID")
+	if !ok {
+		return
+	}
+	targetID, ok := parseIDParam(c, "user_id", "Invalid user ID")
+	if !ok {
+		return
+	}
+
+	var req SetMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.channelService.SetRole(userID.(uint), channelID, targetID, req.Role); err != nil {
+		h.respondError(c, err, "Failed to change role")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// CreateInviteLink generates a new invite code, replacing the previous one
+func (h *ChannelHandler) CreateInviteLink(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	channelID, ok := parseIDParam(c, "id", "Invalid channel ID")
+	if !ok {
+		return
+	}
+
+	code, err := h.channelService.CreateInviteLink(userID.(uint), channelID)
+	if err != nil {
+		h.respondError(c, err, "Failed to create invite link")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invite_code": code})
+}
+
+// RevokeInviteLink disables joining the channel by link
+func (h *ChannelHandler) RevokeInviteLink(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	channelID, ok := parseIDParam(c, "id", "Invalid channel ID")
+	if !ok {
+		return
+	}
+
+	if err := h.channelService.RevokeInviteLink(userID.(uint), channelID); err != nil {
+		h.respondError(c, err, "Failed to revoke invite link")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// JoinChannel subscribes the current user to the channel an invite code belongs to
+func (h *ChannelHandler) JoinChannel(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	channel, err := h.channelService.JoinByLink(userID.(uint), c.Param("code"))
+	if err != nil {
+		h.respondError(c, err, "Failed to join channel")
+		return
+	}
+
+	c.JSON(http.StatusOK, channel)
+}
+
+// GetPosts retrieves a window of the channel's posts.
+// Query parameters: before, after or around (message ID) and limit.
+func (h *ChannelHandler) GetPosts(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	channelID, ok := parseIDParam(c, "id", "Invalid channel ID")
+	if !ok {
+		return
+	}
+
+	query, ok := parseHistoryQuery(c)
+	if !ok {
+		return
+	}
+
+	page, err := h.channelService.History(userID.(uint), channelID, query)
+	if err != nil {
+		if errors.Is(err, messaging.ErrCursorNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Cursor message not found"})
+			return
+		}
+		h.respondError(c, err, "Failed to get posts")
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// MarkChannelRead moves the current user's read position, counting views
+func (h *ChannelHandler) MarkChannelRead(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	channelID, ok := parseIDParam(c, "id", "Invalid channel ID")
+	if !ok {
+		return
+	}
+
+	var req MarkChannelReadRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+
+	position, err := h.channelService.MarkRead(userID.(uint), channelID, req.MessageID)
+	if err != nil {
+		h.respondError(c, err, "Failed to mark channel as read")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"last_read_message_id": position})
+}
+
+func (h *ChannelHandler) respondError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, messaging.ErrInvalidTitle), errors.Is(err, messaging.ErrInvalidMemberRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, messaging.ErrRoleForbidden), errors.Is(err, messaging.ErrCannotTargetMember),
+		errors.Is(err, messaging.ErrChannelInviteOnly):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, messaging.ErrChannelNotFound), errors.Is(err, messaging.ErrNotSubscribed),
+		errors.Is(err, messaging.ErrInvalidInviteCode):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/everest-an/dchat-backend/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+type EmailAuthHandler struct {
+	emailAuthService *auth.EmailAuthService
+}
+
+func NewEmailAuthHandler(emailAuthService *auth.EmailAuthService) *EmailAuthHandler {
+	return &EmailAuthHandler{emailAuthService: emailAuthService}
+}
+
+type RegisterRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Name     string `json:"name"`
+}
+
+// Register creates an email + password account and sends a verification mail
+func (h *EmailAuthHandler) Register(c *gin.Context) {
+	var req RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.emailAuthService.Register(req.Email, req.Password, req.Name); err != nil {
+		h.respondError(c, err, "Failed to register")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Check your inbox to verify your email"})
+}
+
+type EmailLoginRequest struct {
+	Email      string `json:"email" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+	DeviceName string `json:"device_name"`
+}
+
+// Login authenticates with email and password
+func (h *EmailAuthHandler) Login(c *gin.Context) {
+	var req EmailLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	tokens, user, err := h.emailAuthService.Login(req.Email, req.Password, deviceName(c, req.DeviceName), c.ClientIP())
+	if err != nil {
+		h.respondError(c, err, "Failed to log in")
+		return
+	}
+
+	c.JSON(http.StatusOK, LoginResponse{
+		TokenPair: tokens,
+		User:      user,
+		IsNewUser: false,
+	})
+}
+
+type EmailTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// VerifyEmail confirms an email address from the mailed link
+func (h *EmailAuthHandler) VerifyEmail(c *gin.Context) {
+	var req EmailTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	user, err := h.emailAuthService.VerifyEmail(req.Token)
+	if err != nil {
+		h.respondError(c, err, "Failed to verify email")
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+type EmailRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// ResendVerification sends the verification mail again
+func (h *EmailAuthHandler) ResendVerification(c *gin.Context) {
+	var req EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.emailAuthService.ResendVerification(req.Email); err != nil {
+		h.respondError(c, err, "Failed to send verification email")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the address is pending verification, a new link was sent"})
+}
+
+// RequestPasswordReset mails a password reset link
+func (h *EmailAuthHandler) RequestPasswordReset(c *gin.Context) {
+	var req EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.emailAuthService.RequestPasswordReset(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send reset email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the address is registered, a reset link was sent"})
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// ResetPassword sets a new password from a reset link
+func (h *EmailAuthHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.emailAuthService.ResetPassword(req.Token, req.NewPassword); err != nil {
+		h.respondError(c, err, "Failed to reset password")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated, please log in again"})
+}
+
+type LinkEmailRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password"`
+}
+
+// LinkEmail sends a verification mail to link an email to the current user
+func (h *EmailAuthHandler) LinkEmail(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req LinkEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.emailAuthService.RequestEmailLink(userID.(uint), req.Email, req.Password); err != nil {
+		h.respondError(c, err, "Failed to link email")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Check your inbox to confirm the email"})
+}
+
+func (h *EmailAuthHandler) respondError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidEmail), errors.Is(err, auth.ErrWeakPassword):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrEmailTaken), errors.Is(err, auth.ErrIdentityInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrInvalidCredentials), errors.Is(err, auth.ErrInvalidEmailToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrAccountLocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
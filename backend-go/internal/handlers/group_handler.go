@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/everest-an/dchat-backend/internal/messaging"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/websocket"
+	"github.com/gin-gonic/gin"
+)
+
+type GroupHandler struct {
+	groupService *messaging.GroupService
+	notifier     *websocket.Notifier
+}
+
+func NewGroupHandler(groupService *messaging.GroupService, notifier *websocket.Notifier) *GroupHandler {
+	return &GroupHandler{groupService: groupService, notifier: notifier}
+}
+
+type CreateGroupRequest struct {
+	Title     string `json:"title" binding:"required"`
+	MemberIDs []uint `json:"member_ids"`
+}
+
+type RenameGroupRequest struct {
+	Title string `json:"title" binding:"required"`
+}
+
+type InviteMembersRequest struct {
+	UserIDs []uint `json:"user_ids" binding:"required,min=1"`
+}
+
+type SetMemberRoleRequest struct {
+	Role models.MemberRole `json:"role" binding:"required"`
+}
+
+// CreateGroup creates a group owned by the current user
+func (h *GroupHandler) CreateGroup(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req CreateGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	group, err := h.groupService.Create(userID.(uint), req.Title, req.MemberIDs)
+	if err != nil {
+		h.respondError(c, err, "Failed to create group")
+		return
+	}
+
+	c.JSON(http.StatusCreated, group)
+}
+
+// GetGroup returns a group and its members
+func (h *GroupHandler) GetGroup(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, ok := parseIDParam(c, "id", "Invalid group ID")
+	if !ok {
+		return
+	}
+
+	group, err := h.groupService.Get(userID.(uint), groupID)
+	if err != nil {
+		h.respondError(c, err, "Failed to get group")
+		return
+	}
+
+	c.JSON(http.StatusOK, group)
+}
+
+// RenameGroup changes the group title
+func (h *GroupHandler) RenameGroup(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, ok := parseIDParam(c, "id", "Invalid group ID")
+	if !ok {
+		return
+	}
+
+	var req RenameGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	group, change, err := h.groupService.Rename(userID.(uint), groupID, req.Title)
+	if err != nil {
+		h.respondError(c, err, "Failed to rename group")
+		return
+	}
+	h.notifier.GroupChanged(change)
+
+	c.JSON(http.StatusOK, group)
+}
+
+// InviteMembers adds users to the group
+func (h *GroupHandler) InviteMembers(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, ok := parseIDParam(c, "id", "Invalid group ID")
+	if !ok {
+		return
+	}
+
+	var req InviteMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	change, err := h.groupService.Invite(userID.(uint), groupID, req.UserIDs)
+	if err != nil {
+		h.respondError(c, err, "Failed to invite members")
+		return
+	}
+	h.notifier.GroupChanged(change)
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// KickMember removes a member from the group
+func (h *GroupHandler) KickMember(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, ok := parseIDParam(c, "id", "Invalid group ID")
+	if !ok {
+		return
+	}
+	targetID, ok := parseIDParam(c, "user_id", "Invalid user ID")
+	if !ok {
+		return
+	}
+
+	change, err := h.groupService.Kick(userID.(uint), groupID, targetID)
+	if err != nil {
+		h.respondError(c, err, "Failed to remove member")
+		return
+	}
+	h.notifier.GroupChanged(change)
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// SetMemberRole promotes or demotes a member
+func (h *GroupHandler) SetMemberRole(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, ok := parseIDParam(c, "id", "Invalid group ID")
+	if !ok {
+		return
+	}
+	targetID, ok := parseIDParam(c, "user_id", "Invalid user ID")
+	if !ok {
+		return
+	}
+
+	var req SetMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	change, err := h.groupService.SetRole(userID.(uint), groupID, targetID, req.Role)
+	if err != nil {
+		h.respondError(c, err, "Failed to change role")
+		return
+	}
+	h.notifier.GroupChanged(change)
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// LeaveGroup removes the current user from the group
+func (h *GroupHandler) LeaveGroup(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, ok := parseIDParam(c, "id", "Invalid group ID")
+	if !ok {
+		return
+	}
+
+	change, err := h.groupService.Leave(userID.(uint), groupID)
+	if err != nil {
+		h.respondError(c, err, "Failed to leave group")
+		return
+	}
+	h.notifier.GroupChanged(change)
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// CreateInviteLink generates a new invite code, replacing the previous one
+func (h *GroupHandler) CreateInviteLink(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, ok := parseIDParam(c, "id", "Invalid group ID")
+	if !ok {
+		return
+	}
+
+	code, err := h.groupService.CreateInviteLink(userID.(uint), groupID)
+	if err != nil {
+		h.respondError(c, err, "Failed to create invite link")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invite_code": code})
+}
+
+// RevokeInviteLink disables joining the group by link
+func (h *GroupHandler) RevokeInviteLink(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, ok := parseIDParam(c, "id", "Invalid group ID")
+	if !ok {
+		return
+	}
+
+	if err := h.groupService.RevokeInviteLink(userID.(uint), groupID); err != nil {
+		h.respondError(c, err, "Failed to revoke invite link")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// JoinGroup adds the current user to the group an invite code belongs to
+func (h *GroupHandler) JoinGroup(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	group, change, err := h.groupService.JoinByLink(userID.(uint), c.Param("code"))
+	if err != nil {
+		h.respondError(c, err, "Failed to join group")
+		return
+	}
+	h.notifier.GroupChanged(change)
+
+	c.JSON(http.StatusOK, group)
+}
+
+// GetGroupMessages retrieves a window of the group's messages.
+// Query parameters: before, after or around (message ID) and limit.
+func (h *GroupHandler) GetGroupMessages(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, ok := parseIDParam(c, "id", "Invalid group ID")
+	if !ok {
+		return
+	}
+
+	query, ok := parseHistoryQuery(c)
+	if !ok {
+		return
+	}
+
+	page, err := h.groupService.History(userID.(uint), groupID, query)
+	if err != nil {
+		if errors.Is(err, messaging.ErrCursorNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Cursor message not found"})
+			return
+		}
+		h.respondError(c, err, "Failed to get messages")
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// MarkGroupRead moves the current user's read position to the latest message
+func (h *GroupHandler) MarkGroupRead(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, ok := parseIDParam(c, "id", "Invalid group ID")
+	if !ok {
+		return
+	}
+
+	lastMessageID, err := h.groupService.MarkRead(userID.(uint), groupID)
+	if err != nil {
+		h.respondError(c, err, "Failed to mark group as read")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"last_read_message_id": lastMessageID})
+}
+
+func (h *GroupHandler) respondError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, messaging.ErrInvalidTitle), errors.Is(err, messaging.ErrInvalidMemberRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, messaging.ErrRoleForbidden), errors.Is(err, messaging.ErrCannotTargetMember):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, messaging.ErrGroupNotFound), errors.Is(err, messaging.ErrNotGroupMember),
+		errors.Is(err, messaging.ErrInvalidInviteCode):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, messaging.ErrGroupFull):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// parseIDParam reads a numeric path parameter, responding with message if it is invalid
+func parseIDParam(c *gin.Context, name, message string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/everest-an/dchat-backend/internal/auth"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type IdentityHandler struct {
+	identityService *auth.IdentityService
+	sessionService  *auth.SessionService
+	siweService     *auth.SIWEService
+	linkedIn        *auth.LinkedInClient
+}
+
+func NewIdentityHandler(identityService *auth.IdentityService, sessionService *auth.SessionService, siweService *auth.SIWEService, linkedIn *auth.LinkedInClient) *IdentityHandler {
+	return &IdentityHandler{
+		identityService: identityService,
+		sessionService:  sessionService,
+		siweService:     siweService,
+		linkedIn:        linkedIn,
+	}
+}
+
+// ListIdentities returns the login methods linked to the current user
+func (h *IdentityHandler) ListIdentities(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	identities, err := h.identityService.ListIdentities(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve identities"})
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+type LinkWalletRequest struct {
+	WalletAddress string `json:"wallet_address" binding:"required"`
+	Signature     string `json:"signature" binding:"required"`
+	Message       string `json:"message" binding:"required"`
+}
+
+// LinkWallet links another wallet, proven by a SIWE signature from /auth/nonce
+func (h *IdentityHandler) LinkWallet(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req LinkWalletRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	message, _, err := h.siweService.Verify(req.WalletAddress, req.Message, req.Signature)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to prove wallet ownership: " + err.Error()})
+		return
+	}
+
+	// The same address can be linked once per chain
+	h.link(c, userID.(uint), models.IdentityWallet, auth.WalletChain(message.ChainID), req.WalletAddress)
+}
+
+type LinkedInCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// LinkLinkedIn links a LinkedIn account using an OAuth authorization code
+func (h *IdentityHandler) LinkLinkedIn(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req LinkedInCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	profile, ok := h.exchangeLinkedInCode(c, req.Code)
+	if !ok {
+		return
+	}
+
+	h.link(c, userID.(uint), models.IdentityLinkedIn, "", profile.Subject)
+}
+
+// LinkedInLogin signs in to the account a LinkedIn identity is linked to
+func (h *IdentityHandler) LinkedInLogin(c *gin.Context) {
+	var req LinkedInCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	profile, ok := h.exchangeLinkedInCode(c, req.Code)
+	if !ok {
+		return
+	}
+
+	user, err := h.identityService.FindUser(models.IdentityLinkedIn, "", profile.Subject)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No account is linked to this LinkedIn profile"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process user"})
+		return
+	}
+
+	tokens, err := h.sessionService.CreateSession(user, deviceName(c, ""), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, LoginResponse{
+		TokenPair: tokens,
+		User:      user,
+		IsNewUser: false,
+	})
+}
+
+// SetPrimary makes an identity the primary one of its type
+func (h *IdentityHandler) SetPrimary(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	identityID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid identity ID"})
+		return
+	}
+
+	identity, err := h.identityService.SetPrimary(userID.(uint), uint(identityID))
+	if err != nil {
+		if errors.Is(err, auth.ErrIdentityNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set primary identity"})
+		return
+	}
+
+	c.JSON(http.StatusOK, identity)
+}
+
+// Unlink removes a login method from the current user
+func (h *IdentityHandler) Unlink(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	identityID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid identity ID"})
+		return
+	}
+
+	if err := h.identityService.Unlink(userID.(uint), uint(identityID)); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrIdentityNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found"})
+		case errors.Is(err, auth.ErrLastIdentity):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked"})
+}
+
+func (h *IdentityHandler) link(c *gin.Context, userID uint, identityType models.IdentityType, chain, identifier string) {
+	identity, err := h.identityService.LinkIdentity(userID, identityType, chain, identifier)
+	if err != nil {
+		if errors.Is(err, auth.ErrIdentityInUse) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link identity"})
+		return
+	}
+
+	c.JSON(http.StatusOK, identity)
+}
+
+func (h *IdentityHandler) exchangeLinkedInCode(c *gin.Context, code string) (*auth.LinkedInProfile, bool) {
+	profile, err := h.linkedIn.Exchange(code)
+	if err != nil {
+		if errors.Is(err, auth.ErrLinkedInNotConfigured) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to verify LinkedIn account"})
+		return nil, false
+	}
+	return profile, true
+}
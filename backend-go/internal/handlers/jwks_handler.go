@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/everest-an/dchat-backend/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+type JWKSHandler struct {
+	keyring *auth.Keyring
+}
+
+func NewJWKSHandler(keyring *auth.Keyring) *JWKSHandler {
+	return &JWKSHandler{keyring: keyring}
+}
+
+// GetJWKS publishes the public keys used to verify access tokens
+func (h *JWKSHandler) GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keyring.JWKS())
+}
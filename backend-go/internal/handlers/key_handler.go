@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/everest-an/dchat-backend/internal/e2ee"
+	"github.com/everest-an/dchat-backend/internal/websocket"
+	"github.com/gin-gonic/gin"
+)
+
+type KeyHandler struct {
+	preKeyService *e2ee.PreKeyService
+	notifier      *websocket.Notifier
+}
+
+func NewKeyHandler(preKeyService *e2ee.PreKeyService, notifier *websocket.Notifier) *KeyHandler {
+	return &KeyHandler{preKeyService: preKeyService, notifier: notifier}
+}
+
+// UploadKeys publishes the identity key, signed prekey and one-time prekeys
+// of the device the request is made from
+func (h *KeyHandler) UploadKeys(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	sessionID, _ := c.Get("session_id")
+
+	var req e2ee.KeyUpload
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	remaining, err := h.preKeyService.Upload(userID.(uint), sessionID.(uint), &req)
+	if err != nil {
+		h.respondError(c, err, "Failed to upload keys")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"device_id": sessionID, "remaining": remaining})
+}
+
+// GetPreKeyCount returns how many one-time prekeys the current device has left
+func (h *KeyHandler) GetPreKeyCount(c *gin.Context) {
+	sessionID, _ := c.Get("session_id")
+
+	remaining, err := h.preKeyService.Remaining(sessionID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count prekeys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"device_id": sessionID, "remaining": remaining})
+}
+
+// GetDevices lists a user's devices that can receive encrypted messages
+func (h *KeyHandler) GetDevices(c *gin.Context) {
+	targetID, ok := parseIDParam(c, "user_id", "Invalid user ID")
+	if !ok {
+		return
+	}
+
+	devices, err := h.preKeyService.Devices(targetID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve devices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+// ClaimBundle hands out a prekey bundle for one of a user's devices,
+// consuming one of its one-time prekeys
+func (h *KeyHandler) ClaimBundle(c *gin.Context) {
+	targetID, ok := parseIDParam(c, "user_id", "Invalid user ID")
+	if !ok {
+		return
+	}
+	deviceID, ok := parseIDParam(c, "device_id", "Invalid device ID")
+	if !ok {
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	bundle, err := h.preKeyService.Claim(userID.(uint), targetID, deviceID)
+	if err != nil {
+		h.respondError(c, err, "Failed to claim prekey bundle")
+		return
+	}
+
+	// Warn once when the device runs low and again when it runs out
+	if bundle.NotifyLow {
+		h.notifier.PreKeysLow(targetID, deviceID, bundle.Remaining)
+	}
+
+	c.JSON(http.StatusOK, bundle)
+}
+
+func (h *KeyHandler) respondError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, e2ee.ErrInvalidKey), errors.Is(err, e2ee.ErrInvalidSignature),
+		errors.Is(err, e2ee.ErrIdentityKeyRequired), errors.Is(err, e2ee.ErrTooManyPreKeys),
+		errors.Is(err, e2ee.ErrDuplicatePreKeyID), errors.Is(err, e2ee.ErrDeviceSessionRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, e2ee.ErrIdentityKeyChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, e2ee.ErrDeviceNotFound), errors.Is(err, e2ee.ErrSignedPreKeyMissing):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, e2ee.ErrClaimRateLimited):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -1,26 +1,37 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
-	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/messaging"
+	"github.com/everest-an/dchat-backend/internal/websocket"
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
 type MessageHandler struct {
-	db *gorm.DB
+	messageService      *messaging.MessageService
+	conversationService *messaging.ConversationService
+	notifier            *websocket.Notifier
 }
 
-func NewMessageHandler(db *gorm.DB) *MessageHandler {
-	return &MessageHandler{db: db}
+func NewMessageHandler(messageService *messaging.MessageService, conversationService *messaging.ConversationService, notifier *websocket.Notifier) *MessageHandler {
+	return &MessageHandler{
+		messageService:      messageService,
+		conversationService: conversationService,
+		notifier:            notifier,
+	}
 }
 
 type SendMessageRequest struct {
 	ReceiverID uint   `json:"receiver_id" binding:"required"`
 	Content    string `json:"content" binding:"required"`
 	Encrypted  bool   `json:"encrypted"`
+	// Optional client-generated UUID that makes retries idempotent
+	ClientID string `json:"client_id"`
+	// Optional message in the same conversation that this one replies to
+	ReplyToID uint `json:"reply_to_id"`
 }
 
 // SendMessage handles sending a new message
@@ -34,26 +45,189 @@ func (h *MessageHandler) SendMessage(c *gin.Context) {
 		return
 	}
 
-	message := models.Message{
+	message, created, err := h.messageService.Send(&messaging.SendRequest{
 		SenderID:   senderID,
 		ReceiverID: req.ReceiverID,
+		ClientID:   req.ClientID,
 		Content:    req.Content,
 		Encrypted:  req.Encrypted,
-		Read:       false,
+		ReplyToID:  req.ReplyToID,
+	})
+	if err != nil {
+		if errors.Is(err, messaging.ErrInvalidClientID) || errors.Is(err, messaging.ErrInvalidReplyTarget) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return
 	}
+	h.notifier.MessageSent(message, created)
 
-	if err := h.db.Create(&message).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+	c.JSON(http.StatusOK, message)
+}
+
+type EditMessageRequest struct {
+	Content   string `json:"content" binding:"required"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+// EditMessage replaces the content of one of the current user's messages
+func (h *MessageHandler) EditMessage(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	messageID, ok := parseIDParam(c, "id", "Invalid message ID")
+	if !ok {
 		return
 	}
 
-	// Load sender and receiver info
-	h.db.Preload("Sender").Preload("Receiver").First(&message, message.ID)
+	var req EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
 
-	c.JSON(http.StatusOK, message)
+	change, err := h.messageService.Edit(userID.(uint), messageID, req.Content, req.Encrypted)
+	if err != nil {
+		h.respondChangeError(c, err, "Failed to edit message")
+		return
+	}
+	h.notifier.MessageEdited(userID.(uint), change)
+
+	c.JSON(http.StatusOK, change.Message)
+}
+
+// DeleteMessage deletes a message for the current user only, or for
+// everyone with ?for_everyone=true
+func (h *MessageHandler) DeleteMessage(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	messageID, ok := parseIDParam(c, "id", "Invalid message ID")
+	if !ok {
+		return
+	}
+
+	forEveryone, err := strconv.ParseBool(c.DefaultQuery("for_everyone", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid for_everyone"})
+		return
+	}
+
+	change, err := h.messageService.Delete(userID.(uint), messageID, forEveryone)
+	if err != nil {
+		h.respondChangeError(c, err, "Failed to delete message")
+		return
+	}
+	h.notifier.MessageDeleted(userID.(uint), change)
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "for_everyone": forEveryone})
+}
+
+// GetMessageEdits returns the earlier versions of a message
+func (h *MessageHandler) GetMessageEdits(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	messageID, ok := parseIDParam(c, "id", "Invalid message ID")
+	if !ok {
+		return
+	}
+
+	edits, err := h.messageService.Edits(userID.(uint), messageID)
+	if err != nil {
+		h.respondChangeError(c, err, "Failed to get edit history")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"edits": edits})
+}
+
+// GetThread retrieves the thread a message belongs to: its root message and a
+// window of the replies. Query parameters: before, after or around (message ID) and limit.
+func (h *MessageHandler) GetThread(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	messageID, ok := parseIDParam(c, "id", "Invalid message ID")
+	if !ok {
+		return
+	}
+
+	query, ok := parseHistoryQuery(c)
+	if !ok {
+		return
+	}
+
+	thread, err := h.messageService.Thread(userID.(uint), messageID, query)
+	if err != nil {
+		if errors.Is(err, messaging.ErrCursorNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.respondChangeError(c, err, "Failed to retrieve thread")
+		return
+	}
+
+	c.JSON(http.StatusOK, thread)
+}
+
+type ReactRequest struct {
+	Emoji string `json:"emoji" binding:"required"`
+}
+
+// AddReaction adds the current user's reaction to a message
+func (h *MessageHandler) AddReaction(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	messageID, ok := parseIDParam(c, "id", "Invalid message ID")
+	if !ok {
+		return
+	}
+
+	var req ReactRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	change, err := h.messageService.React(userID.(uint), messageID, req.Emoji)
+	if err != nil {
+		h.respondChangeError(c, err, "Failed to add reaction")
+		return
+	}
+	h.notifier.ReactionChanged(change)
+
+	c.JSON(http.StatusOK, gin.H{"reactions": change.Reactions})
+}
+
+// RemoveReaction removes the current user's reaction given by ?emoji=
+func (h *MessageHandler) RemoveReaction(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	messageID, ok := parseIDParam(c, "id", "Invalid message ID")
+	if !ok {
+		return
+	}
+
+	change, err := h.messageService.Unreact(userID.(uint), messageID, c.Query("emoji"))
+	if err != nil {
+		h.respondChangeError(c, err, "Failed to remove reaction")
+		return
+	}
+	h.notifier.ReactionChanged(change)
+
+	c.JSON(http.StatusOK, gin.H{"reactions": change.Reactions})
+}
+
+func (h *MessageHandler) respondChangeError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, messaging.ErrEmptyContent), errors.Is(err, messaging.ErrInvalidReaction):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, messaging.ErrNotMessageSender), errors.Is(err, messaging.ErrDeleteWindowExpired),
+		errors.Is(err, messaging.ErrSystemMessage):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, messaging.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, messaging.ErrMessageDeleted):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
 }
 
-// GetMessages retrieves messages between two users
+// GetMessages retrieves a window of the conversation with another user.
+// Query parameters: before, after or around (message ID) and limit.
 func (h *MessageHandler) GetMessages(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	currentUserID := userID.(uint)
@@ -65,72 +239,127 @@ func (h *MessageHandler) GetMessages(c *gin.Context) {
 		return
 	}
 
-	var messages []models.Message
-	err = h.db.
-		Where("(sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)",
-			currentUserID, otherUserID, otherUserID, currentUserID).
-		Order("created_at ASC").
-		Preload("Sender").
-		Preload("Receiver").
-		Find(&messages).Error
+	query, ok := parseHistoryQuery(c)
+	if !ok {
+		return
+	}
 
+	page, err := h.messageService.History(currentUserID, uint(otherUserID), query)
 	if err != nil {
+		if errors.Is(err, messaging.ErrCursorNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve messages"})
 		return
 	}
 
-	c.JSON(http.StatusOK, messages)
+	c.JSON(http.StatusOK, page)
 }
 
-// GetConversations retrieves all conversations for the current user
+// GetConversations returns a page of the current user's inbox.
+// Query parameters: cursor, limit and archived.
 func (h *MessageHandler) GetConversations(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	currentUserID := userID.(uint)
 
-	// Get distinct users who have conversations with current user
-	var conversations []struct {
-		UserID    uint   `json:"user_id"`
-		Name      string `json:"name"`
-		Username  string `json:"username"`
-		LastMessage string `json:"last_message"`
-		Timestamp string `json:"timestamp"`
-		Unread    int64  `json:"unread"`
-	}
-
-	err := h.db.Raw(`
-		SELECT DISTINCT
-			CASE 
-				WHEN m.sender_id = ? THEN m.receiver_id 
-				ELSE m.sender_id 
-			END as user_id,
-			u.name,
-			u.username,
-			(SELECT content FROM message 
-			 WHERE (sender_id = ? AND receiver_id = u.id) 
-			    OR (sender_id = u.id AND receiver_id = ?)
-			 ORDER BY created_at DESC LIMIT 1) as last_message,
-			(SELECT created_at FROM message 
-			 WHERE (sender_id = ? AND receiver_id = u.id) 
-			    OR (sender_id = u.id AND receiver_id = ?)
-			 ORDER BY created_at DESC LIMIT 1) as timestamp,
-			(SELECT COUNT(*) FROM message 
-			 WHERE sender_id = u.id AND receiver_id = ? AND read = false) as unread
-		FROM message m
-		JOIN "user" u ON u.id = CASE 
-			WHEN m.sender_id = ? THEN m.receiver_id 
-			ELSE m.sender_id 
-		END
-		WHERE m.sender_id = ? OR m.receiver_id = ?
-		ORDER BY timestamp DESC
-	`, currentUserID, currentUserID, currentUserID, currentUserID, currentUserID, currentUserID, currentUserID, currentUserID, currentUserID).
-		Scan(&conversations).Error
+	query := messaging.InboxQuery{
+		Cursor:   c.Query("cursor"),
+		Archived: c.Query("archived") == "true",
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		query.Limit = limit
+	}
 
+	page, err := h.conversationService.Inbox(currentUserID, query)
 	if err != nil {
+		if errors.Is(err, messaging.ErrInvalidInboxCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve conversations"})
 		return
 	}
 
-	c.JSON(http.StatusOK, conversations)
+	c.JSON(http.StatusOK, page)
+}
+
+// UpdateConversation changes the current user's mute, archive and pin settings
+func (h *MessageHandler) UpdateConversation(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	currentUserID := userID.(uint)
+
+	conversationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var settings messaging.ConversationSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	member, err := h.conversationService.UpdateSettings(currentUserID, uint(conversationID), settings)
+	if err != nil {
+		if errors.Is(err, messaging.ErrConversationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update conversation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+type DisappearingTimerRequest struct {
+	// Seconds new messages last, 0 to keep them
+	Seconds *int `json:"seconds" binding:"required"`
+}
+
+// SetDisappearingTimer changes how long new messages in a conversation last
+func (h *MessageHandler) SetDisappearingTimer(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	currentUserID := userID.(uint)
+
+	conversationID, ok := parseIDParam(c, "id", "Invalid conversation ID")
+	if !ok {
+		return
+	}
+
+	var req DisappearingTimerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	change, err := h.conversationService.SetDisappearingTimer(currentUserID, conversationID, *req.Seconds)
+	if err != nil {
+		switch {
+		case errors.Is(err, messaging.ErrInvalidDisappearingTimer), errors.Is(err, messaging.ErrDisappearingNotSupported):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, messaging.ErrRoleForbidden):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, messaging.ErrConversationNotFound), errors.Is(err, messaging.ErrGroupNotFound),
+			errors.Is(err, messaging.ErrNotGroupMember):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set disappearing timer"})
+		}
+		return
+	}
+	if change.Message != nil {
+		h.notifier.TimerChanged(change)
+	}
+
+	c.JSON(http.StatusOK, change.Conversation)
 }
 
 // MarkAsRead marks messages as read
@@ -145,14 +374,46 @@ func (h *MessageHandler) MarkAsRead(c *gin.Context) {
 		return
 	}
 
-	err = h.db.Model(&models.Message{}).
-		Where("sender_id = ? AND receiver_id = ? AND read = false", senderID, currentUserID).
-		Update("read", true).Error
-
+	messageIDs, err := h.messageService.MarkConversationRead(currentUserID, uint(senderID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark messages as read"})
 		return
 	}
+	h.notifier.MessagesRead(currentUserID, uint(senderID), messageIDs)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Messages marked as read"})
 }
+
+// parseHistoryQuery reads the before/after/around cursors and limit of a history request
+func parseHistoryQuery(c *gin.Context) (messaging.HistoryQuery, bool) {
+	var query messaging.HistoryQuery
+	cursors := 0
+
+	for name, target := range map[string]*uint{"before": &query.Before, "after": &query.After, "around": &query.Around} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || id == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + " cursor"})
+			return query, false
+		}
+		*target = uint(id)
+		cursors++
+	}
+	if cursors > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use only one of before, after and around"})
+		return query, false
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return query, false
+		}
+		query.Limit = limit
+	}
+	return query, true
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/everest-an/dchat-backend/internal/messaging"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseHistoryQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		query string
+		want  messaging.HistoryQuery
+		ok    bool
+	}{
+		{"", messaging.HistoryQuery{}, true},
+		{"before=42&limit=20", messaging.HistoryQuery{Before: 42, Limit: 20}, true},
+		{"after=7", messaging.HistoryQuery{After: 7}, true},
+		{"around=9&limit=11", messaging.HistoryQuery{Around: 9, Limit: 11}, true},
+		{"before=0", messaging.HistoryQuery{}, false},
+		{"after=-3", messaging.HistoryQuery{}, false},
+		{"around=abc", messaging.HistoryQuery{}, false},
+		{"before=99999999999", messaging.HistoryQuery{}, false},
+		{"before=1&after=2", messaging.HistoryQuery{}, false},
+		{"limit=0", messaging.HistoryQuery{}, false},
+		{"limit=ten", messaging.HistoryQuery{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/messages?"+tt.query, nil)
+
+			query, ok := parseHistoryQuery(c)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("status = %d, want 400", w.Code)
+				}
+				return
+			}
+			if query != tt.want {
+				t.Fatalf("query = %+v, want %+v", query, tt.want)
+			}
+		})
+	}
+}
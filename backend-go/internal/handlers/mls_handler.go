@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/everest-an/dchat-backend/internal/e2ee"
+	"github.com/everest-an/dchat-backend/internal/messaging"
+	"github.com/gin-gonic/gin"
+)
+
+type MLSHandler struct {
+	mlsService *e2ee.MLSService
+}
+
+func NewMLSHandler(mlsService *e2ee.MLSService) *MLSHandler {
+	return &MLSHandler{mlsService: mlsService}
+}
+
+// Binary fields are base64 in JSON
+type CreateMLSGroupRequest struct {
+	GroupID     []byte `json:"group_id" binding:"required"`
+	CipherSuite uint16 `json:"cipher_suite" binding:"required"`
+}
+
+type UploadKeyPackagesRequest struct {
+	KeyPackages [][]byte `json:"key_packages" binding:"required"`
+}
+
+// CreateMLSGroup registers the MLS group an admin set up for a group
+func (h *MLSHandler) CreateMLSGroup(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, ok := parseIDParam(c, "id", "Invalid group ID")
+	if !ok {
+		return
+	}
+
+	var req CreateMLSGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	group, err := h.mlsService.CreateGroup(userID.(uint), groupID, req.GroupID, req.CipherSuite)
+	if err != nil {
+		h.respondError(c, err, "Failed to create MLS group")
+		return
+	}
+
+	c.JSON(http.StatusCreated, group)
+}
+
+// GetMLSGroup returns a group's MLS group and its current epoch
+func (h *MLSHandler) GetMLSGroup(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, ok := parseIDParam(c, "id", "Invalid group ID")
+	if !ok {
+		return
+	}
+
+	group, err := h.mlsService.Group(userID.(uint), groupID)
+	if err != nil {
+		h.respondError(c, err, "Failed to get MLS group")
+		return
+	}
+
+	c.JSON(http.StatusOK, group)
+}
+
+// GetMLSMessages returns the MLS messages relayed after a sequence number,
+// for members catching up after being offline
+func (h *MLSHandler) GetMLSMessages(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, ok := parseIDParam(c, "id", "Invalid group ID")
+	if !ok {
+		return
+	}
+
+	var after uint64
+	if value := c.Query("after"); value != "" {
+		var err error
+		if after, err = strconv.ParseUint(value, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid after cursor"})
+			return
+		}
+	}
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+
+	messages, err := h.mlsService.Messages(userID.(uint), groupID, after, limit)
+	if err != nil {
+		h.respondError(c, err, "Failed to retrieve MLS messages")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
+
+// UploadKeyPackages publishes KeyPackages for the device the request is made from
+func (h *MLSHandler) UploadKeyPackages(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	sessionID, _ := c.Get("session_id")
+
+	var req UploadKeyPackagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	available, err := h.mlsService.UploadKeyPackages(userID.(uint), sessionID.(uint), req.KeyPackages)
+	if err != nil {
+		h.respondError(c, err, "Failed to upload key packages")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"device_id": sessionID, "available": available})
+}
+
+// GetKeyPackageCount returns how many KeyPackages the current device has left
+func (h *MLSHandler) GetKeyPackageCount(c *gin.Context) {
+	sessionID, _ := c.Get("session_id")
+
+	available, err := h.mlsService.KeyPackageCount(sessionID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count key packages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"device_id": sessionID, "available": available})
+}
+
+// ClaimKeyPackages hands out one KeyPackage for each of a user's devices so
+// they can be added to an MLS group
+func (h *MLSHandler) ClaimKeyPackages(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	targetID, ok := parseIDParam(c, "user_id", "Invalid user ID")
+	if !ok {
+		return
+	}
+	cipherSuite, err := strconv.ParseUint(c.Query("cipher_suite"), 10, 16)
+	if err != nil || cipherSuite == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cipher suite"})
+		return
+	}
+
+	keyPackages, err := h.mlsService.ClaimKeyPackages(userID.(uint), targetID, uint16(cipherSuite))
+	if err != nil {
+		h.respondError(c, err, "Failed to claim key packages")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"key_packages": keyPackages})
+}
+
+func (h *MLSHandler) respondError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, e2ee.ErrInvalidMLSGroupID), errors.Is(err, e2ee.ErrMalformedMLSMessage),
+		errors.Is(err, e2ee.ErrMLSMessageTooLarge), errors.Is(err, e2ee.ErrTooManyKeyPackages),
+		errors.Is(err, e2ee.ErrDeviceSessionRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, messaging.ErrRoleForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, e2ee.ErrMLSGroupNotFound), errors.Is(err, messaging.ErrGroupNotFound),
+		errors.Is(err, messaging.ErrNotGroupMember):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, e2ee.ErrMLSGroupExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, e2ee.ErrClaimRateLimited):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/everest-an/dchat-backend/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+type PhoneAuthHandler struct {
+	phoneAuthService *auth.PhoneAuthService
+}
+
+func NewPhoneAuthHandler(phoneAuthService *auth.PhoneAuthService) *PhoneAuthHandler {
+	return &PhoneAuthHandler{phoneAuthService: phoneAuthService}
+}
+
+type PhoneCodeRequest struct {
+	PhoneNumber string `json:"phone_number" binding:"required"`
+}
+
+type PhoneVerifyRequest struct {
+	PhoneNumber string `json:"phone_number" binding:"required"`
+	Code        string `json:"code" binding:"required"`
+	DeviceName  string `json:"device_name"`
+}
+
+// RequestLoginCode texts a login code to a phone number
+func (h *PhoneAuthHandler) RequestLoginCode(c *gin.Context) {
+	var req PhoneCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.phoneAuthService.RequestLoginCode(req.PhoneNumber, c.ClientIP()); err != nil {
+		h.respondError(c, err, "Failed to send code")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Code sent"})
+}
+
+// Login signs in with a phone number and the code texted to it
+func (h *PhoneAuthHandler) Login(c *gin.Context) {
+	var req PhoneVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	tokens, user, isNewUser, err := h.phoneAuthService.Login(req.PhoneNumber, req.Code, deviceName(c, req.DeviceName), c.ClientIP())
+	if err != nil {
+		h.respondError(c, err, "Failed to log in")
+		return
+	}
+
+	c.JSON(http.StatusOK, LoginResponse{
+		TokenPair: tokens,
+		User:      user,
+		IsNewUser: isNewUser,
+	})
+}
+
+// RequestVerificationCode texts a code to link a phone number to the current user
+func (h *PhoneAuthHandler) RequestVerificationCode(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req PhoneCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.phoneAuthService.RequestVerificationCode(userID.(uint), req.PhoneNumber, c.ClientIP()); err != nil {
+		h.respondError(c, err, "Failed to send code")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Code sent"})
+}
+
+// VerifyPhone links a phone number to the current user once the code matches
+func (h *PhoneAuthHandler) VerifyPhone(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req PhoneVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	identity, err := h.phoneAuthService.VerifyPhone(userID.(uint), req.PhoneNumber, req.Code)
+	if err != nil {
+		h.respondError(c, err, "Failed to verify phone number")
+		return
+	}
+
+	c.JSON(http.StatusOK, identity)
+}
+
+func (h *PhoneAuthHandler) respondError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidPhone):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrInvalidOTP):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrIdentityInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrOTPRateLimited), errors.Is(err, auth.ErrOTPAttemptsExceeded):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/everest-an/dchat-backend/internal/e2ee"
+	"github.com/everest-an/dchat-backend/internal/websocket"
+	"github.com/gin-gonic/gin"
+)
+
+type SealedSenderHandler struct {
+	sealedSenderService *e2ee.SealedSenderService
+	notifier            *websocket.Notifier
+}
+
+func NewSealedSenderHandler(sealedSenderService *e2ee.SealedSenderService, notifier *websocket.Notifier) *SealedSenderHandler {
+	return &SealedSenderHandler{sealedSenderService: sealedSenderService, notifier: notifier}
+}
+
+// Binary fields are base64 in JSON
+type DeliveryTokenRequest struct {
+	Token []byte `json:"token" binding:"required"`
+}
+
+type SendSealedMessageRequest struct {
+	RecipientID   uint   `json:"recipient_id" binding:"required"`
+	DeliveryToken []byte `json:"delivery_token" binding:"required"`
+	// Encrypted payload holding the sender's delivery certificate and message
+	Content string `json:"content" binding:"required"`
+}
+
+// IssueCertificate signs a short-lived delivery certificate for the current device
+func (h *SealedSenderHandler) IssueCertificate(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	sessionID, _ := c.Get("session_id")
+
+	certificate, err := h.sealedSenderService.IssueCertificate(userID.(uint), sessionID.(uint))
+	if err != nil {
+		h.respondError(c, err, "Failed to issue delivery certificate")
+		return
+	}
+
+	c.JSON(http.StatusOK, certificate)
+}
+
+// GetCertificateKey returns the Ed25519 key delivery certificates are signed with
+func (h *SealedSenderHandler) GetCertificateKey(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"algorithm": "Ed25519", "public_key": []byte(h.sealedSenderService.PublicKey())})
+}
+
+// SetDeliveryToken enables sealed delivery to the current user, replacing
+// the previous token so that contacts who only have it are cut off
+func (h *SealedSenderHandler) SetDeliveryToken(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req DeliveryTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.sealedSenderService.SetDeliveryToken(userID.(uint), req.Token); err != nil {
+		h.respondError(c, err, "Failed to set delivery token")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Sealed sender enabled"})
+}
+
+// DisableSealedSender stops accepting sealed messages for the current user
+func (h *SealedSenderHandler) DisableSealedSender(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	if err := h.sealedSenderService.DisableSealedSender(userID.(uint)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable sealed sender"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Sealed sender disabled"})
+}
+
+// SendSealedMessage accepts a message whose sender is hidden from the
+// server. The route is unauthenticated on purpose: the delivery token
+// authorizes it, and the certificate inside the content authenticates the
+// sender to the recipient.
+func (h *SealedSenderHandler) SendSealedMessage(c *gin.Context) {
+	var req SendSealedMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	message, err := h.sealedSenderService.Send(req.RecipientID, req.DeliveryToken, req.Content, c.ClientIP())
+	if err != nil {
+		h.respondError(c, err, "Failed to send sealed message")
+		return
+	}
+	h.notifier.SealedMessage(message)
+
+	c.JSON(http.StatusOK, gin.H{"id": message.ID, "created_at": message.CreatedAt})
+}
+
+// GetSealedMessages returns the current user's sealed messages after an ID
+func (h *SealedSenderHandler) GetSealedMessages(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var after uint64
+	if value := c.Query("after"); value != "" {
+		var err error
+		if after, err = strconv.ParseUint(value, 10, 32); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid after cursor"})
+			return
+		}
+	}
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+
+	messages, err := h.sealedSenderService.Messages(userID.(uint), uint(after), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sealed messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
+
+// AckSealedMessages records that the current device fetched the user's
+// sealed messages up to an ID. They are deleted once every device has.
+func (h *SealedSenderHandler) AckSealedMessages(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	sessionID, _ := c.Get("session_id")
+
+	through, err := strconv.ParseUint(c.Query("through"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid through cursor"})
+		return
+	}
+
+	deleted, err := h.sealedSenderService.Acknowledge(userID.(uint), sessionID.(uint), uint(through))
+	if err != nil {
+		h.respondError(c, err, "Failed to acknowledge sealed messages")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+func (h *SealedSenderHandler) respondError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, e2ee.ErrInvalidDeliveryToken), errors.Is(err, e2ee.ErrEmptySealedMessage),
+		errors.Is(err, e2ee.ErrSealedMessageTooLarge), errors.Is(err, e2ee.ErrDeviceSessionRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, e2ee.ErrDeliveryTokenRejected):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, e2ee.ErrSealedSenderRateLimited):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/messaging"
+	"github.com/gin-gonic/gin"
+)
+
+type SearchHandler struct {
+	messageService *messaging.MessageService
+}
+
+func NewSearchHandler(messageService *messaging.MessageService) *SearchHandler {
+	return &SearchHandler{messageService: messageService}
+}
+
+// SearchMessages runs a full-text search over the current user's plain-text messages.
+// Query parameters: q, conversation_id, user_id (sender), start_date, end_date,
+// has_attachment, cursor and limit. Dates are RFC 3339 timestamps or
+// YYYY-MM-DD days; a day as end_date includes the whole day.
+func (h *SearchHandler) SearchMessages(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	query := messaging.SearchQuery{
+		Text:   c.Query("q"),
+		Cursor: c.Query("cursor"),
+	}
+	for name, target := range map[string]*uint{
+		"conversation_id": &query.ConversationID,
+		"user_id":         &query.SenderID,
+	} {
+		if value := c.Query(name); value != "" {
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+				return
+			}
+			*target = uint(id)
+		}
+	}
+	if value := c.Query("start_date"); value != "" {
+		since, _, err := parseSearchDate(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date"})
+			return
+		}
+		query.Since = &since
+	}
+	if value := c.Query("end_date"); value != "" {
+		until, day, err := parseSearchDate(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date"})
+			return
+		}
+		if day {
+			until = until.AddDate(0, 0, 1)
+		}
+		query.Until = &until
+	}
+	if value := c.Query("has_attachment"); value != "" {
+		hasAttachment, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid has_attachment"})
+			return
+		}
+		query.HasAttachment = &hasAttachment
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		query.Limit = limit
+	}
+
+	page, err := h.messageService.Search(userID.(uint), query)
+	if err != nil {
+		if errors.Is(err, messaging.ErrEmptySearchQuery) || errors.Is(err, messaging.ErrInvalidSearchCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// parseSearchDate reads an RFC 3339 timestamp or a YYYY-MM-DD day in UTC,
+// reporting which one it was
+func parseSearchDate(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	return t, true, err
+}
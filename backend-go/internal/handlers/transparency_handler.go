@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/everest-an/dchat-backend/internal/transparency"
+	"github.com/gin-gonic/gin"
+)
+
+type TransparencyHandler struct {
+	keyLog *transparency.KeyLog
+}
+
+func NewTransparencyHandler(keyLog *transparency.KeyLog) *TransparencyHandler {
+	return &TransparencyHandler{keyLog: keyLog}
+}
+
+type PublishKeyRequest struct {
+	PublicKey string `json:"public_key" binding:"required"`
+}
+
+// PublishKey sets the current user's public key and logs it
+func (h *TransparencyHandler) PublishKey(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req PublishKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	entry, err := h.keyLog.Publish(userID.(uint), req.PublicKey)
+	if err != nil {
+		h.respondError(c, err, "Failed to publish public key")
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// GetLogKey returns the Ed25519 key tree heads are signed with
+func (h *TransparencyHandler) GetLogKey(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"algorithm": "Ed25519", "public_key": []byte(h.keyLog.PublicKey())})
+}
+
+// GetTreeHead returns the latest signed tree head
+func (h *TransparencyHandler) GetTreeHead(c *gin.Context) {
+	head, err := h.keyLog.Latest()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tree head"})
+		return
+	}
+
+	c.JSON(http.StatusOK, head)
+}
+
+// GetTreeHeadAt returns the signed tree head published at a tree size
+func (h *TransparencyHandler) GetTreeHeadAt(c *gin.Context) {
+	size, err := strconv.ParseUint(c.Param("size"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tree size"})
+		return
+	}
+
+	head, err := h.keyLog.TreeHeadAt(size)
+	if err != nil {
+		h.respondError(c, err, "Failed to get tree head")
+		return
+	}
+
+	c.JSON(http.StatusOK, head)
+}
+
+// GetConsistencyProof proves that the tree at size first is a prefix of
+// the tree at size second
+func (h *TransparencyHandler) GetConsistencyProof(c *gin.Context) {
+	first, err := strconv.ParseUint(c.Query("first"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid first tree size"})
+		return
+	}
+	second, err := strconv.ParseUint(c.Query("second"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid second tree size"})
+		return
+	}
+
+	proof, err := h.keyLog.Consistency(first, second)
+	if err != nil {
+		h.respondError(c, err, "Failed to build consistency proof")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"first": first, "second": second, "proof": proof})
+}
+
+// GetInclusionProof proves that a user's current key is in the latest tree
+func (h *TransparencyHandler) GetInclusionProof(c *gin.Context) {
+	targetID, ok := parseIDParam(c, "id", "Invalid user ID")
+	if !ok {
+		return
+	}
+
+	inclusion, err := h.keyLog.Inclusion(targetID)
+	if err != nil {
+		h.respondError(c, err, "Failed to build inclusion proof")
+		return
+	}
+
+	c.JSON(http.StatusOK, inclusion)
+}
+
+func (h *TransparencyHandler) respondError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, transparency.ErrPublicKeyTooLong), errors.Is(err, transparency.ErrInvalidTreeSize):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, transparency.ErrUserNotFound), errors.Is(err, transparency.ErrKeyNotPublished),
+		errors.Is(err, transparency.ErrTreeHeadNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/everest-an/dchat-backend/internal/auth"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+type UserHandler struct {
+	userService *auth.UserService
+}
+
+func NewUserHandler(userService *auth.UserService) *UserHandler {
+	return &UserHandler{userService: userService}
+}
+
+type DiscoverabilityRequest struct {
+	Discoverability models.Discoverability `json:"discoverability" binding:"required"`
+}
+
+// SearchUsers finds people in the directory.
+// Query parameters: q, offset and limit.
+func (h *UserHandler) SearchUsers(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var offset, limit int
+	for name, target := range map[string]*int{"offset": &offset, "limit": &limit} {
+		if value := c.Query(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+				return
+			}
+			*target = n
+		}
+	}
+
+	users, err := h.userService.SearchUsers(userID.(uint), c.Query("q"), offset, limit)
+	if err != nil {
+		if errors.Is(err, auth.ErrDirectoryQueryTooShort) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// GetProfile returns another user's public profile
+func (h *UserHandler) GetProfile(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	profileID, ok := parseIDParam(c, "id", "Invalid user ID")
+	if !ok {
+		return
+	}
+
+	profile, err := h.userService.PublicProfile(userID.(uint), profileID)
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// SetDiscoverability changes who can find the current user in the directory
+func (h *UserHandler) SetDiscoverability(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req DiscoverabilityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.userService.SetDiscoverability(userID.(uint), req.Discoverability); err != nil {
+		if errors.Is(err, auth.ErrInvalidDiscoverability) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update discoverability"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"discoverability": req.Discoverability})
+}
@@ -0,0 +1,396 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/everest-an/dchat-backend/internal/database"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultChannelSearchLimit = 20
+	MaxChannelSearchLimit     = 50
+)
+
+var (
+	ErrChannelNotFound   = errors.New("channel not found")
+	ErrNotSubscribed     = errors.New("not subscribed to this channel")
+	ErrChannelInviteOnly = errors.New("channel is invite-only")
+)
+
+// ChannelDetails is a channel as seen by a subscriber or, for public
+// channels, anyone
+type ChannelDetails struct {
+	models.Conversation
+	SubscriberCount int64              `json:"subscriber_count"`
+	Role            *models.MemberRole `json:"role,omitempty"`
+}
+
+// ChannelSettings changes a channel; nil fields are left as they are
+type ChannelSettings struct {
+	Title  *string `json:"title"`
+	Public *bool   `json:"public"`
+}
+
+// ChannelService manages broadcast channels. Only admins and the owner post;
+// subscribers are members with the member role. Posts are stored once and
+// delivered live by the hub to whichever subscribers are online, so no
+// per-subscriber rows are written per post.
+type ChannelService struct {
+	db       *gorm.DB
+	messages *MessageService
+}
+
+func NewChannelService(db *gorm.DB, messages *MessageService) *ChannelService {
+	return &ChannelService{db: db, messages: messages}
+}
+
+// Create starts a channel owned by ownerID
+func (s *ChannelService) Create(ownerID uint, title string, public bool) (*models.Conversation, error) {
+	title, err := normalizeTitle(title)
+	if err != nil {
+		return nil, err
+	}
+
+	channel := models.Conversation{
+		Type:      models.ConversationChannel,
+		Title:     title,
+		CreatedBy: &ownerID,
+		Public:    public,
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&channel).Error; err != nil {
+			return fmt.Errorf("failed to create channel: %w", err)
+		}
+		return tx.Create(&models.ConversationMember{ConversationID: channel.ID, UserID: ownerID, Role: models.RoleOwner}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// Get returns a channel with its subscriber count. Invite-only channels are
+// only visible to their subscribers.
+func (s *ChannelService) Get(userID, channelID uint) (*ChannelDetails, error) {
+	channel, member, err := s.visible(userID, channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	details := &ChannelDetails{Conversation: *channel}
+	if err := s.db.Model(&models.ConversationMember{}).Where("conversation_id = ?", channelID).Count(&details.SubscriberCount).Error; err != nil {
+		return nil, err
+	}
+	if member != nil {
+		details.Role = &member.Role
+	}
+	if member == nil || roleRank(member.Role) < roleRank(models.RoleAdmin) {
+		details.InviteCode = nil
+	}
+	return details, nil
+}
+
+// Search returns public channels whose title contains query, largest first
+func (s *ChannelService) Search(query string, limit int) ([]ChannelDetails, error) {
+	if limit <= 0 {
+		limit = DefaultChannelSearchLimit
+	}
+	if limit > MaxChannelSearchLimit {
+		limit = MaxChannelSearchLimit
+	}
+
+	db := s.db.Table("conversation c").
+		Select("c.*, (SELECT COUNT(*) FROM conversation_member cm WHERE cm.conversation_id = c.id) AS subscriber_count").
+		Where("c.type = ? AND c.public", models.ConversationChannel)
+	if query = strings.TrimSpace(query); query != "" {
+		db = db.Where("c.title ILIKE ?", "%"+database.EscapeLike(query)+"%")
+	}
+
+	var channels []ChannelDetails
+	if err := db.Order("subscriber_count DESC, c.id ASC").Limit(limit).Scan(&channels).Error; err != nil {
+		return nil, err
+	}
+	for i := range channels {
+		channels[i].InviteCode = nil
+	}
+	return channels, nil
+}
+
+// Update changes the title or visibility; admins and the owner may update
+func (s *ChannelService) Update(userID, channelID uint, settings ChannelSettings) (*models.Conversation, error) {
+	updates := make(map[string]interface{})
+	if settings.Title != nil {
+		title, err := normalizeTitle(*settings.Title)
+		if err != nil {
+			return nil, err
+		}
+		updates["title"] = title
+	}
+	if settings.Public != nil {
+		updates["public"] = *settings.Public
+	}
+
+	channel, _, err := channelScope.requireRole(s.db, userID, channelID, models.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if len(updates) == 0 {
+		return channel, nil
+	}
+	if err := s.db.Model(channel).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	return channel, nil
+}
+
+// Subscribe adds the user to a public channel
+func (s *ChannelService) Subscribe(userID, channelID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		channel, err := channelScope.conversation(tx, channelID)
+		if err != nil {
+			return err
+		}
+		if !channel.Public {
+			return ErrChannelInviteOnly
+		}
+		_, err = addMembers(tx, channelID, []uint{userID}, 0)
+		return err
+	})
+}
+
+// Invite subscribes users to the channel; admins and the owner may invite
+func (s *ChannelService) Invite(userID, channelID uint, userIDs []uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if _, _, err := channelScope.requireRole(tx, userID, channelID, models.RoleAdmin); err != nil {
+			return err
+		}
+		_, err := addMembers(tx, channelID, userIDs, 0)
+		return err
+	})
+}
+
+// JoinByLink subscribes the user to the channel an invite code belongs to
+func (s *ChannelService) JoinByLink(userID uint, code string) (*models.Conversation, error) {
+	var channel models.Conversation
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("invite_code = ? AND type = ?", code, models.ConversationChannel).First(&channel).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidInviteCode
+		}
+		if err != nil {
+			return err
+		}
+		_, err = addMembers(tx, channel.ID, []uint{userID}, 0)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	channel.InviteCode = nil
+	return &channel, nil
+}
+
+// CreateInviteLink returns the channel's invite code, generating a new one
+// and invalidating the previous link
+func (s *ChannelService) CreateInviteLink(userID, channelID uint) (string, error) {
+	channel, _, err := channelScope.requireRole(s.db, userID, channelID, models.RoleAdmin)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := newInviteCode()
+	if err != nil {
+		return "", err
+	}
+	if err := s.db.Model(channel).Update("invite_code", code).Error; err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// RevokeInviteLink disables joining by link
+func (s *ChannelService) RevokeInviteLink(userID, channelID uint) error {
+	channel, _, err := channelScope.requireRole(s.db, userID, channelID, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	return s.db.Model(channel).Update("invite_code", nil).Error
+}
+
+// Unsubscribe removes the user from the channel. When the owner leaves,
+// ownership passes to the longest-standing admin, or else subscriber.
+func (s *ChannelService) Unsubscribe(userID, channelID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		_, err := channelScope.leave(tx, userID, channelID)
+		return err
+	})
+}
+
+// RemoveSubscriber removes a subscriber or, for the owner, an admin
+func (s *ChannelService) RemoveSubscriber(userID, channelID, targetID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		target, err := channelScope.manageable(tx, userID, channelID, targetID)
+		if err != nil {
+			return err
+		}
+		return tx.Where("conversation_id = ? AND user_id = ?", channelID, target.UserID).Delete(&models.ConversationMember{}).Error
+	})
+}
+
+// SetRole makes a subscriber an admin who may post, or back; only the owner may do this
+func (s *ChannelService) SetRole(userID, channelID, targetID uint, role models.MemberRole) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return channelScope.setRole(tx, userID, channelID, targetID, role)
+	})
+}
+
+// Post stores a channel post, optionally as a reply to replyToID. Only admins
+// and the owner may post. When the sender already posted with the same client
+// ID, that post is returned instead.
+func (s *ChannelService) Post(senderID, channelID uint, clientID, content string, encrypted bool, replyToID uint) (*MessageView, bool, error) {
+	normalized, err := parseClientID(clientID)
+	if err != nil {
+		return nil, false, err
+	}
+	message := models.Message{
+		SenderID:       senderID,
+		ConversationID: &channelID,
+		ClientID:       normalized,
+		Content:        content,
+		Encrypted:      encrypted,
+		Status:         models.MessageStored,
+	}
+
+	created := false
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if _, _, err := channelScope.requireRole(tx, senderID, channelID, models.RoleAdmin); err != nil {
+			return err
+		}
+		if err := attachReply(tx, &message, replyToID); err != nil {
+			return err
+		}
+
+		created, err = insertMessage(tx, &message)
+		if err != nil || !created {
+			return err
+		}
+		if err := countReply(tx, &message); err != nil {
+			return err
+		}
+		return recordPost(tx, channelID, &message)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Subscribers see a summary of the sender, not their account
+	view, err := s.messages.viewMessage(senderID, message.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	return view, created, nil
+}
+
+// History returns a window of the channel's posts. Anyone may read public channels.
+func (s *ChannelService) History(userID, channelID uint, query HistoryQuery) (*HistoryPage, error) {
+	if _, _, err := s.visible(userID, channelID); err != nil {
+		return nil, err
+	}
+	return s.messages.ConversationHistory(userID, channelID, query)
+}
+
+// MarkRead moves the subscriber's read position forward to upTo, or to the
+// latest post when upTo is 0, counting a view on every post it passes. It
+// returns the new read position.
+func (s *ChannelService) MarkRead(userID, channelID, upTo uint) (*uint, error) {
+	var position *uint
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		channel, err := channelScope.conversation(tx, channelID)
+		if err != nil {
+			return err
+		}
+
+		// The row lock keeps concurrent reads from counting a view twice
+		member, err := channelScope.member(tx.Clauses(clause.Locking{Strength: "UPDATE"}), channelID, userID)
+		if err != nil {
+			return err
+		}
+		position = member.LastReadMessageID
+
+		if channel.LastMessageID == nil {
+			return nil
+		}
+		if upTo == 0 || upTo > *channel.LastMessageID {
+			upTo = *channel.LastMessageID
+		}
+		var from uint
+		if member.LastReadMessageID != nil {
+			from = *member.LastReadMessageID
+		}
+		if upTo <= from {
+			return nil
+		}
+
+		if err := tx.Model(&models.Message{}).
+			Where("conversation_id = ? AND id > ? AND id <= ?", channelID, from, upTo).
+			Update("view_count", gorm.Expr("view_count + 1")).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.ConversationMember{}).
+			Where("conversation_id = ? AND user_id = ?", channelID, userID).
+			Update("last_read_message_id", upTo).Error; err != nil {
+			return err
+		}
+		position = &upTo
+		return nil
+	})
+	return position, err
+}
+
+// EachSubscriber calls fn with every subscriber of the channel, streaming
+// them from the database rather than loading a large channel at once
+func (s *ChannelService) EachSubscriber(channelID uint, fn func(userID uint)) error {
+	rows, err := s.db.Model(&models.ConversationMember{}).
+		Select("user_id").
+		Where("conversation_id = ?", channelID).
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID uint
+		if err := rows.Scan(&userID); err != nil {
+			return err
+		}
+		fn(userID)
+	}
+	return rows.Err()
+}
+
+// visible loads a channel the user may read, with their subscription if any
+func (s *ChannelService) visible(userID, channelID uint) (*models.Conversation, *models.ConversationMember, error) {
+	channel, err := channelScope.conversation(s.db, channelID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	member, err := channelScope.member(s.db, channelID, userID)
+	if errors.Is(err, ErrNotSubscribed) {
+		if !channel.Public {
+			// Invite-only channels don't reveal themselves to outsiders
+			return nil, nil, ErrChannelNotFound
+		}
+		return channel, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return channel, member, nil
+}
@@ -0,0 +1,214 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+	"gorm.io/gorm"
+)
+
+func TestChannelPostSummarizesSender(t *testing.T) {
+	db := testutil.NewDB(t)
+	owner := testutil.NewUser(t, db, "Owner")
+	if err := db.Model(owner).Updates(map[string]interface{}{"email": "owner@example.com", "linkedin_id": "owner-li"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	messages := NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+	channels := NewChannelService(db, messages)
+	channel, err := channels.Create(owner.ID, "Announcements", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post, created, err := channels.Post(owner.ID, channel.ID, "7b3f9e21-4c8d-4a6b-b1e0-5f2c8d9a3e47", "hello", false, 0)
+	if err != nil || !created {
+		t.Fatalf("post: created=%v err=%v", created, err)
+	}
+	if post.Sender == nil || post.Sender.Name != "Owner" {
+		t.Fatalf("sender = %+v, want a summary of the owner", post.Sender)
+	}
+
+	data, err := json.Marshal(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []string{"owner@example.com", "owner-li"} {
+		if strings.Contains(string(data), private) {
+			t.Fatalf("post event exposes %q: %s", private, data)
+		}
+	}
+}
+
+func newTestChannels(db *gorm.DB) *ChannelService {
+	return NewChannelService(db, NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60}))
+}
+
+func TestChannelPostingIsAdminOnly(t *testing.T) {
+	db := testutil.NewDB(t)
+	owner := testutil.NewUser(t, db, "Owner")
+	admin := testutil.NewUser(t, db, "Admin")
+	subscriber := testutil.NewUser(t, db, "Subscriber")
+	stranger := testutil.NewUser(t, db, "Stranger")
+	channels := newTestChannels(db)
+	channel, err := channels.Create(owner.ID, "Announcements", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range []uint{admin.ID, subscriber.ID} {
+		if err := channels.Subscribe(userID, channel.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	post := func(userID uint, n int) error {
+		_, _, err := channels.Post(userID, channel.ID, fmt.Sprintf("00000000-0000-4000-8000-%012d", n), "news", false, 0)
+		return err
+	}
+	if err := post(subscriber.ID, 1); !errors.Is(err, ErrRoleForbidden) {
+		t.Fatalf("subscriber posts: err = %v", err)
+	}
+	if err := post(stranger.ID, 2); !errors.Is(err, ErrNotSubscribed) {
+		t.Fatalf("stranger posts: err = %v", err)
+	}
+	if err := channels.SetRole(owner.ID, channel.ID, admin.ID, models.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	for i, userID := range []uint{owner.ID, admin.ID} {
+		if err := post(userID, 3+i); err != nil {
+			t.Fatalf("post by %d: %v", userID, err)
+		}
+	}
+
+	// Demoted admins go back to reading
+	if err := channels.SetRole(owner.ID, channel.ID, admin.ID, models.RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if err := post(admin.ID, 5); !errors.Is(err, ErrRoleForbidden) {
+		t.Fatalf("demoted admin posts: err = %v", err)
+	}
+}
+
+func TestChannelInviteOnlyVisibility(t *testing.T) {
+	db := testutil.NewDB(t)
+	owner := testutil.NewUser(t, db, "Owner")
+	invited := testutil.NewUser(t, db, "Invited")
+	stranger := testutil.NewUser(t, db, "Stranger")
+	channels := newTestChannels(db)
+	channel, err := channels.Create(owner.ID, "Backstage", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Outsiders can't tell an invite-only channel exists
+	if _, err := channels.Get(stranger.ID, channel.ID); !errors.Is(err, ErrChannelNotFound) {
+		t.Fatalf("stranger gets the channel: err = %v", err)
+	}
+	if _, err := channels.History(stranger.ID, channel.ID, HistoryQuery{}); !errors.Is(err, ErrChannelNotFound) {
+		t.Fatalf("stranger reads the channel: err = %v", err)
+	}
+	if err := channels.Subscribe(stranger.ID, channel.ID); !errors.Is(err, ErrChannelInviteOnly) {
+		t.Fatalf("stranger subscribes: err = %v", err)
+	}
+	found, err := channels.Search("Backstage", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, details := range found {
+		if details.ID == channel.ID {
+			t.Fatal("search found an invite-only channel")
+		}
+	}
+
+	if err := channels.Invite(owner.ID, channel.ID, []uint{invited.ID}); err != nil {
+		t.Fatal(err)
+	}
+	details, err := channels.Get(invited.ID, channel.ID)
+	if err != nil || details.SubscriberCount != 2 || details.InviteCode != nil {
+		t.Fatalf("subscriber's view: %+v err=%v", details, err)
+	}
+
+	// Making it public opens it to everyone
+	public := true
+	if _, err := channels.Update(owner.ID, channel.ID, ChannelSettings{Public: &public}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := channels.Get(stranger.ID, channel.ID); err != nil {
+		t.Fatalf("stranger gets a public channel: %v", err)
+	}
+	if err := channels.Subscribe(stranger.ID, channel.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var subscribers []uint
+	if err := channels.EachSubscriber(channel.ID, func(userID uint) { subscribers = append(subscribers, userID) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(subscribers) != 3 {
+		t.Fatalf("subscribers = %v, want all three", subscribers)
+	}
+}
+
+func TestChannelMarkReadCountsViews(t *testing.T) {
+	db := testutil.NewDB(t)
+	owner := testutil.NewUser(t, db, "Owner")
+	reader := testutil.NewUser(t, db, "Reader")
+	stranger := testutil.NewUser(t, db, "Stranger")
+	channels := newTestChannels(db)
+	channel, err := channels.Create(owner.ID, "Announcements", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := channels.Subscribe(reader.ID, channel.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing to read yet
+	if position, err := channels.MarkRead(reader.ID, channel.ID, 0); err != nil || position != nil {
+		t.Fatalf("empty channel: position=%v err=%v", position, err)
+	}
+
+	var posts []uint
+	for i := 0; i < 3; i++ {
+		post, _, err := channels.Post(owner.ID, channel.ID, fmt.Sprintf("00000000-0000-4000-8000-%012d", i+1), "news", false, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		posts = append(posts, post.ID)
+	}
+	views := func() []int {
+		t.Helper()
+		var counts []int
+		if err := db.Model(&models.Message{}).Where("id IN ?", posts).Order("id").Pluck("view_count", &counts).Error; err != nil {
+			t.Fatal(err)
+		}
+		return counts
+	}
+
+	position, err := channels.MarkRead(reader.ID, channel.ID, posts[0])
+	if err != nil || position == nil || *position != posts[0] {
+		t.Fatalf("read the first post: position=%v err=%v", position, err)
+	}
+	// Reading up to the latest counts only the posts passed since
+	position, err = channels.MarkRead(reader.ID, channel.ID, 0)
+	if err != nil || position == nil || *position != posts[2] {
+		t.Fatalf("read to the latest: position=%v err=%v", position, err)
+	}
+	// Going back or reading again counts nothing
+	if position, err := channels.MarkRead(reader.ID, channel.ID, posts[1]); err != nil || *position != posts[2] {
+		t.Fatalf("read backwards: position=%v err=%v", position, err)
+	}
+	if got := views(); fmt.Sprint(got) != "[1 1 1]" {
+		t.Fatalf("view counts = %v, want one view each", got)
+	}
+
+	if _, err := channels.MarkRead(stranger.ID, channel.ID, 0); !errors.Is(err, ErrNotSubscribed) {
+		t.Fatalf("non-subscriber reads: err = %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package messaging
+
+import (
+	"fmt"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// directKey identifies the direct conversation between two users regardless of order
+func directKey(a, b uint) string {
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%d:%d", a, b)
+}
+
+// directConversation returns the direct conversation between two users,
+// creating it and its memberships on the first message
+func directConversation(tx *gorm.DB, userID, otherUserID uint) (*models.Conversation, error) {
+	key := directKey(userID, otherUserID)
+	conversation := models.Conversation{
+		Type:      models.ConversationDirect,
+		DirectKey: &key,
+	}
+
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "direct_key"}},
+		DoNothing: true,
+	}).Create(&conversation).Error; err != nil {
+		return nil, fmt.Errorf("failed to create conversation: %w", err)
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("direct_key = ?", key).First(&conversation).Error; err != nil {
+		return nil, err
+	}
+
+	members := []models.ConversationMember{
+		{ConversationID: conversation.ID, UserID: userID},
+	}
+	if otherUserID != userID {
+		members = append(members, models.ConversationMember{ConversationID: conversation.ID, UserID: otherUserID})
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&members).Error; err != nil {
+		return nil, fmt.Errorf("failed to add conversation members: %w", err)
+	}
+	return &conversation, nil
+}
+
+// recordMessage moves the conversation's last message pointer to a new
+// message and counts it as unread for every member but the sender
+func recordMessage(tx *gorm.DB, conversationID uint, message *models.Message) error {
+	if err := moveLastMessage(tx, conversationID, message); err != nil {
+		return err
+	}
+
+	// The sender has read everything up to their own message, and by
+	// writing has accepted the conversation
+	if err := tx.Model(&models.ConversationMember{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, message.SenderID).
+		Updates(map[string]interface{}{
+			"unread_count":         0,
+			"last_read_message_id": gorm.Expr("GREATEST(last_read_message_id, ?)", message.ID),
+			"archived":             false,
+			"accepted":             true,
+		}).Error; err != nil {
+		return err
+	}
+
+	// New messages bring archived conversations back unless they are muted
+	return tx.Model(&models.ConversationMember{}).
+		Where("conversation_id = ? AND user_id <> ?", conversationID, message.SenderID).
+		Updates(map[string]interface{}{
+			"unread_count": gorm.Expr("unread_count + 1"),
+			"archived":     gorm.Expr("archived AND muted"),
+		}).Error
+}
+
+// recordPost moves a channel's last message pointer to a new post. Unread
+// counts of subscribers are derived from their read position instead, so a
+// post touches no subscriber rows.
+func recordPost(tx *gorm.DB, channelID uint, message *models.Message) error {
+	return moveLastMessage(tx, channelID, message)
+}
+
+// moveLastMessage points the conversation at a new message. Messages sent
+// concurrently can commit out of order, so the pointer only moves forward.
+func moveLastMessage(tx *gorm.DB, conversationID uint, message *models.Message) error {
+	return tx.Model(&models.Conversation{}).Where("id = ?", conversationID).Updates(map[string]interface{}{
+		"last_message_id": gorm.Expr("GREATEST(last_message_id, ?)", message.ID),
+		"last_message_at": gorm.Expr("GREATEST(last_message_at, ?)", message.CreatedAt),
+	}).Error
+}
+
+// markRead moves a member's read position to the conversation's last message
+func markRead(tx *gorm.DB, conversationID, userID uint) error {
+	return tx.Model(&models.ConversationMember{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Updates(map[string]interface{}{
+			"unread_count":         0,
+			"last_read_message_id": gorm.Expr("(SELECT last_message_id FROM conversation WHERE id = ?)", conversationID),
+		}).Error
+}
@@ -0,0 +1,271 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultInboxLimit = 30
+	MaxInboxLimit     = 100
+)
+
+var (
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrInvalidInboxCursor   = errors.New("invalid inbox cursor")
+)
+
+// InboxQuery selects a page of the user's conversations. Pinned conversations
+// are all returned on the first page, ahead of the others.
+type InboxQuery struct {
+	Cursor   string
+	Limit    int
+	Archived bool
+}
+
+// ConversationView is one inbox entry from the point of view of a member
+type ConversationView struct {
+	ID                uint                    `json:"id"`
+	Type              models.ConversationType `json:"type"`
+	Title             string                  `json:"title,omitempty"`
+	Peer              *models.UserSummary     `json:"peer,omitempty"`
+	LastMessage       *MessageView            `json:"last_message,omitempty"`
+	LastMessageAt     *time.Time              `json:"last_message_at,omitempty"`
+	UnreadCount       int                     `json:"unread_count"`
+	LastReadMessageID *uint                   `json:"last_read_message_id,omitempty"`
+	Muted             bool                    `json:"muted"`
+	Archived          bool                    `json:"archived"`
+	Pinned            bool                    `json:"pinned"`
+	PinnedAt          *time.Time              `json:"pinned_at,omitempty"`
+}
+
+// InboxPage is a page of conversations, most recently active first
+type InboxPage struct {
+	Conversations []ConversationView `json:"conversations"`
+	NextCursor    string             `json:"next_cursor,omitempty"`
+}
+
+// ConversationSettings changes a member's inbox settings; nil fields are left as they are
+type ConversationSettings struct {
+	Muted    *bool `json:"muted"`
+	Archived *bool `json:"archived"`
+	Pinned   *bool `json:"pinned"`
+}
+
+// ConversationService serves the inbox from the conversation and membership tables
+type ConversationService struct {
+	db       *gorm.DB
+	messages *MessageService
+}
+
+func NewConversationService(db *gorm.DB, messages *MessageService) *ConversationService {
+	return &ConversationService{db: db, messages: messages}
+}
+
+type inboxRow struct {
+	ID                uint
+	Type              models.ConversationType
+	Title             string
+	LastMessageID     *uint
+	LastMessageAt     *time.Time
+	UnreadCount       int
+	LastReadMessageID *uint
+	Muted             bool
+	Archived          bool
+	PinnedAt          *time.Time
+}
+
+// Inbox returns a page of the user's conversations. Channel unread counts
+// are counted from the read position, since posts don't update subscribers.
+func (s *ConversationService) Inbox(userID uint, query InboxQuery) (*InboxPage, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultInboxLimit
+	}
+	if limit > MaxInboxLimit {
+		limit = MaxInboxLimit
+	}
+
+	base := s.db.Table("conversation_member cm").
+		Select(`c.id, c.type, c.title, c.last_message_id, c.last_message_at,
+			CASE WHEN c.type = ? THEN (
+				SELECT COUNT(*) FROM message m
+				WHERE m.conversation_id = c.id AND m.id > COALESCE(cm.last_read_message_id, 0)
+			) ELSE cm.unread_count END AS unread_count,
+			cm.last_read_message_id, cm.muted, cm.archived, cm.pinned_at`, models.ConversationChannel).
+		Joins("JOIN conversation c ON c.id = cm.conversation_id").
+		Where("cm.user_id = ? AND cm.archived = ? AND c.last_message_id IS NOT NULL", userID, query.Archived).
+		Session(&gorm.Session{})
+
+	var rows []inboxRow
+	if query.Cursor == "" {
+		if err := base.Where("cm.pinned_at IS NOT NULL").Order("cm.pinned_at DESC").Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	unpinned := base.Where("cm.pinned_at IS NULL")
+	if query.Cursor != "" {
+		at, id, err := parseInboxCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		unpinned = unpinned.Where("(c.last_message_at, c.id) < (?, ?)", at, id)
+	}
+
+	var page []inboxRow
+	if err := unpinned.Order("c.last_message_at DESC, c.id DESC").Limit(limit + 1).Scan(&page).Error; err != nil {
+		return nil, err
+	}
+
+	result := &InboxPage{}
+	if len(page) > limit {
+		page = page[:limit]
+		last := page[len(page)-1]
+		result.NextCursor = formatInboxCursor(*last.LastMessageAt, last.ID)
+	}
+	rows = append(rows, page...)
+
+	views, err := s.viewConversations(userID, rows)
+	if err != nil {
+		return nil, err
+	}
+	result.Conversations = views
+	return result, nil
+}
+
+// UpdateSettings changes the user's mute, archive and pin settings for a conversation
+func (s *ConversationService) UpdateSettings(userID, conversationID uint, settings ConversationSettings) (*models.ConversationMember, error) {
+	updates := make(map[string]interface{})
+	if settings.Muted != nil {
+		updates["muted"] = *settings.Muted
+	}
+	if settings.Archived != nil {
+		updates["archived"] = *settings.Archived
+	}
+	if settings.Pinned != nil {
+		if *settings.Pinned {
+			updates["pinned_at"] = time.Now()
+		} else {
+			updates["pinned_at"] = nil
+		}
+	}
+
+	var member models.ConversationMember
+	if err := s.db.Where("conversation_id = ? AND user_id = ?", conversationID, userID).First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrConversationNotFound
+		}
+		return nil, err
+	}
+	if len(updates) == 0 {
+		return &member, nil
+	}
+
+	if err := s.db.Model(&models.ConversationMember{}).Where("conversation_id = ? AND user_id = ?", conversationID, userID).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("conversation_id = ? AND user_id = ?", conversationID, userID).First(&member).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (s *ConversationService) viewConversations(userID uint, rows []inboxRow) ([]ConversationView, error) {
+	directIDs := make([]uint, 0, len(rows))
+	messageIDs := make([]uint, 0, len(rows))
+	for _, row := range rows {
+		if row.Type == models.ConversationDirect {
+			directIDs = append(directIDs, row.ID)
+		}
+		if row.LastMessageID != nil {
+			messageIDs = append(messageIDs, *row.LastMessageID)
+		}
+	}
+
+	// Last messages, with their sender summaries
+	var messages []models.Message
+	if len(messageIDs) > 0 {
+		if err := s.db.Where("id IN ?", messageIDs).Find(&messages).Error; err != nil {
+			return nil, err
+		}
+	}
+	messageViews, err := s.messages.viewMessages(userID, messages)
+	if err != nil {
+		return nil, err
+	}
+	lastMessages := make(map[uint]*MessageView, len(messageViews))
+	for i := range messageViews {
+		lastMessages[messageViews[i].ID] = &messageViews[i]
+	}
+
+	// The other member of each direct conversation
+	var peers []models.ConversationMember
+	if len(directIDs) > 0 {
+		if err := s.db.Where("conversation_id IN ? AND user_id <> ?", directIDs, userID).Find(&peers).Error; err != nil {
+			return nil, err
+		}
+	}
+	peerIDs := make([]uint, 0, len(peers))
+	peerOf := make(map[uint]uint, len(peers))
+	for _, peer := range peers {
+		peerIDs = append(peerIDs, peer.UserID)
+		peerOf[peer.ConversationID] = peer.UserID
+	}
+	summaries, err := s.messages.userSummaries(peerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]ConversationView, 0, len(rows))
+	for _, row := range rows {
+		view := ConversationView{
+			ID:                row.ID,
+			Type:              row.Type,
+			Title:             row.Title,
+			LastMessageAt:     row.LastMessageAt,
+			UnreadCount:       row.UnreadCount,
+			LastReadMessageID: row.LastReadMessageID,
+			Muted:             row.Muted,
+			Archived:          row.Archived,
+			Pinned:            row.PinnedAt != nil,
+			PinnedAt:          row.PinnedAt,
+		}
+		if row.LastMessageID != nil {
+			view.LastMessage = lastMessages[*row.LastMessageID]
+		}
+		if row.Type == models.ConversationDirect {
+			view.Peer = summaries[peerOf[row.ID]]
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// formatInboxCursor encodes the position of a conversation in the inbox as
+// "<last message time in microseconds>_<conversation ID>"
+func formatInboxCursor(lastMessageAt time.Time, id uint) string {
+	return fmt.Sprintf("%d_%d", lastMessageAt.UnixMicro(), id)
+}
+
+func parseInboxCursor(cursor string) (time.Time, uint, error) {
+	at, id, ok := strings.Cut(cursor, "_")
+	if !ok {
+		return time.Time{}, 0, ErrInvalidInboxCursor
+	}
+	micros, err := strconv.ParseInt(at, 10, 64)
+	if err != nil || micros < 0 {
+		return time.Time{}, 0, ErrInvalidInboxCursor
+	}
+	conversationID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil || conversationID == 0 {
+		return time.Time{}, 0, ErrInvalidInboxCursor
+	}
+	return time.UnixMicro(micros), uint(conversationID), nil
+}
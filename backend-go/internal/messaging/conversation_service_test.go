@@ -0,0 +1,84 @@
+package messaging
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+)
+
+func TestInboxCursorRoundTrip(t *testing.T) {
+	at := time.Date(2026, 10, 16, 10, 0, 0, 123456000, time.UTC)
+	cursor := formatInboxCursor(at, 42)
+
+	gotAt, gotID, err := parseInboxCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotAt.Equal(at) || gotID != 42 {
+		t.Fatalf("parsed %v, %d from %q", gotAt, gotID, cursor)
+	}
+}
+
+func TestParseInboxCursorRejects(t *testing.T) {
+	for _, cursor := range []string{
+		"",
+		"1792144800123456",
+		"1792144800123456_",
+		"_42",
+		"1792144800123456_42_7",
+		"1792144800123456_42abc",
+		"-1_42",
+		"1792144800123456_0",
+		"1792144800123456_-42",
+		"soon_42",
+	} {
+		if _, _, err := parseInboxCursor(cursor); !errors.Is(err, ErrInvalidInboxCursor) {
+			t.Errorf("%q: err = %v, want ErrInvalidInboxCursor", cursor, err)
+		}
+	}
+}
+
+func TestRecordMessageOutOfOrder(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+
+	conversation, err := directConversation(db, alice.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	older := &models.Message{SenderID: alice.ID, ReceiverID: bob.ID, ConversationID: &conversation.ID, Content: "first", CreatedAt: now.Add(-time.Second)}
+	newer := &models.Message{SenderID: alice.ID, ReceiverID: bob.ID, ConversationID: &conversation.ID, Content: "second", CreatedAt: now}
+	for _, message := range []*models.Message{older, newer} {
+		if err := db.Create(message).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The later message commits first
+	if err := recordMessage(db, conversation.ID, newer); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordMessage(db, conversation.ID, older); err != nil {
+		t.Fatal(err)
+	}
+
+	var got models.Conversation
+	if err := db.First(&got, conversation.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got.LastMessageID == nil || *got.LastMessageID != newer.ID || !got.LastMessageAt.Equal(newer.CreatedAt) {
+		t.Fatalf("last message = %v at %v, want %d at %v", got.LastMessageID, got.LastMessageAt, newer.ID, newer.CreatedAt)
+	}
+	var member models.ConversationMember
+	if err := db.Where("conversation_id = ? AND user_id = ?", conversation.ID, alice.ID).First(&member).Error; err != nil {
+		t.Fatal(err)
+	}
+	if member.LastReadMessageID == nil || *member.LastReadMessageID != newer.ID {
+		t.Fatalf("sender read position = %v, want %d", member.LastReadMessageID, newer.ID)
+	}
+}
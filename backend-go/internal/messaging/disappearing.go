@@ -0,0 +1,260 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Longest disappearing-message timer, four weeks
+	MaxDisappearingSeconds = 28 * 24 * 60 * 60
+
+	// Expired messages deleted per reaper transaction
+	ReapBatchSize = 500
+)
+
+var (
+	ErrInvalidDisappearingTimer = fmt.Errorf("disappearing timer must be between 0 and %d seconds", MaxDisappearingSeconds)
+	ErrDisappearingNotSupported = errors.New("disappearing messages are not available in channels")
+)
+
+// TimerAnnouncement is the content of the system message announcing a new
+// disappearing-message timer
+type TimerAnnouncement struct {
+	Event   string `json:"event"`
+	Seconds int    `json:"seconds"`
+}
+
+// TimerChange is a conversation's new disappearing-message timer. Message
+// is the system message announcing it, nil when the timer was unchanged.
+type TimerChange struct {
+	Conversation *models.Conversation
+	Message      *MessageView
+	Members      []uint
+}
+
+// ExpiredMessages are the messages of one conversation the reaper deleted
+type ExpiredMessages struct {
+	ConversationID uint
+	MessageIDs     []uint
+	Members        []uint
+}
+
+// SetDisappearingTimer sets how long new messages in a conversation last.
+// Either party of a direct conversation may change it; in groups it takes
+// an admin. Existing messages keep the expiry they were sent with.
+func (s *ConversationService) SetDisappearingTimer(userID, conversationID uint, seconds int) (*TimerChange, error) {
+	if seconds < 0 || seconds > MaxDisappearingSeconds {
+		return nil, ErrInvalidDisappearingTimer
+	}
+
+	change := &TimerChange{}
+	var announcement *models.Message
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var conversation models.Conversation
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&conversation, conversationID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrConversationNotFound
+		}
+		if err != nil {
+			return err
+		}
+		change.Conversation = &conversation
+
+		switch conversation.Type {
+		case models.ConversationChannel:
+			return ErrDisappearingNotSupported
+		case models.ConversationGroup:
+			if _, _, err := groupScope.requireRole(tx, userID, conversationID, models.RoleAdmin); err != nil {
+				return err
+			}
+		default:
+			err := tx.Where("conversation_id = ? AND user_id = ?", conversationID, userID).First(&models.ConversationMember{}).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrConversationNotFound
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if conversation.DisappearingSeconds == seconds {
+			return nil
+		}
+
+		if err := tx.Model(&conversation).Update("disappearing_seconds", seconds).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.ConversationMember{}).Where("conversation_id = ?", conversationID).Pluck("user_id", &change.Members).Error; err != nil {
+			return err
+		}
+
+		content, err := json.Marshal(TimerAnnouncement{Event: "disappearing_timer", Seconds: seconds})
+		if err != nil {
+			return err
+		}
+		message := &models.Message{
+			SenderID:       userID,
+			ConversationID: &conversationID,
+			Content:        string(content),
+			System:         true,
+			Status:         models.MessageStored,
+		}
+		// Direct messages are addressed to the other party, like those they send
+		if conversation.Type == models.ConversationDirect {
+			for _, memberID := range change.Members {
+				if memberID != userID {
+					message.ReceiverID = memberID
+				}
+			}
+		}
+		if _, err := insertMessage(tx, message); err != nil {
+			return err
+		}
+		announcement = message
+		return recordMessage(tx, conversationID, message)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Members see a summary of who changed the timer, not their account
+	if announcement != nil {
+		if change.Message, err = s.messages.viewMessage(userID, announcement.ID); err != nil {
+			return nil, err
+		}
+	}
+	return change, nil
+}
+
+// stampExpiry sets when a message that is about to be stored expires,
+// from its conversation's disappearing timer
+func stampExpiry(tx *gorm.DB, message *models.Message) error {
+	if message.ConversationID == nil {
+		return nil
+	}
+
+	var seconds int
+	if err := tx.Model(&models.Conversation{}).Where("id = ?", *message.ConversationID).Select("disappearing_seconds").Scan(&seconds).Error; err != nil {
+		return err
+	}
+	if seconds > 0 {
+		expiresAt := time.Now().Add(time.Duration(seconds) * time.Second)
+		message.ExpiresAt = &expiresAt
+	}
+	return nil
+}
+
+// notExpired leaves out messages past their expiry that the reaper has not
+// deleted yet
+func notExpired(db *gorm.DB) *gorm.DB {
+	return db.Where("message.expires_at IS NULL OR message.expires_at > ?", time.Now())
+}
+
+// ReapExpired hard-deletes up to limit messages past their expiry, together
+// with their edit history, reactions and the events that carried them, and
+// drops their quotes from the events of replies that outlive them.
+// Concurrent reapers skip each other's rows. It returns the deleted
+// messages by conversation and how many were deleted.
+func (s *MessageService) ReapExpired(limit int) ([]ExpiredMessages, int, error) {
+	var batches []ExpiredMessages
+	var expired []models.Message
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("id", "conversation_id", "thread_root_id").
+			Where("expires_at <= ?", time.Now()).
+			Order("expires_at").
+			Limit(limit).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Find(&expired).Error; err != nil {
+			return err
+		}
+		if len(expired) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(expired))
+		reaped := make(map[uint]bool, len(expired))
+		byConversation := make(map[uint][]uint)
+		conversationIDs := make([]uint, 0)
+		for _, message := range expired {
+			ids = append(ids, message.ID)
+			reaped[message.ID] = true
+			if message.ConversationID == nil {
+				continue
+			}
+			if _, ok := byConversation[*message.ConversationID]; !ok {
+				conversationIDs = append(conversationIDs, *message.ConversationID)
+			}
+			byConversation[*message.ConversationID] = append(byConversation[*message.ConversationID], message.ID)
+		}
+
+		// Events keep a copy of the content, so they go with the message
+		if err := forgetEvents(tx, ids); err != nil {
+			return err
+		}
+		if err := redactQuotes(tx, ids, nil); err != nil {
+			return err
+		}
+		if err := tx.Where("id IN ?", ids).Delete(&models.Message{}).Error; err != nil {
+			return err
+		}
+
+		// Thread roots that outlive their replies
+		replies := make(map[uint]int)
+		for _, message := range expired {
+			if message.ThreadRootID != nil && !reaped[*message.ThreadRootID] {
+				replies[*message.ThreadRootID]++
+			}
+		}
+		for rootID, n := range replies {
+			if err := tx.Model(&models.Message{}).Where("id = ?", rootID).
+				Update("reply_count", gorm.Expr("GREATEST(reply_count - ?, 0)", n)).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(conversationIDs) == 0 {
+			return nil
+		}
+		// Point conversations at their newest remaining message and recount unread
+		if err := tx.Exec(`UPDATE conversation SET
+				last_message_id = (SELECT m.id FROM message m WHERE m.conversation_id = conversation.id ORDER BY m.id DESC LIMIT 1),
+				last_message_at = (SELECT m.created_at FROM message m WHERE m.conversation_id = conversation.id ORDER BY m.id DESC LIMIT 1)
+			WHERE last_message_id IN ?`, ids).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`UPDATE conversation_member cm SET unread_count = (
+				SELECT COUNT(*) FROM message m
+				WHERE m.conversation_id = cm.conversation_id AND m.id > COALESCE(cm.last_read_message_id, 0) AND m.sender_id <> cm.user_id)
+			WHERE cm.conversation_id IN ? AND cm.unread_count > 0`, conversationIDs).Error; err != nil {
+			return err
+		}
+
+		var members []models.ConversationMember
+		if err := tx.Select("conversation_id", "user_id").Where("conversation_id IN ?", conversationIDs).Find(&members).Error; err != nil {
+			return err
+		}
+		membersOf := make(map[uint][]uint, len(conversationIDs))
+		for _, member := range members {
+			membersOf[member.ConversationID] = append(membersOf[member.ConversationID], member.UserID)
+		}
+
+		for _, conversationID := range conversationIDs {
+			batches = append(batches, ExpiredMessages{
+				ConversationID: conversationID,
+				MessageIDs:     byConversation[conversationID],
+				Members:        membersOf[conversationID],
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return batches, len(expired), nil
+}
@@ -0,0 +1,152 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+)
+
+func TestSetDisappearingTimer(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	messages := NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+	service := NewConversationService(db, messages)
+
+	conversation, err := directConversation(db, alice.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, seconds := range []int{-1, MaxDisappearingSeconds + 1} {
+		if _, err := service.SetDisappearingTimer(alice.ID, conversation.ID, seconds); !errors.Is(err, ErrInvalidDisappearingTimer) {
+			t.Fatalf("%d seconds: err = %v", seconds, err)
+		}
+	}
+
+	change, err := service.SetDisappearingTimer(alice.ID, conversation.ID, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	announcement := change.Message
+	if announcement == nil || !announcement.System || announcement.ReceiverID != bob.ID {
+		t.Fatalf("announcement = %+v", announcement)
+	}
+	// Members see a summary of who changed the timer, not their account
+	if announcement.Sender == nil || announcement.Sender.ID != alice.ID {
+		t.Fatalf("announcement sender = %+v", announcement.Sender)
+	}
+	data, _ := json.Marshal(announcement)
+	if strings.Contains(string(data), `"email"`) {
+		t.Fatalf("announcement leaks the sender's account: %s", data)
+	}
+	if len(change.Members) != 2 {
+		t.Fatalf("members = %v, want both parties", change.Members)
+	}
+
+	// Not even the member who changed the timer can alter the announcement
+	if _, err := messages.Edit(alice.ID, announcement.ID, "forged", false); !errors.Is(err, ErrSystemMessage) {
+		t.Fatalf("edit announcement: err = %v", err)
+	}
+	if _, err := messages.Delete(alice.ID, announcement.ID, true); !errors.Is(err, ErrSystemMessage) {
+		t.Fatalf("delete announcement for everyone: err = %v", err)
+	}
+	if _, err := messages.React(bob.ID, announcement.ID, "👍"); !errors.Is(err, ErrSystemMessage) {
+		t.Fatalf("react to announcement: err = %v", err)
+	}
+	if _, err := messages.Delete(bob.ID, announcement.ID, false); err != nil {
+		t.Fatalf("hide announcement: %v", err)
+	}
+
+	// Setting the same timer announces nothing
+	change, err = service.SetDisappearingTimer(bob.ID, conversation.ID, 60)
+	if err != nil || change.Message != nil {
+		t.Fatalf("unchanged timer: change=%+v err=%v", change, err)
+	}
+
+	message, _, err := messages.Send(&SendRequest{SenderID: bob.ID, ReceiverID: alice.ID, ClientID: "00000000-0000-4000-8000-000000000001", Content: "soon gone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.ExpiresAt == nil || time.Until(*message.ExpiresAt) > time.Minute {
+		t.Fatalf("expires_at = %v, want within a minute", message.ExpiresAt)
+	}
+}
+
+func TestReapExpired(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	service := NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+
+	send := func(senderID, receiverID uint, n int, content string, replyToID uint) *MessageView {
+		message, _, err := service.Send(&SendRequest{
+			SenderID:   senderID,
+			ReceiverID: receiverID,
+			ClientID:   fmt.Sprintf("00000000-0000-4000-8000-%012d", n),
+			Content:    content,
+			ReplyToID:  replyToID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+	secret := send(alice.ID, bob.ID, 1, "the secret", 0)
+	reply := send(bob.ID, alice.ID, 2, "got it", secret.ID)
+
+	storeEvent(t, db, bob.ID, 1, secret, secret.ID)
+	storeEvent(t, db, alice.ID, 1, map[string]interface{}{
+		"id":       reply.ID,
+		"content":  reply.Content,
+		"reply_to": QuotedMessage{ID: secret.ID, SenderID: alice.ID, Content: secret.Content},
+	}, reply.ID)
+
+	if err := db.Model(&models.Message{}).Where("id = ?", secret.ID).Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	batches, n, err := service.ReapExpired(ReapBatchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(batches) != 1 || len(batches[0].MessageIDs) != 1 || batches[0].MessageIDs[0] != secret.ID {
+		t.Fatalf("reaped n=%d batches=%+v, want message %d", n, batches, secret.ID)
+	}
+	if batches[0].ConversationID != *secret.ConversationID || len(batches[0].Members) != 2 {
+		t.Fatalf("batch = %+v, want both members of conversation %d", batches[0], *secret.ConversationID)
+	}
+
+	if err := db.First(&models.Message{}, secret.ID).Error; err == nil {
+		t.Fatal("expired message was not deleted")
+	}
+	var events []models.UserEvent
+	if err := db.Where("user_id IN ?", []uint{alice.ID, bob.ID}).Find(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || *events[0].MessageID != reply.ID {
+		t.Fatalf("events left = %+v, want only the reply's", events)
+	}
+	if strings.Contains(events[0].Payload, "the secret") || strings.Contains(events[0].Payload, "reply_to") {
+		t.Fatalf("reply event still quotes the expired message: %s", events[0].Payload)
+	}
+
+	// The reply outlives the message it quoted
+	var left models.Message
+	if err := db.First(&left, reply.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if left.ReplyToID != nil {
+		t.Fatalf("reply still points at expired message %d", *left.ReplyToID)
+	}
+
+	if _, n, err := service.ReapExpired(ReapBatchSize); err != nil || n != 0 {
+		t.Fatalf("second reap: n=%d err=%v", n, err)
+	}
+}
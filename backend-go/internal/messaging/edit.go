@@ -0,0 +1,241 @@
+package messaging
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrMessageNotFound     = errors.New("message not found")
+	ErrNotMessageSender    = errors.New("only the sender can change this message")
+	ErrMessageDeleted      = errors.New("message has been deleted")
+	ErrEmptyContent        = errors.New("content is required")
+	ErrDeleteWindowExpired = errors.New("message is too old to delete for everyone")
+	ErrSystemMessage       = errors.New("system messages can't be changed")
+)
+
+// MessageChange is an edited or deleted message and who has to hear about it
+type MessageChange struct {
+	Message *models.Message
+	// Users whose devices get the change through their event logs
+	Recipients []uint
+	// Set for channel posts, which reach subscribers through the channel instead
+	ChannelID uint
+	// Set when the message was deleted for everyone, not only for the actor
+	ForEveryone bool
+}
+
+// Edit replaces the content of a message sent by userID, keeping the
+// previous version in the edit history
+func (s *MessageService) Edit(userID, messageID uint, content string, encrypted bool) (*MessageChange, error) {
+	if strings.TrimSpace(content) == "" {
+		return nil, ErrEmptyContent
+	}
+
+	var change *MessageChange
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		message, err := lockMessage(tx, messageID)
+		if err != nil {
+			return err
+		}
+		if message.System {
+			return ErrSystemMessage
+		}
+		if message.SenderID != userID {
+			return ErrNotMessageSender
+		}
+		if message.DeletedAt != nil {
+			return ErrMessageDeleted
+		}
+
+		if err := tx.Create(&models.MessageEdit{
+			MessageID: message.ID,
+			Content:   message.Content,
+			Encrypted: message.Encrypted,
+		}).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(message).Updates(map[string]interface{}{
+			"content":   content,
+			"encrypted": encrypted,
+			"edited_at": now,
+		}).Error; err != nil {
+			return err
+		}
+
+		change, err = s.changeFor(tx, message)
+		return err
+	})
+	return change, err
+}
+
+// Delete removes a message. For everyone, only the sender may delete, within
+// the configured window; the content, edit history and the events carrying
+// the message are erased, quotes of it in replies become tombstones, and a
+// tombstone stays in the conversation. Otherwise the message is only hidden
+// from userID's own history and event log.
+func (s *MessageService) Delete(userID, messageID uint, forEveryone bool) (*MessageChange, error) {
+	var change *MessageChange
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		message, err := lockMessage(tx, messageID)
+		if err != nil {
+			return err
+		}
+
+		if !forEveryone {
+			if err := s.checkVisible(tx, userID, message); err != nil {
+				return err
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.MessageHidden{
+				MessageID: message.ID,
+				UserID:    userID,
+			}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ? AND message_id = ?", userID, message.ID).Delete(&models.UserEvent{}).Error; err != nil {
+				return err
+			}
+			change = &MessageChange{Message: message, Recipients: []uint{userID}}
+			return nil
+		}
+
+		if message.System {
+			return ErrSystemMessage
+		}
+		if message.SenderID != userID {
+			return ErrNotMessageSender
+		}
+		if message.DeletedAt != nil {
+			return ErrMessageDeleted
+		}
+		if time.Since(message.CreatedAt) > s.deleteWindow {
+			return ErrDeleteWindowExpired
+		}
+
+		if err := tx.Where("message_id = ?", message.ID).Delete(&models.MessageEdit{}).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := tx.Model(message).Updates(map[string]interface{}{
+			"content":    "",
+			"deleted_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		if err := forgetEvents(tx, []uint{message.ID}); err != nil {
+			return err
+		}
+		if err := redactQuotes(tx, []uint{message.ID}, &now); err != nil {
+			return err
+		}
+
+		change, err = s.changeFor(tx, message)
+		if change != nil {
+			change.ForEveryone = true
+		}
+		return err
+	})
+	return change, err
+}
+
+// Edits returns the earlier versions of a message, oldest first
+func (s *MessageService) Edits(userID, messageID uint) ([]models.MessageEdit, error) {
+	var message models.Message
+	if err := s.db.First(&message, messageID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	if err := s.checkVisible(s.db, userID, &message); err != nil {
+		return nil, err
+	}
+
+	var edits []models.MessageEdit
+	err := s.db.Where("message_id = ?", messageID).Order("created_at ASC, id ASC").Find(&edits).Error
+	return edits, err
+}
+
+func lockMessage(tx *gorm.DB, messageID uint) (*models.Message, error) {
+	var message models.Message
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&message, messageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// conversationOf loads the conversation a message belongs to, or nil for
+// direct messages sent before conversations existed
+func conversationOf(tx *gorm.DB, message *models.Message) (*models.Conversation, error) {
+	if message.ConversationID == nil {
+		return nil, nil
+	}
+	var conversation models.Conversation
+	if err := tx.First(&conversation, *message.ConversationID).Error; err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// checkVisible reports ErrMessageNotFound unless the user can see the message
+func (s *MessageService) checkVisible(tx *gorm.DB, userID uint, message *models.Message) error {
+	if message.SenderID == userID || message.ReceiverID == userID {
+		return nil
+	}
+
+	conversation, err := conversationOf(tx, message)
+	if err != nil || conversation == nil {
+		return ErrMessageNotFound
+	}
+	if conversation.Type == models.ConversationChannel && conversation.Public {
+		return nil
+	}
+
+	var count int64
+	if err := tx.Model(&models.ConversationMember{}).
+		Where("conversation_id = ? AND user_id = ?", conversation.ID, userID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+// changeFor reloads a changed message and finds the users to notify
+func (s *MessageService) changeFor(tx *gorm.DB, message *models.Message) (*MessageChange, error) {
+	if err := tx.First(message, message.ID).Error; err != nil {
+		return nil, err
+	}
+	change := &MessageChange{Message: message}
+
+	conversation, err := conversationOf(tx, message)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case conversation == nil:
+		change.Recipients = uniqueUserIDs(message.SenderID, []uint{message.ReceiverID})
+	case conversation.Type == models.ConversationChannel:
+		change.ChannelID = conversation.ID
+	default:
+		if err := tx.Model(&models.ConversationMember{}).
+			Where("conversation_id = ?", conversation.ID).
+			Pluck("user_id", &change.Recipients).Error; err != nil {
+			return nil, err
+		}
+	}
+	return change, nil
+}
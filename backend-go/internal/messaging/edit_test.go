@@ -0,0 +1,105 @@
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+	"gorm.io/gorm"
+)
+
+// storeEvent stores an event like the websocket event log does, with the
+// message as its data
+func storeEvent(t *testing.T, db *gorm.DB, userID uint, seq uint64, data interface{}, messageID uint) {
+	t.Helper()
+	payload, err := json.Marshal(map[string]interface{}{"type": "chat", "message_id": messageID, "data": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.UserEvent{UserID: userID, Seq: seq, Type: "chat", Payload: string(payload), MessageID: &messageID}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteForEveryoneScrubsEvents(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	service := NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+
+	send := func(senderID, receiverID uint, n int, content string, replyToID uint) *MessageView {
+		message, _, err := service.Send(&SendRequest{
+			SenderID:   senderID,
+			ReceiverID: receiverID,
+			ClientID:   fmt.Sprintf("00000000-0000-4000-8000-%012d", n),
+			Content:    content,
+			ReplyToID:  replyToID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+	secret := send(alice.ID, bob.ID, 1, "the secret", 0)
+	reply := send(bob.ID, alice.ID, 2, "got it", secret.ID)
+
+	storeEvent(t, db, bob.ID, 1, secret, secret.ID)
+	storeEvent(t, db, bob.ID, 2, reply, reply.ID)
+
+	if _, err := service.Delete(alice.ID, secret.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []models.UserEvent
+	if err := db.Where("user_id = ?", bob.ID).Order("seq").Find(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Seq != 2 {
+		t.Fatalf("events left = %+v, want only the reply", events)
+	}
+	if strings.Contains(events[0].Payload, "the secret") {
+		t.Fatalf("reply event still quotes the deleted message: %s", events[0].Payload)
+	}
+
+	var payload struct {
+		Data struct {
+			ReplyTo *QuotedMessage `json:"reply_to"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(events[0].Payload), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if quote := payload.Data.ReplyTo; quote == nil || quote.ID != secret.ID || quote.DeletedAt == nil {
+		t.Fatalf("quote = %+v, want a tombstone of message %d", quote, secret.ID)
+	}
+}
+
+func TestDeleteForSelfForgetsOwnEvents(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	service := NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+
+	message, _, err := service.Send(&SendRequest{SenderID: alice.ID, ReceiverID: bob.ID, ClientID: "00000000-0000-4000-8000-000000000001", Content: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeEvent(t, db, alice.ID, 1, message, message.ID)
+	storeEvent(t, db, bob.ID, 1, message, message.ID)
+
+	if _, err := service.Delete(bob.ID, message.ID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var left []uint
+	if err := db.Model(&models.UserEvent{}).Where("message_id = ?", message.ID).Pluck("user_id", &left).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0] != alice.ID {
+		t.Fatalf("events left for users %v, want only the sender's", left)
+	}
+}
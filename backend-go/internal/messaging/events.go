@@ -0,0 +1,39 @@
+package messaging
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Events in user_event keep a copy of the message they carry, and reply
+// events a copy of the message they quote, so removing a message's content
+// has to reach into the event log too.
+
+// forgetEvents deletes the events that carry the messages
+func forgetEvents(tx *gorm.DB, messageIDs []uint) error {
+	return tx.Where("message_id IN ?", messageIDs).Delete(&models.UserEvent{}).Error
+}
+
+// redactQuotes erases the copies of the messages quoted in the events of
+// their replies. A deleted message is quoted as a tombstone like in history;
+// with deletedAt nil the quote is dropped, as for a message that is gone.
+func redactQuotes(tx *gorm.DB, messageIDs []uint, deletedAt *time.Time) error {
+	replies := tx.Model(&models.Message{}).Select("id").Where("reply_to_id IN ?", messageIDs)
+	quoted := tx.Model(&models.UserEvent{}).
+		Where("message_id IN (?) AND payload->'data'->'reply_to' IS NOT NULL", replies)
+
+	if deletedAt == nil {
+		return quoted.Update("payload", gorm.Expr(`payload #- '{data,reply_to}'`)).Error
+	}
+	stamp, err := json.Marshal(deletedAt)
+	if err != nil {
+		return err
+	}
+	return quoted.Update("payload", gorm.Expr(
+		`jsonb_set(jsonb_set(payload, '{data,reply_to,content}', '""'), '{data,reply_to,deleted_at}', ?::jsonb)`,
+		string(stamp),
+	)).Error
+}
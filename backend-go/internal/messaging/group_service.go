@@ -0,0 +1,387 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const MaxGroupMembers = 1000
+
+var (
+	ErrGroupNotFound  = errors.New("group not found")
+	ErrNotGroupMember = errors.New("not a member of this group")
+	ErrGroupFull      = errors.New("group has reached its member limit")
+)
+
+// GroupMember is a member of a group with a summary of their profile
+type GroupMember struct {
+	UserID            uint              `json:"user_id"`
+	Role              models.MemberRole `json:"role"`
+	LastReadMessageID *uint             `json:"last_read_message_id,omitempty"`
+	JoinedAt          time.Time         `json:"joined_at"`
+	Name              string            `json:"name"`
+	Username          string            `json:"username"`
+}
+
+// GroupDetails is a group with its members
+type GroupDetails struct {
+	models.Conversation
+	Members []GroupMember `json:"members"`
+}
+
+// GroupAction is what a GroupChange did to a group
+type GroupAction string
+
+const (
+	GroupRenamed     GroupAction = "renamed"
+	GroupInvited     GroupAction = "invited"
+	GroupJoined      GroupAction = "joined"
+	GroupLeft        GroupAction = "left"
+	GroupKicked      GroupAction = "kicked"
+	GroupRoleChanged GroupAction = "role_changed"
+)
+
+// GroupChange is a change to a group's title or members and who has to hear
+// about it
+type GroupChange struct {
+	GroupID uint        `json:"group_id"`
+	ActorID uint        `json:"actor_id"`
+	Action  GroupAction `json:"action"`
+	// Members the change added, removed or gave a new role
+	UserIDs []uint            `json:"user_ids,omitempty"`
+	Role    models.MemberRole `json:"role,omitempty"`
+	Title   string            `json:"title,omitempty"`
+	// Set when the owner left and ownership passed to this member
+	OwnerID uint `json:"owner_id,omitempty"`
+	// Members after the change, and anyone it removed
+	Recipients []uint `json:"-"`
+}
+
+// GroupService manages group conversations, their members and roles
+type GroupService struct {
+	db       *gorm.DB
+	messages *MessageService
+}
+
+func NewGroupService(db *gorm.DB, messages *MessageService) *GroupService {
+	return &GroupService{db: db, messages: messages}
+}
+
+// Create starts a group owned by ownerID with the given initial members
+func (s *GroupService) Create(ownerID uint, title string, memberIDs []uint) (*models.Conversation, error) {
+	title, err := normalizeTitle(title)
+	if err != nil {
+		return nil, err
+	}
+
+	members := uniqueUserIDs(ownerID, memberIDs)
+	if len(members) > MaxGroupMembers {
+		return nil, ErrGroupFull
+	}
+
+	group := models.Conversation{
+		Type:      models.ConversationGroup,
+		Title:     title,
+		CreatedBy: &ownerID,
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&group).Error; err != nil {
+			return fmt.Errorf("failed to create group: %w", err)
+		}
+
+		rows := make([]models.ConversationMember, 0, len(members))
+		for _, userID := range members {
+			row := models.ConversationMember{ConversationID: group.ID, UserID: userID, Role: models.RoleMember}
+			if userID == ownerID {
+				row.Role, row.Accepted = models.RoleOwner, true
+			}
+			rows = append(rows, row)
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// Get returns a group and its members, as seen by one of its members
+func (s *GroupService) Get(userID, groupID uint) (*GroupDetails, error) {
+	group, member, err := groupScope.membership(s.db, userID, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []GroupMember
+	err = s.db.Table("conversation_member cm").
+		Select(`cm.user_id, cm.role, cm.last_read_message_id, cm.created_at AS joined_at, u.name, u.username`).
+		Joins(`JOIN "user" u ON u.id = cm.user_id`).
+		Where("cm.conversation_id = ?", groupID).
+		Order("cm.created_at ASC").
+		Scan(&members).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Only admins see the invite link
+	if roleRank(member.Role) < roleRank(models.RoleAdmin) {
+		group.InviteCode = nil
+	}
+	return &GroupDetails{Conversation: *group, Members: members}, nil
+}
+
+// Rename changes the group title; admins and the owner may rename
+func (s *GroupService) Rename(userID, groupID uint, title string) (*models.Conversation, *GroupChange, error) {
+	title, err := normalizeTitle(title)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	group, _, err := groupScope.requireRole(s.db, userID, groupID, models.RoleAdmin)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.db.Model(group).Update("title", title).Error; err != nil {
+		return nil, nil, err
+	}
+
+	change := &GroupChange{GroupID: groupID, ActorID: userID, Action: GroupRenamed, Title: title}
+	if err := changeRecipients(s.db, change); err != nil {
+		return nil, nil, err
+	}
+	return group, change, nil
+}
+
+// Invite adds users to the group; admins and the owner may invite. The
+// change is nil when all of them were members already.
+func (s *GroupService) Invite(userID, groupID uint, userIDs []uint) (*GroupChange, error) {
+	var change *GroupChange
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if _, _, err := groupScope.requireRole(tx, userID, groupID, models.RoleAdmin); err != nil {
+			return err
+		}
+		added, err := addMembers(tx, groupID, userIDs, MaxGroupMembers)
+		if err != nil || len(added) == 0 {
+			return err
+		}
+		change = &GroupChange{GroupID: groupID, ActorID: userID, Action: GroupInvited, UserIDs: added}
+		return changeRecipients(tx, change)
+	})
+	return change, err
+}
+
+// CreateInviteLink returns the group's invite code, generating a new one
+// and invalidating the previous link
+func (s *GroupService) CreateInviteLink(userID, groupID uint) (string, error) {
+	group, _, err := groupScope.requireRole(s.db, userID, groupID, models.RoleAdmin)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := newInviteCode()
+	if err != nil {
+		return "", err
+	}
+	if err := s.db.Model(group).Update("invite_code", code).Error; err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// RevokeInviteLink disables joining by link
+func (s *GroupService) RevokeInviteLink(userID, groupID uint) error {
+	group, _, err := groupScope.requireRole(s.db, userID, groupID, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	return s.db.Model(group).Update("invite_code", nil).Error
+}
+
+// JoinByLink adds the user to the group an invite code belongs to. The
+// change is nil when the user was a member already.
+func (s *GroupService) JoinByLink(userID uint, code string) (*models.Conversation, *GroupChange, error) {
+	var group models.Conversation
+	var change *GroupChange
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("invite_code = ? AND type = ?", code, models.ConversationGroup).First(&group).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidInviteCode
+		}
+		if err != nil {
+			return err
+		}
+		added, err := addMembers(tx, group.ID, []uint{userID}, MaxGroupMembers)
+		if err != nil {
+			return err
+		}
+		// Following a link is accepting the group, even for someone who was
+		// already invited
+		if err := tx.Model(&models.ConversationMember{}).
+			Where("conversation_id = ? AND user_id = ?", group.ID, userID).
+			Update("accepted", true).Error; err != nil {
+			return err
+		}
+		if len(added) == 0 {
+			return nil
+		}
+		change = &GroupChange{GroupID: group.ID, ActorID: userID, Action: GroupJoined, UserIDs: added}
+		return changeRecipients(tx, change)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	group.InviteCode = nil
+	return &group, change, nil
+}
+
+// Leave removes the user from the group. When the owner leaves, ownership
+// passes to the longest-standing admin, or else member.
+func (s *GroupService) Leave(userID, groupID uint) (*GroupChange, error) {
+	change := &GroupChange{GroupID: groupID, ActorID: userID, Action: GroupLeft, UserIDs: []uint{userID}}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		ownerID, err := groupScope.leave(tx, userID, groupID)
+		if err != nil {
+			return err
+		}
+		change.OwnerID = ownerID
+		return changeRecipients(tx, change, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// Kick removes a member. Admins may remove members; the owner may remove anyone.
+func (s *GroupService) Kick(userID, groupID, targetID uint) (*GroupChange, error) {
+	change := &GroupChange{GroupID: groupID, ActorID: userID, Action: GroupKicked, UserIDs: []uint{targetID}}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		target, err := groupScope.manageable(tx, userID, groupID, targetID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id = ? AND user_id = ?", groupID, target.UserID).Delete(&models.ConversationMember{}).Error; err != nil {
+			return err
+		}
+		return changeRecipients(tx, change, targetID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// SetRole promotes a member to admin or demotes an admin; only the owner may do this
+func (s *GroupService) SetRole(userID, groupID, targetID uint, role models.MemberRole) (*GroupChange, error) {
+	change := &GroupChange{GroupID: groupID, ActorID: userID, Action: GroupRoleChanged, UserIDs: []uint{targetID}, Role: role}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := groupScope.setRole(tx, userID, groupID, targetID, role); err != nil {
+			return err
+		}
+		return changeRecipients(tx, change)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// RequireRole reports an error unless the user is a member of the group with
+// at least the given role
+func (s *GroupService) RequireRole(userID, groupID uint, role models.MemberRole) error {
+	_, _, err := groupScope.requireRole(s.db, userID, groupID, role)
+	return err
+}
+
+// MemberIDs returns the members of a group the user belongs to
+func (s *GroupService) MemberIDs(userID, groupID uint) ([]uint, error) {
+	if _, _, err := groupScope.membership(s.db, userID, groupID); err != nil {
+		return nil, err
+	}
+
+	var ids []uint
+	err := s.db.Model(&models.ConversationMember{}).Where("conversation_id = ?", groupID).Pluck("user_id", &ids).Error
+	return ids, err
+}
+
+// changeRecipients sets who hears about a change: the group's members after
+// it, and the users it removed
+func changeRecipients(tx *gorm.DB, change *GroupChange, removed ...uint) error {
+	var ids []uint
+	if err := tx.Model(&models.ConversationMember{}).Where("conversation_id = ?", change.GroupID).Pluck("user_id", &ids).Error; err != nil {
+		return err
+	}
+	change.Recipients = append(ids, removed...)
+	return nil
+}
+
+// Send stores a group message once for all members, optionally as a reply to
+// replyToID. When the sender already sent a message with the same client ID,
+// that message is returned instead.
+func (s *GroupService) Send(senderID, groupID uint, clientID, content string, encrypted bool, replyToID uint) (*MessageView, bool, error) {
+	normalized, err := parseClientID(clientID)
+	if err != nil {
+		return nil, false, err
+	}
+	message := models.Message{
+		SenderID:       senderID,
+		ConversationID: &groupID,
+		ClientID:       normalized,
+		Content:        content,
+		Encrypted:      encrypted,
+		Status:         models.MessageStored,
+	}
+
+	created := false
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if _, _, err := groupScope.membership(tx, senderID, groupID); err != nil {
+			return err
+		}
+		if err := attachReply(tx, &message, replyToID); err != nil {
+			return err
+		}
+
+		created, err = insertMessage(tx, &message)
+		if err != nil || !created {
+			return err
+		}
+		if err := countReply(tx, &message); err != nil {
+			return err
+		}
+		return recordMessage(tx, groupID, &message)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Members see a summary of the sender, not their account
+	view, err := s.messages.viewMessage(senderID, message.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	return view, created, nil
+}
+
+// History returns a window of the group's messages
+func (s *GroupService) History(userID, groupID uint, query HistoryQuery) (*HistoryPage, error) {
+	if _, _, err := groupScope.membership(s.db, userID, groupID); err != nil {
+		return nil, err
+	}
+	return s.messages.ConversationHistory(userID, groupID, query)
+}
+
+// MarkRead moves the user's read position to the group's latest message and returns it
+func (s *GroupService) MarkRead(userID, groupID uint) (*uint, error) {
+	group, _, err := groupScope.membership(s.db, userID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if err := markRead(s.db, groupID, userID); err != nil {
+		return nil, err
+	}
+	return group.LastMessageID, nil
+}
@@ -0,0 +1,303 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+	"gorm.io/gorm"
+)
+
+func TestGroupSendSummarizesSender(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	if err := db.Model(alice).Updates(map[string]interface{}{"email": "alice@example.com", "phone_number": "+15550100"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	messages := NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+	groups := NewGroupService(db, messages)
+	group, err := groups.Create(alice.ID, "Climbing", []uint{bob.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message, created, err := groups.Send(alice.ID, group.ID, "2d7e1c0a-5b6f-4c1e-8f3a-9b2d4e6f8a10", "hello", false, 0)
+	if err != nil || !created {
+		t.Fatalf("send: created=%v err=%v", created, err)
+	}
+	if message.Sender == nil || message.Sender.Name != "Alice" {
+		t.Fatalf("sender = %+v, want a summary of Alice", message.Sender)
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []string{"alice@example.com", "+15550100"} {
+		if strings.Contains(string(data), private) {
+			t.Fatalf("message event exposes %q: %s", private, data)
+		}
+	}
+}
+
+func TestGroupMembershipAcceptance(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	carol := testutil.NewUser(t, db, "Carol")
+	dave := testutil.NewUser(t, db, "Dave")
+
+	messages := NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+	groups := NewGroupService(db, messages)
+	group, err := groups.Create(alice.ID, "Climbing", []uint{bob.ID, carol.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := func(userID uint) bool {
+		t.Helper()
+		var member models.ConversationMember
+		if err := db.Where("conversation_id = ? AND user_id = ?", group.ID, userID).First(&member).Error; err != nil {
+			t.Fatal(err)
+		}
+		return member.Accepted
+	}
+	if !accepted(alice.ID) || accepted(bob.ID) || accepted(carol.ID) {
+		t.Fatal("only the creator accepts a new group")
+	}
+
+	// Writing in the group accepts it, and so does joining by link
+	if _, _, err := groups.Send(bob.ID, group.ID, "2d7e1c0a-5b6f-4c1e-8f3a-9b2d4e6f8a11", "hi", false, 0); err != nil {
+		t.Fatal(err)
+	}
+	code, err := groups.CreateInviteLink(alice.ID, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range []uint{carol.ID, dave.ID} {
+		if _, _, err := groups.JoinByLink(userID, code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, userID := range []uint{bob.ID, carol.ID, dave.ID} {
+		if !accepted(userID) {
+			t.Fatalf("member %d has not accepted", userID)
+		}
+	}
+}
+
+func newTestGroup(t *testing.T, db *gorm.DB, ownerID uint, memberIDs ...uint) (*GroupService, uint) {
+	t.Helper()
+	groups := NewGroupService(db, NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60}))
+	group, err := groups.Create(ownerID, "Climbing", memberIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return groups, group.ID
+}
+
+func sameIDs(got []uint, want ...uint) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	seen := make(map[uint]bool, len(got))
+	for _, id := range got {
+		seen[id] = true
+	}
+	for _, id := range want {
+		if !seen[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGroupRoles(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	carol := testutil.NewUser(t, db, "Carol")
+	dave := testutil.NewUser(t, db, "Dave")
+	groups, groupID := newTestGroup(t, db, alice.ID, bob.ID, carol.ID)
+
+	// Members can't rename, invite or promote
+	if _, _, err := groups.Rename(bob.ID, groupID, "Bouldering"); !errors.Is(err, ErrRoleForbidden) {
+		t.Fatalf("member renames: err = %v", err)
+	}
+	if _, err := groups.Invite(bob.ID, groupID, []uint{dave.ID}); !errors.Is(err, ErrRoleForbidden) {
+		t.Fatalf("member invites: err = %v", err)
+	}
+	if _, err := groups.SetRole(alice.ID, groupID, bob.ID, models.RoleOwner); !errors.Is(err, ErrInvalidMemberRole) {
+		t.Fatalf("promote to owner: err = %v", err)
+	}
+
+	change, err := groups.SetRole(alice.ID, groupID, bob.ID, models.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Action != GroupRoleChanged || change.Role != models.RoleAdmin || !sameIDs(change.Recipients, alice.ID, bob.ID, carol.ID) {
+		t.Fatalf("role change = %+v", change)
+	}
+
+	// Admins rename and invite, but only the owner changes roles
+	if _, err := groups.SetRole(bob.ID, groupID, carol.ID, models.RoleAdmin); !errors.Is(err, ErrRoleForbidden) {
+		t.Fatalf("admin promotes: err = %v", err)
+	}
+	group, change, err := groups.Rename(bob.ID, groupID, "  Bouldering ")
+	if err != nil || group.Title != "Bouldering" || change.Title != "Bouldering" {
+		t.Fatalf("rename: group=%+v change=%+v err=%v", group, change, err)
+	}
+	change, err = groups.Invite(bob.ID, groupID, []uint{dave.ID, carol.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameIDs(change.UserIDs, dave.ID) || !sameIDs(change.Recipients, alice.ID, bob.ID, carol.ID, dave.ID) {
+		t.Fatalf("invite = %+v, want only Dave added and everyone told", change)
+	}
+	if change, err := groups.Invite(bob.ID, groupID, []uint{dave.ID}); err != nil || change != nil {
+		t.Fatalf("inviting a member again: change=%+v err=%v", change, err)
+	}
+
+	// Only members see the group, and only admins its invite link
+	if _, err := groups.Get(testutil.NewUser(t, db, "Eve").ID, groupID); !errors.Is(err, ErrNotGroupMember) {
+		t.Fatalf("stranger gets the group: err = %v", err)
+	}
+	if _, err := groups.CreateInviteLink(alice.ID, groupID); err != nil {
+		t.Fatal(err)
+	}
+	details, err := groups.Get(carol.ID, groupID)
+	if err != nil || details.InviteCode != nil || len(details.Members) != 4 {
+		t.Fatalf("member's view: %+v err=%v", details, err)
+	}
+	if details, _ := groups.Get(bob.ID, groupID); details.InviteCode == nil {
+		t.Fatal("admin does not see the invite link")
+	}
+}
+
+func TestGroupKickAndLeave(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	carol := testutil.NewUser(t, db, "Carol")
+	dave := testutil.NewUser(t, db, "Dave")
+	groups, groupID := newTestGroup(t, db, alice.ID, bob.ID, carol.ID, dave.ID)
+	if _, err := groups.SetRole(alice.ID, groupID, carol.ID, models.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Admins remove members but not each other or the owner
+	if _, err := groups.Kick(bob.ID, groupID, dave.ID); !errors.Is(err, ErrRoleForbidden) {
+		t.Fatalf("member kicks: err = %v", err)
+	}
+	if _, err := groups.Kick(carol.ID, groupID, alice.ID); !errors.Is(err, ErrCannotTargetMember) {
+		t.Fatalf("admin kicks the owner: err = %v", err)
+	}
+	change, err := groups.Kick(carol.ID, groupID, dave.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The removed member hears about it too
+	if change.Action != GroupKicked || !sameIDs(change.Recipients, alice.ID, bob.ID, carol.ID, dave.ID) {
+		t.Fatalf("kick = %+v", change)
+	}
+	if _, err := groups.MemberIDs(dave.ID, groupID); !errors.Is(err, ErrNotGroupMember) {
+		t.Fatalf("kicked member still in the group: err = %v", err)
+	}
+
+	change, err = groups.Leave(bob.ID, groupID)
+	if err != nil || change.OwnerID != 0 || !sameIDs(change.Recipients, alice.ID, carol.ID, bob.ID) {
+		t.Fatalf("member leaves: change=%+v err=%v", change, err)
+	}
+
+	// Ownership passes to the admin, not to whoever joined first
+	change, err = groups.Leave(alice.ID, groupID)
+	if err != nil || change.OwnerID != carol.ID {
+		t.Fatalf("owner leaves: change=%+v err=%v", change, err)
+	}
+	if err := groups.RequireRole(carol.ID, groupID, models.RoleOwner); err != nil {
+		t.Fatalf("admin did not become owner: %v", err)
+	}
+
+	// The group is deleted with its last member
+	if _, err := groups.Leave(carol.ID, groupID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.First(&models.Conversation{}, groupID).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("empty group: err = %v", err)
+	}
+}
+
+func TestGroupMemberLimit(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	carol := testutil.NewUser(t, db, "Carol")
+	dave := testutil.NewUser(t, db, "Dave")
+	_, groupID := newTestGroup(t, db, alice.ID, bob.ID)
+
+	tooMany := make([]uint, MaxGroupMembers)
+	for i := range tooMany {
+		tooMany[i] = alice.ID + uint(i) + 1
+	}
+	if _, err := NewGroupService(db, nil).Create(alice.ID, "Everyone", tooMany); !errors.Is(err, ErrGroupFull) {
+		t.Fatalf("create over the limit: err = %v", err)
+	}
+
+	// Members already in the group don't count against the room left
+	added, err := addMembers(db, groupID, []uint{alice.ID, bob.ID, carol.ID}, 3)
+	if err != nil || !sameIDs(added, carol.ID) {
+		t.Fatalf("add up to the limit: added=%v err=%v", added, err)
+	}
+	if _, err := addMembers(db, groupID, []uint{dave.ID}, 3); !errors.Is(err, ErrGroupFull) {
+		t.Fatalf("add over the limit: err = %v", err)
+	}
+}
+
+func TestGroupInviteLink(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	carol := testutil.NewUser(t, db, "Carol")
+	groups, groupID := newTestGroup(t, db, alice.ID, bob.ID)
+
+	if _, err := groups.CreateInviteLink(bob.ID, groupID); !errors.Is(err, ErrRoleForbidden) {
+		t.Fatalf("member creates a link: err = %v", err)
+	}
+	first, err := groups.CreateInviteLink(alice.ID, groupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := groups.CreateInviteLink(alice.ID, groupID)
+	if err != nil || second == first {
+		t.Fatalf("new link: code=%q err=%v", second, err)
+	}
+	if _, _, err := groups.JoinByLink(carol.ID, first); !errors.Is(err, ErrInvalidInviteCode) {
+		t.Fatalf("replaced link: err = %v", err)
+	}
+
+	group, change, err := groups.JoinByLink(carol.ID, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.ID != groupID || group.InviteCode != nil {
+		t.Fatalf("joined %+v", group)
+	}
+	if change.Action != GroupJoined || !sameIDs(change.Recipients, alice.ID, bob.ID, carol.ID) {
+		t.Fatalf("join = %+v", change)
+	}
+	if _, change, err := groups.JoinByLink(carol.ID, second); err != nil || change != nil {
+		t.Fatalf("joining twice: change=%+v err=%v", change, err)
+	}
+
+	if err := groups.RevokeInviteLink(alice.ID, groupID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := groups.JoinByLink(testutil.NewUser(t, db, "Dave").ID, second); !errors.Is(err, ErrInvalidInviteCode) {
+		t.Fatalf("revoked link: err = %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package messaging
+
+import (
+	"errors"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 200
+)
+
+var ErrCursorNotFound = errors.New("cursor message not found in this conversation")
+
+// HistoryQuery selects a window of a conversation. At most one of Before,
+// After and Around is set; with none, the latest messages are returned.
+type HistoryQuery struct {
+	Before uint
+	After  uint
+	Around uint
+	Limit  int
+}
+
+// MessageView is a message with a summary of its sender instead of full user rows
+type MessageView struct {
+	ID             uint                 `json:"id"`
+	SenderID       uint                 `json:"sender_id"`
+	ReceiverID     uint                 `json:"receiver_id,omitempty"`
+	ConversationID *uint                `json:"conversation_id,omitempty"`
+	ClientID       *string              `json:"client_id,omitempty"`
+	Content        string               `json:"content"`
+	Encrypted      bool                 `json:"encrypted"`
+	Read           bool                 `json:"read"`
+	Status         models.MessageStatus `json:"status"`
+	DeliveredAt    *time.Time           `json:"delivered_at,omitempty"`
+	ReadAt         *time.Time           `json:"read_at,omitempty"`
+	ViewCount      int                  `json:"view_count,omitempty"`
+	EditedAt       *time.Time           `json:"edited_at,omitempty"`
+	DeletedAt      *time.Time           `json:"deleted_at,omitempty"`
+	ReplyToID      *uint                `json:"reply_to_id,omitempty"`
+	ThreadRootID   *uint                `json:"thread_root_id,omitempty"`
+	ReplyCount     int                  `json:"reply_count,omitempty"`
+	ReplyTo        *QuotedMessage       `json:"reply_to,omitempty"`
+	ExpiresAt      *time.Time           `json:"expires_at,omitempty"`
+	System         bool                 `json:"system,omitempty"`
+	CreatedAt      time.Time            `json:"created_at"`
+	UpdatedAt      time.Time            `json:"updated_at"`
+	Sender         *models.UserSummary  `json:"sender,omitempty"`
+	Reactions      []ReactionCount      `json:"reactions,omitempty"`
+}
+
+// HistoryPage is a window of messages in chronological order
+type HistoryPage struct {
+	Messages      []MessageView `json:"messages"`
+	HasMoreBefore bool          `json:"has_more_before"`
+	HasMoreAfter  bool          `json:"has_more_after"`
+}
+
+// History returns a window of the direct conversation between two users,
+// paginated by message ID cursors
+func (s *MessageService) History(userID, otherUserID uint, query HistoryQuery) (*HistoryPage, error) {
+	var conversations []models.Conversation
+	if err := s.db.Where("direct_key = ?", directKey(userID, otherUserID)).Limit(1).Find(&conversations).Error; err != nil {
+		return nil, err
+	}
+	// Before the first message there is no conversation, and no history
+	var conversationID uint
+	if len(conversations) > 0 {
+		conversationID = conversations[0].ID
+	}
+	return s.ConversationHistory(userID, conversationID, query)
+}
+
+// ConversationHistory returns a window of the messages in a conversation as seen by userID
+func (s *MessageService) ConversationHistory(userID, conversationID uint, query HistoryQuery) (*HistoryPage, error) {
+	conversation := s.db.Model(&models.Message{}).
+		Where("conversation_id = ?", conversationID).
+		Scopes(notHiddenFor(userID), notExpired).
+		Session(&gorm.Session{})
+	return s.history(userID, conversation, query)
+}
+
+// notHiddenFor leaves out messages the user deleted for themselves
+func notHiddenFor(userID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = message.id AND h.user_id = ?)", userID)
+	}
+}
+
+func (s *MessageService) history(viewerID uint, conversation *gorm.DB, query HistoryQuery) (*HistoryPage, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	if limit > MaxHistoryLimit {
+		limit = MaxHistoryLimit
+	}
+
+	page := &HistoryPage{}
+	var messages []models.Message
+
+	switch {
+	case query.Before != 0 || query.After != 0 || query.Around != 0:
+		anchorID := query.Before + query.After + query.Around
+		var anchor models.Message
+		if err := conversation.Where("id = ?", anchorID).First(&anchor).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrCursorNotFound
+			}
+			return nil, err
+		}
+
+		switch {
+		case query.Before != 0:
+			older, more, err := s.window(conversation, &anchor, false, false, limit)
+			if err != nil {
+				return nil, err
+			}
+			messages, page.HasMoreBefore, page.HasMoreAfter = older, more, true
+
+		case query.After != 0:
+			newer, more, err := s.window(conversation, &anchor, true, false, limit)
+			if err != nil {
+				return nil, err
+			}
+			messages, page.HasMoreBefore, page.HasMoreAfter = newer, true, more
+
+		default:
+			// The anchor and the messages after it take the second half of the window
+			older, moreBefore, err := s.window(conversation, &anchor, false, false, limit/2)
+			if err != nil {
+				return nil, err
+			}
+			newer, moreAfter, err := s.window(conversation, &anchor, true, true, limit-limit/2)
+			if err != nil {
+				return nil, err
+			}
+			messages, page.HasMoreBefore, page.HasMoreAfter = append(older, newer...), moreBefore, moreAfter
+		}
+
+	default:
+		latest, more, err := s.window(conversation, nil, false, false, limit)
+		if err != nil {
+			return nil, err
+		}
+		messages, page.HasMoreBefore = latest, more
+	}
+
+	views, err := s.viewMessages(viewerID, messages)
+	if err != nil {
+		return nil, err
+	}
+	page.Messages = views
+	return page, nil
+}
+
+// window loads up to limit messages after (or before) the anchor in
+// chronological order, and whether more exist beyond them
+func (s *MessageService) window(conversation *gorm.DB, anchor *models.Message, after, inclusive bool, limit int) ([]models.Message, bool, error) {
+	if limit <= 0 {
+		return nil, anchor != nil, nil
+	}
+
+	q := conversation
+	if anchor != nil {
+		op := "<"
+		if after {
+			op = ">"
+		}
+		if inclusive {
+			op += "="
+		}
+		q = q.Where("(created_at, id) "+op+" (?, ?)", anchor.CreatedAt, anchor.ID)
+	}
+
+	order := "created_at DESC, id DESC"
+	if after {
+		order = "created_at ASC, id ASC"
+	}
+
+	var messages []models.Message
+	if err := q.Order(order).Limit(limit + 1).Find(&messages).Error; err != nil {
+		return nil, false, err
+	}
+
+	more := len(messages) > limit
+	if more {
+		messages = messages[:limit]
+	}
+	if !after {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, more, nil
+}
+
+// viewMessages attaches sender summaries, quoted messages and reaction
+// counts as seen by the viewer, each loaded in one query
+func (s *MessageService) viewMessages(viewerID uint, messages []models.Message) ([]MessageView, error) {
+	senderIDs := make([]uint, 0, 2)
+	seen := make(map[uint]bool)
+	for _, message := range messages {
+		if !seen[message.SenderID] {
+			seen[message.SenderID] = true
+			senderIDs = append(senderIDs, message.SenderID)
+		}
+	}
+
+	summaries, err := s.userSummaries(senderIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	messageIDs := make([]uint, 0, len(messages))
+	for _, message := range messages {
+		messageIDs = append(messageIDs, message.ID)
+	}
+	reactions, err := reactionCounts(s.db, viewerID, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	quotes, err := quotedMessages(s.db, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]MessageView, 0, len(messages))
+	for _, message := range messages {
+		views = append(views, MessageView{
+			ID:             message.ID,
+			SenderID:       message.SenderID,
+			ReceiverID:     message.ReceiverID,
+			ConversationID: message.ConversationID,
+			ClientID:       message.ClientID,
+			Content:        message.Content,
+			Encrypted:      message.Encrypted,
+			Read:           message.Read,
+			Status:         message.Status,
+			DeliveredAt:    message.DeliveredAt,
+			ReadAt:         message.ReadAt,
+			ViewCount:      message.ViewCount,
+			EditedAt:       message.EditedAt,
+			DeletedAt:      message.DeletedAt,
+			ReplyToID:      message.ReplyToID,
+			ThreadRootID:   message.ThreadRootID,
+			ReplyCount:     message.ReplyCount,
+			ExpiresAt:      message.ExpiresAt,
+			System:         message.System,
+			CreatedAt:      message.CreatedAt,
+			UpdatedAt:      message.UpdatedAt,
+			Sender:         summaries[message.SenderID],
+			Reactions:      reactions[message.ID],
+			ReplyTo:        quoteOf(quotes, message.ReplyToID),
+		})
+	}
+	return views, nil
+}
+
+// viewMessage loads one message as the viewer sees it
+func (s *MessageService) viewMessage(viewerID, messageID uint) (*MessageView, error) {
+	var message models.Message
+	if err := s.db.First(&message, messageID).Error; err != nil {
+		return nil, err
+	}
+	views, err := s.viewMessages(viewerID, []models.Message{message})
+	if err != nil {
+		return nil, err
+	}
+	return &views[0], nil
+}
+
+func (s *MessageService) userSummaries(userIDs []uint) (map[uint]*models.UserSummary, error) {
+	summaries := make(map[uint]*models.UserSummary, len(userIDs))
+	if len(userIDs) == 0 {
+		return summaries, nil
+	}
+
+	var users []models.UserSummary
+	if err := s.db.Model(&models.User{}).Select("id, name, username").Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	for i := range users {
+		summaries[users[i].ID] = &users[i]
+	}
+	return summaries, nil
+}
@@ -0,0 +1,113 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+)
+
+func TestHistoryWindows(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	service := NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+
+	var ids []uint
+	for i := 0; i < 5; i++ {
+		message, _, err := service.Send(&SendRequest{
+			SenderID:   alice.ID,
+			ReceiverID: bob.ID,
+			ClientID:   fmt.Sprintf("00000000-0000-4000-8000-%012d", i),
+			Content:    fmt.Sprintf("message %d", i),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, message.ID)
+	}
+
+	tests := []struct {
+		name       string
+		query      HistoryQuery
+		want       []uint
+		moreBefore bool
+		moreAfter  bool
+	}{
+		{"latest", HistoryQuery{Limit: 2}, ids[3:], true, false},
+		{"before", HistoryQuery{Before: ids[3], Limit: 2}, ids[1:3], true, true},
+		{"before the first", HistoryQuery{Before: ids[1], Limit: 2}, ids[:1], false, true},
+		{"after", HistoryQuery{After: ids[1], Limit: 2}, ids[2:4], true, true},
+		{"after to the end", HistoryQuery{After: ids[2], Limit: 5}, ids[3:], true, false},
+		{"around", HistoryQuery{Around: ids[2], Limit: 3}, ids[1:4], true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := service.History(bob.ID, alice.ID, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]uint, 0, len(page.Messages))
+			for _, message := range page.Messages {
+				got = append(got, message.ID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("messages = %v, want %v", got, tt.want)
+			}
+			if page.HasMoreBefore != tt.moreBefore || page.HasMoreAfter != tt.moreAfter {
+				t.Fatalf("more before/after = %v/%v, want %v/%v", page.HasMoreBefore, page.HasMoreAfter, tt.moreBefore, tt.moreAfter)
+			}
+		})
+	}
+
+	if _, err := service.History(bob.ID, alice.ID, HistoryQuery{Before: ids[4] + 1000}); !errors.Is(err, ErrCursorNotFound) {
+		t.Fatalf("unknown cursor: err = %v, want ErrCursorNotFound", err)
+	}
+
+	// Users who never wrote to each other have no history
+	carol := testutil.NewUser(t, db, "Carol")
+	page, err := service.History(carol.ID, alice.ID, HistoryQuery{})
+	if err != nil || len(page.Messages) != 0 || page.HasMoreBefore {
+		t.Fatalf("history without a conversation: page = %+v, err = %v", page, err)
+	}
+	if _, err := service.History(carol.ID, alice.ID, HistoryQuery{Around: ids[0]}); !errors.Is(err, ErrCursorNotFound) {
+		t.Fatalf("cursor from another conversation: err = %v, want ErrCursorNotFound", err)
+	}
+}
+
+func TestSendSummarizesSender(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	if err := db.Model(alice).Updates(map[string]interface{}{"email": "alice@example.com", "phone_number": "+15550100"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	service := NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+
+	message, created, err := service.Send(&SendRequest{SenderID: alice.ID, ReceiverID: bob.ID, ClientID: "00000000-0000-4000-8000-000000000001", Content: "hello"})
+	if err != nil || !created {
+		t.Fatalf("send: created=%v err=%v", created, err)
+	}
+	if message.Sender == nil || message.Sender.Name != "Alice" {
+		t.Fatalf("sender = %+v, want a summary of Alice", message.Sender)
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []string{"alice@example.com", "+15550100"} {
+		if strings.Contains(string(data), private) {
+			t.Fatalf("message event exposes %q: %s", private, data)
+		}
+	}
+
+	// A retry returns the stored message
+	again, created, err := service.Send(&SendRequest{SenderID: alice.ID, ReceiverID: bob.ID, ClientID: "00000000-0000-4000-8000-000000000001", Content: "hello"})
+	if err != nil || created || again.ID != message.ID {
+		t.Fatalf("retry: id=%d created=%v err=%v", again.ID, created, err)
+	}
+}
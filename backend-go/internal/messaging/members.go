@@ -0,0 +1,289 @@
+package messaging
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxTitleLength = 100
+
+var (
+	ErrRoleForbidden      = errors.New("your role does not allow this")
+	ErrInvalidTitle       = fmt.Errorf("title must be between 1 and %d characters", maxTitleLength)
+	ErrInvalidInviteCode  = errors.New("invite link is invalid or has been revoked")
+	ErrInvalidMemberRole  = errors.New("role must be admin or member")
+	ErrCannotTargetMember = errors.New("cannot change a member with an equal or higher role")
+)
+
+// memberScope checks roles in one type of multi-member conversation and
+// reports that type's own errors
+type memberScope struct {
+	kind      models.ConversationType
+	notFound  error
+	notMember error
+}
+
+var (
+	groupScope   = memberScope{kind: models.ConversationGroup, notFound: ErrGroupNotFound, notMember: ErrNotGroupMember}
+	channelScope = memberScope{kind: models.ConversationChannel, notFound: ErrChannelNotFound, notMember: ErrNotSubscribed}
+)
+
+// roleRank orders roles so that higher roles can manage lower ones
+func roleRank(role models.MemberRole) int {
+	switch role {
+	case models.RoleOwner:
+		return 3
+	case models.RoleAdmin:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// conversation loads a conversation of the scope's type
+func (s memberScope) conversation(tx *gorm.DB, conversationID uint) (*models.Conversation, error) {
+	var conversation models.Conversation
+	err := tx.Where("id = ? AND type = ?", conversationID, s.kind).First(&conversation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, s.notFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// membership loads a conversation and the user's membership in it
+func (s memberScope) membership(tx *gorm.DB, userID, conversationID uint) (*models.Conversation, *models.ConversationMember, error) {
+	conversation, err := s.conversation(tx, conversationID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	member, err := s.member(tx, conversationID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conversation, member, nil
+}
+
+func (s memberScope) member(tx *gorm.DB, conversationID, userID uint) (*models.ConversationMember, error) {
+	var member models.ConversationMember
+	err := tx.Where("conversation_id = ? AND user_id = ?", conversationID, userID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, s.notMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// requireRole checks that the user holds at least the given role
+func (s memberScope) requireRole(tx *gorm.DB, userID, conversationID uint, role models.MemberRole) (*models.Conversation, *models.ConversationMember, error) {
+	conversation, member, err := s.membership(tx, userID, conversationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if roleRank(member.Role) < roleRank(role) {
+		return nil, nil, ErrRoleForbidden
+	}
+	return conversation, member, nil
+}
+
+// manageable returns the target's membership if the user is an admin who outranks them
+func (s memberScope) manageable(tx *gorm.DB, userID, conversationID, targetID uint) (*models.ConversationMember, error) {
+	_, actor, err := s.requireRole(tx, userID, conversationID, models.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := s.member(tx, conversationID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if roleRank(target.Role) >= roleRank(actor.Role) {
+		return nil, ErrCannotTargetMember
+	}
+	return target, nil
+}
+
+// setRole promotes a member to admin or demotes an admin; only the owner may do this
+func (s memberScope) setRole(tx *gorm.DB, userID, conversationID, targetID uint, role models.MemberRole) error {
+	if role != models.RoleAdmin && role != models.RoleMember {
+		return ErrInvalidMemberRole
+	}
+	if _, _, err := s.requireRole(tx, userID, conversationID, models.RoleOwner); err != nil {
+		return err
+	}
+	target, err := s.manageable(tx, userID, conversationID, targetID)
+	if err != nil {
+		return err
+	}
+	return tx.Model(&models.ConversationMember{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, target.UserID).
+		Update("role", role).Error
+}
+
+// leave removes the user from the conversation. When the owner leaves,
+// ownership passes to the longest-standing admin, or else member, whose ID
+// is returned, and the conversation is deleted once nobody is left.
+func (s memberScope) leave(tx *gorm.DB, userID, conversationID uint) (uint, error) {
+	_, member, err := s.membership(tx, userID, conversationID)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Where("conversation_id = ? AND user_id = ?", conversationID, userID).Delete(&models.ConversationMember{}).Error; err != nil {
+		return 0, err
+	}
+	if member.Role != models.RoleOwner {
+		return 0, nil
+	}
+
+	var successor models.ConversationMember
+	err = tx.Where("conversation_id = ?", conversationID).
+		Order("CASE role WHEN 'admin' THEN 0 ELSE 1 END, created_at ASC").
+		Take(&successor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, tx.Delete(&models.Conversation{}, conversationID).Error
+	}
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Model(&models.ConversationMember{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, successor.UserID).
+		Update("role", models.RoleOwner).Error
+	return successor.UserID, err
+}
+
+// addMembers adds users as members, ignoring those already in the
+// conversation, and returns the ones it added. A limit of 0 means no member
+// limit.
+func addMembers(tx *gorm.DB, conversationID uint, userIDs []uint, limit int) ([]uint, error) {
+	userIDs = uniqueUserIDs(0, userIDs)
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	if limit > 0 {
+		// Lock the conversation so concurrent joins can't overshoot the limit
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Conversation{}, conversationID).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var existing []uint
+	if err := tx.Model(&models.ConversationMember{}).
+		Where("conversation_id = ? AND user_id IN ?", conversationID, userIDs).
+		Pluck("user_id", &existing).Error; err != nil {
+		return nil, err
+	}
+	isMember := make(map[uint]bool, len(existing))
+	for _, id := range existing {
+		isMember[id] = true
+	}
+	added := make([]uint, 0, len(userIDs))
+	for _, id := range userIDs {
+		if !isMember[id] {
+			added = append(added, id)
+		}
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	if limit > 0 {
+		var count int64
+		if err := tx.Model(&models.ConversationMember{}).Where("conversation_id = ?", conversationID).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if int(count)+len(added) > limit {
+			return nil, ErrGroupFull
+		}
+	}
+
+	rows := make([]models.ConversationMember, 0, len(added))
+	for _, id := range added {
+		rows = append(rows, models.ConversationMember{ConversationID: conversationID, UserID: id, Role: models.RoleMember})
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// newInviteCode returns a random code for joining by link
+func newInviteCode() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// parseClientID normalizes an optional client message UUID
+func parseClientID(clientID string) (*string, error) {
+	if clientID == "" {
+		return nil, nil
+	}
+	parsed, err := uuid.Parse(clientID)
+	if err != nil {
+		return nil, ErrInvalidClientID
+	}
+	normalized := parsed.String()
+	return &normalized, nil
+}
+
+// insertMessage stores a message, stamped with its conversation's
+// disappearing timer, unless its sender already stored one with the same
+// client ID, in which case message is loaded from that one and created is
+// false
+func insertMessage(tx *gorm.DB, message *models.Message) (bool, error) {
+	if err := stampExpiry(tx, message); err != nil {
+		return false, err
+	}
+	result := tx.Clauses(clause.OnConflict{
+		Columns:     []clause.Column{{Name: "sender_id"}, {Name: "client_id"}},
+		TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "client_id IS NOT NULL"}}},
+		DoNothing:   true,
+	}).Create(message)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to save message: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	// A retry of a message that was already stored
+	return false, tx.Where("sender_id = ? AND client_id = ?", message.SenderID, *message.ClientID).First(message).Error
+}
+
+func normalizeTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if title == "" || len([]rune(title)) > maxTitleLength {
+		return "", ErrInvalidTitle
+	}
+	return title, nil
+}
+
+// uniqueUserIDs returns first (if non-zero) followed by the other IDs, without duplicates
+func uniqueUserIDs(first uint, others []uint) []uint {
+	seen := make(map[uint]bool, len(others)+1)
+	ids := make([]uint, 0, len(others)+1)
+	for _, id := range append([]uint{first}, others...) {
+		if id == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
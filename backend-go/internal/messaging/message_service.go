@@ -0,0 +1,145 @@
+package messaging
+
+import (
+	"errors"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidClientID = errors.New("client_id must be a UUID")
+
+// MessageService stores messages and moves them through their delivery states.
+// It is shared by the REST API and the websocket hub.
+type MessageService struct {
+	db           *gorm.DB
+	deleteWindow time.Duration
+}
+
+func NewMessageService(db *gorm.DB, cfg *config.MessagingConfig) *MessageService {
+	return &MessageService{
+		db:           db,
+		deleteWindow: time.Duration(cfg.DeleteWindowMinutes) * time.Minute,
+	}
+}
+
+// SendRequest is a message to store on behalf of its sender
+type SendRequest struct {
+	SenderID   uint
+	ReceiverID uint
+	ClientID   string
+	Content    string
+	Encrypted  bool
+	// The message this one replies to, in the same conversation; 0 for none
+	ReplyToID uint
+}
+
+// Send stores a message. When the sender already sent a message with the
+// same client ID, that message is returned instead and created is false.
+func (s *MessageService) Send(req *SendRequest) (*MessageView, bool, error) {
+	clientID, err := parseClientID(req.ClientID)
+	if err != nil {
+		return nil, false, err
+	}
+	message := models.Message{
+		SenderID:   req.SenderID,
+		ReceiverID: req.ReceiverID,
+		ClientID:   clientID,
+		Content:    req.Content,
+		Encrypted:  req.Encrypted,
+		Status:     models.MessageStored,
+	}
+
+	created := false
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		conversation, err := directConversation(tx, req.SenderID, req.ReceiverID)
+		if err != nil {
+			return err
+		}
+		message.ConversationID = &conversation.ID
+		if err := attachReply(tx, &message, req.ReplyToID); err != nil {
+			return err
+		}
+
+		created, err = insertMessage(tx, &message)
+		if err != nil || !created {
+			return err
+		}
+		if err := countReply(tx, &message); err != nil {
+			return err
+		}
+		return recordMessage(tx, conversation.ID, &message)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The receiver sees a summary of the sender, not their account
+	view, err := s.viewMessage(req.SenderID, message.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	return view, created, nil
+}
+
+// MarkDelivered moves stored messages addressed to the receiver to delivered
+// and returns the ones that changed state
+func (s *MessageService) MarkDelivered(receiverID uint, messageIDs []uint) ([]models.Message, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+
+	var messages []models.Message
+	err := s.db.Model(&messages).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}, {Name: "sender_id"}, {Name: "receiver_id"}, {Name: "delivered_at"}}}).
+		Where("id IN ? AND receiver_id = ? AND status = ?", messageIDs, receiverID, models.MessageStored).
+		Updates(map[string]interface{}{
+			"status":       models.MessageDelivered,
+			"delivered_at": time.Now(),
+		}).Error
+	return messages, err
+}
+
+// MarkConversationRead marks every unread message from sender to receiver as
+// read and returns the IDs that changed state
+func (s *MessageService) MarkConversationRead(receiverID, senderID uint) ([]uint, error) {
+	now := time.Now()
+
+	var messages []models.Message
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&messages).
+			Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}}}).
+			Where("sender_id = ? AND receiver_id = ? AND status <> ?", senderID, receiverID, models.MessageRead).
+			Updates(map[string]interface{}{
+				"read":         true,
+				"status":       models.MessageRead,
+				"read_at":      now,
+				"delivered_at": gorm.Expr("COALESCE(delivered_at, ?)", now),
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		var conversation models.Conversation
+		err = tx.Where("direct_key = ?", directKey(receiverID, senderID)).First(&conversation).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return markRead(tx, conversation.ID, receiverID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	return ids, nil
+}
@@ -0,0 +1,139 @@
+package messaging
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Emoji sequences joined with ZWJ and modifiers run to about ten code points
+const maxReactionRunes = 16
+
+var ErrInvalidReaction = errors.New("reaction must be a single emoji")
+
+// ReactionCount is how many users reacted to a message with one emoji
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	// Whether the viewing user is one of them
+	Reacted bool `json:"reacted,omitempty"`
+}
+
+// ReactionChange is a reaction added or removed, with the message's new counts
+type ReactionChange struct {
+	MessageChange
+	UserID    uint
+	Emoji     string
+	Added     bool
+	Reactions []ReactionCount
+}
+
+// React adds the user's reaction to a message they can see. Adding a
+// reaction twice is a no-op.
+func (s *MessageService) React(userID, messageID uint, emoji string) (*ReactionChange, error) {
+	return s.setReaction(userID, messageID, emoji, true)
+}
+
+// Unreact removes the user's reaction from a message
+func (s *MessageService) Unreact(userID, messageID uint, emoji string) (*ReactionChange, error) {
+	return s.setReaction(userID, messageID, emoji, false)
+}
+
+func (s *MessageService) setReaction(userID, messageID uint, emoji string, add bool) (*ReactionChange, error) {
+	if !validReaction(emoji) {
+		return nil, ErrInvalidReaction
+	}
+
+	var change *ReactionChange
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var message models.Message
+		if err := tx.First(&message, messageID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrMessageNotFound
+			}
+			return err
+		}
+		if err := s.checkVisible(tx, userID, &message); err != nil {
+			return err
+		}
+		if message.DeletedAt != nil {
+			return ErrMessageDeleted
+		}
+		if message.System {
+			return ErrSystemMessage
+		}
+
+		reaction := models.MessageReaction{MessageID: messageID, UserID: userID, Emoji: emoji}
+		if add {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&reaction).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Where(&reaction).Delete(&models.MessageReaction{}).Error; err != nil {
+			return err
+		}
+
+		messageChange, err := s.changeFor(tx, &message)
+		if err != nil {
+			return err
+		}
+		counts, err := reactionCounts(tx, 0, []uint{messageID})
+		if err != nil {
+			return err
+		}
+
+		change = &ReactionChange{
+			MessageChange: *messageChange,
+			UserID:        userID,
+			Emoji:         emoji,
+			Added:         add,
+			Reactions:     counts[messageID],
+		}
+		return nil
+	})
+	return change, err
+}
+
+// reactionCounts aggregates the reactions to each message, most used first,
+// marking those by viewerID
+func reactionCounts(db *gorm.DB, viewerID uint, messageIDs []uint) (map[uint][]ReactionCount, error) {
+	counts := make(map[uint][]ReactionCount)
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		MessageID uint
+		Emoji     string
+		Count     int
+		Reacted   bool
+	}
+	err := db.Model(&models.MessageReaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, BOOL_OR(user_id = ?) AS reacted", viewerID).
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").
+		Order("message_id, count DESC, MIN(created_at)").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		counts[row.MessageID] = append(counts[row.MessageID], ReactionCount{
+			Emoji:   row.Emoji,
+			Count:   row.Count,
+			Reacted: row.Reacted,
+		})
+	}
+	return counts, nil
+}
+
+// validReaction accepts a single emoji
+func validReaction(emoji string) bool {
+	if emoji == "" || !utf8.ValidString(emoji) || utf8.RuneCountInString(emoji) > maxReactionRunes {
+		return false
+	}
+	return isEmoji(emoji)
+}
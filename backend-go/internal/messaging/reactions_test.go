@@ -0,0 +1,40 @@
+package messaging
+
+import "testing"
+
+func TestValidReaction(t *testing.T) {
+	tests := []struct {
+		name  string
+		emoji string
+		valid bool
+	}{
+		{"emoji", "👍", true},
+		{"skin tone", "👍🏽", true},
+		{"text presentation with selector", "❤️", true},
+		{"ZWJ sequence", "👩‍👩‍👧‍👦", true},
+		{"ZWJ with skin tones", "🧑🏻‍🤝‍🧑🏿", true},
+		{"flag", "🇺🇦", true},
+		{"subdivision flag", "🏴󠁧󠁢󠁳󠁣󠁴󠁿", true},
+		{"keycap", "1️⃣", true},
+		{"symbol", "©", true},
+		{"empty", "", false},
+		{"two emoji", "👍👍", false},
+		{"two flags", "🇺🇦🇵🇱", false},
+		{"lone regional indicator", "🇺", false},
+		{"lone skin tone", "🏽", false},
+		{"letter", "a", false},
+		{"word", "lol", false},
+		{"digit", "1", false},
+		{"emoji and text", "👍ok", false},
+		{"emoji and space", "👍 ", false},
+		{"CJK", "好", false},
+		{"invalid UTF-8", "\xff", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validReaction(tt.emoji); got != tt.valid {
+				t.Fatalf("validReaction(%q) = %v, want %v", tt.emoji, got, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/database"
+	"github.com/everest-an/dchat-backend/internal/models"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// Clients send files as "[FILE]name|ipfs hash|mime type|size"
+const attachmentPrefix = "[FILE]"
+
+// ts_headline marks matches with private-use characters, which are replaced
+// with <mark> tags once the rest of the snippet has been HTML-escaped
+const (
+	matchStart = "\ue000"
+	matchStop  = "\ue001"
+)
+
+var (
+	ErrEmptySearchQuery    = errors.New("search query is required")
+	ErrInvalidSearchCursor = errors.New("invalid search cursor")
+)
+
+var (
+	headlineOptions = fmt.Sprintf(`StartSel="%s", StopSel="%s", MaxWords=30, MinWords=10, MaxFragments=2`, matchStart, matchStop)
+	snippetMarks    = strings.NewReplacer(matchStart, "<mark>", matchStop, "</mark>")
+)
+
+// SearchQuery selects messages matching Text among those the user can see.
+// Zero-valued filters are not applied.
+type SearchQuery struct {
+	Text           string
+	ConversationID uint
+	SenderID       uint
+	Since          *time.Time
+	Until          *time.Time
+	HasAttachment  *bool
+	Cursor         string
+	Limit          int
+}
+
+// SearchResult is a matching message with an HTML snippet around the matches
+type SearchResult struct {
+	MessageView
+	Snippet string  `json:"snippet"`
+	Rank    float32 `json:"rank"`
+}
+
+// SearchPage is a page of results, best matches first
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type searchRow struct {
+	models.Message
+	Rank    float32
+	Snippet string
+}
+
+// Search runs a full-text query over the plain-text messages the user can
+// see: their direct messages and those of the groups and channels they
+// belong to. Encrypted, system and deleted messages are never indexed. The
+// query accepts web search syntax: quoted phrases, OR and -exclusions.
+func (s *MessageService) Search(userID uint, query SearchQuery) (*SearchPage, error) {
+	text := strings.TrimSpace(query.Text)
+	if text == "" {
+		return nil, ErrEmptySearchQuery
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	const tsquery = "websearch_to_tsquery('simple', ?)"
+	matches := s.db.Model(&models.Message{}).
+		Select("message.*, ts_rank(message.search_vector, "+tsquery+") AS rank", text).
+		Where("message.search_vector @@ "+tsquery, text).
+		Where(`(message.sender_id = ? OR message.receiver_id = ? OR message.conversation_id IN (
+			SELECT conversation_id FROM conversation_member WHERE user_id = ?))`, userID, userID, userID).
+		Scopes(notHiddenFor(userID), notExpired)
+
+	if query.ConversationID != 0 {
+		matches = matches.Where("message.conversation_id = ?", query.ConversationID)
+	}
+	if query.SenderID != 0 {
+		matches = matches.Where("message.sender_id = ?", query.SenderID)
+	}
+	if query.Since != nil {
+		matches = matches.Where("message.created_at >= ?", *query.Since)
+	}
+	if query.Until != nil {
+		matches = matches.Where("message.created_at < ?", *query.Until)
+	}
+	if query.HasAttachment != nil {
+		op := "LIKE"
+		if !*query.HasAttachment {
+			op = "NOT LIKE"
+		}
+		matches = matches.Where("message.content "+op+" ?", database.EscapeLike(attachmentPrefix)+"%")
+	}
+	if query.Cursor != "" {
+		rank, id, err := parseSearchCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		matches = matches.Where("(ts_rank(message.search_vector, "+tsquery+"), message.id) < (?::real, ?)", text, rank, id)
+	}
+	matches = matches.Order("rank DESC, message.id DESC").Limit(limit + 1)
+
+	// Snippets are only built for the page, not for every match
+	var rows []searchRow
+	if err := s.db.Table("(?) AS r", matches).
+		Select("r.*, ts_headline('simple', r.content, "+tsquery+", ?) AS snippet", text, headlineOptions).
+		Order("r.rank DESC, r.id DESC").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	page := &SearchPage{}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		last := rows[len(rows)-1]
+		page.NextCursor = strconv.FormatFloat(float64(last.Rank), 'g', -1, 32) + "_" + strconv.FormatUint(uint64(last.ID), 10)
+	}
+
+	messages := make([]models.Message, 0, len(rows))
+	for _, row := range rows {
+		messages = append(messages, row.Message)
+	}
+	views, err := s.viewMessages(userID, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	page.Results = make([]SearchResult, 0, len(rows))
+	for i, row := range rows {
+		page.Results = append(page.Results, SearchResult{
+			MessageView: views[i],
+			Snippet:     snippetMarks.Replace(html.EscapeString(row.Snippet)),
+			Rank:        row.Rank,
+		})
+	}
+	return page, nil
+}
+
+func parseSearchCursor(cursor string) (float32, uint, error) {
+	i := strings.LastIndexByte(cursor, '_')
+	if i < 0 {
+		return 0, 0, ErrInvalidSearchCursor
+	}
+	rank, err := strconv.ParseFloat(cursor[:i], 32)
+	if err != nil {
+		return 0, 0, ErrInvalidSearchCursor
+	}
+	id, err := strconv.ParseUint(cursor[i+1:], 10, 32)
+	if err != nil {
+		return 0, 0, ErrInvalidSearchCursor
+	}
+	return float32(rank), uint(id), nil
+}
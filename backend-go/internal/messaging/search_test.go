@@ -0,0 +1,197 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+)
+
+// searchIDs returns the IDs of the messages matching text for the user
+func searchIDs(t *testing.T, service *MessageService, userID uint, text string) []uint {
+	t.Helper()
+	page, err := service.Search(userID, SearchQuery{Text: text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]uint, 0, len(page.Results))
+	for _, result := range page.Results {
+		ids = append(ids, result.ID)
+	}
+	return ids
+}
+
+func TestSearchVisibility(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	carol := testutil.NewUser(t, db, "Carol")
+	messages := NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+	conversations := NewConversationService(db, messages)
+
+	n := 0
+	send := func(content string, encrypted bool) *MessageView {
+		n++
+		message, _, err := messages.Send(&SendRequest{
+			SenderID:   alice.ID,
+			ReceiverID: bob.ID,
+			ClientID:   fmt.Sprintf("00000000-0000-4000-8000-%012d", n),
+			Content:    content,
+			Encrypted:  encrypted,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+
+	direct := send("pineapple on pizza", false)
+	hidden := send("pineapple for bob only", false)
+	expired := send("pineapple that disappears", false)
+	send("pineapple", true)
+	deleted := send("pineapple I regret", false)
+
+	groups, groupID := newTestGroup(t, db, alice.ID, bob.ID)
+	inGroup, _, err := groups.Send(bob.ID, groupID, "00000000-0000-4000-8000-000000000100", "pineapple party", false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := messages.Delete(bob.ID, hidden.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := messages.Delete(alice.ID, deleted.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&models.Message{}).Where("id = ?", expired.ID).Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Encrypted, deleted and expired messages match for nobody; hidden ones
+	// only for whoever didn't hide them
+	if got := searchIDs(t, messages, bob.ID, "pineapple"); !sameIDs(got, direct.ID, inGroup.ID) {
+		t.Fatalf("bob found %v, want %v", got, []uint{direct.ID, inGroup.ID})
+	}
+	if got := searchIDs(t, messages, alice.ID, "pineapple"); !sameIDs(got, direct.ID, hidden.ID, inGroup.ID) {
+		t.Fatalf("alice found %v, want %v", got, []uint{direct.ID, hidden.ID, inGroup.ID})
+	}
+	if got := searchIDs(t, messages, carol.ID, "pineapple"); len(got) != 0 {
+		t.Fatalf("non-member found %v", got)
+	}
+
+	// Server announcements aren't something anyone wrote
+	if _, err := conversations.SetDisappearingTimer(alice.ID, *direct.ConversationID, 60); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchIDs(t, messages, alice.ID, "disappearing"); len(got) != 0 {
+		t.Fatalf("system message found: %v", got)
+	}
+}
+
+func TestSearchCursor(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	service := NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+
+	// Ranks differ with the number of matches, and tie within each group
+	var want []uint
+	for i, content := range []string{"kiwi", "kiwi kiwi kiwi", "kiwi", "kiwi kiwi kiwi", "kiwi and more words around the kiwi"} {
+		message, _, err := service.Send(&SendRequest{
+			SenderID:   alice.ID,
+			ReceiverID: bob.ID,
+			ClientID:   fmt.Sprintf("00000000-0000-4000-8000-%012d", i),
+			Content:    content,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, message.ID)
+	}
+
+	var got []uint
+	var ranks []float32
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatal("cursor never ran out")
+		}
+		page, err := service.Search(bob.ID, SearchQuery{Text: "kiwi", Cursor: cursor, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, result := range page.Results {
+			got = append(got, result.ID)
+			ranks = append(ranks, result.Rank)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	if !sameIDs(got, want...) || len(got) != len(want) {
+		t.Fatalf("pages returned %v, want each of %v once", got, want)
+	}
+	for i := 1; i < len(got); i++ {
+		if ranks[i] > ranks[i-1] || (ranks[i] == ranks[i-1] && got[i] > got[i-1]) {
+			t.Fatalf("results out of order at %d: ids %v, ranks %v", i, got, ranks)
+		}
+	}
+
+	if _, err := service.Search(bob.ID, SearchQuery{Text: "kiwi", Cursor: "0.5"}); !errors.Is(err, ErrInvalidSearchCursor) {
+		t.Fatalf("malformed cursor: err = %v, want ErrInvalidSearchCursor", err)
+	}
+}
+
+func TestParseSearchCursor(t *testing.T) {
+	rank, id, err := parseSearchCursor("0.0607927_42")
+	if err != nil || rank != float32(0.0607927) || id != 42 {
+		t.Fatalf("rank=%v id=%d err=%v", rank, id, err)
+	}
+	for _, cursor := range []string{"", "0.5", "_42", "0.5_", "rank_42", "0.5_-1"} {
+		if _, _, err := parseSearchCursor(cursor); !errors.Is(err, ErrInvalidSearchCursor) {
+			t.Fatalf("%q: err = %v, want ErrInvalidSearchCursor", cursor, err)
+		}
+	}
+}
+
+func TestSearchSnippetEscapesContent(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	service := NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+
+	if _, _, err := service.Send(&SendRequest{
+		SenderID:   alice.ID,
+		ReceiverID: bob.ID,
+		ClientID:   "00000000-0000-4000-8000-000000000001",
+		Content:    `<img src=x onerror="alert(1)"> 5 < 6 & mango > "mango"`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := service.Search(bob.ID, SearchQuery{Text: "mango"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 {
+		t.Fatalf("results = %+v", page.Results)
+	}
+	snippet := page.Results[0].Snippet
+	if strings.Contains(snippet, "<img") || !strings.Contains(snippet, "&lt; 6 &amp;") {
+		t.Fatalf("snippet not escaped: %s", snippet)
+	}
+	if !strings.Contains(snippet, "<mark>mango</mark> &gt;") {
+		t.Fatalf("snippet = %s, want marked matches around escaped text", snippet)
+	}
+	// Only the marks are markup
+	if strings.Count(snippet, "<") != 2*strings.Count(snippet, "<mark>") {
+		t.Fatalf("snippet has markup besides marks: %s", snippet)
+	}
+}
@@ -0,0 +1,147 @@
+package messaging
+
+import (
+	"errors"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidReplyTarget = errors.New("reply_to_id must be a message in the same conversation")
+
+// ThreadPage is the first message of a thread with a window of its replies
+type ThreadPage struct {
+	Root MessageView `json:"root"`
+	HistoryPage
+}
+
+// QuotedMessage is the part of a replied-to message clients need to render a quote
+type QuotedMessage struct {
+	ID        uint       `json:"id"`
+	SenderID  uint       `json:"sender_id"`
+	Content   string     `json:"content"`
+	Encrypted bool       `json:"encrypted"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Thread returns the thread a message belongs to: its first message and a
+// window of the replies, paginated like conversation history
+func (s *MessageService) Thread(userID, messageID uint, query HistoryQuery) (*ThreadPage, error) {
+	var message models.Message
+	if err := s.db.First(&message, messageID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	if err := s.checkVisible(s.db, userID, &message); err != nil {
+		return nil, err
+	}
+
+	root := message
+	if message.ThreadRootID != nil {
+		if err := s.db.First(&root, *message.ThreadRootID).Error; err != nil {
+			return nil, err
+		}
+	}
+	views, err := s.viewMessages(userID, []models.Message{root})
+	if err != nil {
+		return nil, err
+	}
+
+	replies := s.db.Model(&models.Message{}).
+		Where("thread_root_id = ?", root.ID).
+		Scopes(notHiddenFor(userID), notExpired).
+		Session(&gorm.Session{})
+	page, err := s.history(userID, replies, query)
+	if err != nil {
+		return nil, err
+	}
+	return &ThreadPage{Root: views[0], HistoryPage: *page}, nil
+}
+
+// attachReply links a message that is about to be stored to the message it
+// replies to, which must be in the same conversation, and to the root of
+// that message's thread
+func attachReply(tx *gorm.DB, message *models.Message, replyToID uint) error {
+	if replyToID == 0 {
+		return nil
+	}
+
+	var parent models.Message
+	err := tx.First(&parent, replyToID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidReplyTarget
+	}
+	if err != nil {
+		return err
+	}
+	if !sameConversation(&parent, message) {
+		return ErrInvalidReplyTarget
+	}
+
+	rootID := parent.ID
+	if parent.ThreadRootID != nil {
+		rootID = *parent.ThreadRootID
+	}
+	message.ReplyToID = &parent.ID
+	message.ThreadRootID = &rootID
+	return nil
+}
+
+// countReply adds a newly stored reply to its thread's reply count
+func countReply(tx *gorm.DB, message *models.Message) error {
+	if message.ThreadRootID == nil {
+		return nil
+	}
+	return tx.Model(&models.Message{}).
+		Where("id = ?", *message.ThreadRootID).
+		Update("reply_count", gorm.Expr("reply_count + 1")).Error
+}
+
+// sameConversation compares conversations, falling back to the pair of
+// participants for direct messages sent before conversations existed
+func sameConversation(parent, message *models.Message) bool {
+	if parent.ConversationID != nil && message.ConversationID != nil {
+		return *parent.ConversationID == *message.ConversationID
+	}
+	if parent.ConversationID != nil || parent.ReceiverID == 0 || message.ReceiverID == 0 {
+		return false
+	}
+	return (parent.SenderID == message.SenderID && parent.ReceiverID == message.ReceiverID) ||
+		(parent.SenderID == message.ReceiverID && parent.ReceiverID == message.SenderID)
+}
+
+// quotedMessages loads the messages replied to by the given messages, in one query
+func quotedMessages(db *gorm.DB, messages []models.Message) (map[uint]*QuotedMessage, error) {
+	quotes := make(map[uint]*QuotedMessage)
+	ids := make([]uint, 0)
+	for _, message := range messages {
+		if message.ReplyToID != nil {
+			ids = append(ids, *message.ReplyToID)
+		}
+	}
+	if len(ids) == 0 {
+		return quotes, nil
+	}
+
+	var quoted []QuotedMessage
+	if err := db.Model(&models.Message{}).
+		Select("id, sender_id, content, encrypted, deleted_at").
+		Where("id IN ?", ids).
+		Find(&quoted).Error; err != nil {
+		return nil, err
+	}
+	for i := range quoted {
+		quotes[quoted[i].ID] = &quoted[i]
+	}
+	return quotes, nil
+}
+
+func quoteOf(quotes map[uint]*QuotedMessage, replyToID *uint) *QuotedMessage {
+	if replyToID == nil {
+		return nil
+	}
+	return quotes[*replyToID]
+}
@@ -0,0 +1,176 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+)
+
+func TestRepliesJoinTheRootThread(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	carol := testutil.NewUser(t, db, "Carol")
+	service := NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+
+	n := 0
+	send := func(senderID, receiverID, replyToID uint) (*MessageView, error) {
+		n++
+		message, _, err := service.Send(&SendRequest{
+			SenderID:   senderID,
+			ReceiverID: receiverID,
+			ClientID:   fmt.Sprintf("00000000-0000-4000-8000-%012d", n),
+			Content:    fmt.Sprintf("message %d", n),
+			ReplyToID:  replyToID,
+		})
+		return message, err
+	}
+
+	root, err := send(alice.ID, bob.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := send(bob.ID, alice.ID, root.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.ReplyToID == nil || *reply.ReplyToID != root.ID || reply.ThreadRootID == nil || *reply.ThreadRootID != root.ID {
+		t.Fatalf("reply = %+v, want it to reply to and join thread %d", reply, root.ID)
+	}
+	// A reply to a reply stays in the thread of the first message
+	nested, err := send(alice.ID, bob.ID, reply.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *nested.ReplyToID != reply.ID || nested.ThreadRootID == nil || *nested.ThreadRootID != root.ID {
+		t.Fatalf("nested reply = %+v, want it to reply to %d in thread %d", nested, reply.ID, root.ID)
+	}
+
+	// Replies can't reach into another conversation
+	groups, groupID := newTestGroup(t, db, alice.ID, bob.ID)
+	if _, err := send(carol.ID, alice.ID, root.ID); !errors.Is(err, ErrInvalidReplyTarget) {
+		t.Fatalf("reply from another direct conversation: err = %v", err)
+	}
+	if _, _, err := groups.Send(bob.ID, groupID, "00000000-0000-4000-8000-000000000100", "in the group", false, root.ID); !errors.Is(err, ErrInvalidReplyTarget) {
+		t.Fatalf("reply from a group: err = %v", err)
+	}
+	if _, err := send(alice.ID, bob.ID, nested.ID+1000); !errors.Is(err, ErrInvalidReplyTarget) {
+		t.Fatalf("reply to a missing message: err = %v", err)
+	}
+
+	// Only the replies that were stored count
+	var stored models.Message
+	if err := db.First(&stored, root.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.ReplyCount != 2 {
+		t.Fatalf("reply count = %d, want 2", stored.ReplyCount)
+	}
+	if err := db.First(&stored, reply.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.ReplyCount != 0 {
+		t.Fatalf("reply to a reply counted on the reply: %d", stored.ReplyCount)
+	}
+}
+
+func TestSameConversationBeforeConversations(t *testing.T) {
+	id := func(v uint) *uint { return &v }
+	tests := []struct {
+		name    string
+		parent  models.Message
+		message models.Message
+		want    bool
+	}{
+		{"same conversation", models.Message{ConversationID: id(1)}, models.Message{ConversationID: id(1)}, true},
+		{"other conversation", models.Message{ConversationID: id(1)}, models.Message{ConversationID: id(2)}, false},
+		{"legacy, same direction", models.Message{SenderID: 1, ReceiverID: 2}, models.Message{SenderID: 1, ReceiverID: 2}, true},
+		{"legacy, answering", models.Message{SenderID: 1, ReceiverID: 2}, models.Message{SenderID: 2, ReceiverID: 1}, true},
+		{"legacy, other pair", models.Message{SenderID: 1, ReceiverID: 2}, models.Message{SenderID: 3, ReceiverID: 1}, false},
+		{"group parent", models.Message{SenderID: 1, ConversationID: id(1)}, models.Message{SenderID: 1, ReceiverID: 2}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameConversation(&tt.parent, &tt.message); got != tt.want {
+				t.Fatalf("sameConversation = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThreadPages(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	carol := testutil.NewUser(t, db, "Carol")
+	service := NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+
+	send := func(n int, replyToID uint) *MessageView {
+		message, _, err := service.Send(&SendRequest{
+			SenderID:   alice.ID,
+			ReceiverID: bob.ID,
+			ClientID:   fmt.Sprintf("00000000-0000-4000-8000-%012d", n),
+			Content:    fmt.Sprintf("message %d", n),
+			ReplyToID:  replyToID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+	root := send(0, 0)
+	var replies []uint
+	for i := 1; i <= 5; i++ {
+		replies = append(replies, send(i, root.ID).ID)
+	}
+	// Messages outside the thread don't show up in it
+	send(6, 0)
+
+	ids := func(page *ThreadPage) []uint {
+		got := make([]uint, 0, len(page.Messages))
+		for _, message := range page.Messages {
+			got = append(got, message.ID)
+		}
+		return got
+	}
+
+	// Opening any message of the thread shows the whole thread
+	page, err := service.Thread(bob.ID, replies[2], HistoryQuery{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Root.ID != root.ID || page.Root.ReplyCount != 5 {
+		t.Fatalf("root = %+v, want message %d with 5 replies", page.Root, root.ID)
+	}
+	if got := ids(page); fmt.Sprint(got) != fmt.Sprint(replies[3:]) || !page.HasMoreBefore || page.HasMoreAfter {
+		t.Fatalf("latest replies = %v (more %v/%v), want %v", got, page.HasMoreBefore, page.HasMoreAfter, replies[3:])
+	}
+
+	page, err = service.Thread(bob.ID, root.ID, HistoryQuery{Before: replies[3], Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(page); fmt.Sprint(got) != fmt.Sprint(replies[1:3]) || !page.HasMoreBefore || !page.HasMoreAfter {
+		t.Fatalf("replies before %d = %v (more %v/%v), want %v", replies[3], got, page.HasMoreBefore, page.HasMoreAfter, replies[1:3])
+	}
+
+	page, err = service.Thread(bob.ID, root.ID, HistoryQuery{After: replies[2], Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(page); fmt.Sprint(got) != fmt.Sprint(replies[3:]) || page.HasMoreAfter {
+		t.Fatalf("replies after %d = %v, want %v", replies[2], got, replies[3:])
+	}
+
+	// The root isn't one of its replies, so it can't be a cursor
+	if _, err := service.Thread(bob.ID, root.ID, HistoryQuery{Before: root.ID}); !errors.Is(err, ErrCursorNotFound) {
+		t.Fatalf("root as cursor: err = %v, want ErrCursorNotFound", err)
+	}
+	if _, err := service.Thread(carol.ID, root.ID, HistoryQuery{}); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("thread of another conversation: err = %v, want ErrMessageNotFound", err)
+	}
+}
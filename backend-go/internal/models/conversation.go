@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+)
+
+// ConversationType distinguishes direct chats from multi-user conversations
+type ConversationType string
+
+const (
+	ConversationDirect  ConversationType = "direct"
+	ConversationGroup   ConversationType = "group"
+	ConversationChannel ConversationType = "channel"
+)
+
+// MemberRole is what a member may do in a group or channel. Channel
+// subscribers are members; only admins and the owner post.
+type MemberRole string
+
+const (
+	RoleOwner  MemberRole = "owner"
+	RoleAdmin  MemberRole = "admin"
+	RoleMember MemberRole = "member"
+)
+
+// Conversation is a thread of messages between its members. A direct
+// conversation is identified by its DirectKey, "<lower user ID>:<higher user ID>".
+type Conversation struct {
+	ID            uint             `gorm:"primaryKey" json:"id"`
+	Type          ConversationType `gorm:"size:20;not null" json:"type"`
+	DirectKey     *string          `gorm:"size:41;uniqueIndex" json:"-"`
+	Title         string           `gorm:"size:100" json:"title,omitempty"`
+	CreatedBy     *uint            `json:"created_by,omitempty"`
+	InviteCode    *string          `gorm:"size:32;uniqueIndex" json:"invite_code,omitempty"`
+	Public        bool             `gorm:"not null;default:false" json:"public,omitempty"`
+	LastMessageID *uint            `json:"last_message_id,omitempty"`
+	LastMessageAt *time.Time       `gorm:"index" json:"last_message_at,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+
+	// Messages sent while this is set expire after that many seconds; 0 is off
+	DisappearingSeconds int `gorm:"not null;default:0" json:"disappearing_seconds,omitempty"`
+}
+
+func (Conversation) TableName() string {
+	return "conversation"
+}
+
+// ConversationMember is a user's membership in a conversation, with their
+// read position and inbox settings
+type ConversationMember struct {
+	ConversationID    uint       `gorm:"primaryKey" json:"conversation_id"`
+	UserID            uint       `gorm:"primaryKey;index" json:"user_id"`
+	Role              MemberRole `gorm:"size:20;not null;default:member" json:"role"`
+	UnreadCount       int        `gorm:"not null;default:0" json:"unread_count"`
+	LastReadMessageID *uint      `json:"last_read_message_id,omitempty"`
+	Muted             bool       `gorm:"not null;default:false" json:"muted"`
+	Archived          bool       `gorm:"not null;default:false" json:"archived"`
+	PinnedAt          *time.Time `json:"pinned_at,omitempty"`
+	// Set once the member sends a message, joins by themselves or creates
+	// the conversation; being added by someone else doesn't make a contact
+	Accepted  bool      `gorm:"not null;default:false" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (ConversationMember) TableName() string {
+	return "conversation_member"
+}
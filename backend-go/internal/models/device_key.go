@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+)
+
+// DeviceKey holds the public keys other users need to start an end-to-end
+// encrypted session with one device. A device is a login session; its
+// identity key never changes, while the signed prekey is rotated.
+type DeviceKey struct {
+	DeviceID    uint   `gorm:"primaryKey;autoIncrement:false" json:"device_id"`
+	UserID      uint   `gorm:"not null;index" json:"user_id"`
+	IdentityKey string `gorm:"size:64;not null" json:"identity_key"`
+
+	SignedPreKeyID        *uint32    `gorm:"column:signed_prekey_id" json:"signed_prekey_id,omitempty"`
+	SignedPreKey          string     `gorm:"column:signed_prekey;size:64" json:"signed_prekey,omitempty"`
+	SignedPreKeySignature string     `gorm:"column:signed_prekey_signature;size:128" json:"signed_prekey_signature,omitempty"`
+	SignedPreKeyUpdatedAt *time.Time `gorm:"column:signed_prekey_updated_at" json:"signed_prekey_updated_at,omitempty"`
+
+	// Low-prekey warning last sent: 0 none, 1 low, 2 out of one-time prekeys
+	PreKeyWarning int `gorm:"column:prekey_warning;not null;default:0" json:"-"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (DeviceKey) TableName() string {
+	return "device_key"
+}
+
+// OneTimePreKey is a prekey handed out to a single session initiator and
+// then deleted
+type OneTimePreKey struct {
+	DeviceID  uint      `gorm:"primaryKey;autoIncrement:false" json:"device_id"`
+	KeyID     uint32    `gorm:"primaryKey;autoIncrement:false" json:"key_id"`
+	PublicKey string    `gorm:"size:64;not null" json:"public_key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (OneTimePreKey) TableName() string {
+	return "one_time_prekey"
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// EmailTokenPurpose is what a mailed token authorizes
+type EmailTokenPurpose string
+
+const (
+	EmailTokenVerify        EmailTokenPurpose = "verify"
+	EmailTokenPasswordReset EmailTokenPurpose = "password_reset"
+)
+
+// EmailToken is a single-use link token sent by email. Only its hash is stored.
+type EmailToken struct {
+	ID        uint              `gorm:"primaryKey" json:"id"`
+	UserID    uint              `gorm:"not null;index" json:"user_id"`
+	Email     string            `gorm:"size:120;not null" json:"email"`
+	Purpose   EmailTokenPurpose `gorm:"size:20;not null" json:"purpose"`
+	TokenHash string            `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	ExpiresAt time.Time         `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time        `json:"used_at,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+func (EmailToken) TableName() string {
+	return "email_token"
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+)
+
+// UserEvent is a realtime event delivered to a user, numbered by a per-user
+// sequence so that devices can resume from the last event they acknowledged
+type UserEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_user_event_seq" json:"user_id"`
+	Seq       uint64    `gorm:"not null;uniqueIndex:idx_user_event_seq" json:"seq"`
+	Type      string    `gorm:"size:32;not null" json:"type"`
+	Payload   string    `gorm:"type:jsonb;not null" json:"payload"`
+	MessageID *uint     `json:"message_id,omitempty"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+func (UserEvent) TableName() string {
+	return "user_event"
+}
+
+// UserEventCursor holds the last sequence number issued to a user, and the
+// highest one the retention window has pruned
+type UserEventCursor struct {
+	UserID        uint   `gorm:"primaryKey" json:"user_id"`
+	LastSeq       uint64 `gorm:"not null;default:0" json:"last_seq"`
+	PrunedThrough uint64 `gorm:"not null;default:0" json:"pruned_through"`
+}
+
+func (UserEventCursor) TableName() string {
+	return "user_event_cursor"
+}
+
+// DeviceAck is the highest sequence number a device has acknowledged
+type DeviceAck struct {
+	UserID    uint      `gorm:"primaryKey" json:"user_id"`
+	DeviceID  string    `gorm:"primaryKey;size:64" json:"device_id"`
+	Seq       uint64    `gorm:"not null" json:"seq"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (DeviceAck) TableName() string {
+	return "device_ack"
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+// IdentityType is a login method that can be linked to an account
+type IdentityType string
+
+const (
+	IdentityWallet   IdentityType = "wallet"
+	IdentityEmail    IdentityType = "email"
+	IdentityPhone    IdentityType = "phone"
+	IdentityLinkedIn IdentityType = "linkedin"
+)
+
+// UserIdentity links a proven wallet, email, phone or LinkedIn account to a user.
+// Each identity belongs to exactly one user; a user has at most one primary
+// identity per type, which is mirrored into the matching User column.
+type UserIdentity struct {
+	ID         uint         `gorm:"primaryKey" json:"id"`
+	UserID     uint         `gorm:"not null;index" json:"user_id"`
+	Type       IdentityType `gorm:"size:20;not null;uniqueIndex:idx_user_identity_lookup" json:"type"`
+	Chain      string       `gorm:"size:64;not null;default:'';uniqueIndex:idx_user_identity_lookup" json:"chain,omitempty"`
+	Identifier string       `gorm:"size:255;not null;uniqueIndex:idx_user_identity_lookup" json:"identifier"`
+	IsPrimary  bool         `gorm:"default:false" json:"is_primary"`
+	VerifiedAt time.Time    `json:"verified_at"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+}
+
+func (UserIdentity) TableName() string {
+	return "user_identity"
+}
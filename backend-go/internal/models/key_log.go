@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+)
+
+// KeyLogEntry is one publication of a user's public key in the key
+// transparency log. Entries are only ever appended; Index is the leaf's
+// position in the Merkle tree.
+type KeyLogEntry struct {
+	Index     uint64 `gorm:"column:leaf_index;primaryKey;autoIncrement:false" json:"index"`
+	UserID    uint   `gorm:"not null;index" json:"user_id"`
+	PublicKey string `gorm:"type:text;not null" json:"public_key"`
+	// Milliseconds since the Unix epoch, as encoded in the leaf
+	Timestamp int64  `gorm:"not null" json:"timestamp"`
+	LeafHash  []byte `gorm:"type:bytea;not null" json:"-"`
+}
+
+func (KeyLogEntry) TableName() string {
+	return "key_log_entry"
+}
+
+// KeyLogNode is the hash of a complete subtree of the key log: the 2^Level
+// leaves starting at leaf NodeIndex<<Level. Proofs are built from these
+// rather than from every leaf.
+type KeyLogNode struct {
+	Level     uint   `gorm:"primaryKey;autoIncrement:false"`
+	NodeIndex uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Hash      []byte `gorm:"type:bytea;not null"`
+}
+
+func (KeyLogNode) TableName() string {
+	return "key_log_node"
+}
+
+// KeyLogHead is a signed tree head the log published, kept so that
+// consistency can be proven between any two of them
+type KeyLogHead struct {
+	TreeSize  uint64    `gorm:"primaryKey;autoIncrement:false" json:"tree_size"`
+	Timestamp int64     `gorm:"not null" json:"timestamp"`
+	RootHash  []byte    `gorm:"type:bytea;not null" json:"root_hash"`
+	Signature []byte    `gorm:"type:bytea;not null" json:"signature"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (KeyLogHead) TableName() string {
+	return "key_log_head"
+}
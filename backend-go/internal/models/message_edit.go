@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// MessageEdit is an earlier version of an edited message
+type MessageEdit struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	MessageID uint      `gorm:"not null;index" json:"message_id"`
+	Content   string    `gorm:"type:text;not null" json:"content"`
+	Encrypted bool      `gorm:"not null;default:false" json:"encrypted"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (MessageEdit) TableName() string {
+	return "message_edit"
+}
+
+// MessageHidden marks a message deleted for one user only
+type MessageHidden struct {
+	MessageID uint      `gorm:"primaryKey" json:"message_id"`
+	UserID    uint      `gorm:"primaryKey;index" json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (MessageHidden) TableName() string {
+	return "message_hidden"
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// MessageReaction is one user's emoji reaction to a message; a user can
+// react with several emoji but each only once
+type MessageReaction struct {
+	MessageID uint      `gorm:"primaryKey" json:"message_id"`
+	UserID    uint      `gorm:"primaryKey" json:"user_id"`
+	Emoji     string    `gorm:"primaryKey;size:64" json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (MessageReaction) TableName() string {
+	return "message_reaction"
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+)
+
+// MLSGroup binds a group conversation to the MLS group its members run,
+// and tracks the epoch the delivery service accepts messages for
+type MLSGroup struct {
+	ConversationID uint   `gorm:"primaryKey;autoIncrement:false" json:"conversation_id"`
+	GroupID        []byte `gorm:"type:bytea;not null;uniqueIndex" json:"group_id"`
+	CipherSuite    uint16 `gorm:"not null" json:"cipher_suite"`
+	Epoch          uint64 `gorm:"not null;default:0" json:"epoch"`
+	// Sequence number of the last message accepted for the group
+	LastSeq   uint64    `gorm:"not null;default:0" json:"last_seq"`
+	CreatedBy uint      `gorm:"not null" json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (MLSGroup) TableName() string {
+	return "mls_group"
+}
+
+// MLSMessage is a handshake, application or Welcome message relayed to a
+// group, in the order the delivery service accepted it. Data is the
+// TLS-encoded MLSMessage; the server only reads its framing header.
+type MLSMessage struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	ConversationID uint      `gorm:"not null;uniqueIndex:idx_mls_message_seq" json:"conversation_id"`
+	Seq            uint64    `gorm:"not null;uniqueIndex:idx_mls_message_seq" json:"seq"`
+	Epoch          uint64    `gorm:"not null" json:"epoch"`
+	ContentType    string    `gorm:"size:20;not null" json:"content_type"`
+	SenderID       uint      `gorm:"not null" json:"sender_id"`
+	Data           []byte    `gorm:"type:bytea;not null" json:"data"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (MLSMessage) TableName() string {
+	return "mls_message"
+}
+
+// MLSWelcomeRecipient is a user a Welcome message is addressed to; other
+// members never receive it
+type MLSWelcomeRecipient struct {
+	MessageID uint `gorm:"primaryKey;autoIncrement:false" json:"message_id"`
+	UserID    uint `gorm:"primaryKey;autoIncrement:false" json:"user_id"`
+}
+
+func (MLSWelcomeRecipient) TableName() string {
+	return "mls_welcome_recipient"
+}
+
+// MLSKeyPackage is a KeyPackage a device published so that group members
+// can add it; each one is handed out once
+type MLSKeyPackage struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"not null;index" json:"user_id"`
+	DeviceID    uint      `gorm:"not null;index" json:"device_id"`
+	CipherSuite uint16    `gorm:"not null" json:"cipher_suite"`
+	Data        []byte    `gorm:"type:bytea;not null" json:"data"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (MLSKeyPackage) TableName() string {
+	return "mls_key_package"
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+// DeliveryToken gates sealed-sender delivery to a user. The user shares the
+// token with their contacts inside encrypted messages; a sealed message is
+// only accepted with it. Only its hash is stored.
+type DeliveryToken struct {
+	UserID    uint      `gorm:"primaryKey;autoIncrement:false" json:"user_id"`
+	TokenHash []byte    `gorm:"type:bytea;not null" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (DeliveryToken) TableName() string {
+	return "delivery_token"
+}
+
+// SealedMessage is a 1:1 message sent in sealed-sender mode. The sender and
+// their delivery certificate are inside the encrypted content, so the row
+// records only the recipient. Rows are deleted when the recipient
+// acknowledges them, or after a retention window.
+type SealedMessage struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	RecipientID uint      `gorm:"not null;index" json:"recipient_id"`
+	Content     string    `gorm:"type:text;not null" json:"content"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (SealedMessage) TableName() string {
+	return "sealed_message"
+}
+
+// SealedMessageAck is how far one device of the recipient has acknowledged
+// their sealed messages
+type SealedMessageAck struct {
+	SessionID uint      `gorm:"primaryKey;autoIncrement:false" json:"session_id"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	ThroughID uint      `gorm:"not null" json:"through_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (SealedMessageAck) TableName() string {
+	return "sealed_message_ack"
+}
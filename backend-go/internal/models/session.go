@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is one signed-in device. Its refresh tokens rotate on every use.
+type Session struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	UserID          uint       `gorm:"not null;index" json:"user_id"`
+	Device          string     `gorm:"size:255" json:"device"`
+	IPAddress       string     `gorm:"size:45" json:"ip_address"`
+	AccessTokenID   string     `gorm:"size:36" json:"-"`
+	AccessExpiresAt time.Time  `json:"-"`
+	LastUsedAt      time.Time  `json:"last_used_at"`
+	ExpiresAt       time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+func (Session) TableName() string {
+	return "session"
+}
+
+// IsActive reports whether the session can still be refreshed
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+// RefreshToken stores the hash of an opaque refresh token.
+// Rotated tokens are kept so that reuse of a stolen token can be detected.
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	SessionID uint       `gorm:"not null;index" json:"session_id"`
+	TokenHash string     `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+
+	Session Session `gorm:"foreignKey:SessionID" json:"-"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_token"
+}
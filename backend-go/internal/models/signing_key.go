@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// SigningKey is one asymmetric key in the JWT keyring.
+// The key is published in the JWKS from creation until ExpiresAt,
+// and signs new tokens only between NotBefore and RetireAt.
+type SigningKey struct {
+	ID                  uint      `gorm:"primaryKey" json:"id"`
+	KID                 string    `gorm:"column:kid;uniqueIndex;size:64;not null" json:"kid"`
+	Algorithm           string    `gorm:"size:10;not null" json:"algorithm"`
+	PublicKey           []byte    `gorm:"not null" json:"-"`
+	PrivateKeyEncrypted []byte    `gorm:"not null" json:"-"`
+	NotBefore           time.Time `gorm:"not null" json:"not_before"`
+	RetireAt            time.Time `gorm:"not null" json:"retire_at"`
+	ExpiresAt           time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+func (SigningKey) TableName() string {
+	return "signing_key"
+}
@@ -8,37 +8,104 @@ import (
 
 type User struct {
 	ID              uint           `gorm:"primaryKey" json:"id"`
-	WalletAddress   string         `gorm:"uniqueIndex;size:42" json:"wallet_address"`
-	Email           string         `gorm:"uniqueIndex;size:120" json:"email"`
+	WalletAddress   string         `gorm:"uniqueIndex;size:42;default:null" json:"wallet_address"`
+	Email           string         `gorm:"uniqueIndex;size:120;default:null" json:"email"`
 	Username        string         `gorm:"size:80" json:"username"`
 	PasswordHash    string         `gorm:"size:255" json:"-"`
 	Name            string         `gorm:"size:100;not null" json:"name"`
 	Company         string         `gorm:"size:200" json:"company"`
 	Position        string         `gorm:"size:200" json:"position"`
 	LinkedInID      string         `gorm:"column:linkedin_id;size:100" json:"linkedin_id"`
-	PhoneNumber     string         `gorm:"uniqueIndex;size:20" json:"phone_number"`
+	PhoneNumber     string         `gorm:"uniqueIndex;size:20;default:null" json:"phone_number"`
 	IsEmailVerified bool           `json:"is_email_verified"`
 	IsPhoneVerified bool           `json:"is_phone_verified"`
 	PublicKey       string         `gorm:"type:text" json:"public_key"`
 	CreatedAt       time.Time      `json:"created_at"`
 	UpdatedAt       time.Time      `json:"updated_at"`
 	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// Password login lockout
+	FailedLoginAttempts int        `gorm:"default:0" json:"-"`
+	LockedUntil         *time.Time `json:"-"`
+
+	// Who can find the user in the directory and view their profile
+	Discoverability Discoverability `gorm:"size:20;not null;default:everyone" json:"discoverability"`
 }
 
 func (User) TableName() string {
 	return "user"
 }
 
+// Discoverability controls who finds a user by searching the directory
+type Discoverability string
+
+const (
+	DiscoverableByEveryone Discoverability = "everyone"
+	// Only users who share a direct or group conversation with them
+	DiscoverableByContacts Discoverability = "contacts"
+	DiscoverableByNobody   Discoverability = "nobody"
+)
+
+// PublicProfile is what other users see of a user; contact details such as
+// email and phone number are never included
+type PublicProfile struct {
+	ID            uint      `json:"id"`
+	Name          string    `json:"name"`
+	Username      string    `json:"username"`
+	Company       string    `json:"company"`
+	Position      string    `json:"position"`
+	WalletAddress string    `json:"wallet_address,omitempty"`
+	PublicKey     string    `json:"public_key,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// UserSummary is the lightweight view of a user embedded in lists
+type UserSummary struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+}
+
 type Message struct {
 	ID         uint      `gorm:"primaryKey" json:"id"`
 	SenderID   uint      `gorm:"not null;index" json:"sender_id"`
-	ReceiverID uint      `gorm:"not null;index" json:"receiver_id"`
+	ReceiverID uint      `gorm:"index;default:null" json:"receiver_id,omitempty"`
 	Content    string    `gorm:"type:text;not null" json:"content"`
 	Encrypted  bool      `gorm:"default:false" json:"encrypted"`
 	Read       bool      `gorm:"default:false" json:"read"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
 
+	ConversationID *uint `gorm:"index" json:"conversation_id,omitempty"`
+
+	// Client-generated UUID; a retried send with the same ID returns the original message
+	ClientID    *string       `gorm:"size:36" json:"client_id,omitempty"`
+	Status      MessageStatus `gorm:"size:20;not null;default:stored" json:"status"`
+	DeliveredAt *time.Time    `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time    `json:"read_at,omitempty"`
+
+	// Set when the content was last edited; earlier versions are in message_edit
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// Set when the sender deleted the message for everyone; the content is cleared
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// The message this one quotes, and the first message of its thread
+	ReplyToID    *uint    `gorm:"index" json:"reply_to_id,omitempty"`
+	ThreadRootID *uint    `gorm:"index" json:"thread_root_id,omitempty"`
+	ReplyTo      *Message `gorm:"foreignKey:ReplyToID" json:"reply_to,omitempty"`
+	// On thread roots, the number of replies in the thread
+	ReplyCount int `gorm:"not null;default:0" json:"reply_count,omitempty"`
+
+	// Channel posts count each subscriber once, when their read position passes the post
+	ViewCount int `gorm:"not null;default:0" json:"view_count,omitempty"`
+
+	// Set on messages sent while the conversation has a disappearing timer;
+	// the reaper deletes the row once it passes
+	ExpiresAt *time.Time `gorm:"index" json:"expires_at,omitempty"`
+	// Written by the server to announce a change to the conversation, such
+	// as a new disappearing timer; Content describes the change as JSON
+	System bool `gorm:"not null;default:false" json:"system,omitempty"`
+
 	Sender   User `gorm:"foreignKey:SenderID" json:"sender,omitempty"`
 	Receiver User `gorm:"foreignKey:ReceiverID" json:"receiver,omitempty"`
 }
@@ -47,6 +114,18 @@ func (Message) TableName() string {
 	return "message"
 }
 
+// MessageStatus is the delivery state of a message. Clients hold a message
+// as pending until the server confirms it is stored; after that it only
+// moves forward to delivered and read.
+type MessageStatus string
+
+const (
+	MessagePending   MessageStatus = "pending"
+	MessageStored    MessageStatus = "stored"
+	MessageDelivered MessageStatus = "delivered"
+	MessageRead      MessageStatus = "read"
+)
+
 type Project struct {
 	ID          uint      `gorm:"primaryKey" json:"id"`
 	UserID      uint      `gorm:"not null;index" json:"user_id"`
@@ -0,0 +1,36 @@
+package sms
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+)
+
+// SMSSender delivers text messages to phone numbers in E.164 format
+type SMSSender interface {
+	Send(to, body string) error
+}
+
+// New returns the SMS sender selected by SMS_DRIVER
+func New(cfg *config.SMSConfig) (SMSSender, error) {
+	switch cfg.Driver {
+	case "log", "":
+		return NewLogSender(), nil
+	default:
+		return nil, fmt.Errorf("unknown SMS driver: %s", cfg.Driver)
+	}
+}
+
+// LogSender writes messages to the log instead of sending them.
+// It is meant for development and tests.
+type LogSender struct{}
+
+func NewLogSender() *LogSender {
+	return &LogSender{}
+}
+
+func (s *LogSender) Send(to, body string) error {
+	log.Printf("📱 SMS to=%s: %s", to, body)
+	return nil
+}
@@ -0,0 +1,60 @@
+package testutil
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// NewDB connects to the PostgreSQL database in TEST_DATABASE_URL, which
+// must have every migration applied, and skips the test when it is unset.
+// The test runs inside a transaction that is rolled back when it ends;
+// services nest their own transactions in it as savepoints.
+func NewDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+		NowFunc: func() time.Time {
+			return time.Now().UTC()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	t.Cleanup(func() {
+		tx.Rollback()
+		sqlDB.Close()
+	})
+	return tx
+}
+
+// NewUser stores a user with the given name
+func NewUser(t *testing.T, db *gorm.DB, name string) *models.User {
+	t.Helper()
+
+	user := &models.User{Name: name}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
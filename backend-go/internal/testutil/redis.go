@@ -0,0 +1,30 @@
+// Package testutil holds helpers shared by the package tests.
+package testutil
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/pkg/utils"
+)
+
+// NewRedis starts an in-memory Redis server for the test and returns a
+// client connected to it, along with the server for inspecting keys and
+// moving its clock
+func NewRedis(t *testing.T) (*utils.RedisClient, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	port, err := strconv.Atoi(server.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := utils.NewRedisClient(&config.RedisConfig{Host: server.Host(), Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, server
+}
@@ -0,0 +1,270 @@
+package transparency
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Serializes appends across API replicas
+const keyLogAdvisoryLock = 4361002
+
+var (
+	ErrUserNotFound      = errors.New("user not found")
+	ErrKeyNotPublished   = errors.New("user has not published a public key")
+	ErrTreeHeadNotFound  = errors.New("no tree head was published at that size")
+	ErrInvalidTreeSize   = errors.New("tree sizes must satisfy 0 < first <= second <= current size")
+	ErrInvalidSigningKey = errors.New("KT_SIGNING_KEY must be a base64 Ed25519 seed")
+)
+
+// KeyInclusion proves that a user's current key is in the log at the
+// given tree head
+type KeyInclusion struct {
+	Entry    models.KeyLogEntry `json:"entry"`
+	TreeHead *TreeHead          `json:"tree_head"`
+	Proof    [][]byte           `json:"proof"`
+}
+
+// KeyLog is an append-only Merkle log (RFC 6962) of every public key users
+// publish. Each append publishes a signed tree head; clients check that the
+// key they were given is included, and auditors check that successive tree
+// heads are consistent, so the server cannot show different users
+// different keys without it being detectable.
+type KeyLog struct {
+	db  *gorm.DB
+	key ed25519.PrivateKey
+}
+
+func NewKeyLog(db *gorm.DB, key ed25519.PrivateKey) *KeyLog {
+	return &KeyLog{db: db, key: key}
+}
+
+// SigningKey returns the configured log key. It is required: auditors pin
+// it, so it must not change when another secret is rotated.
+func SigningKey(cfg *config.Config) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(cfg.Transparency.SigningKey)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrInvalidSigningKey
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// PublicKey is the key tree head signatures verify against
+func (l *KeyLog) PublicKey() ed25519.PublicKey {
+	return l.key.Public().(ed25519.PublicKey)
+}
+
+// Publish sets the user's public key and appends it to the log.
+// Republishing the current key does not add an entry.
+func (l *KeyLog) Publish(userID uint, publicKey string) (*models.KeyLogEntry, error) {
+	if len(publicKey) > MaxPublicKeyLength {
+		return nil, ErrPublicKeyTooLong
+	}
+
+	var entry *models.KeyLogEntry
+	err := l.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", keyLogAdvisoryLock).Error; err != nil {
+			return err
+		}
+
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "public_key").Take(&user, userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+
+		var latest models.KeyLogEntry
+		err := tx.Where("user_id = ?", userID).Order("leaf_index DESC").Take(&latest).Error
+		if err == nil && latest.PublicKey == publicKey && user.PublicKey == publicKey {
+			entry = &latest
+			return nil
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if err := tx.Model(&user).Update("public_key", publicKey).Error; err != nil {
+			return err
+		}
+		entry, err = l.append(tx, userID, publicKey)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// append adds a leaf and publishes the tree head that includes it. The
+// caller holds the advisory lock.
+func (l *KeyLog) append(tx *gorm.DB, userID uint, publicKey string) (*models.KeyLogEntry, error) {
+	var size uint64
+	if err := tx.Model(&models.KeyLogEntry{}).Select("COALESCE(MAX(leaf_index) + 1, 0)").Scan(&size).Error; err != nil {
+		return nil, err
+	}
+
+	timestamp := time.Now().UnixMilli()
+	entry := &models.KeyLogEntry{
+		Index:     size,
+		UserID:    userID,
+		PublicKey: publicKey,
+		Timestamp: timestamp,
+		LeafHash:  LeafHash(EncodeLeaf(userID, publicKey, timestamp)),
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return nil, fmt.Errorf("failed to append key log entry: %w", err)
+	}
+
+	var completed [][]byte
+	err := loadSubtrees(tx, func(subtrees subtreeHash) {
+		completed = completedSubtrees(subtrees, size, entry.LeafHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]models.KeyLogNode, len(completed))
+	for level, hash := range completed {
+		nodes[level] = models.KeyLogNode{Level: uint(level), NodeIndex: size >> level, Hash: hash}
+	}
+	if err := tx.Create(&nodes).Error; err != nil {
+		return nil, fmt.Errorf("failed to store key log subtrees: %w", err)
+	}
+
+	head := &TreeHead{TreeSize: size + 1, Timestamp: timestamp}
+	err = loadSubtrees(tx, func(subtrees subtreeHash) {
+		head.RootHash = rangeRoot(subtrees, 0, size+1)
+	})
+	if err != nil {
+		return nil, err
+	}
+	head.Sign(l.key)
+	row := models.KeyLogHead{TreeSize: head.TreeSize, Timestamp: head.Timestamp, RootHash: head.RootHash, Signature: head.Signature}
+	if err := tx.Create(&row).Error; err != nil {
+		return nil, fmt.Errorf("failed to publish tree head: %w", err)
+	}
+	return entry, nil
+}
+
+// Latest returns the newest tree head, or a signed head of the empty tree
+// when nothing has been logged yet
+func (l *KeyLog) Latest() (*TreeHead, error) {
+	var row models.KeyLogHead
+	err := l.db.Order("tree_size DESC").Take(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		head := &TreeHead{Timestamp: time.Now().UnixMilli(), RootHash: RootHash(nil)}
+		head.Sign(l.key)
+		return head, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return treeHead(&row), nil
+}
+
+// TreeHeadAt returns the tree head published when the log reached size
+func (l *KeyLog) TreeHeadAt(size uint64) (*TreeHead, error) {
+	var row models.KeyLogHead
+	err := l.db.Where("tree_size = ?", size).Take(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTreeHeadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return treeHead(&row), nil
+}
+
+// Consistency proves that the tree of size first is a prefix of the tree of
+// size second
+func (l *KeyLog) Consistency(first, second uint64) ([][]byte, error) {
+	latest, err := l.Latest()
+	if err != nil {
+		return nil, err
+	}
+	if first == 0 || first > second || second > latest.TreeSize {
+		return nil, ErrInvalidTreeSize
+	}
+
+	var proof [][]byte
+	err = loadSubtrees(l.db, func(subtrees subtreeHash) {
+		proof = rangeConsistency(subtrees, 0, second, first, true)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// Inclusion proves that the user's current key is in the latest tree
+func (l *KeyLog) Inclusion(userID uint) (*KeyInclusion, error) {
+	head, err := l.Latest()
+	if err != nil {
+		return nil, err
+	}
+
+	var entry models.KeyLogEntry
+	err = l.db.Where("user_id = ? AND leaf_index < ?", userID, head.TreeSize).Order("leaf_index DESC").Take(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrKeyNotPublished
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var proof [][]byte
+	err = loadSubtrees(l.db, func(subtrees subtreeHash) {
+		proof = rangeInclusion(subtrees, 0, head.TreeSize, entry.Index)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &KeyInclusion{Entry: entry, TreeHead: head, Proof: proof}, nil
+}
+
+// loadSubtrees runs compute with the stored subtree hashes it reads, loaded
+// in one query. compute runs twice, first to learn which subtrees it reads,
+// so it must not have other effects.
+func loadSubtrees(db *gorm.DB, compute func(subtreeHash)) error {
+	var keys [][]interface{}
+	placeholder := make([]byte, sha256.Size)
+	compute(func(level uint, index uint64) []byte {
+		keys = append(keys, []interface{}{level, index})
+		return placeholder
+	})
+
+	var nodes []models.KeyLogNode
+	if len(keys) > 0 {
+		if err := db.Where("(level, node_index) IN ?", keys).Find(&nodes).Error; err != nil {
+			return err
+		}
+	}
+	hashes := make(map[[2]uint64][]byte, len(nodes))
+	for _, node := range nodes {
+		hashes[[2]uint64{uint64(node.Level), node.NodeIndex}] = node.Hash
+	}
+
+	missing := false
+	compute(func(level uint, index uint64) []byte {
+		hash, ok := hashes[[2]uint64{uint64(level), index}]
+		missing = missing || !ok
+		return hash
+	})
+	if missing {
+		return errors.New("key log is missing subtree hashes")
+	}
+	return nil
+}
+
+func treeHead(row *models.KeyLogHead) *TreeHead {
+	return &TreeHead{TreeSize: row.TreeSize, Timestamp: row.Timestamp, RootHash: row.RootHash, Signature: row.Signature}
+}
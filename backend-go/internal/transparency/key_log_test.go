@@ -0,0 +1,89 @@
+package transparency
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+)
+
+func TestSigningKey(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	seed[0] = 1
+	cfg := &config.Config{}
+
+	cfg.Transparency.SigningKey = base64.StdEncoding.EncodeToString(seed)
+	key, err := SigningKey(cfg)
+	if err != nil || !key.Equal(ed25519.NewKeyFromSeed(seed)) {
+		t.Fatalf("configured key: err = %v", err)
+	}
+
+	for _, value := range []string{"", "not base64", base64.StdEncoding.EncodeToString(seed[:16])} {
+		cfg.Transparency.SigningKey = value
+		if _, err := SigningKey(cfg); !errors.Is(err, ErrInvalidSigningKey) {
+			t.Fatalf("KT_SIGNING_KEY %q: err = %v, want ErrInvalidSigningKey", value, err)
+		}
+	}
+}
+
+func TestKeyLogProofs(t *testing.T) {
+	db := testutil.NewDB(t)
+	_, key, _ := ed25519.GenerateKey(nil)
+	keyLog := NewKeyLog(db, key)
+
+	alice := testutil.NewUser(t, db, "alice")
+	bob := testutil.NewUser(t, db, "bob")
+	var heads []*TreeHead
+	for i := 0; i < 6; i++ {
+		for _, userID := range []uint{alice.ID, bob.ID} {
+			if _, err := keyLog.Publish(userID, fmt.Sprintf("key %d of %d", i, userID)); err != nil {
+				t.Fatal(err)
+			}
+			head, err := keyLog.Latest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !head.Verify(keyLog.PublicKey()) {
+				t.Fatalf("tree head at size %d has an invalid signature", head.TreeSize)
+			}
+			heads = append(heads, head)
+		}
+	}
+
+	// Republishing the current key does not grow the log
+	if _, err := keyLog.Publish(bob.ID, fmt.Sprintf("key 5 of %d", bob.ID)); err != nil {
+		t.Fatal(err)
+	}
+	latest, _ := keyLog.Latest()
+	if latest.TreeSize != uint64(len(heads)) {
+		t.Fatalf("tree size = %d, want %d", latest.TreeSize, len(heads))
+	}
+
+	inclusion, err := keyLog.Inclusion(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inclusion.Entry.PublicKey != fmt.Sprintf("key 5 of %d", alice.ID) ||
+		!VerifyInclusion(inclusion.Entry.Index, latest.TreeSize, inclusion.Entry.LeafHash, inclusion.Proof, latest.RootHash) {
+		t.Fatalf("inclusion proof of %+v does not verify", inclusion.Entry)
+	}
+
+	for _, first := range heads {
+		for _, second := range heads[first.TreeSize-1:] {
+			proof, err := keyLog.Consistency(first.TreeSize, second.TreeSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyConsistency(first.TreeSize, second.TreeSize, first.RootHash, second.RootHash, proof) {
+				t.Fatalf("%d to %d: consistency proof does not verify", first.TreeSize, second.TreeSize)
+			}
+		}
+	}
+	if _, err := keyLog.Consistency(0, latest.TreeSize); !errors.Is(err, ErrInvalidTreeSize) {
+		t.Fatalf("consistency from the empty tree: err = %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/bits"
+)
+
+// Merkle tree hashing as specified by RFC 6962 section 2.1. Leaves and
+// interior nodes are hashed with different prefixes so that a leaf can never
+// be passed off as a subtree.
+const (
+	leafHashPrefix = 0x00
+	nodeHashPrefix = 0x01
+)
+
+// LeafHash is the hash of one log entry's encoded leaf
+func LeafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafHashPrefix})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodeHashPrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// RootHash is the Merkle tree hash of a list of leaf hashes
+func RootHash(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(len(leaves))
+	return nodeHash(RootHash(leaves[:k]), RootHash(leaves[k:]))
+}
+
+// InclusionProof is the audit path of leaf index in the tree of the given
+// leaves, or nil when index is out of range
+func InclusionProof(leaves [][]byte, index int) [][]byte {
+	if index < 0 || index >= len(leaves) {
+		return nil
+	}
+	return rangeInclusion(leafSubtrees(leaves), 0, uint64(len(leaves)), uint64(index))
+}
+
+// ConsistencyProof proves that the tree of the first size leaves is a prefix
+// of the tree of all the leaves, or is nil when size is out of range. The
+// empty tree is a prefix of every tree and needs no proof.
+func ConsistencyProof(leaves [][]byte, size int) [][]byte {
+	switch {
+	case size < 0 || size > len(leaves):
+		return nil
+	case size == 0:
+		return [][]byte{}
+	}
+	return rangeConsistency(leafSubtrees(leaves), 0, uint64(len(leaves)), uint64(size), true)
+}
+
+// subtreeHash returns the hash of the complete subtree of 2^level leaves
+// that starts at leaf index<<level. The proofs below only ever read such
+// subtrees, so a log that stores them builds a proof from O(log² n) hashes
+// instead of every leaf.
+type subtreeHash func(level uint, index uint64) []byte
+
+// leafSubtrees hashes complete subtrees from the leaf hashes themselves
+func leafSubtrees(leaves [][]byte) subtreeHash {
+	return func(level uint, index uint64) []byte {
+		return RootHash(leaves[index<<level : (index+1)<<level])
+	}
+}
+
+// completedSubtrees returns the hashes of the complete subtrees that
+// appending the leaf at index completes, the leaf itself first. Entry i is
+// the subtree at level i; the smaller ones it joins are read from subtrees.
+func completedSubtrees(subtrees subtreeHash, index uint64, leafHash []byte) [][]byte {
+	hashes := [][]byte{leafHash}
+	for level := uint(0); index>>level&1 == 1; level++ {
+		hashes = append(hashes, nodeHash(subtrees(level, index>>level-1), hashes[level]))
+	}
+	return hashes
+}
+
+// rangeRoot is the Merkle tree hash of leaves [lo, hi). Every range the
+// RFC 6962 recursion visits starts at a multiple of the smallest power of
+// two at least as large as the range, so its left halves are complete
+// subtrees.
+func rangeRoot(subtrees subtreeHash, lo, hi uint64) []byte {
+	n := hi - lo
+	if n&(n-1) == 0 {
+		level := uint(bits.TrailingZeros64(n))
+		return subtrees(level, lo>>level)
+	}
+	k := uint64(splitPoint(int(n)))
+	return nodeHash(rangeRoot(subtrees, lo, lo+k), rangeRoot(subtrees, lo+k, hi))
+}
+
+// rangeInclusion is PATH(index, D[lo:hi]) of RFC 6962 section 2.1.1 with
+// absolute leaf indexes
+func rangeInclusion(subtrees subtreeHash, lo, hi, index uint64) [][]byte {
+	if hi-lo <= 1 {
+		return [][]byte{}
+	}
+	k := uint64(splitPoint(int(hi - lo)))
+	if index < lo+k {
+		return append(rangeInclusion(subtrees, lo, lo+k, index), rangeRoot(subtrees, lo+k, hi))
+	}
+	return append(rangeInclusion(subtrees, lo+k, hi, index), rangeRoot(subtrees, lo, lo+k))
+}
+
+// rangeConsistency is SUBPROOF(size, D[lo:hi], complete) of RFC 6962
+// section 2.1.2 with absolute leaf indexes, for lo < size <= hi
+func rangeConsistency(subtrees subtreeHash, lo, hi, size uint64, complete bool) [][]byte {
+	if size == hi {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{rangeRoot(subtrees, lo, hi)}
+	}
+	k := uint64(splitPoint(int(hi - lo)))
+	if size <= lo+k {
+		return append(rangeConsistency(subtrees, lo, lo+k, size, complete), rangeRoot(subtrees, lo+k, hi))
+	}
+	return append(rangeConsistency(subtrees, lo+k, hi, size, false), rangeRoot(subtrees, lo, lo+k))
+}
+
+// splitPoint is the largest power of two smaller than n, for n > 1
+func splitPoint(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
+
+// VerifyInclusion checks an audit path for the leaf at index in a tree of
+// the given size, as in RFC 9162 section 2.1.3.2
+func VerifyInclusion(index, size uint64, leafHash []byte, proof [][]byte, root []byte) bool {
+	if index >= size {
+		return false
+	}
+	fn, sn := index, size-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
+
+// VerifyConsistency checks that the tree of size second with root
+// secondRoot extends the tree of size first with root firstRoot, as in
+// RFC 9162 section 2.1.4.2
+func VerifyConsistency(first, second uint64, firstRoot, secondRoot []byte, proof [][]byte) bool {
+	switch {
+	case first > second:
+		return false
+	case first == second:
+		return len(proof) == 0 && bytes.Equal(firstRoot, secondRoot)
+	case first == 0:
+		// The empty tree is a prefix of every tree
+		return len(proof) == 0
+	case len(proof) == 0:
+		return false
+	}
+
+	// A first tree that is a complete subtree is its own starting node
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, firstRoot) && bytes.Equal(sr, secondRoot)
+}
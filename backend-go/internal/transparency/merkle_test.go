@@ -0,0 +1,162 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = LeafHash([]byte(fmt.Sprintf("leaf %d", i)))
+	}
+	return leaves
+}
+
+func TestRootHashSmallTrees(t *testing.T) {
+	empty := sha256.Sum256(nil)
+	if !bytes.Equal(RootHash(nil), empty[:]) {
+		t.Fatal("empty tree should hash to SHA-256 of nothing")
+	}
+
+	leaves := testLeaves(3)
+	if !bytes.Equal(RootHash(leaves[:1]), leaves[0]) {
+		t.Fatal("a single leaf should be its own root")
+	}
+	want := nodeHash(nodeHash(leaves[0], leaves[1]), leaves[2])
+	if !bytes.Equal(RootHash(leaves), want) {
+		t.Fatalf("root of 3 leaves = %x, want %x", RootHash(leaves), want)
+	}
+}
+
+func TestLeafHashDomainSeparation(t *testing.T) {
+	// An empty leaf hashes to SHA-256 of the leaf prefix alone
+	want := "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d"
+	if got := hex.EncodeToString(LeafHash(nil)); got != want {
+		t.Fatalf("LeafHash(nil) = %s, want %s", got, want)
+	}
+}
+
+func TestInclusionProofs(t *testing.T) {
+	leaves := testLeaves(33)
+	for size := 1; size <= len(leaves); size++ {
+		tree := leaves[:size]
+		root := RootHash(tree)
+		for index := 0; index < size; index++ {
+			proof := InclusionProof(tree, index)
+			if !VerifyInclusion(uint64(index), uint64(size), tree[index], proof, root) {
+				t.Fatalf("leaf %d of %d: proof does not verify", index, size)
+			}
+			if VerifyInclusion(uint64(index), uint64(size), leaves[(index+1)%len(leaves)], proof, root) {
+				t.Fatalf("leaf %d of %d: proof verifies another leaf", index, size)
+			}
+			if size > 1 && VerifyInclusion(uint64(index), uint64(size), tree[index], proof[:len(proof)-1], root) {
+				t.Fatalf("leaf %d of %d: truncated proof verifies", index, size)
+			}
+		}
+	}
+}
+
+func TestInclusionProofOutOfRange(t *testing.T) {
+	leaves := testLeaves(4)
+	for _, index := range []int{-1, 4} {
+		if proof := InclusionProof(leaves, index); proof != nil {
+			t.Fatalf("index %d: proof = %x, want nil", index, proof)
+		}
+	}
+	if VerifyInclusion(4, 4, leaves[0], nil, RootHash(leaves)) {
+		t.Fatal("verified a leaf past the end of the tree")
+	}
+}
+
+func TestConsistencyProofs(t *testing.T) {
+	leaves := testLeaves(33)
+	for second := 1; second <= len(leaves); second++ {
+		tree := leaves[:second]
+		secondRoot := RootHash(tree)
+		for first := 0; first <= second; first++ {
+			firstRoot := RootHash(leaves[:first])
+			proof := ConsistencyProof(tree, first)
+			if !VerifyConsistency(uint64(first), uint64(second), firstRoot, secondRoot, proof) {
+				t.Fatalf("%d to %d: proof does not verify", first, second)
+			}
+			if first > 0 && first < second {
+				forged := RootHash(append(append([][]byte{}, leaves[:first-1]...), LeafHash([]byte("forged"))))
+				if VerifyConsistency(uint64(first), uint64(second), forged, secondRoot, proof) {
+					t.Fatalf("%d to %d: proof verifies a rewritten first tree", first, second)
+				}
+			}
+		}
+	}
+}
+
+func TestConsistencyProofOutOfRange(t *testing.T) {
+	leaves := testLeaves(5)
+	if proof := ConsistencyProof(leaves, 0); proof == nil || len(proof) != 0 {
+		t.Fatalf("empty first tree: proof = %x, want an empty proof", proof)
+	}
+	for _, size := range []int{-1, 6} {
+		if proof := ConsistencyProof(leaves, size); proof != nil {
+			t.Fatalf("size %d: proof = %x, want nil", size, proof)
+		}
+	}
+	if VerifyConsistency(6, 5, RootHash(leaves), RootHash(leaves), nil) {
+		t.Fatal("verified a tree that shrank")
+	}
+}
+
+// The key log appends one leaf at a time, storing the subtrees each leaf
+// completes, and builds its roots and proofs from those alone
+func TestStoredSubtrees(t *testing.T) {
+	leaves := testLeaves(40)
+	stored := map[[2]uint64][]byte{}
+	subtrees := func(level uint, index uint64) []byte {
+		hash, ok := stored[[2]uint64{uint64(level), index}]
+		if !ok {
+			t.Fatalf("read subtree %d at level %d, which is not stored", index, level)
+		}
+		return hash
+	}
+
+	for i, leaf := range leaves {
+		index := uint64(i)
+		for level, hash := range completedSubtrees(subtrees, index, leaf) {
+			stored[[2]uint64{uint64(level), index >> level}] = hash
+		}
+		size := index + 1
+
+		if got, want := rangeRoot(subtrees, 0, size), RootHash(leaves[:size]); !bytes.Equal(got, want) {
+			t.Fatalf("size %d: root = %x, want %x", size, got, want)
+		}
+		for j := uint64(0); j < size; j++ {
+			got := rangeInclusion(subtrees, 0, size, j)
+			if want := InclusionProof(leaves[:size], int(j)); !equalProofs(got, want) {
+				t.Fatalf("size %d: inclusion proof of %d differs", size, j)
+			}
+			got = rangeConsistency(subtrees, 0, size, j+1, true)
+			if want := ConsistencyProof(leaves[:size], int(j+1)); !equalProofs(got, want) {
+				t.Fatalf("size %d: consistency proof from %d differs", size, j+1)
+			}
+		}
+	}
+
+	// Each leaf is stored once per level it completes: fewer than two nodes per leaf
+	if len(stored) >= 2*len(leaves) {
+		t.Fatalf("stored %d subtrees for %d leaves", len(stored), len(leaves))
+	}
+}
+
+func equalProofs(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
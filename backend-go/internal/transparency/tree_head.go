@@ -0,0 +1,61 @@
+package transparency
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	leafVersion = 0
+
+	// TreeHeadSignature fields of RFC 6962 section 3.5
+	treeHeadVersion       = 0
+	treeHeadSignatureType = 1
+)
+
+// MaxPublicKeyLength bounds the keys users publish, which are logged verbatim
+const MaxPublicKeyLength = 1024
+
+var ErrPublicKeyTooLong = errors.New("public key is too long")
+
+// TreeHead is a signed commitment to the log's contents at one size.
+// Binary fields are base64 in JSON.
+type TreeHead struct {
+	TreeSize uint64 `json:"tree_size"`
+	// Milliseconds since the Unix epoch
+	Timestamp int64  `json:"timestamp"`
+	RootHash  []byte `json:"root_hash"`
+	Signature []byte `json:"signature"`
+}
+
+// signedData is what the log key signs for a tree head
+func (h *TreeHead) signedData() []byte {
+	data := make([]byte, 0, 2+8+8+len(h.RootHash))
+	data = append(data, treeHeadVersion, treeHeadSignatureType)
+	data = binary.BigEndian.AppendUint64(data, uint64(h.Timestamp))
+	data = binary.BigEndian.AppendUint64(data, h.TreeSize)
+	return append(data, h.RootHash...)
+}
+
+// Sign signs the tree head with the log's private key
+func (h *TreeHead) Sign(key ed25519.PrivateKey) {
+	h.Signature = ed25519.Sign(key, h.signedData())
+}
+
+// Verify checks the tree head's signature against the log's public key
+func (h *TreeHead) Verify(key ed25519.PublicKey) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, h.signedData(), h.Signature)
+}
+
+// EncodeLeaf is the leaf logged when a user publishes a public key: a
+// version byte, the publication time in milliseconds and the user ID as
+// big-endian uint64s, then the key with a two-byte length prefix
+func EncodeLeaf(userID uint, publicKey string, timestamp int64) []byte {
+	leaf := make([]byte, 0, 1+8+8+2+len(publicKey))
+	leaf = append(leaf, leafVersion)
+	leaf = binary.BigEndian.AppendUint64(leaf, uint64(timestamp))
+	leaf = binary.BigEndian.AppendUint64(leaf, uint64(userID))
+	leaf = binary.BigEndian.AppendUint16(leaf, uint16(len(publicKey)))
+	return append(leaf, publicKey...)
+}
@@ -0,0 +1,223 @@
+package websocket
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/messaging"
+	"github.com/everest-an/dchat-backend/pkg/utils"
+	"gorm.io/gorm"
+)
+
+// changeEvent describes an edited or deleted message to the devices of its participants
+func changeEvent(eventType string, actorID uint, change *messaging.MessageChange) *Message {
+	event := &Message{
+		Type:        eventType,
+		From:        actorID,
+		MessageID:   change.Message.ID,
+		Content:     change.Message.Content,
+		Encrypted:   change.Message.Encrypted,
+		ForEveryone: change.ForEveryone,
+		Timestamp:   time.Now(),
+		Data:        change.Message,
+	}
+	if change.Message.ConversationID != nil {
+		event.ConversationID = *change.Message.ConversationID
+	}
+	return event
+}
+
+// reactionEvent describes a reaction added or removed, with the message's new
+// counts. Channel subscribers can't see each other, so reactions to channel
+// posts don't say who reacted.
+func reactionEvent(change *messaging.ReactionChange) *Message {
+	data := map[string]interface{}{
+		"added":     change.Added,
+		"reactions": change.Reactions,
+	}
+	event := &Message{
+		Type:      "reaction",
+		MessageID: change.Message.ID,
+		Emoji:     change.Emoji,
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+	if change.ChannelID == 0 {
+		event.From = change.UserID
+		data["user_id"] = change.UserID
+	}
+	if change.Message.ConversationID != nil {
+		event.ConversationID = *change.Message.ConversationID
+	}
+	return event
+}
+
+// handleEdit replaces the content of one of the client's messages
+func (h *Hub) handleEdit(client *Client, msg *Message) {
+	change, err := h.messages.Edit(client.UserID, msg.MessageID, msg.Content, msg.Encrypted)
+	if err != nil {
+		h.sendChangeError(client, msg, err, "Failed to edit message")
+		return
+	}
+	h.messageChanged(changeEvent("edit", client.UserID, change), change)
+}
+
+// handleDelete deletes a message for everyone or only for the client's user
+func (h *Hub) handleDelete(client *Client, msg *Message) {
+	change, err := h.messages.Delete(client.UserID, msg.MessageID, msg.ForEveryone)
+	if err != nil {
+		h.sendChangeError(client, msg, err, "Failed to delete message")
+		return
+	}
+	h.messageChanged(changeEvent("delete", client.UserID, change), change)
+}
+
+// handleReaction adds or removes one of the client's reactions
+func (h *Hub) handleReaction(client *Client, msg *Message, add bool) {
+	var change *messaging.ReactionChange
+	var err error
+	if add {
+		change, err = h.messages.React(client.UserID, msg.MessageID, msg.Emoji)
+	} else {
+		change, err = h.messages.Unreact(client.UserID, msg.MessageID, msg.Emoji)
+	}
+	if err != nil {
+		h.sendChangeError(client, msg, err, "Failed to update reaction")
+		return
+	}
+	h.messageChanged(reactionEvent(change), &change.MessageChange)
+}
+
+// messageChanged records the change in the event log of every participant,
+// so devices that are offline pick it up when they resume, and delivers it
+// to the devices that are online, including the one that made the change.
+// Channel posts reach online subscribers only; the rest see the change in
+// the channel history.
+func (h *Hub) messageChanged(event *Message, change *messaging.MessageChange) {
+	if change.ChannelID != 0 {
+		h.deliverChannel(change.ChannelID, "", event)
+		if err := h.cluster.PublishToChannel(change.ChannelID, event, ""); err != nil {
+			log.Printf("Failed to publish channel change: %v", err)
+		}
+		return
+	}
+
+	for _, userID := range change.Recipients {
+		if _, err := h.sendEvent(userID, event, nil); err != nil {
+			log.Printf("Failed to record %s event: %v", event.Type, err)
+		}
+	}
+}
+
+func (h *Hub) sendChangeError(client *Client, msg *Message, err error, fallback string) {
+	reason := fallback
+	if errors.Is(err, messaging.ErrMessageNotFound) || errors.Is(err, messaging.ErrNotMessageSender) ||
+		errors.Is(err, messaging.ErrMessageDeleted) || errors.Is(err, messaging.ErrEmptyContent) ||
+		errors.Is(err, messaging.ErrDeleteWindowExpired) || errors.Is(err, messaging.ErrInvalidReaction) ||
+		errors.Is(err, messaging.ErrSystemMessage) {
+		reason = err.Error()
+	} else {
+		log.Printf("%s: %v", fallback, err)
+	}
+
+	client.SendMessage(&Message{
+		Type:      "error",
+		To:        client.UserID,
+		MessageID: msg.MessageID,
+		Timestamp: time.Now(),
+		Data:      map[string]interface{}{"error": reason},
+	})
+}
+
+// Notifier delivers message changes from processes that don't run a hub,
+// such as the REST API. Events are appended to the recipients' event logs
+// and published to the websocket nodes through Redis.
+type Notifier struct {
+	events *EventLog
+	redis  *utils.RedisClient
+	nodeID string
+}
+
+func NewNotifier(db *gorm.DB, redisClient *utils.RedisClient, nodeID string) *Notifier {
+	return &Notifier{
+		events: NewEventLog(db),
+		redis:  redisClient,
+		nodeID: nodeID,
+	}
+}
+
+// MessageEdited tells the participants' devices that a message was edited
+func (n *Notifier) MessageEdited(actorID uint, change *messaging.MessageChange) {
+	n.messageChanged(changeEvent("edit", actorID, change), change)
+}
+
+// MessageDeleted tells the participants' devices that a message was deleted
+func (n *Notifier) MessageDeleted(actorID uint, change *messaging.MessageChange) {
+	n.messageChanged(changeEvent("delete", actorID, change), change)
+}
+
+// ReactionChanged tells the participants' devices that a reaction was added or removed
+func (n *Notifier) ReactionChanged(change *messaging.ReactionChange) {
+	n.messageChanged(reactionEvent(change), &change.MessageChange)
+}
+
+// MessageSent delivers a direct message sent over the REST API to the
+// devices of both parties. A retried client ID only reaches whoever has no
+// event for the message yet.
+func (n *Notifier) MessageSent(message *messaging.MessageView, created bool) {
+	recipients := []uint{message.ReceiverID, message.SenderID}
+	if !created {
+		var err error
+		if recipients, err = n.events.WithoutMessage(message.ID, recipients); err != nil {
+			log.Printf("Failed to load message events: %v", err)
+			return
+		}
+	}
+
+	event := chatEvent(message)
+	for _, userID := range recipients {
+		n.sendEvent(userID, event)
+	}
+}
+
+// MessagesRead tells the sender's devices that the reader read their
+// messages, and syncs the reader's devices
+func (n *Notifier) MessagesRead(readerID, senderID uint, messageIDs []uint) {
+	event := readEvent(readerID, senderID, messageIDs)
+	n.sendEvent(senderID, event)
+	n.sendEvent(readerID, event)
+}
+
+// SessionRevoked disconnects the devices signed in with a revoked session
+func (n *Notifier) SessionRevoked(userID, sessionID uint) {
+	if err := publishEnvelope(n.redis, userChannel(userID), &envelope{Node: n.nodeID, UserID: userID, RevokedSession: sessionID}); err != nil {
+		log.Printf("Failed to publish session revocation: %v", err)
+	}
+}
+
+func (n *Notifier) messageChanged(event *Message, change *messaging.MessageChange) {
+	if change.ChannelID != 0 {
+		if err := publishEnvelope(n.redis, broadcastChannel, &envelope{Node: n.nodeID, ChannelID: change.ChannelID, Message: event}); err != nil {
+			log.Printf("Failed to publish channel change: %v", err)
+		}
+		return
+	}
+
+	for _, userID := range change.Recipients {
+		n.sendEvent(userID, event)
+	}
+}
+
+// sendEvent appends an event to the user's event log and publishes it to
+// the nodes their devices are connected to
+func (n *Notifier) sendEvent(userID uint, event *Message) {
+	sequenced := *event
+	if _, err := n.events.Append(userID, &sequenced); err != nil {
+		log.Printf("Failed to record %s event: %v", event.Type, err)
+		return
+	}
+	if err := publishEnvelope(n.redis, userChannel(userID), &envelope{Node: n.nodeID, UserID: userID, Message: &sequenced}); err != nil {
+		log.Printf("Failed to publish %s event: %v", event.Type, err)
+	}
+}
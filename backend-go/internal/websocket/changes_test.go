@@ -0,0 +1,32 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/everest-an/dchat-backend/internal/messaging"
+	"github.com/everest-an/dchat-backend/internal/models"
+)
+
+func TestReactionEventHidesChannelReactors(t *testing.T) {
+	conversationID := uint(7)
+	change := &messaging.ReactionChange{
+		MessageChange: messaging.MessageChange{Message: &models.Message{ID: 3, ConversationID: &conversationID}},
+		UserID:        5,
+		Emoji:         "🎉",
+		Added:         true,
+	}
+
+	event := reactionEvent(change)
+	if event.From != 5 || event.Data.(map[string]interface{})["user_id"] != uint(5) {
+		t.Fatalf("conversation reaction = %+v, want the reactor", event)
+	}
+
+	change.ChannelID = conversationID
+	event = reactionEvent(change)
+	if _, ok := event.Data.(map[string]interface{})["user_id"]; ok || event.From != 0 {
+		t.Fatalf("channel reaction = %+v, names the reactor", event)
+	}
+	if event.ConversationID != conversationID || event.Emoji != "🎉" {
+		t.Fatalf("channel reaction = %+v", event)
+	}
+}
@@ -0,0 +1,124 @@
+package websocket
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/messaging"
+)
+
+// handleChannelPost stores a post from a channel admin and publishes it once
+// to every node, which deliver it to the subscribers connected to them.
+// Posts are not written to subscribers' event logs; devices that were
+// offline catch up from the channel history.
+func (h *Hub) handleChannelPost(client *Client, msg *Message) {
+	post, created, err := h.channels.Post(client.UserID, msg.ConversationID, msg.ClientID, msg.Content, msg.Encrypted, msg.ReplyToID)
+	if err != nil {
+		log.Printf("Failed to save channel post: %v", err)
+		reason := "Failed to post"
+		if errors.Is(err, messaging.ErrRoleForbidden) || errors.Is(err, messaging.ErrNotSubscribed) ||
+			errors.Is(err, messaging.ErrChannelNotFound) || errors.Is(err, messaging.ErrInvalidClientID) ||
+			errors.Is(err, messaging.ErrInvalidReplyTarget) {
+			reason = err.Error()
+		}
+		client.SendMessage(&Message{
+			Type:           "error",
+			To:             client.UserID,
+			ConversationID: msg.ConversationID,
+			ClientID:       msg.ClientID,
+			Timestamp:      time.Now(),
+			Data:           map[string]interface{}{"error": reason},
+		})
+		return
+	}
+
+	client.SendMessage(&Message{
+		Type:           "sent",
+		From:           client.UserID,
+		ConversationID: msg.ConversationID,
+		ClientID:       msg.ClientID,
+		MessageID:      post.ID,
+		Timestamp:      post.CreatedAt,
+		Data:           post,
+	})
+	if !created {
+		return
+	}
+
+	postMsg := &Message{
+		Type:           "post",
+		From:           client.UserID,
+		ConversationID: msg.ConversationID,
+		Content:        msg.Content,
+		Encrypted:      msg.Encrypted,
+		MessageID:      post.ID,
+		Timestamp:      post.CreatedAt,
+		Data:           post,
+	}
+	withThread(postMsg, post.ReplyToID, post.ThreadRootID)
+	h.deliverChannel(msg.ConversationID, client.ID, postMsg)
+	if err := h.cluster.PublishToChannel(msg.ConversationID, postMsg, client.ID); err != nil {
+		log.Printf("Failed to publish channel post: %v", err)
+	}
+}
+
+// handleChannelView moves a subscriber's read position in a channel, which
+// counts a view on every post it passes
+func (h *Hub) handleChannelView(client *Client, msg *Message) {
+	position, err := h.channels.MarkRead(client.UserID, msg.ConversationID, msg.MessageID)
+	if err != nil {
+		log.Printf("Failed to record channel view: %v", err)
+		return
+	}
+	if position == nil {
+		return
+	}
+
+	// The subscriber's other devices clear their unread badge
+	viewMsg := &Message{
+		Type:           "view",
+		From:           client.UserID,
+		ConversationID: msg.ConversationID,
+		MessageID:      *position,
+		Timestamp:      msg.Timestamp,
+		Data:           map[string]interface{}{"last_read_message_id": *position},
+	}
+	h.sendToUser(client.UserID, viewMsg, client)
+}
+
+// deliverChannel sends a channel post to the subscribers connected to this
+// node. The channel's subscribers are matched against the local connections
+// rather than the other way round, which would put every connected user in
+// one query for each post.
+func (h *Hub) deliverChannel(channelID uint, exceptClient string, msg *Message) {
+	online := make(map[uint]bool)
+	for _, userID := range h.GetOnlineUsers() {
+		online[userID] = true
+	}
+	if len(online) == 0 {
+		return
+	}
+
+	var subscribers []uint
+	err := h.channels.EachSubscriber(channelID, func(userID uint) {
+		if online[userID] {
+			subscribers = append(subscribers, userID)
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to load channel subscribers: %v", err)
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, userID := range subscribers {
+		for _, client := range h.Clients[userID] {
+			if client.ID != exceptClient {
+				client.SendMessage(msg)
+			}
+		}
+	}
+}
@@ -19,11 +19,19 @@ const (
 type Client struct {
 	ID         string
 	UserID     uint
+	// DeviceID identifies the login session; one connection is kept per device
+	DeviceID   string
 	Conn       *websocket.Conn
 	Hub        *Hub
 	Send       chan []byte
 	mu         sync.Mutex
 	isClosing  bool
+
+	// Sequenced events are buffered until the client has resumed
+	syncing    bool
+	resuming   bool
+	pending    []*Message
+	overflowed bool
 }
 
 type Message struct {
@@ -34,15 +42,36 @@ type Message struct {
 	Encrypted bool        `json:"encrypted"`
 	Timestamp time.Time   `json:"timestamp"`
 	Data      interface{} `json:"data,omitempty"`
+	// Per-user event sequence; on resume and ack frames, the client's last processed event
+	Seq uint64 `json:"seq,omitempty"`
+	// Client-generated UUID of a chat message, echoed on its confirmation
+	ClientID  string `json:"client_id,omitempty"`
+	MessageID uint   `json:"message_id,omitempty"`
+	// Group the frame belongs to; To is unused when set
+	ConversationID uint `json:"conversation_id,omitempty"`
+	// On delete frames, delete for every participant instead of only the sender's devices
+	ForEveryone bool `json:"for_everyone,omitempty"`
+	// On react, unreact and reaction frames, the emoji
+	Emoji string `json:"emoji,omitempty"`
+	// On chat and post frames, the message replied to; events also carry the
+	// thread the message belongs to, whose reply count grows by one
+	ReplyToID    uint `json:"reply_to_id,omitempty"`
+	ThreadRootID uint `json:"thread_root_id,omitempty"`
+	// On mls frames, a Welcome for the members a commit adds, and who they are;
+	// the MLS message itself is base64 in Content
+	Welcome    string `json:"welcome,omitempty"`
+	Recipients []uint `json:"recipients,omitempty"`
 }
 
-func NewClient(id string, userID uint, conn *websocket.Conn, hub *Hub) *Client {
+func NewClient(id string, userID uint, deviceID string, conn *websocket.Conn, hub *Hub) *Client {
 	return &Client{
-		ID:     id,
-		UserID: userID,
-		Conn:   conn,
-		Hub:    hub,
-		Send:   make(chan []byte, 256),
+		ID:       id,
+		UserID:   userID,
+		DeviceID: deviceID,
+		Conn:     conn,
+		Hub:      hub,
+		Send:     make(chan []byte, 256),
+		syncing:  true,
 	}
 }
 
@@ -130,6 +159,104 @@ func (c *Client) SendMessage(msg *Message) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	return c.send(msg)
+}
+
+// SendEvent sends a sequenced event, or buffers it while the client resumes
+func (c *Client) SendEvent(msg *Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.syncing {
+		return c.send(msg)
+	}
+	if len(c.pending) >= replayBatchSize {
+		// The replay reads these back from the event log
+		c.pending = nil
+		c.overflowed = true
+		return nil
+	}
+	c.pending = append(c.pending, msg)
+	return nil
+}
+
+// waitForRoom waits up to writeWait until the send buffer has room for n
+// messages. It returns false if the client is closing or stopped reading.
+func (c *Client) waitForRoom(n int) bool {
+	deadline := time.Now().Add(writeWait)
+	for {
+		c.mu.Lock()
+		closing := c.isClosing
+		free := cap(c.Send) - len(c.Send)
+		c.mu.Unlock()
+
+		if closing {
+			return false
+		}
+		if free >= n {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(replayPollInterval)
+	}
+}
+
+// beginResume marks the client as replaying missed events. It returns false
+// if the client has already gone live.
+func (c *Client) beginResume() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.syncing || c.resuming {
+		return false
+	}
+	c.resuming = true
+	return true
+}
+
+// goLive sends the buffered events after seq and switches to live delivery.
+// It returns false if the buffer overflowed and the replay has to continue.
+func (c *Client) goLive(seq uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.syncing {
+		return true
+	}
+	if c.overflowed {
+		c.overflowed = false
+		return false
+	}
+
+	c.flushPending(seq)
+	return true
+}
+
+// skipResume switches a client that did not ask to resume to live delivery
+func (c *Client) skipResume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.syncing && !c.resuming {
+		c.flushPending(0)
+	}
+}
+
+func (c *Client) flushPending(seq uint64) {
+	for _, msg := range c.pending {
+		if msg.Seq > seq {
+			c.send(msg)
+		}
+	}
+	c.pending = nil
+	c.overflowed = false
+	c.syncing = false
+	c.resuming = false
+}
+
+func (c *Client) send(msg *Message) error {
 	if c.isClosing {
 		return nil
 	}
@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientWaitForRoom(t *testing.T) {
+	client := NewClient("c1", 1, "device", nil, nil)
+	for i := 0; i < cap(client.Send)-replayBatchSize+1; i++ {
+		client.Send <- []byte("{}")
+	}
+
+	done := make(chan bool)
+	go func() { done <- client.waitForRoom(replayBatchSize) }()
+	select {
+	case <-done:
+		t.Fatal("returned before the buffer drained")
+	case <-time.After(3 * replayPollInterval):
+	}
+
+	<-client.Send
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatal("waitForRoom = false after the buffer drained")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("did not notice the buffer drained")
+	}
+
+	client.Close()
+	if client.waitForRoom(1) {
+		t.Fatal("waitForRoom = true for a closed client")
+	}
+}
+
+func TestClientSendEventOverflow(t *testing.T) {
+	client := NewClient("c1", 1, "device", nil, nil)
+	if !client.beginResume() {
+		t.Fatal("beginResume = false for a new client")
+	}
+	for seq := uint64(1); seq <= replayBatchSize+1; seq++ {
+		client.SendEvent(&Message{Type: "chat", Seq: seq})
+	}
+	if client.goLive(0) {
+		t.Fatal("goLive = true after the pending buffer overflowed")
+	}
+
+	client.SendEvent(&Message{Type: "chat", Seq: replayBatchSize + 2})
+	client.SendEvent(&Message{Type: "chat", Seq: replayBatchSize + 3})
+	if !client.goLive(replayBatchSize + 2) {
+		t.Fatal("goLive = false after the replay caught up")
+	}
+	if n := len(client.Send); n != 1 {
+		t.Fatalf("sent %d buffered events, want the one after the replay", n)
+	}
+}
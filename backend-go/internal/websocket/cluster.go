@@ -0,0 +1,285 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/everest-an/dchat-backend/pkg/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	userChannelPrefix = "ws:user:"
+	broadcastChannel  = "ws:broadcast"
+
+	presenceKeyPrefix = "ws:presence:"
+	nodeKeyPrefix     = "ws:node:"
+	nodesKey          = "ws:nodes"
+
+	heartbeatInterval = 10 * time.Second
+	heartbeatTTL      = 30 * time.Second
+)
+
+// envelope carries an event between websocket nodes
+type envelope struct {
+	Node         string   `json:"node"`
+	UserID       uint     `json:"user_id,omitempty"`
+	ChannelID    uint     `json:"channel_id,omitempty"`
+	ExceptClient string   `json:"except_client,omitempty"`
+	Message      *Message `json:"message"`
+	// Set instead of Message when a session of UserID was revoked
+	RevokedSession uint `json:"revoked_session,omitempty"`
+}
+
+// Cluster connects websocket nodes through Redis. Every node subscribes to
+// the channel of each user connected to it, so an event published for a user
+// reaches all of their devices whichever node they are on. Presence is kept in
+// Redis as the set of nodes each user is connected to; nodes that stop sending
+// heartbeats are reaped by the others.
+type Cluster struct {
+	redis  *utils.RedisClient
+	nodeID string
+	pubsub *redis.PubSub
+	ctx    context.Context
+}
+
+func NewCluster(redisClient *utils.RedisClient, nodeID string) *Cluster {
+	ctx := context.Background()
+	return &Cluster{
+		redis:  redisClient,
+		nodeID: nodeID,
+		pubsub: redisClient.Subscribe(broadcastChannel),
+		ctx:    ctx,
+	}
+}
+
+// NodeID identifies this websocket process in the cluster
+func (c *Cluster) NodeID() string {
+	return c.nodeID
+}
+
+// Listen passes events published by other nodes to deliver, or for channel
+// posts to deliverChannel, and revoked sessions to revoke, until the
+// subscription is closed
+func (c *Cluster) Listen(deliver func(userID uint, exceptClient string, msg *Message), deliverChannel func(channelID uint, exceptClient string, msg *Message), revoke func(userID, sessionID uint)) {
+	for raw := range c.pubsub.Channel() {
+		var env envelope
+		if err := json.Unmarshal([]byte(raw.Payload), &env); err != nil {
+			log.Printf("Failed to decode cluster event: %v", err)
+			continue
+		}
+		if env.RevokedSession != 0 {
+			revoke(env.UserID, env.RevokedSession)
+			continue
+		}
+		if env.Node == c.nodeID || env.Message == nil {
+			// Already delivered locally before publishing
+			continue
+		}
+		if env.ChannelID != 0 {
+			deliverChannel(env.ChannelID, env.ExceptClient, env.Message)
+			continue
+		}
+		deliver(env.UserID, env.ExceptClient, env.Message)
+	}
+}
+
+// PublishToUser sends an event to the devices of a user connected to other nodes
+func (c *Cluster) PublishToUser(userID uint, msg *Message, exceptClient string) error {
+	return c.publish(userChannel(userID), &envelope{
+		Node:         c.nodeID,
+		UserID:       userID,
+		ExceptClient: exceptClient,
+		Message:      msg,
+	})
+}
+
+// Broadcast sends an event to every user connected to other nodes
+func (c *Cluster) Broadcast(msg *Message) error {
+	return c.publish(broadcastChannel, &envelope{
+		Node:    c.nodeID,
+		Message: msg,
+	})
+}
+
+// PublishToChannel sends a channel post to every node once; each node
+// delivers it to the subscribers connected to it
+func (c *Cluster) PublishToChannel(channelID uint, msg *Message, exceptClient string) error {
+	return c.publish(broadcastChannel, &envelope{
+		Node:         c.nodeID,
+		ChannelID:    channelID,
+		ExceptClient: exceptClient,
+		Message:      msg,
+	})
+}
+
+// Connect records that a user has a device on this node and subscribes to
+// their channel. It reports whether the user just came online cluster-wide.
+func (c *Cluster) Connect(userID uint) (bool, error) {
+	if err := c.pubsub.Subscribe(c.ctx, userChannel(userID)); err != nil {
+		return false, fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	var added, nodes *redis.IntCmd
+	_, err := c.redis.Client.TxPipelined(c.ctx, func(pipe redis.Pipeliner) error {
+		added = pipe.HSet(c.ctx, presenceKey(userID), c.nodeID, time.Now().Unix())
+		nodes = pipe.HLen(c.ctx, presenceKey(userID))
+		pipe.SAdd(c.ctx, nodeUsersKey(c.nodeID), userID)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to record presence: %w", err)
+	}
+	return added.Val() == 1 && nodes.Val() == 1, nil
+}
+
+// Disconnect records that a user has no devices left on this node. It
+// reports whether the user went offline cluster-wide.
+func (c *Cluster) Disconnect(userID uint) (bool, error) {
+	if err := c.pubsub.Unsubscribe(c.ctx, userChannel(userID)); err != nil {
+		log.Printf("Failed to unsubscribe from user channel: %v", err)
+	}
+
+	var nodes *redis.IntCmd
+	_, err := c.redis.Client.TxPipelined(c.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(c.ctx, presenceKey(userID), c.nodeID)
+		nodes = pipe.HLen(c.ctx, presenceKey(userID))
+		pipe.SRem(c.ctx, nodeUsersKey(c.nodeID), userID)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to clear presence: %w", err)
+	}
+	return nodes.Val() == 0, nil
+}
+
+// IsUserOnline reports whether the user has a device on any node
+func (c *Cluster) IsUserOnline(userID uint) (bool, error) {
+	n, err := c.redis.Client.HLen(c.ctx, presenceKey(userID)).Result()
+	return n > 0, err
+}
+
+// Heartbeat keeps this node registered and reaps nodes that stopped sending
+// heartbeats, calling offline for each user that lost their last node
+func (c *Cluster) Heartbeat(offline func(userID uint)) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.beat(); err != nil {
+			log.Printf("Failed to send node heartbeat: %v", err)
+		}
+		c.reapDeadNodes(offline)
+		<-ticker.C
+	}
+}
+
+// Close removes this node and its presence from the cluster, calling
+// offline for each user that had no devices on other nodes
+func (c *Cluster) Close(offline func(userID uint)) error {
+	users, err := c.redis.Client.SMembers(c.ctx, nodeUsersKey(c.nodeID)).Result()
+	if err == nil {
+		for _, member := range users {
+			userID := parseUserID(member)
+			var nodesLeft *redis.IntCmd
+			_, err := c.redis.Client.TxPipelined(c.ctx, func(pipe redis.Pipeliner) error {
+				pipe.HDel(c.ctx, presenceKey(userID), c.nodeID)
+				nodesLeft = pipe.HLen(c.ctx, presenceKey(userID))
+				return nil
+			})
+			if err == nil && nodesLeft.Val() == 0 {
+				offline(userID)
+			}
+		}
+	}
+	c.redis.Client.Del(c.ctx, nodeUsersKey(c.nodeID), nodeKeyPrefix+c.nodeID)
+	c.redis.Client.SRem(c.ctx, nodesKey, c.nodeID)
+	return c.pubsub.Close()
+}
+
+func (c *Cluster) beat() error {
+	_, err := c.redis.Client.TxPipelined(c.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(c.ctx, nodeKeyPrefix+c.nodeID, time.Now().Unix(), heartbeatTTL)
+		pipe.SAdd(c.ctx, nodesKey, c.nodeID)
+		return nil
+	})
+	return err
+}
+
+func (c *Cluster) reapDeadNodes(offline func(userID uint)) {
+	nodes, err := c.redis.Client.SMembers(c.ctx, nodesKey).Result()
+	if err != nil {
+		log.Printf("Failed to list websocket nodes: %v", err)
+		return
+	}
+
+	for _, node := range nodes {
+		if node == c.nodeID {
+			continue
+		}
+		alive, err := c.redis.Exists(nodeKeyPrefix + node)
+		if err != nil || alive {
+			continue
+		}
+
+		// Only the node that removes the dead node from the set reaps it
+		removed, err := c.redis.Client.SRem(c.ctx, nodesKey, node).Result()
+		if err != nil || removed == 0 {
+			continue
+		}
+
+		users, _ := c.redis.Client.SMembers(c.ctx, nodeUsersKey(node)).Result()
+		for _, member := range users {
+			userID := parseUserID(member)
+			var nodesLeft *redis.IntCmd
+			_, err := c.redis.Client.TxPipelined(c.ctx, func(pipe redis.Pipeliner) error {
+				pipe.HDel(c.ctx, presenceKey(userID), node)
+				nodesLeft = pipe.HLen(c.ctx, presenceKey(userID))
+				return nil
+			})
+			if err == nil && nodesLeft.Val() == 0 {
+				offline(userID)
+			}
+		}
+		c.redis.Client.Del(c.ctx, nodeUsersKey(node))
+		log.Printf("🪦 Reaped dead websocket node %s (%d users)", node, len(users))
+	}
+}
+
+func (c *Cluster) publish(channel string, env *envelope) error {
+	return publishEnvelope(c.redis, channel, env)
+}
+
+func publishEnvelope(redisClient *utils.RedisClient, channel string, env *envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return redisClient.Publish(channel, data)
+}
+
+// SessionDevice is the device ID of the connections of a login session
+func SessionDevice(sessionID uint) string {
+	return fmt.Sprintf("session:%d", sessionID)
+}
+
+func userChannel(userID uint) string {
+	return fmt.Sprintf("%s%d", userChannelPrefix, userID)
+}
+
+func presenceKey(userID uint) string {
+	return fmt.Sprintf("%s%d", presenceKeyPrefix, userID)
+}
+
+func nodeUsersKey(nodeID string) string {
+	return nodeKeyPrefix + nodeID + ":users"
+}
+
+func parseUserID(s string) uint {
+	id, _ := strconv.ParseUint(s, 10, 64)
+	return uint(id)
+}
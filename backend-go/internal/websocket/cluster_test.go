@@ -0,0 +1,180 @@
+package websocket
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/testutil"
+	"github.com/everest-an/dchat-backend/pkg/utils"
+)
+
+func newTestCluster(t *testing.T, redisClient *utils.RedisClient, nodeID string) *Cluster {
+	t.Helper()
+	c := NewCluster(redisClient, nodeID)
+	if err := c.beat(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClusterPresence(t *testing.T) {
+	redisClient, _ := testutil.NewRedis(t)
+	a := newTestCluster(t, redisClient, "a")
+	b := newTestCluster(t, redisClient, "b")
+	defer a.Close(func(uint) {})
+	defer b.Close(func(uint) {})
+
+	if online, err := a.Connect(1); err != nil || !online {
+		t.Fatalf("first device: online=%v err=%v", online, err)
+	}
+	if online, err := b.Connect(1); err != nil || online {
+		t.Fatalf("device on a second node: online=%v err=%v", online, err)
+	}
+	if offline, err := a.Disconnect(1); err != nil || offline {
+		t.Fatalf("leaving one node: offline=%v err=%v", offline, err)
+	}
+	if online, _ := b.IsUserOnline(1); !online {
+		t.Fatal("user should still be online through node b")
+	}
+	if offline, err := b.Disconnect(1); err != nil || !offline {
+		t.Fatalf("leaving the last node: offline=%v err=%v", offline, err)
+	}
+	if online, _ := a.IsUserOnline(1); online {
+		t.Fatal("user should be offline")
+	}
+}
+
+func TestClusterPublishToUser(t *testing.T) {
+	redisClient, _ := testutil.NewRedis(t)
+	a := newTestCluster(t, redisClient, "a")
+	b := newTestCluster(t, redisClient, "b")
+	defer a.Close(func(uint) {})
+
+	if _, err := b.Connect(7); err != nil {
+		t.Fatal(err)
+	}
+	delivered := make(chan *Message, 1)
+	go b.Listen(func(userID uint, exceptClient string, msg *Message) {
+		if userID == 7 && exceptClient == "phone" {
+			delivered <- msg
+		}
+	}, func(uint, string, *Message) {}, func(uint, uint) {})
+	defer b.Close(func(uint) {})
+
+	// The subscription may not be active yet when the first event is published
+	deadline := time.After(2 * time.Second)
+	for {
+		if err := a.PublishToUser(7, &Message{Type: "chat", Content: "hi"}, "phone"); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-delivered:
+			if msg.Content != "hi" {
+				t.Fatalf("unexpected message: %+v", msg)
+			}
+			return
+		case <-deadline:
+			t.Fatal("event was not delivered to the other node")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+func TestClusterCloseRemovesPresence(t *testing.T) {
+	redisClient, server := testutil.NewRedis(t)
+	a := newTestCluster(t, redisClient, "a")
+	b := newTestCluster(t, redisClient, "b")
+	defer b.Close(func(uint) {})
+
+	a.Connect(1)
+	a.Connect(2)
+	b.Connect(2)
+
+	var offline []uint
+	if err := a.Close(func(userID uint) { offline = append(offline, userID) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(offline) != 1 || offline[0] != 1 {
+		t.Fatalf("offline = %v, want [1]", offline)
+	}
+	if online, _ := b.IsUserOnline(2); !online {
+		t.Fatal("user 2 should still be online through node b")
+	}
+	for _, key := range []string{nodeKeyPrefix + "a", nodeUsersKey("a"), presenceKey(1)} {
+		if server.Exists(key) {
+			t.Fatalf("%s should have been removed", key)
+		}
+	}
+	if nodes, _ := server.Members(nodesKey); len(nodes) != 1 || nodes[0] != "b" {
+		t.Fatalf("nodes = %v, want [b]", nodes)
+	}
+}
+
+func TestClusterReapDeadNodes(t *testing.T) {
+	redisClient, server := testutil.NewRedis(t)
+	dead := newTestCluster(t, redisClient, "dead")
+	defer dead.pubsub.Close()
+	dead.Connect(1)
+	dead.Connect(2)
+	dead.Connect(3)
+
+	server.FastForward(heartbeatTTL + time.Second)
+	alive := newTestCluster(t, redisClient, "alive")
+	defer alive.Close(func(uint) {})
+	alive.Connect(3)
+
+	var offline []uint
+	alive.reapDeadNodes(func(userID uint) { offline = append(offline, userID) })
+	sort.Slice(offline, func(i, j int) bool { return offline[i] < offline[j] })
+	if len(offline) != 2 || offline[0] != 1 || offline[1] != 2 {
+		t.Fatalf("offline = %v, want [1 2]", offline)
+	}
+	if server.Exists(nodeUsersKey("dead")) {
+		t.Fatal("users of the dead node should have been removed")
+	}
+
+	// Another node reaping at the same time finds nothing left to do
+	offline = nil
+	alive.reapDeadNodes(func(userID uint) { offline = append(offline, userID) })
+	if len(offline) != 0 {
+		t.Fatalf("node reaped twice: offline = %v", offline)
+	}
+}
+
+func TestClusterSessionRevoked(t *testing.T) {
+	redisClient, _ := testutil.NewRedis(t)
+	node := newTestCluster(t, redisClient, "a")
+	notifier := NewNotifier(nil, redisClient, "api")
+
+	if _, err := node.Connect(7); err != nil {
+		t.Fatal(err)
+	}
+	revoked := make(chan uint, 1)
+	go node.Listen(func(uint, string, *Message) {
+		t.Error("a revoked session was delivered as an event")
+	}, func(uint, string, *Message) {}, func(userID, sessionID uint) {
+		if userID == 7 {
+			select {
+			case revoked <- sessionID:
+			default:
+			}
+		}
+	})
+	defer node.Close(func(uint) {})
+
+	deadline := time.After(2 * time.Second)
+	for {
+		notifier.SessionRevoked(7, 3)
+		select {
+		case sessionID := <-revoked:
+			if sessionID != 3 {
+				t.Fatalf("revoked session %d, want 3", sessionID)
+			}
+			return
+		case <-deadline:
+			t.Fatal("revocation did not reach the node")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"log"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/messaging"
+)
+
+// Disappearing messages are deleted at most this long after they expire
+const reapInterval = 30 * time.Second
+
+// reapExpiredMessages periodically deletes messages past their expiry and
+// tells the members' devices to remove them. Devices that are offline get
+// the "expired" event when they resume.
+func (h *Hub) reapExpiredMessages() {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			batches, n, err := h.messages.ReapExpired(messaging.ReapBatchSize)
+			if err != nil {
+				log.Printf("Failed to reap expired messages: %v", err)
+				break
+			}
+			for _, batch := range batches {
+				h.messagesExpired(batch)
+			}
+			if n > 0 {
+				log.Printf("🧹 Reaped %d expired messages", n)
+			}
+			if n < messaging.ReapBatchSize {
+				break
+			}
+		}
+	}
+}
+
+func (h *Hub) messagesExpired(batch messaging.ExpiredMessages) {
+	event := &Message{
+		Type:           "expired",
+		ConversationID: batch.ConversationID,
+		Timestamp:      time.Now(),
+		Data: map[string]interface{}{
+			"message_ids": batch.MessageIDs,
+		},
+	}
+	for _, memberID := range batch.Members {
+		if _, err := h.sendEvent(memberID, event, nil); err != nil {
+			log.Printf("Failed to record expired event: %v", err)
+		}
+	}
+}
+
+// TimerChanged announces a conversation's new disappearing-message timer to
+// its members with the system message recording the change
+func (n *Notifier) TimerChanged(change *messaging.TimerChange) {
+	message := change.Message
+	event := &Message{
+		Type:           "chat",
+		From:           message.SenderID,
+		ConversationID: change.Conversation.ID,
+		Content:        message.Content,
+		MessageID:      message.ID,
+		Timestamp:      message.CreatedAt,
+		Data:           message,
+	}
+	for _, memberID := range change.Members {
+		n.sendEvent(memberID, event)
+	}
+}
@@ -0,0 +1,171 @@
+package websocket
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/messaging"
+)
+
+// handleGroupMessage stores a group message once and fans it out to every member
+func (h *Hub) handleGroupMessage(client *Client, msg *Message) {
+	message, created, err := h.groups.Send(client.UserID, msg.ConversationID, msg.ClientID, msg.Content, msg.Encrypted, msg.ReplyToID)
+	if err != nil {
+		log.Printf("Failed to save group message: %v", err)
+		reason := "Failed to send message"
+		if errors.Is(err, messaging.ErrNotGroupMember) || errors.Is(err, messaging.ErrGroupNotFound) ||
+			errors.Is(err, messaging.ErrInvalidReplyTarget) {
+			reason = err.Error()
+		}
+		client.SendMessage(&Message{
+			Type:           "error",
+			To:             client.UserID,
+			ConversationID: msg.ConversationID,
+			ClientID:       msg.ClientID,
+			Timestamp:      time.Now(),
+			Data:           map[string]interface{}{"error": reason},
+		})
+		return
+	}
+
+	confirmMsg := &Message{
+		Type:           "sent",
+		From:           client.UserID,
+		ConversationID: msg.ConversationID,
+		ClientID:       msg.ClientID,
+		MessageID:      message.ID,
+		Timestamp:      message.CreatedAt,
+		Data:           message,
+	}
+
+	memberIDs, err := h.groups.MemberIDs(client.UserID, msg.ConversationID)
+	if err != nil {
+		log.Printf("Failed to load group members: %v", err)
+	}
+	if !created {
+		// The first attempt may have stopped before the message reached
+		// every member; deliver it to whoever has no event for it yet
+		memberIDs, err = h.events.WithoutMessage(message.ID, memberIDs)
+		if err != nil {
+			log.Printf("Failed to load message events: %v", err)
+			memberIDs = nil
+		}
+	}
+
+	responseMsg := &Message{
+		Type:           "chat",
+		From:           client.UserID,
+		ConversationID: msg.ConversationID,
+		Content:        message.Content,
+		Encrypted:      message.Encrypted,
+		ClientID:       msg.ClientID,
+		MessageID:      message.ID,
+		Timestamp:      message.CreatedAt,
+		Data:           message,
+	}
+	withThread(responseMsg, message.ReplyToID, message.ThreadRootID)
+
+	// Every member gets the message in their event log, including the
+	// sender's other devices
+	var senderEvent *Message
+	for _, memberID := range memberIDs {
+		var except *Client
+		if memberID == client.UserID {
+			except = client
+		}
+		event, err := h.sendEvent(memberID, responseMsg, except)
+		if err != nil {
+			log.Printf("Failed to record group message event: %v", err)
+			continue
+		}
+		if memberID == client.UserID {
+			senderEvent = event
+		}
+	}
+
+	if senderEvent == nil {
+		// The sender's copy is already in their event log
+		client.SendMessage(confirmMsg)
+		return
+	}
+	confirmMsg.Seq = senderEvent.Seq
+	client.SendEvent(confirmMsg)
+}
+
+// handleGroupTyping relays a typing indicator to the other members
+func (h *Hub) handleGroupTyping(client *Client, msg *Message) {
+	memberIDs, err := h.groups.MemberIDs(client.UserID, msg.ConversationID)
+	if err != nil {
+		return
+	}
+
+	typingMsg := &Message{
+		Type:           "typing",
+		From:           client.UserID,
+		ConversationID: msg.ConversationID,
+		Timestamp:      msg.Timestamp,
+	}
+	for _, memberID := range memberIDs {
+		if memberID != client.UserID {
+			h.sendToUser(memberID, typingMsg, nil)
+		}
+	}
+}
+
+// handleGroupRead moves the reader's position to the latest group message and
+// tells the other members how far the reader has read
+func (h *Hub) handleGroupRead(client *Client, msg *Message) {
+	lastMessageID, err := h.groups.MarkRead(client.UserID, msg.ConversationID)
+	if err != nil {
+		log.Printf("Failed to mark group as read: %v", err)
+		return
+	}
+	if lastMessageID == nil {
+		return
+	}
+
+	readMsg := &Message{
+		Type:           "read",
+		From:           client.UserID,
+		ConversationID: msg.ConversationID,
+		MessageID:      *lastMessageID,
+		Timestamp:      msg.Timestamp,
+		Data:           map[string]interface{}{"last_read_message_id": *lastMessageID},
+	}
+
+	// The reader's other devices clear their unread badge
+	if _, err := h.sendEvent(client.UserID, readMsg, client); err != nil {
+		log.Printf("Failed to record read event: %v", err)
+	}
+
+	// Read positions in groups are live only; members fetch the latest from the REST API
+	memberIDs, err := h.groups.MemberIDs(client.UserID, msg.ConversationID)
+	if err != nil {
+		log.Printf("Failed to load group members: %v", err)
+		return
+	}
+	for _, memberID := range memberIDs {
+		if memberID != client.UserID {
+			h.sendToUser(memberID, readMsg, nil)
+		}
+	}
+}
+
+// GroupChanged tells the members of a group, and anyone the change removed,
+// that its title or members changed
+func (n *Notifier) GroupChanged(change *messaging.GroupChange) {
+	if change == nil {
+		return
+	}
+	event := &Message{
+		Type:           "group_update",
+		From:           change.ActorID,
+		ConversationID: change.GroupID,
+		Timestamp:      time.Now(),
+		Data:           change,
+	}
+	for _, userID := range change.Recipients {
+		n.sendEvent(userID, event)
+	}
+}
@@ -1,17 +1,20 @@
 package websocket
 
 import (
+	"errors"
 	"log"
 	"sync"
 	"time"
 
-	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/e2ee"
+	"github.com/everest-an/dchat-backend/internal/messaging"
 	"gorm.io/gorm"
 )
 
 type Hub struct {
-	// Registered clients by user ID
-	Clients map[uint]*Client
+	// Registered clients by user ID, then by device ID
+	Clients map[uint]map[string]*Client
 
 	// Register requests from clients
 	Register chan *Client
@@ -24,18 +27,51 @@ type Hub struct {
 
 	// Database connection
 	db *gorm.DB
+
+	// Delivery to clients connected to other nodes
+	cluster *Cluster
+
+	// Sequenced events for offline delivery and resume
+	events *EventLog
+
+	// Message storage and delivery states
+	messages *messaging.MessageService
+
+	// Group membership and group message storage
+	groups *messaging.GroupService
+
+	// Channel subscriptions and posts
+	channels *messaging.ChannelService
+
+	// Ordered relay of end-to-end encrypted MLS group messages
+	mls *e2ee.MLSService
 }
 
-func NewHub(db *gorm.DB) *Hub {
+func NewHub(db *gorm.DB, cluster *Cluster, cfg *config.MessagingConfig) *Hub {
+	messages := messaging.NewMessageService(db, cfg)
+	groups := messaging.NewGroupService(db, messages)
 	return &Hub{
-		Clients:    make(map[uint]*Client),
+		Clients:    make(map[uint]map[string]*Client),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		db:         db,
+		cluster:    cluster,
+		events:     NewEventLog(db),
+		messages:   messages,
+		groups:     groups,
+		channels:   messaging.NewChannelService(db, messages),
+		mls:        e2ee.NewMLSService(db, cluster.redis, groups),
 	}
 }
 
 func (h *Hub) Run() {
+	go h.cluster.Listen(h.deliverLocal, h.deliverChannel, h.dropSession)
+	go h.cluster.Heartbeat(func(userID uint) {
+		h.broadcastStatus(userID, false)
+	})
+	go h.pruneEvents()
+	go h.reapExpiredMessages()
+
 	for {
 		select {
 		case client := <-h.Register:
@@ -47,131 +83,292 @@ func (h *Hub) Run() {
 	}
 }
 
+// Shutdown takes this node out of the cluster. Users connected only here
+// are announced offline rather than left online until the node's presence
+// would have been reaped.
+func (h *Hub) Shutdown() error {
+	return h.cluster.Close(func(userID uint) {
+		h.broadcastStatus(userID, false)
+	})
+}
+
 func (h *Hub) registerClient(client *Client) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
 
-	// If user already has a connection, close the old one
-	if oldClient, exists := h.Clients[client.UserID]; exists {
+	devices, online := h.Clients[client.UserID]
+	if !online {
+		devices = make(map[string]*Client)
+		h.Clients[client.UserID] = devices
+	}
+
+	// A device that reconnects replaces its stale connection
+	if oldClient, exists := devices[client.DeviceID]; exists {
 		oldClient.Close()
 	}
 
-	h.Clients[client.UserID] = client
-	log.Printf("✅ Client registered: UserID=%d, Total=%d", client.UserID, len(h.Clients))
+	devices[client.DeviceID] = client
+	time.AfterFunc(resumeTimeout, client.skipResume)
+	log.Printf("✅ Client registered: UserID=%d, Device=%s, Devices=%d, Total=%d", client.UserID, client.DeviceID, len(devices), len(h.Clients))
+	h.mu.Unlock()
+
+	if online {
+		return
+	}
 
-	// Send online status
-	h.broadcastStatus(client.UserID, true)
+	// Send online status when the user's first device in the cluster connects
+	first, err := h.cluster.Connect(client.UserID)
+	if err != nil {
+		log.Printf("Failed to join cluster presence: %v", err)
+		return
+	}
+	if first {
+		h.broadcastStatus(client.UserID, true)
+	}
 }
 
 func (h *Hub) unregisterClient(client *Client) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
 
-	if _, exists := h.Clients[client.UserID]; exists {
-		delete(h.Clients, client.UserID)
+	devices := h.Clients[client.UserID]
+	if devices[client.DeviceID] != client {
+		// Already replaced by a newer connection from the same device
+		h.mu.Unlock()
 		client.Close()
-		log.Printf("❌ Client unregistered: UserID=%d, Total=%d", client.UserID, len(h.Clients))
+		return
+	}
+
+	delete(devices, client.DeviceID)
+	client.Close()
+
+	offline := len(devices) == 0
+	if offline {
+		delete(h.Clients, client.UserID)
+	}
+	log.Printf("❌ Client unregistered: UserID=%d, Device=%s, Devices=%d, Total=%d", client.UserID, client.DeviceID, len(devices), len(h.Clients))
+	h.mu.Unlock()
+
+	if !offline {
+		return
+	}
 
-		// Send offline status
+	// Send offline status when the user's last device in the cluster disconnects
+	last, err := h.cluster.Disconnect(client.UserID)
+	if err != nil {
+		log.Printf("Failed to leave cluster presence: %v", err)
+		return
+	}
+	if last {
 		h.broadcastStatus(client.UserID, false)
 	}
 }
 
 func (h *Hub) HandleMessage(client *Client, msg *Message) {
+	if msg.Type != "resume" && msg.Type != "ack" {
+		client.skipResume()
+	}
+
 	switch msg.Type {
+	case "resume":
+		h.handleResume(client, msg)
+	case "ack":
+		h.handleAck(client, msg)
 	case "chat":
+		if msg.ConversationID != 0 {
+			h.handleGroupMessage(client, msg)
+			return
+		}
 		h.handleChatMessage(client, msg)
 	case "typing":
+		if msg.ConversationID != 0 {
+			h.handleGroupTyping(client, msg)
+			return
+		}
 		h.handleTypingIndicator(client, msg)
 	case "read":
+		if msg.ConversationID != 0 {
+			h.handleGroupRead(client, msg)
+			return
+		}
 		h.handleReadReceipt(client, msg)
+	case "edit":
+		h.handleEdit(client, msg)
+	case "delete":
+		h.handleDelete(client, msg)
+	case "react":
+		h.handleReaction(client, msg, true)
+	case "unreact":
+		h.handleReaction(client, msg, false)
+	case "post":
+		h.handleChannelPost(client, msg)
+	case "view":
+		h.handleChannelView(client, msg)
+	case "mls":
+		h.handleMLS(client, msg)
 	default:
 		log.Printf("Unknown message type: %s", msg.Type)
 	}
 }
 
+// chatEvent carries a new direct message to the devices of both parties
+func chatEvent(message *messaging.MessageView) *Message {
+	event := &Message{
+		Type:      "chat",
+		From:      message.SenderID,
+		To:        message.ReceiverID,
+		Content:   message.Content,
+		Encrypted: message.Encrypted,
+		MessageID: message.ID,
+		Timestamp: message.CreatedAt,
+		Data:      message,
+	}
+	if message.ClientID != nil {
+		event.ClientID = *message.ClientID
+	}
+	withThread(event, message.ReplyToID, message.ThreadRootID)
+	return event
+}
+
+// readEvent tells both parties which of the sender's messages the reader has read
+func readEvent(readerID, senderID uint, messageIDs []uint) *Message {
+	return &Message{
+		Type:      "read",
+		From:      readerID,
+		To:        senderID,
+		Timestamp: time.Now(),
+		Data:      map[string]interface{}{"message_ids": messageIDs},
+	}
+}
+
 func (h *Hub) handleChatMessage(client *Client, msg *Message) {
-	// Save message to database
-	dbMessage := models.Message{
+	// Save message to database; a retried client ID returns the stored message
+	dbMessage, created, err := h.messages.Send(&messaging.SendRequest{
 		SenderID:   msg.From,
 		ReceiverID: msg.To,
+		ClientID:   msg.ClientID,
 		Content:    msg.Content,
 		Encrypted:  msg.Encrypted,
-		Read:       false,
-	}
-
-	if err := h.db.Create(&dbMessage).Error; err != nil {
+		ReplyToID:  msg.ReplyToID,
+	})
+	if err != nil {
 		log.Printf("Failed to save message: %v", err)
-		return
-	}
-
-	// Load sender info
-	h.db.Preload("Sender").First(&dbMessage, dbMessage.ID)
-
-	// Send to recipient if online
-	h.mu.RLock()
-	recipientClient, online := h.Clients[msg.To]
-	h.mu.RUnlock()
-
-	if online {
-		responseMsg := &Message{
-			Type:      "chat",
-			From:      msg.From,
-			To:        msg.To,
-			Content:   msg.Content,
-			Encrypted: msg.Encrypted,
-			Timestamp: dbMessage.CreatedAt,
-			Data:      dbMessage,
+		reason := "Failed to send message"
+		if errors.Is(err, messaging.ErrInvalidReplyTarget) {
+			reason = err.Error()
 		}
-		recipientClient.SendMessage(responseMsg)
+		client.SendMessage(&Message{
+			Type:      "error",
+			To:        client.UserID,
+			ClientID:  msg.ClientID,
+			Timestamp: time.Now(),
+			Data:      map[string]interface{}{"error": reason},
+		})
+		return
 	}
 
-	// Send confirmation to sender
 	confirmMsg := &Message{
 		Type:      "sent",
 		From:      msg.From,
 		To:        msg.To,
+		ClientID:  msg.ClientID,
+		MessageID: dbMessage.ID,
 		Timestamp: dbMessage.CreatedAt,
 		Data:      dbMessage,
 	}
-	client.SendMessage(confirmMsg)
-}
 
-func (h *Hub) handleTypingIndicator(client *Client, msg *Message) {
-	h.mu.RLock()
-	recipientClient, online := h.Clients[msg.To]
-	h.mu.RUnlock()
+	recipients := []uint{msg.To, client.UserID}
+	if !created {
+		// The first attempt may have stopped before the message reached
+		// everyone; deliver it to whoever has no event for it yet
+		recipients, err = h.events.WithoutMessage(dbMessage.ID, recipients)
+		if err != nil {
+			log.Printf("Failed to load message events: %v", err)
+			recipients = nil
+		}
+	}
 
-	if online {
-		typingMsg := &Message{
-			Type:      "typing",
-			From:      msg.From,
-			To:        msg.To,
-			Timestamp: msg.Timestamp,
+	responseMsg := chatEvent(dbMessage)
+
+	// Send to every device of the recipient, and echo to the sender's other devices
+	var senderEvent *Message
+	for _, userID := range recipients {
+		var except *Client
+		if userID == client.UserID {
+			except = client
+		}
+		event, err := h.sendEvent(userID, responseMsg, except)
+		if err != nil {
+			log.Printf("Failed to record message event: %v", err)
+			continue
+		}
+		if userID == client.UserID {
+			senderEvent = event
 		}
-		recipientClient.SendMessage(typingMsg)
 	}
+
+	if senderEvent == nil {
+		// The sender's copy is already in their event log
+		client.SendMessage(confirmMsg)
+		return
+	}
+	// Send confirmation to sender, carrying the sequence of their own copy
+	confirmMsg.Seq = senderEvent.Seq
+	client.SendEvent(confirmMsg)
+}
+
+func (h *Hub) handleTypingIndicator(client *Client, msg *Message) {
+	typingMsg := &Message{
+		Type:      "typing",
+		From:      msg.From,
+		To:        msg.To,
+		Timestamp: msg.Timestamp,
+	}
+	h.sendToUser(msg.To, typingMsg, nil)
 }
 
 func (h *Hub) handleReadReceipt(client *Client, msg *Message) {
 	// Mark messages as read in database
-	h.db.Model(&models.Message{}).
-		Where("sender_id = ? AND receiver_id = ? AND read = false", msg.From, client.UserID).
-		Update("read", true)
+	messageIDs, err := h.messages.MarkConversationRead(client.UserID, msg.To)
+	if err != nil {
+		log.Printf("Failed to mark messages as read: %v", err)
+		return
+	}
 
-	// Notify sender
-	h.mu.RLock()
-	senderClient, online := h.Clients[msg.From]
-	h.mu.RUnlock()
+	readMsg := readEvent(client.UserID, msg.To, messageIDs)
 
-	if online {
-		readMsg := &Message{
-			Type:      "read",
+	// Notify every device of the sender, and sync the reader's other devices
+	if _, err := h.sendEvent(msg.To, readMsg, nil); err != nil {
+		log.Printf("Failed to record read event: %v", err)
+	}
+	if _, err := h.sendEvent(client.UserID, readMsg, client); err != nil {
+		log.Printf("Failed to record read event: %v", err)
+	}
+}
+
+// markDelivered moves the messages a device has acknowledged to delivered
+// and tells each sender
+func (h *Hub) markDelivered(client *Client, messageIDs []uint) {
+	delivered, err := h.messages.MarkDelivered(client.UserID, messageIDs)
+	if err != nil {
+		log.Printf("Failed to mark messages as delivered: %v", err)
+		return
+	}
+
+	bySender := make(map[uint][]uint)
+	for _, message := range delivered {
+		bySender[message.SenderID] = append(bySender[message.SenderID], message.ID)
+	}
+
+	for senderID, ids := range bySender {
+		deliveredMsg := &Message{
+			Type:      "delivered",
 			From:      client.UserID,
-			To:        msg.From,
-			Timestamp: msg.Timestamp,
+			To:        senderID,
+			Timestamp: time.Now(),
+			Data:      map[string]interface{}{"message_ids": ids},
+		}
+		if _, err := h.sendEvent(senderID, deliveredMsg, nil); err != nil {
+			log.Printf("Failed to record delivered event: %v", err)
 		}
-		senderClient.SendMessage(readMsg)
 	}
 }
 
@@ -186,16 +383,67 @@ func (h *Hub) broadcastStatus(userID uint, online bool) {
 		},
 	}
 
+	h.deliverLocal(0, "", statusMsg)
+	if err := h.cluster.Broadcast(statusMsg); err != nil {
+		log.Printf("Failed to publish status: %v", err)
+	}
+}
+
+// sendToUser delivers a message to every device of a user across the cluster, except one
+func (h *Hub) sendToUser(userID uint, msg *Message, except *Client) {
+	exceptClient := ""
+	if except != nil {
+		exceptClient = except.ID
+	}
+
+	h.deliverLocal(userID, exceptClient, msg)
+	if err := h.cluster.PublishToUser(userID, msg, exceptClient); err != nil {
+		log.Printf("Failed to publish message: %v", err)
+	}
+}
+
+// deliverLocal sends a message to the devices of a user connected to this
+// node, or to everyone but the message's author when userID is 0
+func (h *Hub) deliverLocal(userID uint, exceptClient string, msg *Message) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	for _, client := range h.Clients {
-		if client.UserID != userID {
-			client.SendMessage(statusMsg)
+	if userID == 0 {
+		for otherID, devices := range h.Clients {
+			if otherID == msg.From {
+				continue
+			}
+			for _, client := range devices {
+				client.SendMessage(msg)
+			}
+		}
+		return
+	}
+
+	for _, client := range h.Clients[userID] {
+		if client.ID == exceptClient {
+			continue
+		}
+		if msg.Seq != 0 {
+			client.SendEvent(msg)
+		} else {
+			client.SendMessage(msg)
 		}
 	}
 }
 
+// dropSession closes the connection of a revoked session on this node
+func (h *Hub) dropSession(userID, sessionID uint) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if client, ok := h.Clients[userID][SessionDevice(sessionID)]; ok {
+		log.Printf("🔒 Dropping revoked session: UserID=%d, SessionID=%d", userID, sessionID)
+		client.Close()
+	}
+}
+
+// GetOnlineUsers returns the users connected to this node
 func (h *Hub) GetOnlineUsers() []uint {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
@@ -207,10 +455,14 @@ func (h *Hub) GetOnlineUsers() []uint {
 	return users
 }
 
+// IsUserOnline reports whether the user is connected to any node
 func (h *Hub) IsUserOnline(userID uint) bool {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-
-	_, exists := h.Clients[userID]
-	return exists
+	online, err := h.cluster.IsUserOnline(userID)
+	if err != nil {
+		log.Printf("Failed to check presence: %v", err)
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		_, online = h.Clients[userID]
+	}
+	return online
 }
@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/everest-an/dchat-backend/internal/testutil"
+)
+
+func TestHubChatFansOutToDevices(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	hub := newTestHub(t, db)
+
+	alicePhone := connect(t, hub, alice.ID, "phone")
+	aliceLaptop := connect(t, hub, alice.ID, "laptop")
+	bobPhone := connect(t, hub, bob.ID, "phone")
+	bobLaptop := connect(t, hub, bob.ID, "laptop")
+	for _, client := range []*Client{alicePhone, aliceLaptop, bobPhone, bobLaptop} {
+		client.skipResume()
+	}
+
+	hub.HandleMessage(alicePhone, &Message{Type: "chat", From: alice.ID, To: bob.ID, ClientID: "5d0f3a7c-1b2e-4c8d-9f6a-2e7b1c4d8a90", Content: "hi"})
+
+	sent := received(t, alicePhone, "sent")
+	if len(sent) != 1 || sent[0].Seq == 0 {
+		t.Fatalf("sender got %+v, want a sequenced confirmation", sent)
+	}
+	if got := received(t, alicePhone, "chat"); len(got) != 0 {
+		t.Fatalf("sending device got its own message back: %+v", got)
+	}
+	// The sender's other device gets the copy the confirmation is numbered after
+	if got := received(t, aliceLaptop, "chat"); len(got) != 1 || got[0].Seq != sent[0].Seq || got[0].MessageID != sent[0].MessageID {
+		t.Fatalf("sender's laptop got %+v, want message %d as event %d", got, sent[0].MessageID, sent[0].Seq)
+	}
+	// Every device of the recipient gets the same event
+	for _, client := range []*Client{bobPhone, bobLaptop} {
+		got := received(t, client, "chat")
+		if len(got) != 1 || got[0].MessageID != sent[0].MessageID || got[0].Content != "hi" {
+			t.Fatalf("device %s got %+v", client.DeviceID, got)
+		}
+	}
+}
+
+func TestHubOfflineAfterLastDevice(t *testing.T) {
+	// Presence only goes through the cluster
+	hub := newTestHub(t, nil)
+	observer := connect(t, hub, 1, "phone")
+	observer.skipResume()
+
+	status := func() []bool {
+		t.Helper()
+		var online []bool
+		for _, msg := range received(t, observer, "status") {
+			if msg.From == 2 {
+				online = append(online, msg.Data.(map[string]interface{})["online"].(bool))
+			}
+		}
+		return online
+	}
+
+	phone := connect(t, hub, 2, "phone")
+	laptop := connect(t, hub, 2, "laptop")
+	if got := status(); len(got) != 1 || !got[0] {
+		t.Fatalf("status = %v, want one online for the first device", got)
+	}
+
+	// A device that reconnects replaces its connection; the old one going
+	// away doesn't take the user offline
+	reconnected := connect(t, hub, 2, "phone")
+	hub.unregisterClient(phone)
+	hub.unregisterClient(laptop)
+	if got := status(); len(got) != 0 {
+		t.Fatalf("status = %v while a device is still connected", got)
+	}
+	if !hub.IsUserOnline(2) {
+		t.Fatal("user offline while a device is still connected")
+	}
+
+	hub.unregisterClient(reconnected)
+	if got := status(); len(got) != 1 || got[0] {
+		t.Fatalf("status = %v, want one offline after the last device", got)
+	}
+	if hub.IsUserOnline(2) {
+		t.Fatal("user online after the last device disconnected")
+	}
+	if users := hub.GetOnlineUsers(); len(users) != 1 || users[0] != 1 {
+		t.Fatalf("online users = %v, want only the observer", users)
+	}
+}
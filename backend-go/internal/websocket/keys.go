@@ -0,0 +1,20 @@
+package websocket
+
+import (
+	"time"
+)
+
+// PreKeysLow tells a user's devices that one of them is running out of
+// one-time prekeys. Every device of the user receives the event; the one
+// whose device_id matches uploads a new batch.
+func (n *Notifier) PreKeysLow(userID, deviceID uint, remaining int64) {
+	n.sendEvent(userID, &Message{
+		Type:      "prekeys_low",
+		To:        userID,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"device_id": deviceID,
+			"remaining": remaining,
+		},
+	})
+}
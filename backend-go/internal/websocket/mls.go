@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/e2ee"
+	"github.com/everest-an/dchat-backend/internal/messaging"
+	"github.com/everest-an/dchat-backend/internal/models"
+)
+
+// handleMLS relays an MLS message to a group in the order the delivery
+// service accepted it. Every member is told about it through their event
+// log, except the members a commit adds: they only hear of the Welcome,
+// since they can't process the commit that adds them.
+func (h *Hub) handleMLS(client *Client, msg *Message) {
+	submission := &e2ee.MLSSubmission{WelcomeRecipients: msg.Recipients}
+	var err error
+	if submission.Message, err = base64.StdEncoding.DecodeString(msg.Content); err != nil {
+		h.sendMLSError(client, msg, e2ee.ErrMalformedMLSMessage)
+		return
+	}
+	if msg.Welcome != "" {
+		if submission.Welcome, err = base64.StdEncoding.DecodeString(msg.Welcome); err != nil {
+			h.sendMLSError(client, msg, e2ee.ErrMalformedMLSMessage)
+			return
+		}
+	}
+
+	delivery, err := h.mls.Submit(client.UserID, msg.ConversationID, submission)
+	if err != nil {
+		h.sendMLSError(client, msg, err)
+		return
+	}
+
+	welcomed := make(map[uint]bool, len(delivery.WelcomeRecipients))
+	for _, userID := range delivery.WelcomeRecipients {
+		welcomed[userID] = true
+	}
+
+	event := mlsEvent("mls", delivery.Message)
+	senderEvent := event
+	for _, memberID := range delivery.Members {
+		if welcomed[memberID] {
+			continue
+		}
+		var except *Client
+		if memberID == client.UserID {
+			except = client
+		}
+		sent, err := h.sendEvent(memberID, event, except)
+		if err != nil {
+			log.Printf("Failed to record MLS event: %v", err)
+			continue
+		}
+		if memberID == client.UserID {
+			senderEvent = sent
+		}
+	}
+
+	if delivery.Welcome != nil {
+		welcome := mlsEvent("mls_welcome", delivery.Welcome)
+		for _, userID := range delivery.WelcomeRecipients {
+			if _, err := h.sendEvent(userID, welcome, nil); err != nil {
+				log.Printf("Failed to record MLS welcome event: %v", err)
+			}
+		}
+	}
+
+	client.SendEvent(&Message{
+		Type:           "sent",
+		From:           client.UserID,
+		ConversationID: msg.ConversationID,
+		ClientID:       msg.ClientID,
+		Seq:            senderEvent.Seq,
+		Timestamp:      delivery.Message.CreatedAt,
+		Data:           mlsEventData(delivery.Message),
+	})
+}
+
+// mlsEvent points at an accepted MLS message without carrying it: a message
+// can be hundreds of kilobytes, and copying it into the event log of every
+// member would multiply that by the size of the group. Members fetch it from
+// GET /groups/:id/mls/messages with after set to the seq before mls_seq.
+// MessageID is left unset: it refers to chat messages in the event log.
+func mlsEvent(eventType string, message *models.MLSMessage) *Message {
+	return &Message{
+		Type:           eventType,
+		From:           message.SenderID,
+		ConversationID: message.ConversationID,
+		Timestamp:      message.CreatedAt,
+		Data:           mlsEventData(message),
+	}
+}
+
+func mlsEventData(message *models.MLSMessage) map[string]interface{} {
+	return map[string]interface{}{
+		"mls_id":       message.ID,
+		"mls_seq":      message.Seq,
+		"epoch":        message.Epoch,
+		"content_type": message.ContentType,
+	}
+}
+
+func (h *Hub) sendMLSError(client *Client, msg *Message, err error) {
+	data := map[string]interface{}{"error": "Failed to relay MLS message"}
+	var epochErr *e2ee.EpochError
+	switch {
+	case errors.As(err, &epochErr):
+		// The client catches up to the current epoch and retries
+		data["error"] = err.Error()
+		data["epoch"] = epochErr.Current
+	case errors.Is(err, e2ee.ErrMalformedMLSMessage) || errors.Is(err, e2ee.ErrMLSGroupNotFound) ||
+		errors.Is(err, e2ee.ErrMLSGroupMismatch) || errors.Is(err, e2ee.ErrMLSMessageTooLarge) ||
+		errors.Is(err, e2ee.ErrWelcomeWithoutCommit) || errors.Is(err, e2ee.ErrWelcomeRecipients) ||
+		errors.Is(err, messaging.ErrNotGroupMember) || errors.Is(err, messaging.ErrGroupNotFound):
+		data["error"] = err.Error()
+	default:
+		log.Printf("Failed to relay MLS message: %v", err)
+	}
+
+	client.SendMessage(&Message{
+		Type:           "error",
+		To:             client.UserID,
+		ConversationID: msg.ConversationID,
+		ClientID:       msg.ClientID,
+		Timestamp:      time.Now(),
+		Data:           data,
+	})
+}
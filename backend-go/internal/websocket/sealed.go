@@ -0,0 +1,21 @@
+package websocket
+
+import (
+	"github.com/everest-an/dchat-backend/internal/models"
+)
+
+// SealedMessage delivers a sealed-sender message to the recipient's
+// devices. The event carries no sender; clients learn it by decrypting
+// the content and checking the delivery certificate inside.
+func (n *Notifier) SealedMessage(message *models.SealedMessage) {
+	n.sendEvent(message.RecipientID, &Message{
+		Type:      "sealed",
+		To:        message.RecipientID,
+		Content:   message.Content,
+		Encrypted: true,
+		Timestamp: message.CreatedAt,
+		Data: map[string]interface{}{
+			"sealed_id": message.ID,
+		},
+	})
+}
@@ -0,0 +1,302 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Events are kept this long for devices that come back online. Message
+	// events carry a copy of the message, in plaintext unless the client
+	// encrypted it; paths that remove a message's content remove or redact
+	// its events in the same transaction.
+	eventRetention = 30 * 24 * time.Hour
+	pruneInterval  = time.Hour
+
+	// Replayed events are sent in batches well under the client's send
+	// buffer, waiting for it to drain in between
+	replayBatchSize    = 64
+	replayPollInterval = 50 * time.Millisecond
+
+	// Clients that don't send resume within this time go live without replay
+	resumeTimeout = 5 * time.Second
+)
+
+// EventLog stores the events delivered to each user under a per-user sequence
+type EventLog struct {
+	db *gorm.DB
+}
+
+func NewEventLog(db *gorm.DB) *EventLog {
+	return &EventLog{db: db}
+}
+
+// Append allocates the user's next sequence number and stores the event.
+// The cursor row lock makes concurrent appends for one user commit in
+// sequence order, so a reader never skips over a later-committed event.
+func (l *EventLog) Append(userID uint, msg *Message) (uint64, error) {
+	var seq uint64
+	err := l.db.Transaction(func(tx *gorm.DB) error {
+		cursor := models.UserEventCursor{UserID: userID, LastSeq: 1}
+		if err := tx.Clauses(
+			clause.OnConflict{
+				Columns:   []clause.Column{{Name: "user_id"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{"last_seq": gorm.Expr("user_event_cursor.last_seq + 1")}),
+			},
+			clause.Returning{Columns: []clause.Column{{Name: "last_seq"}}},
+		).Create(&cursor).Error; err != nil {
+			return err
+		}
+		seq = cursor.LastSeq
+
+		msg.Seq = seq
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+
+		event := models.UserEvent{
+			UserID:  userID,
+			Seq:     seq,
+			Type:    msg.Type,
+			Payload: string(payload),
+		}
+		if msg.MessageID != 0 {
+			event.MessageID = &msg.MessageID
+		}
+		return tx.Create(&event).Error
+	})
+	return seq, err
+}
+
+// After returns up to limit events with a sequence number above seq
+func (l *EventLog) After(userID uint, seq uint64, limit int) ([]*Message, error) {
+	var events []models.UserEvent
+	if err := l.db.Where("user_id = ? AND seq > ?", userID, seq).Order("seq ASC").Limit(limit).Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	messages := make([]*Message, 0, len(events))
+	for _, event := range events {
+		var msg Message
+		if err := json.Unmarshal([]byte(event.Payload), &msg); err != nil {
+			log.Printf("Failed to decode event %d: %v", event.ID, err)
+			continue
+		}
+		msg.Seq = event.Seq
+		messages = append(messages, &msg)
+	}
+	return messages, nil
+}
+
+// PrunedThrough returns the highest sequence number the retention window
+// has removed for the user, or 0. Events deleted along with their message
+// leave gaps below it that don't count: there is nothing left to replay.
+func (l *EventLog) PrunedThrough(userID uint) (uint64, error) {
+	var cursors []models.UserEventCursor
+	if err := l.db.Where("user_id = ?", userID).Limit(1).Find(&cursors).Error; err != nil {
+		return 0, err
+	}
+	if len(cursors) == 0 {
+		return 0, nil
+	}
+	return cursors[0].PrunedThrough, nil
+}
+
+// Ack records the highest sequence number a device has processed
+func (l *EventLog) Ack(userID uint, deviceID string, seq uint64) error {
+	return l.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "device_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"seq":        gorm.Expr("GREATEST(device_ack.seq, EXCLUDED.seq)"),
+			"updated_at": time.Now(),
+		}),
+	}).Create(&models.DeviceAck{UserID: userID, DeviceID: deviceID, Seq: seq}).Error
+}
+
+// ReceivedMessages returns the IDs of chat messages received by the user in the sequence range (after, upTo]
+func (l *EventLog) ReceivedMessages(userID uint, after, upTo uint64) ([]uint, error) {
+	var ids []uint
+	err := l.db.Model(&models.UserEvent{}).
+		Where("user_id = ? AND seq > ? AND seq <= ? AND type = ? AND message_id IS NOT NULL", userID, after, upTo, "chat").
+		Pluck("message_id", &ids).Error
+	return ids, err
+}
+
+// WithoutMessage returns the users among userIDs that have no chat event
+// carrying the message
+func (l *EventLog) WithoutMessage(messageID uint, userIDs []uint) ([]uint, error) {
+	var have []uint
+	if err := l.db.Model(&models.UserEvent{}).
+		Where("user_id IN ? AND message_id = ? AND type = ?", userIDs, messageID, "chat").
+		Distinct().Pluck("user_id", &have).Error; err != nil {
+		return nil, err
+	}
+
+	missing := make([]uint, 0, len(userIDs))
+	for _, userID := range userIDs {
+		found := false
+		for _, id := range have {
+			if id == userID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, userID)
+		}
+	}
+	return missing, nil
+}
+
+// LastAck returns the sequence number the device last acknowledged, or 0
+func (l *EventLog) LastAck(userID uint, deviceID string) (uint64, error) {
+	var acks []models.DeviceAck
+	if err := l.db.Where("user_id = ? AND device_id = ?", userID, deviceID).Limit(1).Find(&acks).Error; err != nil {
+		return 0, err
+	}
+	if len(acks) == 0 {
+		return 0, nil
+	}
+	return acks[0].Seq, nil
+}
+
+// Prune deletes events older than the retention window, raising each
+// user's pruned_through watermark to the last event it deleted
+func (l *EventLog) Prune() (int64, error) {
+	cutoff := time.Now().Add(-eventRetention)
+	var n int64
+	err := l.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`UPDATE user_event_cursor c SET pruned_through = GREATEST(c.pruned_through, e.seq)
+			FROM (SELECT user_id, MAX(seq) AS seq FROM user_event WHERE created_at < ? GROUP BY user_id) e
+			WHERE c.user_id = e.user_id`, cutoff).Error; err != nil {
+			return err
+		}
+		result := tx.Where("created_at < ?", cutoff).Delete(&models.UserEvent{})
+		n = result.RowsAffected
+		return result.Error
+	})
+	return n, err
+}
+
+// sendEvent appends a copy of msg to the user's event log and delivers it to
+// every device of the user except one. It returns the sequenced copy.
+func (h *Hub) sendEvent(userID uint, msg *Message, except *Client) (*Message, error) {
+	event := *msg
+	if _, err := h.events.Append(userID, &event); err != nil {
+		return nil, err
+	}
+	h.sendToUser(userID, &event, except)
+	return &event, nil
+}
+
+// handleResume streams the events a device missed since seq, then switches it
+// to live delivery. A seq of 0 resumes from the device's last ack.
+func (h *Hub) handleResume(client *Client, msg *Message) {
+	after := msg.Seq
+	if after == 0 {
+		acked, err := h.events.LastAck(client.UserID, client.DeviceID)
+		if err != nil {
+			log.Printf("Failed to load device ack: %v", err)
+		}
+		after = acked
+	}
+
+	if !client.beginResume() {
+		// Already live; the client has to reconnect to resume again
+		return
+	}
+
+	// Events before the retention window are gone; the client must refetch history
+	if pruned, err := h.events.PrunedThrough(client.UserID); err != nil {
+		log.Printf("Failed to load pruned events: %v", err)
+	} else if pruned > after {
+		client.SendMessage(&Message{
+			Type:      "resync",
+			To:        client.UserID,
+			Timestamp: time.Now(),
+			Data:      map[string]interface{}{"oldest_seq": pruned + 1},
+		})
+	}
+
+	for {
+		if !client.waitForRoom(replayBatchSize) {
+			log.Printf("Client stopped reading during replay: UserID=%d, Device=%s", client.UserID, client.DeviceID)
+			client.Close()
+			return
+		}
+		events, err := h.events.After(client.UserID, after, replayBatchSize)
+		if err != nil {
+			log.Printf("Failed to replay events: %v", err)
+			client.goLive(after)
+			return
+		}
+		for _, event := range events {
+			client.SendMessage(event)
+			after = event.Seq
+		}
+		if len(events) == replayBatchSize {
+			continue
+		}
+		// Room for the events buffered during the replay
+		if !client.waitForRoom(replayBatchSize) {
+			client.Close()
+			return
+		}
+		if client.goLive(after) {
+			log.Printf("🔄 Client resumed: UserID=%d, Device=%s, Seq=%d", client.UserID, client.DeviceID, after)
+			return
+		}
+		// Live events overflowed the buffer during replay; read them from the log
+	}
+}
+
+// handleAck records the highest sequence the device has processed
+func (h *Hub) handleAck(client *Client, msg *Message) {
+	if msg.Seq == 0 {
+		return
+	}
+
+	previous, err := h.events.LastAck(client.UserID, client.DeviceID)
+	if err != nil {
+		log.Printf("Failed to load device ack: %v", err)
+		return
+	}
+	if err := h.events.Ack(client.UserID, client.DeviceID, msg.Seq); err != nil {
+		log.Printf("Failed to record ack: %v", err)
+		return
+	}
+	if msg.Seq <= previous {
+		return
+	}
+
+	// Messages in the acknowledged range have reached this device
+	messageIDs, err := h.events.ReceivedMessages(client.UserID, previous, msg.Seq)
+	if err != nil {
+		log.Printf("Failed to load acknowledged messages: %v", err)
+		return
+	}
+	h.markDelivered(client, messageIDs)
+}
+
+// pruneEvents periodically removes events past the retention window
+func (h *Hub) pruneEvents() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := h.events.Prune()
+		if err != nil {
+			log.Printf("Failed to prune events: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("🧹 Pruned %d expired events", n)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/everest-an/dchat-backend/internal/config"
+	"github.com/everest-an/dchat-backend/internal/messaging"
+	"github.com/everest-an/dchat-backend/internal/models"
+	"github.com/everest-an/dchat-backend/internal/testutil"
+	"gorm.io/gorm"
+)
+
+// newTestHub returns a hub on its own single-node cluster, without its
+// background loops running
+func newTestHub(t *testing.T, db *gorm.DB) *Hub {
+	t.Helper()
+	redisClient, _ := testutil.NewRedis(t)
+	cluster := newTestCluster(t, redisClient, "a")
+	t.Cleanup(func() { cluster.Close(func(uint) {}) })
+	return NewHub(db, cluster, &config.MessagingConfig{DeleteWindowMinutes: 60})
+}
+
+// connect registers a device of the user with the hub
+func connect(t *testing.T, hub *Hub, userID uint, deviceID string) *Client {
+	t.Helper()
+	client := NewClient(fmt.Sprintf("%d-%s", userID, deviceID), userID, deviceID, nil, hub)
+	hub.registerClient(client)
+	return client
+}
+
+// received drains the frames sent to the client so far, keeping those of the
+// given type
+func received(t *testing.T, client *Client, msgType string) []*Message {
+	t.Helper()
+	var messages []*Message
+	for {
+		select {
+		case data, ok := <-client.Send:
+			if !ok {
+				return messages
+			}
+			var msg Message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Type == msgType {
+				messages = append(messages, &msg)
+			}
+		default:
+			return messages
+		}
+	}
+}
+
+// seqs returns the sequence numbers of the events
+func seqs(events []*Message) []uint64 {
+	out := make([]uint64, len(events))
+	for i, event := range events {
+		out[i] = event.Seq
+	}
+	return out
+}
+
+func TestEventLogWithoutMessage(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+
+	messages := messaging.NewMessageService(db, &config.MessagingConfig{DeleteWindowMinutes: 60})
+	message, _, err := messages.Send(&messaging.SendRequest{
+		SenderID:   alice.ID,
+		ReceiverID: bob.ID,
+		ClientID:   "6f1c1b3e-2f4a-4e0b-9a52-0d1f5a7c9e21",
+		Content:    "hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := NewEventLog(db)
+	if _, err := events.Append(alice.ID, &Message{Type: "chat", MessageID: message.ID}); err != nil {
+		t.Fatal(err)
+	}
+	// Other events about the message don't count as delivering it
+	if _, err := events.Append(bob.ID, &Message{Type: "reaction", MessageID: message.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	missing, err := events.WithoutMessage(message.ID, []uint{bob.ID, alice.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 1 || missing[0] != bob.ID {
+		t.Fatalf("missing = %v, want [%d]", missing, bob.ID)
+	}
+}
+
+func TestHubSendEventFanOut(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	hub := newTestHub(t, db)
+
+	phone := connect(t, hub, alice.ID, "phone")
+	laptop := connect(t, hub, alice.ID, "laptop")
+	tablet := connect(t, hub, alice.ID, "tablet")
+	phone.skipResume()
+	laptop.skipResume()
+
+	msg := &Message{Type: "delivered", To: alice.ID, Data: map[string]interface{}{"message_ids": []uint{1}}}
+	event, err := hub.sendEvent(alice.ID, msg, phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Seq != 1 || msg.Seq != 0 {
+		t.Fatalf("event seq = %d, msg seq = %d; want a sequenced copy", event.Seq, msg.Seq)
+	}
+
+	if got := received(t, laptop, "delivered"); len(got) != 1 || got[0].Seq != 1 {
+		t.Fatalf("laptop got %+v, want event 1", got)
+	}
+	if got := received(t, phone, "delivered"); len(got) != 0 {
+		t.Fatalf("excluded device got %+v", got)
+	}
+	// A device that hasn't resumed yet gets the event once it goes live
+	if got := received(t, tablet, "delivered"); len(got) != 0 {
+		t.Fatalf("syncing device got %+v before going live", got)
+	}
+	tablet.skipResume()
+	if got := received(t, tablet, "delivered"); len(got) != 1 || got[0].Seq != 1 {
+		t.Fatalf("tablet got %+v after going live, want event 1", got)
+	}
+
+	stored, err := hub.events.After(alice.ID, 0, replayBatchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].Seq != 1 {
+		t.Fatalf("stored events = %+v", stored)
+	}
+}
+
+func TestHubResume(t *testing.T) {
+	db := testutil.NewDB(t)
+	bob := testutil.NewUser(t, db, "Bob")
+	hub := newTestHub(t, db)
+
+	// Events for a user with no device online are only stored
+	send := func() {
+		t.Helper()
+		if _, err := hub.sendEvent(bob.ID, &Message{Type: "delivered", To: bob.ID}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		send()
+	}
+
+	phone := connect(t, hub, bob.ID, "phone")
+	hub.HandleMessage(phone, &Message{Type: "resume", Seq: 1})
+	if got := seqs(received(t, phone, "delivered")); fmt.Sprint(got) != "[2 3]" {
+		t.Fatalf("replayed %v, want [2 3]", got)
+	}
+	// After the replay the device is live
+	send()
+	if got := seqs(received(t, phone, "delivered")); fmt.Sprint(got) != "[4]" {
+		t.Fatalf("live events %v, want [4]", got)
+	}
+	// Resuming twice on one connection replays nothing
+	hub.HandleMessage(phone, &Message{Type: "resume", Seq: 1})
+	if got := received(t, phone, "delivered"); len(got) != 0 {
+		t.Fatalf("second resume replayed %+v", got)
+	}
+
+	// Events 1 and 2 pass the retention window; event 3 is deleted with its
+	// message, which leaves a gap but nothing to resync
+	if err := db.Model(&models.UserEvent{}).Where("user_id = ? AND seq <= 2", bob.ID).
+		Update("created_at", time.Now().Add(-eventRetention-time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hub.events.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Where("user_id = ? AND seq = 3", bob.ID).Delete(&models.UserEvent{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if pruned, err := hub.events.PrunedThrough(bob.ID); err != nil || pruned != 2 {
+		t.Fatalf("pruned through %d, err = %v; want 2", pruned, err)
+	}
+
+	laptop := connect(t, hub, bob.ID, "laptop")
+	hub.HandleMessage(laptop, &Message{Type: "resume", Seq: 2})
+	if got := received(t, laptop, "resync"); len(got) != 0 {
+		t.Fatalf("device past the pruned events told to resync: %+v", got)
+	}
+	if got := seqs(received(t, laptop, "delivered")); fmt.Sprint(got) != "[4]" {
+		t.Fatalf("replayed %v, want [4]", got)
+	}
+
+	tablet := connect(t, hub, bob.ID, "tablet")
+	hub.HandleMessage(tablet, &Message{Type: "resume", Seq: 1})
+	frames := <-tablet.Send
+	var resync Message
+	if err := json.Unmarshal(frames, &resync); err != nil {
+		t.Fatal(err)
+	}
+	if resync.Type != "resync" || resync.Data.(map[string]interface{})["oldest_seq"] != float64(3) {
+		t.Fatalf("first frame = %+v, want resync from 3", resync)
+	}
+	if got := seqs(received(t, tablet, "delivered")); fmt.Sprint(got) != "[4]" {
+		t.Fatalf("replayed %v after resync, want [4]", got)
+	}
+}
+
+func TestHubAckMarksDelivered(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.NewUser(t, db, "Alice")
+	bob := testutil.NewUser(t, db, "Bob")
+	hub := newTestHub(t, db)
+
+	sender := connect(t, hub, alice.ID, "phone")
+	sender.skipResume()
+	hub.HandleMessage(sender, &Message{Type: "chat", From: alice.ID, To: bob.ID, ClientID: "0b6a7f1e-3c55-4f0e-8d2a-7e4b9c1d2f30", Content: "hi"})
+	sent := received(t, sender, "sent")
+	if len(sent) != 1 || sent[0].MessageID == 0 {
+		t.Fatalf("sent = %+v", sent)
+	}
+	messageID := sent[0].MessageID
+
+	status := func() models.MessageStatus {
+		t.Helper()
+		var message models.Message
+		if err := db.First(&message, messageID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return message.Status
+	}
+
+	// Replaying the message doesn't make it delivered; the device's ack does
+	phone := connect(t, hub, bob.ID, "phone")
+	hub.HandleMessage(phone, &Message{Type: "resume"})
+	chats := received(t, phone, "chat")
+	if len(chats) != 1 || chats[0].MessageID != messageID {
+		t.Fatalf("replayed %+v, want message %d", chats, messageID)
+	}
+	if got := status(); got != models.MessageStored {
+		t.Fatalf("status after replay = %s", got)
+	}
+
+	hub.HandleMessage(phone, &Message{Type: "ack", Seq: chats[0].Seq})
+	if got := status(); got != models.MessageDelivered {
+		t.Fatalf("status after ack = %s", got)
+	}
+	delivered := received(t, sender, "delivered")
+	if len(delivered) != 1 || fmt.Sprint(delivered[0].Data.(map[string]interface{})["message_ids"]) != fmt.Sprintf("[%d]", messageID) {
+		t.Fatalf("sender got %+v, want message %d delivered", delivered, messageID)
+	}
+
+	// Acking the same range again tells the sender nothing new
+	hub.HandleMessage(phone, &Message{Type: "ack", Seq: chats[0].Seq})
+	if got := received(t, sender, "delivered"); len(got) != 0 {
+		t.Fatalf("repeated ack sent %+v", got)
+	}
+
+	// The device resumes from its ack when it reconnects
+	reconnected := connect(t, hub, bob.ID, "phone")
+	hub.HandleMessage(reconnected, &Message{Type: "resume"})
+	if got := received(t, reconnected, "chat"); len(got) != 0 {
+		t.Fatalf("acked events replayed: %+v", got)
+	}
+}
@@ -0,0 +1,13 @@
+package websocket
+
+// withThread marks a message event with the message it replies to and its
+// thread, so clients can render the quote and bump the thread's reply count
+// without fetching it. The quoted message itself travels in Data.
+func withThread(event *Message, replyToID, threadRootID *uint) {
+	if replyToID != nil {
+		event.ReplyToID = *replyToID
+	}
+	if threadRootID != nil {
+		event.ThreadRootID = *threadRootID
+	}
+}
@@ -0,0 +1,36 @@
+-- Migration: Create session and refresh_token tables for token rotation and revocation
+-- Created: 2026-10-16
+
+CREATE TABLE IF NOT EXISTS session (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    device VARCHAR(255),
+    ip_address VARCHAR(45),
+    access_token_id VARCHAR(36),
+    access_expires_at TIMESTAMP,
+    last_used_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_session_user_id ON session(user_id);
+CREATE INDEX idx_session_user_active ON session(user_id, last_used_at DESC) WHERE revoked_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS refresh_token (
+    id SERIAL PRIMARY KEY,
+    session_id INTEGER NOT NULL REFERENCES session(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    rotated_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_refresh_token_token_hash ON refresh_token(token_hash);
+CREATE INDEX idx_refresh_token_session_id ON refresh_token(session_id);
+
+COMMENT ON TABLE session IS 'Signed-in devices; each session owns a chain of rotating refresh tokens';
+COMMENT ON COLUMN session.access_token_id IS 'jti of the latest access token, denylisted when the session is revoked';
+COMMENT ON COLUMN refresh_token.token_hash IS 'SHA-256 of the opaque refresh token; the raw token is never stored';
+COMMENT ON COLUMN refresh_token.rotated_at IS 'Set when the token is exchanged; presenting it again revokes the session';
@@ -0,0 +1,25 @@
+-- Migration: Create signing_key table for the rotating JWT keyring
+-- Created: 2026-10-16
+
+CREATE TABLE IF NOT EXISTS signing_key (
+    id SERIAL PRIMARY KEY,
+    kid VARCHAR(64) NOT NULL,
+    algorithm VARCHAR(10) NOT NULL,
+    public_key BYTEA NOT NULL,
+    private_key_encrypted BYTEA NOT NULL,
+    not_before TIMESTAMP NOT NULL,
+    retire_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT chk_signing_key_algorithm CHECK (algorithm IN ('ES256', 'EdDSA'))
+);
+
+CREATE UNIQUE INDEX idx_signing_key_kid ON signing_key(kid);
+CREATE INDEX idx_signing_key_expires_at ON signing_key(expires_at);
+
+COMMENT ON TABLE signing_key IS 'Asymmetric JWT signing keys, published at /.well-known/jwks.json';
+COMMENT ON COLUMN signing_key.public_key IS 'PKIX DER encoded public key';
+COMMENT ON COLUMN signing_key.private_key_encrypted IS 'PKCS#8 private key sealed with AES-GCM under a key derived from JWT_SECRET';
+COMMENT ON COLUMN signing_key.not_before IS 'The key signs new tokens from not_before until retire_at';
+COMMENT ON COLUMN signing_key.expires_at IS 'The key is removed from the JWKS once every token it signed has expired';
@@ -0,0 +1,46 @@
+-- Migration: Create user_identity table so one account can have many login methods
+-- Created: 2026-10-16
+
+CREATE TABLE IF NOT EXISTS user_identity (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL,
+    chain VARCHAR(64) NOT NULL DEFAULT '',
+    identifier VARCHAR(255) NOT NULL,
+    is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+    verified_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT chk_user_identity_type CHECK (
+        type IN ('wallet', 'email', 'phone', 'linkedin')
+    )
+);
+
+-- An identity resolves to exactly one account
+CREATE UNIQUE INDEX idx_user_identity_lookup ON user_identity(type, chain, identifier);
+CREATE INDEX idx_user_identity_user_id ON user_identity(user_id);
+
+-- At most one primary identity per type and account
+CREATE UNIQUE INDEX idx_user_identity_primary ON user_identity(user_id, type) WHERE is_primary;
+
+-- Backfill verified identities already stored on the user row.
+-- Wallets are linked lazily on their next login, when the chain is known.
+INSERT INTO user_identity (user_id, type, identifier, is_primary)
+SELECT id, 'email', LOWER(email), TRUE FROM "user"
+WHERE email <> '' AND is_email_verified AND deleted_at IS NULL
+ON CONFLICT DO NOTHING;
+
+INSERT INTO user_identity (user_id, type, identifier, is_primary)
+SELECT id, 'phone', phone_number, TRUE FROM "user"
+WHERE phone_number <> '' AND is_phone_verified AND deleted_at IS NULL
+ON CONFLICT DO NOTHING;
+
+INSERT INTO user_identity (user_id, type, identifier, is_primary)
+SELECT id, 'linkedin', linkedin_id, TRUE FROM "user"
+WHERE linkedin_id <> '' AND deleted_at IS NULL
+ON CONFLICT DO NOTHING;
+
+COMMENT ON TABLE user_identity IS 'Login methods (wallets, email, phone, LinkedIn) linked to an account';
+COMMENT ON COLUMN user_identity.chain IS 'CAIP-2 chain for wallets (e.g. eip155:1), empty for other types';
+COMMENT ON COLUMN user_identity.is_primary IS 'Primary identity of its type, mirrored into the matching user column';
@@ -0,0 +1,30 @@
+-- Migration: Add email + password login support (email tokens, login lockout)
+-- Created: 2026-10-16
+
+CREATE TABLE IF NOT EXISTS email_token (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    email VARCHAR(120) NOT NULL,
+    purpose VARCHAR(20) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT chk_email_token_purpose CHECK (
+        purpose IN ('verify', 'password_reset')
+    )
+);
+
+CREATE INDEX idx_email_token_user_id ON email_token(user_id);
+
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS failed_login_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
+
+-- Unique login columns hold NULL, not '', when unset
+UPDATE "user" SET wallet_address = NULL WHERE wallet_address = '';
+UPDATE "user" SET email = NULL WHERE email = '';
+UPDATE "user" SET phone_number = NULL WHERE phone_number = '';
+
+COMMENT ON TABLE email_token IS 'Single-use email verification and password reset tokens (hashed)';
+COMMENT ON COLUMN "user".locked_until IS 'Password login is refused until this time after repeated failures';
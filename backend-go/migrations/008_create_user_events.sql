@@ -0,0 +1,32 @@
+-- Migration: Create per-user event log for offline delivery and resumable sync
+-- Created: 2026-10-16
+
+CREATE TABLE IF NOT EXISTS user_event (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    type VARCHAR(32) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_user_event_seq ON user_event(user_id, seq);
+CREATE INDEX idx_user_event_created_at ON user_event(created_at);
+
+CREATE TABLE IF NOT EXISTS user_event_cursor (
+    user_id INTEGER PRIMARY KEY REFERENCES "user"(id) ON DELETE CASCADE,
+    last_seq BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS device_ack (
+    user_id INTEGER NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    device_id VARCHAR(64) NOT NULL,
+    seq BIGINT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, device_id)
+);
+
+COMMENT ON TABLE user_event IS 'Realtime events per user (messages, reads, edits), replayed to devices that reconnect';
+COMMENT ON COLUMN user_event.payload IS 'The event as sent, including a copy of the message it carries; kept for the 30-day retention window and removed or redacted with the message content';
+COMMENT ON COLUMN user_event.seq IS 'Monotonically increasing per user, allocated from user_event_cursor';
+COMMENT ON TABLE device_ack IS 'Highest event sequence acknowledged by each device (login session)';
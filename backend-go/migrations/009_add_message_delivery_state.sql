@@ -0,0 +1,23 @@
+-- Migration: Add client message IDs and delivery states to message
+-- Created: 2026-10-16
+
+ALTER TABLE message ADD COLUMN IF NOT EXISTS client_id VARCHAR(36);
+ALTER TABLE message ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'stored';
+ALTER TABLE message ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS read_at TIMESTAMP;
+
+ALTER TABLE message ADD CONSTRAINT chk_message_status CHECK (
+    status IN ('stored', 'delivered', 'read')
+);
+
+-- Retried sends carry the same client ID and resolve to the stored message
+CREATE UNIQUE INDEX idx_message_sender_client_id ON message(sender_id, client_id) WHERE client_id IS NOT NULL;
+
+-- Messages already marked read keep that state
+UPDATE message SET status = 'read', read_at = updated_at, delivered_at = updated_at WHERE read;
+
+ALTER TABLE user_event ADD COLUMN IF NOT EXISTS message_id INTEGER REFERENCES message(id) ON DELETE SET NULL;
+
+COMMENT ON COLUMN message.client_id IS 'UUID generated by the sending client; unique per sender';
+COMMENT ON COLUMN message.status IS 'Delivery state: stored -> delivered -> read (pending exists only on the client)';
+COMMENT ON COLUMN user_event.message_id IS 'Message carried by a chat event, used to mark it delivered when the device acks';
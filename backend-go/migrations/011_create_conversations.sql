@@ -0,0 +1,84 @@
+-- Migration: Create conversation and conversation_member tables for the inbox
+-- Created: 2026-10-16
+
+CREATE TABLE IF NOT EXISTS conversation (
+    id SERIAL PRIMARY KEY,
+    type VARCHAR(20) NOT NULL,
+    direct_key VARCHAR(41) UNIQUE,
+    last_message_id INTEGER,
+    last_message_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT chk_conversation_type CHECK (type IN ('direct'))
+);
+
+CREATE INDEX idx_conversation_last_message_at ON conversation(last_message_at DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS conversation_member (
+    conversation_id INTEGER NOT NULL REFERENCES conversation(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    unread_count INTEGER NOT NULL DEFAULT 0,
+    last_read_message_id INTEGER,
+    muted BOOLEAN NOT NULL DEFAULT FALSE,
+    archived BOOLEAN NOT NULL DEFAULT FALSE,
+    pinned_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE INDEX idx_conversation_member_user_id ON conversation_member(user_id, archived);
+
+ALTER TABLE message ADD COLUMN IF NOT EXISTS conversation_id INTEGER REFERENCES conversation(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_message_conversation_id ON message(conversation_id, created_at DESC, id DESC);
+
+-- Backfill one direct conversation per pair of users that exchanged messages
+INSERT INTO conversation (type, direct_key, created_at, updated_at)
+SELECT 'direct', LEAST(sender_id, receiver_id) || ':' || GREATEST(sender_id, receiver_id), MIN(created_at), NOW()
+FROM message
+GROUP BY LEAST(sender_id, receiver_id), GREATEST(sender_id, receiver_id)
+ON CONFLICT (direct_key) DO NOTHING;
+
+UPDATE message m SET conversation_id = c.id
+FROM conversation c
+WHERE m.conversation_id IS NULL
+  AND c.direct_key = LEAST(m.sender_id, m.receiver_id) || ':' || GREATEST(m.sender_id, m.receiver_id);
+
+UPDATE conversation c SET last_message_id = last.id, last_message_at = last.created_at
+FROM (
+    SELECT DISTINCT ON (conversation_id) conversation_id, id, created_at
+    FROM message
+    WHERE conversation_id IS NOT NULL
+    ORDER BY conversation_id, created_at DESC, id DESC
+) last
+WHERE last.conversation_id = c.id;
+
+INSERT INTO conversation_member (conversation_id, user_id)
+SELECT DISTINCT conversation_id, sender_id FROM message WHERE conversation_id IS NOT NULL
+UNION
+SELECT DISTINCT conversation_id, receiver_id FROM message WHERE conversation_id IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+UPDATE conversation_member cm SET unread_count = unread.count
+FROM (
+    SELECT conversation_id, receiver_id, COUNT(*) AS count
+    FROM message
+    WHERE NOT read AND conversation_id IS NOT NULL
+    GROUP BY conversation_id, receiver_id
+) unread
+WHERE unread.conversation_id = cm.conversation_id AND unread.receiver_id = cm.user_id;
+
+-- Members have read everything before their first unread message
+UPDATE conversation_member cm SET last_read_message_id = (
+    SELECT MAX(m.id) FROM message m
+    WHERE m.conversation_id = cm.conversation_id
+      AND NOT EXISTS (
+          SELECT 1 FROM message u
+          WHERE u.conversation_id = cm.conversation_id AND u.receiver_id = cm.user_id AND NOT u.read AND u.id <= m.id
+      )
+);
+
+COMMENT ON TABLE conversation IS 'Message threads; direct conversations are unique per pair of users';
+COMMENT ON COLUMN conversation.direct_key IS '"<lower user id>:<higher user id>" for direct conversations';
+COMMENT ON TABLE conversation_member IS 'Per-member read position, unread count and inbox settings';
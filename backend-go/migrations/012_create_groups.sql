@@ -0,0 +1,19 @@
+-- Migration: Add group conversations with member roles and invite links
+-- Created: 2026-10-16
+
+ALTER TABLE conversation ADD COLUMN IF NOT EXISTS title VARCHAR(100);
+ALTER TABLE conversation ADD COLUMN IF NOT EXISTS created_by INTEGER REFERENCES "user"(id) ON DELETE SET NULL;
+ALTER TABLE conversation ADD COLUMN IF NOT EXISTS invite_code VARCHAR(32) UNIQUE;
+
+ALTER TABLE conversation DROP CONSTRAINT IF EXISTS chk_conversation_type;
+ALTER TABLE conversation ADD CONSTRAINT chk_conversation_type CHECK (type IN ('direct', 'group'));
+
+ALTER TABLE conversation_member ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'member';
+ALTER TABLE conversation_member ADD CONSTRAINT chk_conversation_member_role CHECK (role IN ('owner', 'admin', 'member'));
+
+-- Group messages are stored once for the whole group, without a receiver
+ALTER TABLE message ALTER COLUMN receiver_id DROP NOT NULL;
+
+COMMENT ON COLUMN conversation.title IS 'Group name; empty for direct conversations';
+COMMENT ON COLUMN conversation.invite_code IS 'Code for joining a group by link; NULL when no link is active';
+COMMENT ON COLUMN conversation_member.role IS 'owner, admin or member; direct conversation members are always member';
@@ -0,0 +1,14 @@
+-- Migration: Add broadcast channels with public or invite-only subscriptions and post view counts
+-- Created: 2026-10-16
+
+ALTER TABLE conversation ADD COLUMN IF NOT EXISTS public BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE conversation DROP CONSTRAINT IF EXISTS chk_conversation_type;
+ALTER TABLE conversation ADD CONSTRAINT chk_conversation_type CHECK (type IN ('direct', 'group', 'channel'));
+
+CREATE INDEX IF NOT EXISTS idx_conversation_public_channels ON conversation(id) WHERE type = 'channel' AND public;
+
+ALTER TABLE message ADD COLUMN IF NOT EXISTS view_count INTEGER NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN conversation.public IS 'Channels only: anyone may find, read and subscribe; otherwise subscription is by invite';
+COMMENT ON COLUMN message.view_count IS 'Channel posts: subscribers whose read position has passed the post';
@@ -0,0 +1,29 @@
+-- Migration: Add message edit history and delete-for-me / delete-for-everyone
+-- Created: 2026-10-16
+
+ALTER TABLE message ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS message_edit (
+    id SERIAL PRIMARY KEY,
+    message_id INTEGER NOT NULL REFERENCES message(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_message_edit_message_id ON message_edit(message_id, created_at);
+
+CREATE TABLE IF NOT EXISTS message_hidden (
+    message_id INTEGER NOT NULL REFERENCES message(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX idx_message_hidden_user_id ON message_hidden(user_id);
+
+COMMENT ON COLUMN message.edited_at IS 'Last edit; earlier versions are kept in message_edit';
+COMMENT ON COLUMN message.deleted_at IS 'Deleted for everyone by the sender; content is cleared and the row kept as a tombstone';
+COMMENT ON TABLE message_edit IS 'Previous versions of edited messages, erased when the message is deleted for everyone';
+COMMENT ON TABLE message_hidden IS 'Messages a user deleted for themselves only';
@@ -0,0 +1,14 @@
+-- Migration: Create message_reaction table for emoji reactions
+-- Created: 2026-10-16
+
+CREATE TABLE IF NOT EXISTS message_reaction (
+    message_id INTEGER NOT NULL REFERENCES message(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    emoji VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id, emoji)
+);
+
+CREATE INDEX idx_message_reaction_user_id ON message_reaction(user_id);
+
+COMMENT ON TABLE message_reaction IS 'Emoji reactions; each user reacts to a message with a given emoji at most once';
@@ -0,0 +1,13 @@
+-- Migration: Add threaded replies and quoted messages
+-- Created: 2026-10-16
+
+ALTER TABLE message ADD COLUMN IF NOT EXISTS reply_to_id INTEGER REFERENCES message(id) ON DELETE SET NULL;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS thread_root_id INTEGER REFERENCES message(id) ON DELETE SET NULL;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS reply_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_message_reply_to_id ON message(reply_to_id);
+CREATE INDEX idx_message_thread_root_id ON message(thread_root_id, created_at, id);
+
+COMMENT ON COLUMN message.reply_to_id IS 'Message this one quotes; always in the same conversation';
+COMMENT ON COLUMN message.thread_root_id IS 'First message of the thread this reply belongs to';
+COMMENT ON COLUMN message.reply_count IS 'On thread roots, the number of replies in the thread';
@@ -0,0 +1,13 @@
+-- Migration: Add full-text search over plain-text messages
+-- Created: 2026-10-16
+
+-- The 'simple' configuration doesn't stem, so it works the same for every language users write in
+ALTER TABLE message ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        CASE WHEN encrypted OR deleted_at IS NOT NULL THEN NULL
+        ELSE to_tsvector('simple', content) END
+    ) STORED;
+
+CREATE INDEX idx_message_search_vector ON message USING GIN (search_vector);
+
+COMMENT ON COLUMN message.search_vector IS 'Search terms of plain-text messages; NULL for encrypted and deleted messages, which are never searchable';
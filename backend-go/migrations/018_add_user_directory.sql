@@ -0,0 +1,15 @@
+-- Migration: Add user directory search and discoverability settings
+-- Created: 2026-10-16
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS discoverability VARCHAR(20) NOT NULL DEFAULT 'everyone';
+
+-- Trigram indexes serve both substring (ILIKE) and fuzzy (%) matches
+CREATE INDEX idx_user_name_trgm ON "user" USING GIN (name gin_trgm_ops);
+CREATE INDEX idx_user_username_trgm ON "user" USING GIN (username gin_trgm_ops);
+CREATE INDEX idx_user_company_trgm ON "user" USING GIN (company gin_trgm_ops);
+CREATE INDEX idx_user_position_trgm ON "user" USING GIN (position gin_trgm_ops);
+CREATE INDEX idx_user_wallet_address_prefix ON "user" (wallet_address text_pattern_ops);
+
+COMMENT ON COLUMN "user".discoverability IS 'Who can find the user in the directory: everyone, contacts (shared direct or group conversation) or nobody';
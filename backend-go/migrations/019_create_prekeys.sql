@@ -0,0 +1,31 @@
+-- Migration: Create device_key and one_time_prekey tables for X3DH key bundles
+-- Created: 2026-10-16
+
+CREATE TABLE IF NOT EXISTS device_key (
+    device_id INTEGER PRIMARY KEY REFERENCES session(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    identity_key VARCHAR(64) NOT NULL,
+    signed_prekey_id BIGINT,
+    signed_prekey VARCHAR(64),
+    signed_prekey_signature VARCHAR(128),
+    signed_prekey_updated_at TIMESTAMP,
+    prekey_warning SMALLINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_device_key_user_id ON device_key(user_id);
+
+CREATE TABLE IF NOT EXISTS one_time_prekey (
+    device_id INTEGER NOT NULL REFERENCES device_key(device_id) ON DELETE CASCADE,
+    key_id BIGINT NOT NULL,
+    public_key VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (device_id, key_id)
+);
+
+COMMENT ON TABLE device_key IS 'Public identity key and current signed prekey of each device (login session)';
+COMMENT ON COLUMN device_key.identity_key IS 'Base64 Ed25519 public key; fixed for the life of the device';
+COMMENT ON COLUMN device_key.signed_prekey_signature IS 'Ed25519 signature by the identity key over the raw signed prekey bytes';
+COMMENT ON COLUMN device_key.prekey_warning IS 'Low-prekey warning sent since the last upload: 0 none, 1 low, 2 empty';
+COMMENT ON TABLE one_time_prekey IS 'One-time X25519 prekeys, deleted as they are claimed';
@@ -0,0 +1,51 @@
+-- Migration: Create tables for the MLS (RFC 9420) delivery service
+-- Created: 2026-10-16
+
+CREATE TABLE IF NOT EXISTS mls_group (
+    conversation_id INTEGER PRIMARY KEY REFERENCES conversation(id) ON DELETE CASCADE,
+    group_id BYTEA NOT NULL UNIQUE,
+    cipher_suite INTEGER NOT NULL,
+    epoch BIGINT NOT NULL DEFAULT 0,
+    last_seq BIGINT NOT NULL DEFAULT 0,
+    created_by INTEGER NOT NULL REFERENCES "user"(id),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS mls_message (
+    id SERIAL PRIMARY KEY,
+    conversation_id INTEGER NOT NULL REFERENCES mls_group(conversation_id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    epoch BIGINT NOT NULL,
+    content_type VARCHAR(20) NOT NULL,
+    sender_id INTEGER NOT NULL REFERENCES "user"(id),
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT idx_mls_message_seq UNIQUE (conversation_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS mls_welcome_recipient (
+    message_id INTEGER NOT NULL REFERENCES mls_message(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS mls_key_package (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    device_id INTEGER NOT NULL REFERENCES session(id) ON DELETE CASCADE,
+    cipher_suite INTEGER NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_mls_key_package_user_id ON mls_key_package(user_id);
+CREATE INDEX idx_mls_key_package_device_id ON mls_key_package(device_id, cipher_suite);
+
+COMMENT ON TABLE mls_group IS 'MLS group run by the members of a group conversation';
+COMMENT ON COLUMN mls_group.epoch IS 'Epoch the delivery service accepts messages for; advanced by each accepted commit';
+COMMENT ON COLUMN mls_group.last_seq IS 'Sequence number of the last message relayed to the group';
+COMMENT ON TABLE mls_message IS 'MLS messages relayed to a group, in delivery order';
+COMMENT ON COLUMN mls_message.data IS 'TLS-encoded MLSMessage; only the framing header is read by the server';
+COMMENT ON TABLE mls_welcome_recipient IS 'Users a Welcome message is delivered to';
+COMMENT ON TABLE mls_key_package IS 'KeyPackages published by devices, deleted as they are claimed';
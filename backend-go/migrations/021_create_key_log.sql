@@ -0,0 +1,34 @@
+-- Migration: Create key_log_entry, key_log_node and key_log_head tables for the key transparency log
+-- Created: 2026-10-16
+
+CREATE TABLE IF NOT EXISTS key_log_entry (
+    leaf_index BIGINT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "user"(id),
+    public_key TEXT NOT NULL,
+    timestamp BIGINT NOT NULL,
+    leaf_hash BYTEA NOT NULL
+);
+
+CREATE INDEX idx_key_log_entry_user_id ON key_log_entry(user_id, leaf_index);
+
+CREATE TABLE IF NOT EXISTS key_log_node (
+    level SMALLINT NOT NULL,
+    node_index BIGINT NOT NULL,
+    hash BYTEA NOT NULL,
+    PRIMARY KEY (level, node_index)
+);
+
+CREATE TABLE IF NOT EXISTS key_log_head (
+    tree_size BIGINT PRIMARY KEY,
+    timestamp BIGINT NOT NULL,
+    root_hash BYTEA NOT NULL,
+    signature BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+COMMENT ON TABLE key_log_entry IS 'Append-only Merkle log (RFC 6962) of every public key users publish; rows are never updated or deleted';
+COMMENT ON COLUMN key_log_entry.timestamp IS 'Publication time in milliseconds since the Unix epoch, as encoded in the leaf';
+COMMENT ON COLUMN key_log_entry.leaf_hash IS 'SHA-256 of 0x00 followed by the encoded leaf';
+COMMENT ON TABLE key_log_node IS 'Hash of every complete subtree: the 2^level leaves starting at leaf node_index * 2^level, written as appends complete them';
+COMMENT ON TABLE key_log_head IS 'Signed tree head published after each append';
+COMMENT ON COLUMN key_log_head.signature IS 'Ed25519 signature over the RFC 6962 TreeHeadSignature structure';
@@ -0,0 +1,31 @@
+-- Migration: Create delivery_token, sealed_message and sealed_message_ack tables for sealed-sender delivery
+-- Created: 2026-10-16
+
+CREATE TABLE IF NOT EXISTS delivery_token (
+    user_id INTEGER PRIMARY KEY REFERENCES "user"(id) ON DELETE CASCADE,
+    token_hash BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS sealed_message (
+    id SERIAL PRIMARY KEY,
+    recipient_id INTEGER NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS sealed_message_ack (
+    session_id INTEGER PRIMARY KEY REFERENCES session(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    through_id INTEGER NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_sealed_message_recipient_id ON sealed_message(recipient_id, id);
+CREATE INDEX idx_sealed_message_created_at ON sealed_message(created_at);
+
+COMMENT ON TABLE delivery_token IS 'Token a user shares with contacts to accept sealed-sender messages; rotating it revokes them';
+COMMENT ON COLUMN delivery_token.token_hash IS 'SHA-256 of the token';
+COMMENT ON TABLE sealed_message IS '1:1 messages whose sender is only inside the encrypted content; no sender column by design; deleted once every active session acknowledges them or after 30 days';
+COMMENT ON TABLE sealed_message_ack IS 'Highest sealed message ID each device of the recipient has acknowledged';
@@ -0,0 +1,13 @@
+-- Migration: Add disappearing-message timers to conversations and expiry to messages
+-- Created: 2026-10-16
+
+ALTER TABLE conversation ADD COLUMN IF NOT EXISTS disappearing_seconds INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE message ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS system BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_message_expires_at ON message(expires_at) WHERE expires_at IS NOT NULL;
+
+COMMENT ON COLUMN conversation.disappearing_seconds IS 'How long new messages last; 0 keeps them';
+COMMENT ON COLUMN message.expires_at IS 'When the reaper hard-deletes the message; NULL never';
+COMMENT ON COLUMN message.system IS 'Generated by the server, such as a disappearing timer announcement';
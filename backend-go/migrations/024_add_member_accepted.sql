@@ -0,0 +1,11 @@
+-- Migration: Record which members accepted a conversation, for directory contacts
+-- Created: 2026-10-17
+
+ALTER TABLE conversation_member ADD COLUMN IF NOT EXISTS accepted BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Owners and members who have written in the conversation have accepted it
+UPDATE conversation_member cm SET accepted = TRUE
+WHERE cm.role = 'owner'
+	OR EXISTS (SELECT 1 FROM message m WHERE m.conversation_id = cm.conversation_id AND m.sender_id = cm.user_id);
+
+COMMENT ON COLUMN conversation_member.accepted IS 'The member sent a message, joined by invite link or created the conversation; only accepted members count as contacts';
@@ -0,0 +1,12 @@
+-- Migration: Record how far each user's event log has been pruned
+-- Created: 2026-10-17
+
+ALTER TABLE user_event_cursor ADD COLUMN IF NOT EXISTS pruned_through BIGINT NOT NULL DEFAULT 0;
+
+-- Events already pruned before this migration leave no trace; assume
+-- everything below the oldest event left is gone
+UPDATE user_event_cursor c SET pruned_through = e.oldest - 1
+FROM (SELECT user_id, MIN(seq) AS oldest FROM user_event GROUP BY user_id) e
+WHERE c.user_id = e.user_id AND e.oldest > 1;
+
+COMMENT ON COLUMN user_event_cursor.pruned_through IS 'Highest sequence removed by the retention window; devices that acked below it have to resync. Events deleted with their message do not move it';
@@ -0,0 +1,6 @@
+-- Migration: Drop the direct message history index
+-- Created: 2026-10-17
+
+-- Direct history is read by conversation_id through idx_message_conversation_id
+-- (011); the (sender_id, receiver_id) index only cost writes
+DROP INDEX IF EXISTS idx_message_conversation_created_at;
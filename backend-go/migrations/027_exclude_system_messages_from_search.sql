@@ -0,0 +1,16 @@
+-- Migration: Leave system messages out of full-text search
+-- Created: 2026-10-17
+
+-- A generated column's expression can't be altered, so the column and its
+-- index are rebuilt
+ALTER TABLE message DROP COLUMN IF EXISTS search_vector;
+
+ALTER TABLE message ADD COLUMN search_vector tsvector
+    GENERATED ALWAYS AS (
+        CASE WHEN encrypted OR system OR deleted_at IS NOT NULL THEN NULL
+        ELSE to_tsvector('simple', content) END
+    ) STORED;
+
+CREATE INDEX idx_message_search_vector ON message USING GIN (search_vector);
+
+COMMENT ON COLUMN message.search_vector IS 'Search terms of plain-text messages; NULL for encrypted, system and deleted messages, which are never searchable';
@@ -43,6 +43,11 @@ func (r *RedisClient) Get(key string) (string, error) {
 	return r.Client.Get(r.ctx, key).Result()
 }
 
+// GetDel atomically reads and removes a key, so a value can be consumed at most once
+func (r *RedisClient) GetDel(key string) (string, error) {
+	return r.Client.GetDel(r.ctx, key).Result()
+}
+
 func (r *RedisClient) Delete(key string) error {
 	return r.Client.Del(r.ctx, key).Err()
 }
@@ -52,6 +57,20 @@ func (r *RedisClient) Exists(key string) (bool, error) {
 	return result > 0, err
 }
 
+// IncrWithTTL increments a counter, starting its expiry window on the first increment
+func (r *RedisClient) IncrWithTTL(key string, expiration time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := r.Client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(r.ctx, key)
+		pipe.ExpireNX(r.ctx, key, expiration)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
 func (r *RedisClient) Publish(channel string, message interface{}) error {
 	return r.Client.Publish(r.ctx, channel, message).Err()
 }